@@ -1,10 +1,92 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/signaling"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/turn"
 	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/ui"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "doctor":
+			doctor()
+			return
+		case "qr":
+			showQR(os.Args[2:])
+			return
+		case "scan":
+			scanQR(os.Args[2:])
+			return
+		}
+	}
+
 	app := ui.NewChatApp()
 	app.Run()
-}
\ No newline at end of file
+}
+
+// doctor probes the configured STUN/TURN servers and the NAT, and prints a report
+func doctor() {
+	config, err := turn.LoadPeerConfig("doctor")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Checking connectivity...")
+	fmt.Println()
+	fmt.Print(webrtc.Diagnose(context.Background(), config, webrtc.DiagnoseOptions{}).String())
+}
+
+// showQR prints a code as a QR code in the terminal, or saves it as a PNG or
+// SVG file: p2p-chat qr CODE [FILE]
+func showQR(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		log.Fatal("usage: p2p-chat qr CODE [FILE.png|FILE.svg]")
+	}
+
+	qr, err := signaling.NewQRCode(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) == 1 {
+		fmt.Print(qr.Terminal())
+		return
+	}
+
+	var data []byte
+	switch strings.ToLower(filepath.Ext(args[1])) {
+	case ".png":
+		data, err = qr.PNG(signaling.DefaultQRScale)
+	case ".svg":
+		data = []byte(qr.SVG())
+	default:
+		err = fmt.Errorf("unsupported file type %q, use .png or .svg", filepath.Ext(args[1]))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(args[1], data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// scanQR prints the code read from QR images: p2p-chat scan FILE...
+func scanQR(args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: p2p-chat scan FILE...")
+	}
+
+	code, err := signaling.DecodeQRFiles(args...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(code)
+}
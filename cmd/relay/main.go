@@ -0,0 +1,88 @@
+// Command relay runs a self-hosted STUN/TURN server for p2p-chat.
+//
+//	relay -config relay.json                     run the server
+//	relay -config relay.json -client-config alice  print a chat client config for user alice
+//
+// The client config can be saved and pointed to with P2P_CHAT_CONFIG. Users
+// not listed in the config get a time-limited credential, valid for -ttl,
+// when the relay has a secret.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/turn"
+)
+
+func main() {
+	configPath := flag.String("config", "relay.json", "relay config file")
+	clientUser := flag.String("client-config", "", "print a chat client config for this user and exit")
+	ttl := flag.Duration("ttl", turn.DefaultCredentialTTL, "lifetime of time-limited client credentials")
+	flag.Parse()
+
+	config, err := turn.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *clientUser != "" {
+		if err := printClientConfig(config, *clientUser, *ttl); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	server, err := turn.NewServer(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bound := server.Config()
+	log.Printf("Relay listening on udp %s", bound.ListenUDP)
+	if bound.ListenTCP != "" {
+		log.Printf("Relay listening on tcp %s", bound.ListenTCP)
+	}
+	if bound.ListenTLS != "" {
+		log.Printf("Relay listening on tls %s", bound.ListenTLS)
+	}
+	log.Printf("Relaying through %s", bound.PublicIP)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	log.Printf("Shutting down")
+	if err := server.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// printClientConfig writes the chat client config of a user to stdout: the
+// static password if the user has one, a time-limited credential otherwise
+func printClientConfig(config turn.Config, user string, ttl time.Duration) error {
+	username, password := user, config.Users[user]
+	if password == "" {
+		if config.Secret == "" {
+			return fmt.Errorf("no user %q in the relay config", user)
+		}
+
+		credential := turn.NewCredential(config.Secret, user, ttl)
+		username, password = credential.Username, credential.Password
+		log.Printf("Credential expires on %s", credential.Expires.Format(time.RFC3339))
+	}
+
+	data, err := json.MarshalIndent(config.ClientConfig(username, password), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(data))
+	return nil
+}
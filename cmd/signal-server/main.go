@@ -0,0 +1,72 @@
+// Command signal-server runs a rendezvous server for p2p-chat, so users can
+// connect with a short room ID instead of copying room and answer codes.
+//
+//	signal-server -listen :8080                 run the server
+//	signal-server -room-ttl 5m -max-rooms 100   tighter limits for a public server
+//
+// Each IP address may keep -max-rooms-per-client rooms open. Behind a reverse
+// proxy every client has the proxy's address, so raise it there.
+//
+// Point the chat app at it with P2P_CHAT_SIGNAL, e.g. http://example.com:8080.
+// Put it behind a TLS-terminating proxy to serve wss:// URLs.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/signaling"
+)
+
+// shutdownTimeout bounds waiting for in-flight HTTP requests on exit
+const shutdownTimeout = 5 * time.Second
+
+func main() {
+	listen := flag.String("listen", ":8080", "address to listen on")
+	roomTTL := flag.Duration("room-ttl", signaling.DefaultRoomTTL, "how long a room waits for an answer")
+	roomLifetime := flag.Duration("room-lifetime", signaling.DefaultRoomLifetime, "how long any room lives, answered or not")
+	maxRooms := flag.Int("max-rooms", signaling.DefaultMaxRooms, "maximum number of open rooms")
+	maxPerClient := flag.Int("max-rooms-per-client", signaling.DefaultMaxRoomsPerClient, "maximum number of open rooms per IP address")
+	flag.Parse()
+
+	rendezvous := signaling.NewServer(signaling.ServerConfig{
+		RoomTTL:           *roomTTL,
+		RoomLifetime:      *roomLifetime,
+		MaxRooms:          *maxRooms,
+		MaxRoomsPerClient: *maxPerClient,
+	})
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           rendezvous,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Printf("Signal server listening on %s", *listen)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	log.Printf("Shutting down")
+
+	// WebSocket connections are hijacked, the HTTP server does not close them
+	rendezvous.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,47 +1,124 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"time"
 
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/identity"
 	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/protocol"
 	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/signaling"
 	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
 )
 
+// EphemeralChannel carries typing indicators and presence pings. It is
+// unordered and unreliable so it never holds up chat messages
+const EphemeralChannel = "ephemeral"
+
+// ControlChannel carries in-band renegotiation (ICE restart offers and answers)
+const ControlChannel = "control"
+
+// SendTimeout is how long SendMessage waits for room in a full send buffer
+const SendTimeout = 10 * time.Second
+
 // Provides a high-level interface for the chat application
 type ChatClient struct {
 	peer 		webrtc.Peer
 	username	string
 	roomCode	string
 
-	// Connection state
-isConnected bool
+	// Connection state, see State
+	state		State
 	mu			sync.RWMutex
 
+	// Ordered event stream, see Events
+	events			*eventQueue
+	eventBuffer		int
+	stream			chan Event
+	streamClosed	bool
+	subscribers		map[int]func(StateChange)
+	nextSubscriber	int
+
+	// The host created the room and is the only side that sends restart offers
+	isHost			bool
+
+	// Who joined from the other side, and whether the users compared safety codes
+	peerName		string
+	verified		bool
+
+	// Cancels the reconnect timeout while in StateReconnecting
+	stopReconnectTimer	func() bool
+
+	// Channels createOffer opened, kept when a later step fails so a retry does not reopen them
+	offerChannels	map[string]bool
+
+	// Connection to the rendezvous server until signaling is over, see CreateRoomOnServer
+	session			*signaling.Session
+
 	// Event callbacks
 	onMessage		func(protocol.Message)
 	onConnected 	func()
 	onDisconnected	func()
+	onReconnecting	func()
+	onReconnected	func()
 	onError			func(error)
+
+	// Dependencies, see the With* options
+	peerFactory		PeerFactory
+	peerConfig		webrtc.PeerConfig
+	logger			Logger
+	clock			Clock
+	newID			IDGenerator
+	identity		*identity.Identity
+	contacts		*identity.ContactBook
+	privacy			bool
 }
 
-// Created a new chat client instance
-func NewChatClient(username string) (*ChatClient, error){
+// Created a new chat client instance. By default it talks through a
+// webrtc.RealPeer with webrtc.DefaultPeerConfig(); options replace any of
+// its dependencies
+func NewChatClient(username string, opts ...Option) (*ChatClient, error){
 	if username == ""{
 		return nil, fmt.Errorf("username cannot be empty")
 	}
 
-	peer, err := webrtc.NewRealPeer()
+	client := &ChatClient{
+		username: 		username,
+		peerFactory:	newRealPeer,
+		peerConfig:		webrtc.DefaultPeerConfig(),
+		logger:			log.Default(),
+		clock:			systemClock{},
+		newID:			randomID,
+		eventBuffer:	DefaultEventBuffer,
+		subscribers:	make(map[int]func(StateChange)),
+		offerChannels:	make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		opt(client)
+	}
+	if client.identity != nil {
+		client.peerConfig.Certificate = &client.identity.Certificate
+	}
+	if client.privacy {
+		client.peerConfig.Privacy = true
+	}
+
+	peer, err := client.peerFactory(client.peerConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create peer: %w", err)
 	}
+	client.peer = peer
 
-	client := &ChatClient{
-		peer: 		peer,
-		username: 	username,
-	}
+	// Every callback runs on the dispatcher, in event order
+	client.events = newEventQueue(client.eventBuffer)
+	go client.dispatch()
+
+	// The callback setters are driven by the state machine
+	client.Subscribe(client.fireCallbacks)
 
 	// Set up peer event handlers
 	client.setupPeerHandlers()
@@ -49,66 +126,132 @@ func NewChatClient(username string) (*ChatClient, error){
 	return client, nil
 }
 
+// Creates a new chat client whose peer uses the given ICE configuration.
+// Shorthand for NewChatClient(username, WithPeerConfig(config))
+func NewChatClientWithConfig(username string, config webrtc.PeerConfig, opts ...Option) (*ChatClient, error){
+	return NewChatClient(username, append([]Option{WithPeerConfig(config)}, opts...)...)
+}
+
+// Creates a new room and returns the room code to share
 func (c *ChatClient) CreateRoom() (string, error){
+	return c.CreateRoomContext(context.Background())
+}
+
+// Like CreateRoom, but gives up when ctx is done. ICE gathering is also
+// bounded by the peer's gathering timeout
+func (c *ChatClient) CreateRoomContext(ctx context.Context) (string, error){
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.isConnected {
-		return "", fmt.Errorf("already connected to a room")
+	if err := c.setState(StateOffering, nil); err != nil {
+		return "", fmt.Errorf("cannot create a room: %w", err)
+	}
+
+	roomCode, err := c.createOffer(ctx)
+	if err != nil {
+		c.setState(StateIdle, nil)
+		return "", err
+	}
+
+	c.roomCode = roomCode
+	c.isHost = true
+	c.setState(StateAwaitingAnswer, nil)
+	c.logger.Printf("Created room with code: %s", roomCode[:10]+"...")
+
+	return roomCode, nil
+}
+
+// createOffer opens the channels and returns the encoded offer. Caller must hold c.mu
+func (c *ChatClient) createOffer(ctx context.Context) (string, error) {
+	// The ephemeral channel has to be part of the offer so the guest gets it too
+	if err := c.openOfferChannel(EphemeralChannel, webrtc.EphemeralChannel()); err != nil {
+		return "", fmt.Errorf("failed to open ephemeral channel: %w", err)
+	}
+
+	// Renegotiation after a network change happens over this channel
+	if err := c.openOfferChannel(ControlChannel, webrtc.ReliableChannel()); err != nil {
+		return "", fmt.Errorf("failed to open control channel: %w", err)
 	}
 
 	// Create WebRTC offer
-	offer, err := c.peer.CreateOffer()
+	offer, err := c.peer.CreateOfferContext(ctx)
 	if err != nil {
 		return "", fmt.Errorf("failed to create offer: %w", err)
 	}
 
 	// Encode the offer for sharing
-	roomCode, err := signaling.Encode(offer)
+	roomCode, err := signaling.EncodeOffer(offer)
 	if err != nil {
 		return "", fmt.Errorf("failed to encode offer: %w", err)
 	}
 
-	c.roomCode = roomCode
-	log.Printf("Created room with code: %s", roomCode[:10]+"...")
-
 	return roomCode, nil
 }
 
+// openOfferChannel opens a channel for the offer, unless an earlier attempt
+// that failed later on already did. Caller must hold c.mu
+func (c *ChatClient) openOfferChannel(label string, options webrtc.ChannelOptions) error {
+	if c.offerChannels[label] {
+		return nil
+	}
+
+	if err := c.peer.OpenChannel(label, options); err != nil {
+		return err
+	}
+	c.offerChannels[label] = true
+	return nil
+}
+
 // Join an existing room using a room code and returns the answer code
 func (c *ChatClient) JoinRoom(roomCode string)(string, error){
+	return c.JoinRoomContext(context.Background(), roomCode)
+}
+
+// Like JoinRoom, but gives up when ctx is done
+func (c *ChatClient) JoinRoomContext(ctx context.Context, roomCode string)(string, error){
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.isConnected {
-		return "", fmt.Errorf("already connected to a room")
+	if c.state != StateIdle {
+		return "", fmt.Errorf("cannot join a room: %w: %s -> %s", ErrInvalidTransition, c.state, StateConnecting)
 	}
 
 	if roomCode == ""{
 		return "", fmt.Errorf("room code cannot be empty")
 	}
 
+	encodedAnswer, err := c.createAnswer(ctx, roomCode)
+	if err != nil {
+		return "", err
+	}
+
+	c.roomCode = roomCode
+	c.setState(StateConnecting, nil)
+	c.logger.Printf("Created answer for room. Answer code: %s", encodedAnswer[:10]+"...")
+
+	return encodedAnswer, nil
+}
+
+// createAnswer decodes a room code and returns the encoded answer. Caller must hold c.mu
+func (c *ChatClient) createAnswer(ctx context.Context, roomCode string) (string, error) {
 	// Decode the room code to get the offer
-	offer, err := signaling.Decode(roomCode)
+	offer, err := signaling.DecodeOffer(roomCode)
 	if err != nil {
-		return "", fmt.Errorf("failed to create answer: %w", err)
+		return "", fmt.Errorf("invalid room code: %w", err)
 	}
 
 	// Create answer for the offer
-	answer, err := c.peer.CreateAnswer(offer)
+	answer, err := c.peer.CreateAnswerContext(ctx, offer)
 	if err != nil {
 		return "", fmt.Errorf("failed to create answer: %w", err)
 	}
 
 	// Encode the answer for sharing
-	encodedAnswer, err := signaling.Encode(answer)
+	encodedAnswer, err := signaling.EncodeAnswer(answer)
 	if err != nil {
 		return "", fmt.Errorf("failed to encode answer: %w", err)
 	}
 
-	c.roomCode = roomCode
-	log.Printf("Created answer for room. Answer code: %s", encodedAnswer[:10]+"...")
-
 	return encodedAnswer, nil
 }
 
@@ -117,12 +260,21 @@ func (c *ChatClient) AcceptAnswer(answerCode string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.state != StateAwaitingAnswer {
+		return fmt.Errorf("cannot accept an answer: %w: %s -> %s", ErrInvalidTransition, c.state, StateConnecting)
+	}
+
 	if answerCode == "" {
 		return fmt.Errorf("answer code cannot be empty")
 	}
 
+	return c.acceptAnswer(answerCode)
+}
+
+// acceptAnswer applies an answer code and moves on to StateConnecting. Caller must hold c.mu
+func (c *ChatClient) acceptAnswer(answerCode string) error {
 	// Decode the answer
-	answer, err := signaling.Decode(answerCode)
+	answer, err := signaling.DecodeAnswer(answerCode)
 	if err != nil {
 		return fmt.Errorf("invalid answer code: %w", err)
 	}
@@ -132,56 +284,96 @@ func (c *ChatClient) AcceptAnswer(answerCode string) error {
 		return fmt.Errorf("failed to set remote answer: %w", err)
 	}
 
-	log.Printf("Accepted answer from peer")
+	c.setState(StateConnecting, nil)
+	c.logger.Printf("Accepted answer from peer")
 	return nil
 }
 
+// Sends a chat message, waiting up to SendTimeout while the send buffer is full
 func (c *ChatClient) SendMessage(text string) error {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	if !c.isConnected {
+	if c.state != StateConnected {
+		c.mu.RUnlock()
 		return fmt.Errorf("not connected to any room")
 	}
 
 	if text == ""{
+		c.mu.RUnlock()
 		return fmt.Errorf("message text cannot be empty")
 	}
 
 	// Create protocol message
-	msg := protocol.NewMessage(protocol.TypeChat, c.username, text)
+	msg := c.newMessage(protocol.TypeChat, text)
+	c.mu.RUnlock()
 
 	// Marshal to bytes
 	data := protocol.Marshal(msg)
 	
-	// Send over WebRTC data channel
-	if err := c.peer.Send(data) ; err != nil {
+	// Send over WebRTC data channel. The lock is released first so a stalled
+	// peer cannot hold up state changes or Disconnect
+	ctx, cancel := context.WithTimeout(context.Background(), SendTimeout)
+	defer cancel()
+	if err := c.peer.SendContext(ctx, data) ; err != nil {
 		return fmt.Errorf("faield to send message: %w", err)
 	}
 
-	log.Printf("Sent message: %s", text)
+	c.logger.Printf("Sent message: %s", text)
 	return nil
 }
 
+// Sends a latest-value-wins message (typing, presence) over the ephemeral
+// channel. It may be lost, but never delays chat messages
+func (c *ChatClient) SendEphemeral(msgType, text string) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.state != StateConnected {
+		return fmt.Errorf("not connected to any room")
+	}
+
+	if !protocol.IsEphemeral(msgType) {
+		return fmt.Errorf("message type %q is not ephemeral", msgType)
+	}
+
+	// Never wait for buffer space: when the channel is backed up this update
+	// is dropped and the next one replaces it
+	msg := c.newMessage(msgType, text)
+	err := c.peer.TrySendChannel(EphemeralChannel, protocol.Marshal(msg))
+	if errors.Is(err, webrtc.ErrWouldBlock) {
+		c.logger.Printf("Dropped %s message, send buffer is full", msgType)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send %s message: %w", msgType, err)
+	}
+
+	return nil
+}
+
+// Tells the peer that we are typing
+func (c *ChatClient) SendTyping() error {
+	return c.SendEphemeral(protocol.TypeTyping, "")
+}
+
 // Closes the connection and cleans up resources 
 func (c *ChatClient) Disconnect() error{
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.isConnected {
+	if c.state == StateClosed {
+		return nil
+	}
+
+	if c.state == StateConnected {
 		// Send leave message before disconnecting
-		leaveMsg := protocol.NewMessage(protocol.TypeLeave, c.username, "")
+		leaveMsg := c.newMessage(protocol.TypeLeave, "")
 		data := protocol.Marshal(leaveMsg)
-		c.peer.Send(data)
-
-		c.isConnected = false
+		c.peer.TrySend(data) // best effort, don't wait on a full buffer
 	}
 
 	c.roomCode = ""
-
-	if c.onDisconnected != nil {
-		go c.onDisconnected()
-	}
+	c.stopReconnecting()
+	c.setState(StateClosed, nil)
 
 	return c.peer.Close()
 }
@@ -205,6 +397,20 @@ func (c *ChatClient) OnDisconnected(callback func()) {
 	c.onDisconnected = callback
 }
 
+// OnReconnecting is called when the connection drops and an ICE restart is attempted
+func (c *ChatClient) OnReconnecting(callback func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReconnecting = callback
+}
+
+// OnReconnected is called when the connection recovers without a new room code
+func (c *ChatClient) OnReconnected(callback func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReconnected = callback
+}
+
 func (c *ChatClient) OnError(callback func(error)) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -220,9 +426,7 @@ func (c *ChatClient) GetUsername() string {
 
 // IsConnected returns whether the client is connected to a room
 func (c *ChatClient) IsConnected() bool {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.isConnected
+	return c.State() == StateConnected
 }
 
 // GetRoomCode returns the current room code (if any)
@@ -251,84 +455,95 @@ func (c *ChatClient) GetConnectionInstructions() string {
 4. Connection will establish automatically once they accept your answer`
 }
 
-// ConnectionStatus returns a user-friendly connection status
-func (c *ChatClient) ConnectionStatus() string {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	
-	if c.isConnected {
-		return "Connected - ready to chat!"
-	}
-	
-	if c.roomCode != "" {
-		return "Room created - waiting for connection..."
-	}
-	
-	return "Not connected"
+// Stats returns the selected candidate pair, RTT and message counters of the connection
+func (c *ChatClient) Stats() webrtc.Stats {
+	return c.peer.Stats()
 }
 
+// Privacy reports whether the peer runs in privacy mode, i.e. whether room
+// and answer codes are free of the user's addresses
+func (c *ChatClient) Privacy() bool {
+	return c.peerConfig.Privacy
+}
+
+// ConnectionStatus returns a user-friendly connection status,
+// State().Description()
+func (c *ChatClient) ConnectionStatus() string {
+	return c.State().Description()
+}
 
 func (c *ChatClient) setupPeerHandlers() {
-	// Handle incoming messages
-	c.peer.OnMessage(func(data []byte){
-		msg, err := protocol.Unmarshal(data)
-		if err != nil {
-			log.Printf("Failed to unmarshal message: %v", err)
-			if c.onError != nil {
-				go c.onError(fmt.Errorf("invalid message received: %w", err))
-			}
-			return
-		}
-		log.Printf("Received message: %s from %s", msg.Text, msg.From)
-
-		// Handle special message types
-		switch msg.Type{
-		case protocol.TypeJoin:
-			log.Printf("%s joined the chat", msg.From)
-		case protocol.TypeLeave:
-			log.Printf("%s left the chat", msg.From)
-		} 
-
-		// Notify callback
-		c.mu.RLock()
-		callback := c.onMessage
-		c.mu.RUnlock()
+	// Handle incoming messages, reliable and ephemeral alike
+	c.peer.OnMessage(c.handleData)
+	c.peer.OnChannelMessage(EphemeralChannel, c.handleData)
+	c.peer.OnChannelMessage(ControlChannel, c.handleControl)
+
+	// Handle connection state change
+	c.peer.OnStateChange(c.handleStateChange)
+}
+
+// Drives the client's state machine from the peer's connection state
+func (c *ChatClient) handleStateChange(state webrtc.ConnectionState) {
+	c.logger.Printf("Connection state: %s", state)
 
-		if callback != nil {
-			go callback(msg)
+	c.mu.Lock()
+	current := c.state
+	justConnected := false
+
+	switch {
+	case state == webrtc.ConnectionStateConnected && current == StateConnecting:
+		c.setState(StateConnected, nil)
+		justConnected = true
+	case state == webrtc.ConnectionStateConnected && current == StateReconnecting:
+		// Recovered after a network change, the session carries on
+		c.finishReconnect()
+	case state.Lost() && current == StateConnected:
+		// Possibly a network change, try an ICE restart before giving up
+		c.startReconnect()
+	case (state.Lost() || state == webrtc.ConnectionStateClosed) && (current == StateConnecting || current == StateAwaitingAnswer):
+		c.setState(StateFailed, fmt.Errorf("connection %s before it was established", state))
+	case state == webrtc.ConnectionStateClosed && (current == StateConnected || current == StateReconnecting):
+		c.stopReconnecting()
+		c.setState(StateFailed, fmt.Errorf("connection closed"))
+	}
+	c.mu.Unlock()
+
+	if justConnected {
+		c.logger.Printf("Successfully connected to peer")
+
+		// Send join message
+		joinMsg := c.newMessage(protocol.TypeJoin, "")
+		data := protocol.Marshal(joinMsg)
+		ctx, cancel := context.WithTimeout(context.Background(), SendTimeout)
+		defer cancel()
+		if err := c.peer.SendContext(ctx, data); err != nil {
+			c.logger.Printf("Failed to send join message: %v", err)
 		}
-	})
+	}
+}
 
-	// Handle connection state change
-	c.peer.OnStateChange(func(state string){
-		log.Printf("Connection state: %s", state)
+// Decodes and dispatches a message received on any data channel
+func (c *ChatClient) handleData(data []byte) {
+	msg, err := protocol.Unmarshal(data)
+	if err != nil {
+		c.logger.Printf("Failed to unmarshal message: %v", err)
+		c.publish(Event{Type: EventError, Err: fmt.Errorf("invalid message received: %w", err)})
+		return
+	}
+	c.logger.Printf("Received message: %s from %s", msg.Text, msg.From)
 
+	// Handle special message types
+	switch msg.Type{
+	case protocol.TypeJoin:
+		c.logger.Printf("%s joined the chat", msg.From)
 		c.mu.Lock()
-		wasConnected := c.isConnected
-		c.isConnected = (state == "connected")
-		connectedCallback := c.onConnected
-		disconnectedCallback := c.onDisconnected
+		c.peerName = msg.From
 		c.mu.Unlock()
+		c.checkContact(msg.From)
+	case protocol.TypeLeave:
+		c.logger.Printf("%s left the chat", msg.From)
+	} 
 
-		// Notify about state changes
-		if c.isConnected && !wasConnected {
-			// Just connected
-			log.Printf("Successfully connected to peer")
-
-			// Send join message
-			joinMsg := protocol.NewMessage(protocol.TypeJoin, c.username, "")
-			data := protocol.Marshal(joinMsg)
-			c.peer.Send(data) // ignore error for now
-
-			if connectedCallback != nil {
-				go connectedCallback()
-			}
-		} else if !c.isConnected && wasConnected {
-			// Just disconnected
-			log.Printf("Disconnected from peer")
-			if disconnectedCallback != nil {
-				go disconnectedCallback()
-			}
-		}
-	})
-}
\ No newline at end of file
+	// Queue for the callback and the event stream, in arrival order
+	c.publish(Event{Type: EventMessage, Message: msg})
+}
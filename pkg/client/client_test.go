@@ -0,0 +1,806 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/identity"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/protocol"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/signaling"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/testutil"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const eventTimeout = 2 * time.Second
+
+// testLogger collects log lines so tests stay quiet and can assert on them
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) contains(text string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, line := range l.lines {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// usePeer returns a factory handing out an existing peer
+func usePeer(peer webrtc.Peer) Option {
+	return WithPeerFactory(func(webrtc.PeerConfig) (webrtc.Peer, error) {
+		return peer, nil
+	})
+}
+
+// signal returns a callback that reports each call on the channel
+func signal() (func(), chan struct{}) {
+	ch := make(chan struct{}, 8)
+	return func() { ch <- struct{}{} }, ch
+}
+
+func waitFor(t *testing.T, ch chan struct{}, what string) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(eventTimeout):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+// connectedPair returns a host and a guest client connected through fake peers
+func connectedPair(t *testing.T, opts ...Option) (*ChatClient, *ChatClient, *testutil.FakePeer, *testutil.FakePeer) {
+	t.Helper()
+
+	hostPeer, guestPeer := testutil.NewPeerPair()
+
+	host, err := NewChatClient("alice", append([]Option{usePeer(hostPeer)}, opts...)...)
+	require.NoError(t, err)
+	guest, err := NewChatClient("bob", append([]Option{usePeer(guestPeer)}, opts...)...)
+	require.NoError(t, err)
+
+	onHost, hostConnected := signal()
+	onGuest, guestConnected := signal()
+	host.OnConnected(onHost)
+	guest.OnConnected(onGuest)
+
+	roomCode, err := host.CreateRoom()
+	require.NoError(t, err)
+	answerCode, err := guest.JoinRoom(roomCode)
+	require.NoError(t, err)
+	require.NoError(t, host.AcceptAnswer(answerCode))
+
+	waitFor(t, hostConnected, "host to connect")
+	waitFor(t, guestConnected, "guest to connect")
+	hostPeer.Flush()
+
+	return host, guest, hostPeer, guestPeer
+}
+
+func TestNewChatClient_Validation(t *testing.T) {
+	_, err := NewChatClient("")
+	assert.Error(t, err)
+
+	failure := errors.New("no peer for you")
+	_, err = NewChatClient("alice", WithPeerFactory(func(webrtc.PeerConfig) (webrtc.Peer, error) {
+		return nil, failure
+	}))
+	assert.ErrorIs(t, err, failure)
+}
+
+func TestNewChatClient_PeerConfig(t *testing.T) {
+	config := webrtc.PeerConfig{ICETransportPolicy: "relay"}
+
+	var got webrtc.PeerConfig
+	hostPeer, _ := testutil.NewPeerPair()
+	_, err := NewChatClientWithConfig("alice", config, WithPeerFactory(func(c webrtc.PeerConfig) (webrtc.Peer, error) {
+		got = c
+		return hostPeer, nil
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, config, got)
+}
+
+func TestWithPrivacy(t *testing.T) {
+	config := webrtc.DefaultPeerConfig()
+
+	var got webrtc.PeerConfig
+	hostPeer, _ := testutil.NewPeerPair()
+	c, err := NewChatClient("alice", WithPrivacy(true), WithPeerConfig(config), WithLogger(&testLogger{}),
+		WithPeerFactory(func(c webrtc.PeerConfig) (webrtc.Peer, error) {
+			got = c
+			return hostPeer, nil
+		}))
+	require.NoError(t, err)
+	assert.True(t, got.Privacy)
+	assert.True(t, c.Privacy())
+
+	// A real peer needs a TURN server to hide the user's addresses
+	_, err = NewChatClientWithConfig("alice", config, WithPrivacy(true), WithLogger(&testLogger{}))
+	assert.ErrorContains(t, err, "TURN")
+}
+
+func TestChatClient_Messaging(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	logger := &testLogger{}
+
+	host, guest, hostPeer, _ := connectedPair(t,
+		WithClock(testutil.NewFakeClock(start)),
+		WithIDGenerator(testutil.SequentialIDs("msg")),
+		WithLogger(logger),
+	)
+
+	received := make(chan protocol.Message, 8)
+	guest.OnMessage(func(msg protocol.Message) {
+		if msg.Type == protocol.TypeChat {
+			received <- msg
+		}
+	})
+
+	require.NoError(t, host.SendMessage("hello"))
+	hostPeer.Flush()
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "hello", msg.Text)
+		assert.Equal(t, "alice", msg.From)
+		assert.Equal(t, start.UnixMilli(), msg.Timestamp)
+		assert.True(t, strings.HasPrefix(msg.ID, "msg-"))
+	case <-time.After(eventTimeout):
+		t.Fatal("message not delivered")
+	}
+
+	assert.True(t, logger.contains("Sent message: hello"))
+	assert.Equal(t, "Connected - ready to chat!", host.ConnectionStatus())
+}
+
+func TestChatClient_SendStalled(t *testing.T) {
+	host, _, hostPeer, _ := connectedPair(t, WithLogger(&testLogger{}))
+	host.OnError(func(error) {})
+
+	onReconnecting, reconnecting := signal()
+	host.OnReconnecting(onReconnecting)
+
+	// The send buffer never drains
+	hostPeer.BlockSends()
+	defer hostPeer.UnblockSends()
+
+	sent := make(chan error, 1)
+	go func() { sent <- host.SendMessage("stuck") }()
+
+	select {
+	case err := <-sent:
+		t.Fatalf("send did not wait for the buffer: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// State changes and Disconnect go ahead while the send waits
+	hostPeer.SetState(webrtc.ConnectionStateDisconnected)
+	waitFor(t, reconnecting, "reconnect to start")
+
+	disconnected := make(chan error, 1)
+	go func() { disconnected <- host.Disconnect() }()
+	select {
+	case err := <-disconnected:
+		assert.NoError(t, err)
+	case <-time.After(eventTimeout):
+		t.Fatal("Disconnect waited for the stalled send")
+	}
+
+	hostPeer.UnblockSends()
+	select {
+	case err := <-sent:
+		assert.Error(t, err)
+	case <-time.After(eventTimeout):
+		t.Fatal("send never returned")
+	}
+}
+
+func TestChatClient_Reconnect(t *testing.T) {
+	host, _, hostPeer, _ := connectedPair(t, WithLogger(&testLogger{}))
+
+	onReconnecting, reconnecting := signal()
+	onReconnected, reconnected := signal()
+	host.OnReconnecting(onReconnecting)
+	host.OnReconnected(onReconnected)
+
+	// The host restarts ICE over the control channel, the guest answers
+	hostPeer.SetState(webrtc.ConnectionStateDisconnected)
+
+	waitFor(t, reconnecting, "reconnect to start")
+	waitFor(t, reconnected, "reconnect to finish")
+	assert.True(t, host.IsConnected())
+	assert.Equal(t, 1, hostPeer.Restarts())
+}
+
+func TestChatClient_ReconnectTimeout(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	host, _, hostPeer, _ := connectedPair(t, WithClock(clock), WithLogger(&testLogger{}))
+
+	onReconnecting, reconnecting := signal()
+	onDisconnected, disconnected := signal()
+	host.OnReconnecting(onReconnecting)
+	host.OnDisconnected(onDisconnected)
+	host.OnError(func(error) {})
+
+	hostPeer.FailNext(testutil.OpRestartICE, errors.New("network unreachable"))
+	hostPeer.SetState(webrtc.ConnectionStateDisconnected)
+	waitFor(t, reconnecting, "reconnect to start")
+	assert.Equal(t, "Connection lost - reconnecting...", host.ConnectionStatus())
+
+	clock.Advance(ReconnectTimeout - time.Second)
+	assert.Equal(t, 1, clock.Pending())
+
+	clock.Advance(time.Second)
+	waitFor(t, disconnected, "reconnect to time out")
+	assert.Equal(t, StateFailed, host.State())
+	assert.Equal(t, "Connection failed", host.ConnectionStatus())
+}
+
+func TestState_Transitions(t *testing.T) {
+	assert.True(t, StateIdle.CanTransitionTo(StateOffering))
+	assert.True(t, StateConnected.CanTransitionTo(StateReconnecting))
+	assert.True(t, StateReconnecting.CanTransitionTo(StateConnected))
+	assert.False(t, StateIdle.CanTransitionTo(StateConnected))
+	assert.False(t, StateFailed.CanTransitionTo(StateConnected))
+
+	// Every state can be closed, and nothing leaves StateClosed
+	for state := range transitions {
+		if state != StateClosed {
+			assert.True(t, state.CanTransitionTo(StateClosed), state.String())
+		}
+		assert.False(t, StateClosed.CanTransitionTo(state), state.String())
+		assert.NotEmpty(t, state.Description())
+	}
+
+	assert.Equal(t, "awaiting-answer", StateAwaitingAnswer.String())
+}
+
+func TestChatClient_StateMachine(t *testing.T) {
+	hostPeer, guestPeer := testutil.NewPeerPair()
+
+	host, err := NewChatClient("alice", usePeer(hostPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	guest, err := NewChatClient("bob", usePeer(guestPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	assert.Equal(t, StateIdle, host.State())
+
+	changes := make(chan StateChange, 16)
+	unsubscribe := host.Subscribe(func(change StateChange) { changes <- change })
+
+	// Operations out of order are rejected
+	assert.ErrorIs(t, host.AcceptAnswer("code"), ErrInvalidTransition)
+	assert.Error(t, host.SendMessage("too early"))
+
+	roomCode, err := host.CreateRoom()
+	require.NoError(t, err)
+	_, err = host.CreateRoom()
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	answerCode, err := guest.JoinRoom(roomCode)
+	require.NoError(t, err)
+	require.NoError(t, host.AcceptAnswer(answerCode))
+
+	expected := []StateChange{
+		{From: StateIdle, To: StateOffering},
+		{From: StateOffering, To: StateAwaitingAnswer},
+		{From: StateAwaitingAnswer, To: StateConnecting},
+		{From: StateConnecting, To: StateConnected},
+	}
+	for _, want := range expected {
+		select {
+		case got := <-changes:
+			assert.Equal(t, want, got)
+		case <-time.After(eventTimeout):
+			t.Fatalf("timed out waiting for %s -> %s", want.From, want.To)
+		}
+	}
+
+	require.NoError(t, host.Disconnect())
+	select {
+	case got := <-changes:
+		assert.Equal(t, StateChange{From: StateConnected, To: StateClosed}, got)
+	case <-time.After(eventTimeout):
+		t.Fatal("timed out waiting for closed")
+	}
+
+	unsubscribe()
+	assert.NoError(t, host.Disconnect())
+	_, err = host.CreateRoom()
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+}
+
+func TestChatClient_WrongCode(t *testing.T) {
+	hostPeer, guestPeer := testutil.NewPeerPair()
+
+	host, err := NewChatClient("alice", usePeer(hostPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	guest, err := NewChatClient("bob", usePeer(guestPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+
+	roomCode, err := host.CreateRoom()
+	require.NoError(t, err)
+
+	// The host pastes their own room code back, with line breaks from a chat app
+	err = host.AcceptAnswer(roomCode[:20] + "\n" + roomCode[20:])
+	assert.ErrorIs(t, err, signaling.ErrWrongKind)
+	assert.Contains(t, err.Error(), "this is a room code, not an answer code")
+	assert.Equal(t, StateAwaitingAnswer, host.State())
+
+	answerCode, err := guest.JoinRoom(roomCode)
+	require.NoError(t, err)
+
+	otherPeer, _ := testutil.NewPeerPair()
+	other, err := NewChatClient("carol", usePeer(otherPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	_, err = other.JoinRoom(answerCode)
+	assert.ErrorIs(t, err, signaling.ErrWrongKind)
+	assert.Equal(t, StateIdle, other.State())
+
+	require.NoError(t, host.AcceptAnswer(answerCode))
+}
+
+func TestChatClient_CreateRoomRetry(t *testing.T) {
+	hostPeer, guestPeer := testutil.NewPeerPair()
+
+	host, err := NewChatClient("alice", usePeer(hostPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	guest, err := NewChatClient("bob", usePeer(guestPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+
+	// The channels are open by the time the offer fails
+	hostPeer.FailNext(testutil.OpCreateOffer, errors.New("gathering timed out"))
+	_, err = host.CreateRoom()
+	assert.ErrorContains(t, err, "gathering timed out")
+	assert.Equal(t, StateIdle, host.State())
+
+	onConnected, connected := signal()
+	guest.OnConnected(onConnected)
+
+	roomCode, err := host.CreateRoom()
+	require.NoError(t, err)
+	answerCode, err := guest.JoinRoom(roomCode)
+	require.NoError(t, err)
+	require.NoError(t, host.AcceptAnswer(answerCode))
+	waitFor(t, connected, "guest to connect")
+}
+
+func TestChatClient_ConnectionFailure(t *testing.T) {
+	hostPeer, guestPeer := testutil.NewPeerPairWithOptions(testutil.PairOptions{ManualConnect: true})
+
+	host, err := NewChatClient("alice", usePeer(hostPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	guest, err := NewChatClient("bob", usePeer(guestPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+
+	failed := make(chan StateChange, 1)
+	host.Subscribe(func(change StateChange) {
+		if change.To == StateFailed {
+			failed <- change
+		}
+	})
+
+	roomCode, err := host.CreateRoom()
+	require.NoError(t, err)
+	answerCode, err := guest.JoinRoom(roomCode)
+	require.NoError(t, err)
+	require.NoError(t, host.AcceptAnswer(answerCode))
+
+	hostPeer.SetState(webrtc.ConnectionStateFailed)
+
+	select {
+	case change := <-failed:
+		assert.Equal(t, StateConnecting, change.From)
+		assert.Error(t, change.Err)
+	case <-time.After(eventTimeout):
+		t.Fatal("client never failed")
+	}
+}
+
+func TestEventQueue_Overflow(t *testing.T) {
+	q := newEventQueue(3)
+
+	chat := func(text string) Event {
+		return Event{Type: EventMessage, Message: protocol.Message{Type: protocol.TypeChat, Text: text}}
+	}
+	typing := Event{Type: EventMessage, Message: protocol.Message{Type: protocol.TypeTyping}}
+
+	assert.False(t, q.push(chat("1")))
+	assert.False(t, q.push(typing))
+	assert.False(t, q.push(chat("2")))
+
+	// Full: the typing indicator goes first, then the oldest message
+	assert.True(t, q.push(chat("3")))
+	assert.False(t, q.push(chat("4")))
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		event, ok := q.pop()
+		require.True(t, ok)
+		if event.Type == EventDropped {
+			got = append(got, fmt.Sprintf("dropped %d", event.Dropped))
+			continue
+		}
+		got = append(got, event.Message.Text)
+	}
+	assert.Equal(t, []string{"dropped 2", "2", "3", "4"}, got)
+
+	// Nothing is accepted after the client is closed
+	q.push(Event{Type: EventStateChange, State: StateChange{From: StateIdle, To: StateClosed}})
+	q.push(chat("late"))
+
+	event, ok := q.pop()
+	require.True(t, ok)
+	assert.Equal(t, StateClosed, event.State.To)
+
+	_, ok = q.pop()
+	assert.False(t, ok)
+}
+
+func TestEventQueue_OverflowKeepsStateChanges(t *testing.T) {
+	q := newEventQueue(2)
+
+	chat := func(text string) Event {
+		return Event{Type: EventMessage, Message: protocol.Message{Type: protocol.TypeChat, Text: text}}
+	}
+	connected := Event{Type: EventStateChange, State: StateChange{From: StateConnecting, To: StateConnected}}
+	failed := Event{Type: EventStateChange, State: StateChange{From: StateConnected, To: StateFailed}}
+	failure := Event{Type: EventError, Err: errors.New("boom")}
+
+	q.push(connected)
+	q.push(chat("1"))
+
+	// Messages make way, the pending state change stays
+	assert.True(t, q.push(chat("2")))
+	q.push(failure)
+
+	// With only state changes and errors queued, new messages are dropped
+	// and new state changes go past the limit
+	q.push(chat("3"))
+	q.push(failed)
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		event, ok := q.pop()
+		require.True(t, ok)
+		switch event.Type {
+		case EventDropped:
+			got = append(got, fmt.Sprintf("dropped %d", event.Dropped))
+		case EventStateChange:
+			got = append(got, event.State.To.String())
+		case EventError:
+			got = append(got, event.Err.Error())
+		default:
+			got = append(got, event.Message.Text)
+		}
+	}
+	assert.Equal(t, []string{"dropped 3", "connected", "boom", "failed"}, got)
+}
+
+func TestChatClient_EventsInOrder(t *testing.T) {
+	host, guest, hostPeer, _ := connectedPair(t, WithLogger(&testLogger{}))
+	events := guest.Events()
+
+	const count = 200
+	for i := 0; i < count; i++ {
+		require.NoError(t, host.SendMessage(fmt.Sprint(i)))
+	}
+	hostPeer.Flush()
+
+	// A broken message surfaces as an error event between the chat messages
+	require.NoError(t, hostPeer.Send([]byte("not json")))
+	require.NoError(t, host.SendMessage("last"))
+	hostPeer.Flush()
+
+	// Messages arrive strictly in order, with the error where it happened
+	next := 0
+	sawError := false
+	for done := false; !done; {
+		select {
+		case event := <-events:
+			switch {
+			case event.Type == EventError:
+				assert.Equal(t, count, next, "error out of order")
+				sawError = true
+			case event.Type == EventMessage && event.Message.Type == protocol.TypeChat:
+				if event.Message.Text == "last" {
+					done = true
+					continue
+				}
+				assert.Equal(t, fmt.Sprint(next), event.Message.Text)
+				next++
+			}
+		case <-time.After(eventTimeout):
+			t.Fatalf("timed out after %d messages", next)
+		}
+	}
+	assert.Equal(t, count, next)
+	assert.True(t, sawError)
+
+	// The stream ends with the Closed transition
+	require.NoError(t, guest.Disconnect())
+	var last Event
+	for event := range events {
+		last = event
+	}
+	assert.Equal(t, EventStateChange, last.Type)
+	assert.Equal(t, StateClosed, last.State.To)
+
+	_, open := <-guest.Events()
+	assert.False(t, open)
+}
+
+// contactSession connects alice, who keeps book, to a bob presenting
+// fingerprint. It returns once bob's join message reached alice, with the
+// errors alice reported
+func contactSession(t *testing.T, book *identity.ContactBook, fingerprint string) (*ChatClient, *ChatClient, chan error) {
+	t.Helper()
+
+	hostPeer, guestPeer := testutil.NewPeerPair()
+	guestPeer.SetFingerprint(fingerprint)
+
+	host, err := NewChatClient("alice", usePeer(hostPeer), WithContacts(book), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	guest, err := NewChatClient("bob", usePeer(guestPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		host.Disconnect()
+		guest.Disconnect()
+	})
+
+	errs := make(chan error, 8)
+	host.OnError(func(err error) { errs <- err })
+	onJoin, joined := signal()
+	host.OnMessage(func(msg protocol.Message) {
+		if msg.Type == protocol.TypeJoin {
+			onJoin()
+		}
+	})
+
+	roomCode, err := host.CreateRoom()
+	require.NoError(t, err)
+	answerCode, err := guest.JoinRoom(roomCode)
+	require.NoError(t, err)
+	require.NoError(t, host.AcceptAnswer(answerCode))
+
+	waitFor(t, joined, "bob to join")
+	assert.Equal(t, fingerprint, host.RemoteFingerprint())
+	assert.Equal(t, guest.LocalFingerprint(), host.RemoteFingerprint())
+	return host, guest, errs
+}
+
+func TestChatClient_Contacts(t *testing.T) {
+	keystore, err := identity.OpenKeystore(t.TempDir())
+	require.NoError(t, err)
+	book, err := keystore.Contacts("alice")
+	require.NoError(t, err)
+
+	// First contact: bob's fingerprint is remembered
+	_, _, errs := contactSession(t, book, "sha-256 AA:BB")
+	assert.Empty(t, errs)
+	contact, ok := book.Lookup("bob")
+	require.True(t, ok)
+	assert.Equal(t, "sha-256 AA:BB", contact.Fingerprint)
+
+	// Same certificate next time: nothing to report
+	_, _, errs = contactSession(t, book, "sha-256 AA:BB")
+	assert.Empty(t, errs)
+
+	// Someone else calling themselves bob is flagged
+	_, _, errs = contactSession(t, book, "sha-256 CC:DD")
+	require.Len(t, errs, 1)
+	err = <-errs
+	assert.True(t, errors.Is(err, identity.ErrFingerprintChanged))
+	assert.ErrorContains(t, err, "bob")
+
+	contact, _ = book.Lookup("bob")
+	assert.Equal(t, "sha-256 AA:BB", contact.Fingerprint)
+}
+
+func TestChatClient_SafetyCode(t *testing.T) {
+	keystore, err := identity.OpenKeystore(t.TempDir())
+	require.NoError(t, err)
+	book, err := keystore.Contacts("alice")
+	require.NoError(t, err)
+
+	host, guest, _ := contactSession(t, book, "sha-256 AA:BB")
+
+	// Both sides derive the same code from the two fingerprints
+	hostCode, err := host.SafetyCode()
+	require.NoError(t, err)
+	guestCode, err := guest.SafetyCode()
+	require.NoError(t, err)
+	assert.Equal(t, hostCode.Numeric(), guestCode.Numeric())
+	assert.Equal(t, hostCode.Emoji(), guestCode.Emoji())
+	assert.Equal(t, "bob", host.PeerName())
+
+	assert.False(t, host.Verified())
+	require.NoError(t, host.MarkVerified(true))
+	assert.True(t, host.Verified())
+
+	contact, ok := book.Lookup("bob")
+	require.True(t, ok)
+	assert.True(t, contact.Verified)
+
+	// The next session with the same certificate starts out verified
+	host, _, _ = contactSession(t, book, "sha-256 AA:BB")
+	assert.True(t, host.Verified())
+
+	// A new certificate does not, and warns that the old one was verified
+	host, _, errs := contactSession(t, book, "sha-256 CC:DD")
+	assert.False(t, host.Verified())
+	var mismatch *identity.FingerprintMismatchError
+	require.ErrorAs(t, <-errs, &mismatch)
+	assert.True(t, mismatch.Verified)
+
+	// Codes that did not match clear the mark
+	host, _, _ = contactSession(t, book, "sha-256 AA:BB")
+	require.NoError(t, host.MarkVerified(false))
+	contact, _ = book.Lookup("bob")
+	assert.False(t, contact.Verified)
+}
+
+func TestChatClient_SafetyCodeBeforeSignaling(t *testing.T) {
+	peer, _ := testutil.NewPeerPair()
+	c, err := NewChatClient("alice", usePeer(peer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+
+	_, err = c.SafetyCode()
+	assert.Error(t, err)
+	assert.Error(t, c.MarkVerified(true))
+}
+
+func TestWithIdentity(t *testing.T) {
+	id, err := identity.Generate("alice")
+	require.NoError(t, err)
+
+	var config webrtc.PeerConfig
+	_, err = NewChatClient("alice", WithIdentity(id), WithLogger(&testLogger{}),
+		WithPeerFactory(func(c webrtc.PeerConfig) (webrtc.Peer, error) {
+			config = c
+			peer, _ := testutil.NewPeerPair()
+			return peer, nil
+		}))
+	require.NoError(t, err)
+	assert.Same(t, &id.Certificate, config.Certificate)
+}
+
+// rendezvousServer runs a rendezvous server for one test and returns its URL
+func rendezvousServer(t *testing.T, config signaling.ServerConfig) string {
+	t.Helper()
+
+	server := signaling.NewServer(config)
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		server.Close()
+		httpServer.Close()
+	})
+	return httpServer.URL
+}
+
+func TestChatClient_Rendezvous(t *testing.T) {
+	url := rendezvousServer(t, signaling.ServerConfig{})
+	ctx := context.Background()
+	hostPeer, guestPeer := testutil.NewPeerPair()
+
+	host, err := NewChatClient("alice", usePeer(hostPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	guest, err := NewChatClient("bob", usePeer(guestPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+
+	onHost, hostConnected := signal()
+	onGuest, guestConnected := signal()
+	host.OnConnected(onHost)
+	guest.OnConnected(onGuest)
+
+	roomID, err := host.CreateRoomOnServer(ctx, url)
+	require.NoError(t, err)
+	assert.Len(t, roomID, signaling.RoomIDLength)
+	assert.Equal(t, StateAwaitingAnswer, host.State())
+	assert.Equal(t, roomID, host.GetRoomCode())
+
+	require.NoError(t, guest.JoinRoomOnServer(ctx, url, strings.ToLower(roomID)))
+
+	// The answer comes back through the server, no codes to paste
+	waitFor(t, hostConnected, "host to connect")
+	waitFor(t, guestConnected, "guest to connect")
+
+	// Each side got the other's trickled candidate and the end marker
+	assert.Eventually(t, func() bool {
+		return len(hostPeer.RemoteCandidates()) == 2 && len(guestPeer.RemoteCandidates()) == 2
+	}, eventTimeout, 10*time.Millisecond)
+	assert.Contains(t, guestPeer.RemoteCandidates()[0], "50000")
+	assert.Contains(t, hostPeer.RemoteCandidates()[0], "50001")
+
+	// Signaling is over, the rendezvous sessions are closed
+	host.mu.RLock()
+	assert.Nil(t, host.session)
+	host.mu.RUnlock()
+	guest.mu.RLock()
+	assert.Nil(t, guest.session)
+	guest.mu.RUnlock()
+
+	received := make(chan protocol.Message, 8)
+	guest.OnMessage(func(msg protocol.Message) {
+		if msg.Type == protocol.TypeChat {
+			received <- msg
+		}
+	})
+	require.NoError(t, host.SendMessage("hello"))
+	hostPeer.Flush()
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "hello", msg.Text)
+	case <-time.After(eventTimeout):
+		t.Fatal("message not delivered")
+	}
+}
+
+func TestChatClient_RendezvousErrors(t *testing.T) {
+	url := rendezvousServer(t, signaling.ServerConfig{RoomTTL: 50 * time.Millisecond})
+	ctx := context.Background()
+	hostPeer, guestPeer := testutil.NewPeerPair()
+
+	guest, err := NewChatClient("bob", usePeer(guestPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	err = guest.JoinRoomOnServer(ctx, url, "ABCDEF")
+	assert.ErrorIs(t, err, signaling.ErrRoomNotFound)
+	assert.Equal(t, StateIdle, guest.State())
+
+	host, err := NewChatClient("alice", usePeer(hostPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	failed := make(chan StateChange, 1)
+	host.Subscribe(func(change StateChange) {
+		if change.To == StateFailed {
+			failed <- change
+		}
+	})
+
+	// Nobody joins before the room expires
+	_, err = host.CreateRoomOnServer(ctx, url)
+	require.NoError(t, err)
+
+	select {
+	case change := <-failed:
+		assert.Equal(t, StateAwaitingAnswer, change.From)
+		assert.ErrorIs(t, change.Err, signaling.ErrRoomExpired)
+	case <-time.After(eventTimeout):
+		t.Fatal("client never failed")
+	}
+
+	_, err = host.CreateRoomOnServer(ctx, url)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	// An unreachable server leaves the client idle
+	otherPeer, _ := testutil.NewPeerPair()
+	other, err := NewChatClient("carol", usePeer(otherPeer), WithLogger(&testLogger{}))
+	require.NoError(t, err)
+	_, err = other.CreateRoomOnServer(ctx, "127.0.0.1:1")
+	assert.Error(t, err)
+	assert.Equal(t, StateIdle, other.State())
+
+	// and can try again
+	_, err = other.CreateRoomOnServer(ctx, url)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,248 @@
+package client
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/protocol"
+)
+
+// DefaultEventBuffer is how many undelivered events a client keeps before
+// the overflow policy starts dropping them
+const DefaultEventBuffer = 1024
+
+// EventType tells which field of an Event is set
+type EventType int
+
+const (
+	// EventMessage: Message holds a chat, join, leave or ephemeral message
+	EventMessage EventType = iota
+	// EventStateChange: State holds a transition of the client's state machine
+	EventStateChange
+	// EventError: Err holds an error that did not come back from a method call
+	EventError
+	// EventDropped: Dropped events were discarded because the consumer fell behind
+	EventDropped
+)
+
+var eventTypeNames = map[EventType]string{
+	EventMessage:     "message",
+	EventStateChange: "state-change",
+	EventError:       "error",
+	EventDropped:     "dropped",
+}
+
+func (t EventType) String() string {
+	return eventTypeNames[t]
+}
+
+// Event is one item of the client's ordered event stream
+type Event struct {
+	Type    EventType
+	Message protocol.Message
+	State   StateChange
+	Err     error
+	Dropped int
+}
+
+// eventQueue buffers events between the goroutines that produce them (peer
+// callbacks, timers, method calls) and the client's dispatcher. It never
+// blocks a producer: past its limit it drops events (see push)
+type eventQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []Event
+	limit   int
+	dropped int
+	closing bool
+}
+
+func newEventQueue(limit int) *eventQueue {
+	q := &eventQueue{limit: limit}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues an event. When the queue is full the oldest ephemeral
+// message is dropped, or the oldest other message if there is none. State
+// changes and errors are never dropped: with only those queued, a new
+// message is dropped instead and a new state change or error is queued past
+// the limit. Events pushed after the transition to StateClosed are ignored.
+// Returns true when this push started a run of drops
+func (q *eventQueue) push(event Event) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closing {
+		return false
+	}
+	if event.Type == EventStateChange && event.State.To == StateClosed {
+		q.closing = true
+	}
+
+	firstDrop := false
+	if len(q.pending) >= q.limit {
+		victim := q.victim()
+		if victim < 0 && event.Type == EventMessage {
+			q.dropped++
+			return q.dropped == 1
+		}
+		if victim >= 0 {
+			q.pending = append(q.pending[:victim], q.pending[victim+1:]...)
+			firstDrop = q.dropped == 0
+			q.dropped++
+		}
+	}
+
+	q.pending = append(q.pending, event)
+	q.cond.Signal()
+	return firstDrop
+}
+
+// victim returns the index of the event to drop from a full queue: the
+// oldest ephemeral message, else the oldest message, else -1
+func (q *eventQueue) victim() int {
+	oldest := -1
+	for i, queued := range q.pending {
+		if queued.Type != EventMessage {
+			continue
+		}
+		if protocol.IsEphemeral(queued.Message.Type) {
+			return i
+		}
+		if oldest < 0 {
+			oldest = i
+		}
+	}
+	return oldest
+}
+
+// pop waits for the next event. Dropped events are reported by an
+// EventDropped ahead of the events that survived them. Returns false once
+// the StateClosed change has been handed out
+func (q *eventQueue) pop() (Event, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.pending) == 0 && !q.closing {
+		q.cond.Wait()
+	}
+
+	if q.dropped > 0 {
+		dropped := q.dropped
+		q.dropped = 0
+		return Event{Type: EventDropped, Dropped: dropped}, true
+	}
+
+	if len(q.pending) == 0 {
+		return Event{}, false
+	}
+
+	event := q.pending[0]
+	q.pending = q.pending[1:]
+	return event, true
+}
+
+// Events returns the client's event stream: every message, state change and
+// error in the order it happened. The callback setters and Subscribe are fed
+// from the same stream, before the channel.
+//
+// Once Events has been called the channel must be drained: while it is not,
+// undelivered events pile up and the overflow policy drops them (ephemeral
+// messages first, then the oldest chat, join and leave messages; an
+// EventDropped event reports how many). State changes and errors are never
+// dropped, so subscribers always see every transition. The channel is
+// closed after the StateClosed change, i.e. after Disconnect.
+func (c *ChatClient) Events() <-chan Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stream == nil {
+		c.stream = make(chan Event)
+		if c.streamClosed {
+			close(c.stream)
+		}
+	}
+	return c.stream
+}
+
+// Subscribe registers a callback for every state change and returns a
+// function that unregisters it. Callbacks run on the client's dispatcher,
+// one at a time and in order with the rest of the event stream; they may
+// call back into the client but should return quickly
+func (c *ChatClient) Subscribe(callback func(StateChange)) (unsubscribe func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := c.nextSubscriber
+	c.nextSubscriber++
+	c.subscribers[id] = callback
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subscribers, id)
+	}
+}
+
+// publish queues an event for the dispatcher. Safe to call with c.mu held
+func (c *ChatClient) publish(event Event) {
+	if c.events.push(event) {
+		c.logger.Printf("Event consumer is falling behind, dropping events")
+	}
+}
+
+// dispatch delivers queued events until the client is closed
+func (c *ChatClient) dispatch() {
+	for {
+		event, ok := c.events.pop()
+		if !ok {
+			break
+		}
+		c.deliver(event)
+	}
+
+	c.mu.Lock()
+	c.streamClosed = true
+	if c.stream != nil {
+		close(c.stream)
+	}
+	c.mu.Unlock()
+}
+
+// deliver hands one event to the matching callbacks, then to the stream
+func (c *ChatClient) deliver(event Event) {
+	c.mu.RLock()
+	onMessage := c.onMessage
+	onError := c.onError
+	ids := make([]int, 0, len(c.subscribers))
+	for id := range c.subscribers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	subscribers := make([]func(StateChange), len(ids))
+	for i, id := range ids {
+		subscribers[i] = c.subscribers[id]
+	}
+	stream := c.stream
+	c.mu.RUnlock()
+
+	switch event.Type {
+	case EventMessage:
+		if onMessage != nil {
+			onMessage(event.Message)
+		}
+	case EventStateChange:
+		for _, subscriber := range subscribers {
+			subscriber(event.State)
+		}
+	case EventError:
+		if onError != nil {
+			onError(event.Err)
+		}
+	}
+
+	if stream != nil {
+		stream <- event
+	}
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/identity"
+)
+
+// LocalFingerprint returns the DTLS fingerprint this client presents
+func (c *ChatClient) LocalFingerprint() string {
+	return c.peer.LocalFingerprint()
+}
+
+// RemoteFingerprint returns the DTLS fingerprint the other side presented,
+// or "" before the offer/answer exchange
+func (c *ChatClient) RemoteFingerprint() string {
+	return c.peer.RemoteFingerprint()
+}
+
+// PeerName returns the name the other side joined with, "" until its join
+// message arrives
+func (c *ChatClient) PeerName() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peerName
+}
+
+// SafetyCode returns the code both users should see if nobody swapped the
+// room or answer code on the way. It is available once the fingerprints
+// are exchanged, i.e. after JoinRoom or AcceptAnswer
+func (c *ChatClient) SafetyCode() (identity.SafetyCode, error) {
+	remote := c.peer.RemoteFingerprint()
+	if remote == "" {
+		return identity.SafetyCode{}, fmt.Errorf("no safety code before the peer's fingerprint is known")
+	}
+	return identity.NewSafetyCode(c.peer.LocalFingerprint(), remote), nil
+}
+
+// Verified reports whether the users compared safety codes, in this session
+// or in an earlier one with the same certificates (see WithContacts)
+func (c *ChatClient) Verified() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.verified
+}
+
+// MarkVerified records whether the users' safety codes matched. With
+// WithContacts the outcome is saved for the peer, so later sessions with the
+// same certificate start out verified
+func (c *ChatClient) MarkVerified(verified bool) error {
+	remote := c.peer.RemoteFingerprint()
+	if remote == "" {
+		return fmt.Errorf("cannot verify before the peer's fingerprint is known")
+	}
+
+	c.mu.Lock()
+	c.verified = verified
+	name := c.peerName
+	c.mu.Unlock()
+
+	if c.contacts == nil {
+		return nil
+	}
+	if name == "" {
+		return fmt.Errorf("cannot save verification before the peer has joined")
+	}
+
+	var err error
+	if verified {
+		err = c.contacts.Verify(name, remote)
+	} else {
+		err = c.contacts.Unverify(name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save verification of %q: %w", name, err)
+	}
+	return nil
+}
+
+// checkContact compares the fingerprint of a peer that joined as name with
+// the one in the contact book, remembering it on first contact. A contact
+// verified with this fingerprint makes the session verified
+func (c *ChatClient) checkContact(name string) {
+	if c.contacts == nil {
+		return
+	}
+
+	fingerprint := c.peer.RemoteFingerprint()
+	if fingerprint == "" {
+		return
+	}
+
+	contact, err := c.contacts.Observe(name, fingerprint)
+	switch {
+	case errors.Is(err, identity.ErrFingerprintChanged):
+		c.reportError(err)
+	case err != nil:
+		c.reportError(fmt.Errorf("failed to remember contact %q: %w", name, err))
+	case contact.Verified:
+		c.mu.Lock()
+		c.verified = true
+		c.mu.Unlock()
+	}
+}
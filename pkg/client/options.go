@@ -0,0 +1,150 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/identity"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/protocol"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
+)
+
+// PeerFactory creates the peer a ChatClient talks through
+type PeerFactory func(config webrtc.PeerConfig) (webrtc.Peer, error)
+
+// Logger receives the client's log output. *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// Clock supplies the time for message timestamps and the reconnect timeout.
+// AfterFunc runs f after d and returns a function that cancels it, reporting
+// whether it stopped f from running (like time.Timer.Stop)
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+// IDGenerator returns a new unique id for each outgoing message
+type IDGenerator func() string
+
+// Option customizes a ChatClient created by NewChatClient
+type Option func(*ChatClient)
+
+// WithPeerFactory replaces webrtc.NewRealPeerWithConfig as the way the
+// client's peer is created, e.g. to use a fake or instrumented peer
+func WithPeerFactory(factory PeerFactory) Option {
+	return func(c *ChatClient) {
+		if factory != nil {
+			c.peerFactory = factory
+		}
+	}
+}
+
+// WithPeerConfig sets the ICE servers and transport policy handed to the
+// peer factory. The default is webrtc.DefaultPeerConfig()
+func WithPeerConfig(config webrtc.PeerConfig) Option {
+	return func(c *ChatClient) {
+		c.peerConfig = config
+	}
+}
+
+// WithLogger sends the client's logs to logger instead of the standard logger
+func WithLogger(logger Logger) Option {
+	return func(c *ChatClient) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+// WithClock replaces the system clock, e.g. so tests can control timeouts
+func WithClock(clock Clock) Option {
+	return func(c *ChatClient) {
+		if clock != nil {
+			c.clock = clock
+		}
+	}
+}
+
+// WithIDGenerator sets how ids of outgoing messages are generated
+func WithIDGenerator(generator IDGenerator) Option {
+	return func(c *ChatClient) {
+		if generator != nil {
+			c.newID = generator
+		}
+	}
+}
+
+// WithEventBuffer sets how many undelivered events the client keeps before
+// dropping them. The default is DefaultEventBuffer
+func WithEventBuffer(size int) Option {
+	return func(c *ChatClient) {
+		if size > 0 {
+			c.eventBuffer = size
+		}
+	}
+}
+
+// WithIdentity makes the peer present the identity's certificate, so the
+// DTLS fingerprint stays the same from one session to the next. It takes
+// precedence over a certificate set in WithPeerConfig
+func WithIdentity(id *identity.Identity) Option {
+	return func(c *ChatClient) {
+		c.identity = id
+	}
+}
+
+// WithPrivacy turns on the peer's privacy mode when enabled, on top of
+// WithPeerConfig: only TURN relay candidates are used and room and answer
+// codes never contain the user's addresses. The peer config must have a
+// TURN server. See webrtc.PeerConfig.Privacy
+func WithPrivacy(enabled bool) Option {
+	return func(c *ChatClient) {
+		c.privacy = enabled
+	}
+}
+
+// WithContacts checks the fingerprint of every peer that joins against the
+// contact book. A changed fingerprint is reported as an EventError wrapping
+// identity.ErrFingerprintChanged
+func WithContacts(book *identity.ContactBook) Option {
+	return func(c *ChatClient) {
+		c.contacts = book
+	}
+}
+
+// newRealPeer is the default PeerFactory
+func newRealPeer(config webrtc.PeerConfig) (webrtc.Peer, error) {
+	return webrtc.NewRealPeerWithConfig(config)
+}
+
+// systemClock is the default Clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// randomID is the default IDGenerator: 16 random hex characters
+func randomID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// newMessage creates an outgoing message stamped with the client's clock and id generator
+func (c *ChatClient) newMessage(msgType, text string) protocol.Message {
+	msg := protocol.NewMessage(msgType, c.username, text)
+	msg.ID = c.newID()
+	msg.Timestamp = c.clock.Now().UnixMilli()
+	return msg
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/protocol"
+)
+
+// ReconnectTimeout is how long the client tries to recover a dropped
+// connection with ICE restarts before reporting it as disconnected
+const ReconnectTimeout = 30 * time.Second
+
+// startReconnect begins recovering a dropped connection. Only the host sends
+// restart offers so both sides never offer at once; the guest asks the host
+// to restart in case the host has not noticed the drop yet. Caller must hold c.mu
+func (c *ChatClient) startReconnect() {
+	if err := c.setState(StateReconnecting, nil); err != nil {
+		return
+	}
+	c.stopReconnectTimer = c.clock.AfterFunc(ReconnectTimeout, c.reconnectTimedOut)
+
+	c.logger.Printf("Connection lost, attempting an ICE restart")
+
+	if c.isHost {
+		go c.restartICE()
+		return
+	}
+
+	go func() {
+		if err := c.sendControl(protocol.NewControlMessage(protocol.ControlRestartRequest, "")); err != nil {
+			c.logger.Printf("Failed to request an ICE restart: %v", err)
+		}
+	}()
+}
+
+// finishReconnect is called once the connection is back up. Caller must hold c.mu
+func (c *ChatClient) finishReconnect() {
+	c.stopReconnecting()
+	c.setState(StateConnected, nil)
+	c.logger.Printf("Reconnected to peer")
+}
+
+// reconnectTimedOut gives up on recovering and reports the disconnect
+func (c *ChatClient) reconnectTimedOut() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != StateReconnecting {
+		return
+	}
+	c.stopReconnectTimer = nil
+
+	c.logger.Printf("Could not reconnect within %s", ReconnectTimeout)
+	c.setState(StateFailed, fmt.Errorf("could not reconnect within %s", ReconnectTimeout))
+}
+
+// stopReconnecting cancels the reconnect timeout. Caller must hold c.mu
+func (c *ChatClient) stopReconnecting() {
+	if c.stopReconnectTimer != nil {
+		c.stopReconnectTimer()
+		c.stopReconnectTimer = nil
+	}
+}
+
+// restartICE sends a restart offer to the guest (host only)
+func (c *ChatClient) restartICE() {
+	ctx, cancel := context.WithTimeout(context.Background(), ReconnectTimeout)
+	defer cancel()
+
+	offer, err := c.peer.RestartICE(ctx)
+	if err != nil {
+		c.reportError(fmt.Errorf("failed to restart ICE: %w", err))
+		return
+	}
+
+	if err := c.sendControl(protocol.NewControlMessage(protocol.ControlOffer, offer)); err != nil {
+		c.reportError(fmt.Errorf("failed to send restart offer: %w", err))
+	}
+}
+
+// answerRestart answers a restart offer from the host (guest only)
+func (c *ChatClient) answerRestart(offer string) {
+	ctx, cancel := context.WithTimeout(context.Background(), ReconnectTimeout)
+	defer cancel()
+
+	answer, err := c.peer.CreateAnswerContext(ctx, offer)
+	if err != nil {
+		c.reportError(fmt.Errorf("failed to answer restart offer: %w", err))
+		return
+	}
+
+	if err := c.sendControl(protocol.NewControlMessage(protocol.ControlAnswer, answer)); err != nil {
+		c.reportError(fmt.Errorf("failed to send restart answer: %w", err))
+	}
+}
+
+// Decodes and handles a message received on the control channel
+func (c *ChatClient) handleControl(data []byte) {
+	msg, err := protocol.UnmarshalControl(data)
+	if err != nil {
+		c.reportError(fmt.Errorf("invalid control message received: %w", err))
+		return
+	}
+
+	c.mu.RLock()
+	isHost := c.isHost
+	c.mu.RUnlock()
+
+	c.logger.Printf("Received control message: %s", msg.Kind)
+
+	// Gathering can take a while, so keep it off the channel's read loop
+	switch {
+	case msg.Kind == protocol.ControlOffer && !isHost:
+		go c.answerRestart(msg.SDP)
+	case msg.Kind == protocol.ControlAnswer && isHost:
+		if err := c.peer.SetRemoteAnswer(msg.SDP); err != nil {
+			c.reportError(fmt.Errorf("failed to apply restart answer: %w", err))
+		}
+	case msg.Kind == protocol.ControlRestartRequest && isHost:
+		go c.restartICE()
+	default:
+		c.logger.Printf("Ignoring unexpected control message %q", msg.Kind)
+	}
+}
+
+// sendControl sends a control message over the control channel
+func (c *ChatClient) sendControl(msg protocol.ControlMessage) error {
+	return c.peer.SendChannel(ControlChannel, protocol.MarshalControl(msg))
+}
+
+// reportError logs an error and queues it for the error callback and the event stream
+func (c *ChatClient) reportError(err error) {
+	c.logger.Printf("%v", err)
+	c.publish(Event{Type: EventError, Err: err})
+}
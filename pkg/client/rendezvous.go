@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/signaling"
+)
+
+// CreateRoomOnServer creates a room like CreateRoom, but registers the offer
+// on the rendezvous server at server and returns its short room ID instead
+// of a room code. The guest's answer is applied as soon as the server relays
+// it, and candidates are trickled both ways until the connection is up
+func (c *ChatClient) CreateRoomOnServer(ctx context.Context, server string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.setState(StateOffering, nil); err != nil {
+		return "", fmt.Errorf("cannot create a room: %w", err)
+	}
+
+	// Candidates gathered before the room exists wait in the relay
+	relay := &candidateRelay{}
+	c.peer.OnICECandidate(relay.send)
+
+	roomCode, err := c.createOffer(ctx)
+	if err != nil {
+		c.peer.OnICECandidate(nil)
+		c.setState(StateIdle, nil)
+		return "", err
+	}
+
+	session, err := signaling.HostRoom(ctx, server, roomCode, c.addRemoteCandidate)
+	if err == nil {
+		err = relay.attach(session)
+	}
+	if err != nil {
+		if session != nil {
+			session.Close()
+		}
+		c.peer.OnICECandidate(nil)
+		c.setState(StateIdle, nil)
+		return "", fmt.Errorf("failed to open a room on the rendezvous server: %w", err)
+	}
+
+	c.roomCode = session.Room()
+	c.isHost = true
+	c.session = session
+	c.setState(StateAwaitingAnswer, nil)
+	c.logger.Printf("Created room %s on the rendezvous server", session.Room())
+
+	go c.awaitAnswer(session)
+
+	return session.Room(), nil
+}
+
+// JoinRoomOnServer joins a room created with CreateRoomOnServer. The answer
+// goes back through the rendezvous server, so there is no answer code to
+// pass on; the client moves to StateConnecting
+func (c *ChatClient) JoinRoomOnServer(ctx context.Context, server, roomID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != StateIdle {
+		return fmt.Errorf("cannot join a room: %w: %s -> %s", ErrInvalidTransition, c.state, StateConnecting)
+	}
+
+	session, roomCode, err := signaling.JoinRoom(ctx, server, roomID, c.addRemoteCandidate)
+	if err != nil {
+		return fmt.Errorf("failed to join room %s: %w", roomID, err)
+	}
+
+	// Our candidates must not reach the host before the answer does
+	relay := &candidateRelay{}
+	c.peer.OnICECandidate(relay.send)
+
+	encodedAnswer, err := c.createAnswer(ctx, roomCode)
+	if err == nil {
+		err = session.SendAnswer(encodedAnswer)
+	}
+	if err == nil {
+		err = relay.attach(session)
+	}
+	if err != nil {
+		session.Close()
+		c.peer.OnICECandidate(nil)
+		return err
+	}
+
+	c.roomCode = session.Room()
+	c.session = session
+	c.setState(StateConnecting, nil)
+	c.logger.Printf("Joined room %s on the rendezvous server", session.Room())
+
+	return nil
+}
+
+// awaitAnswer applies the answer relayed by the rendezvous server (host only)
+func (c *ChatClient) awaitAnswer(session *signaling.Session) {
+	answerCode, err := session.WaitAnswer(context.Background())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Disconnected, or the room is someone else's business by now
+	if c.session != session || c.state != StateAwaitingAnswer {
+		return
+	}
+
+	if err == nil {
+		err = c.acceptAnswer(answerCode)
+	}
+	if err != nil {
+		c.setState(StateFailed, fmt.Errorf("no answer from the rendezvous server: %w", err))
+	}
+}
+
+// addRemoteCandidate hands a candidate relayed by the rendezvous server to the peer
+func (c *ChatClient) addRemoteCandidate(candidate string) {
+	if err := c.peer.AddICECandidate(candidate); err != nil {
+		c.logger.Printf("Failed to add remote candidate: %v", err)
+	}
+}
+
+// leaveRendezvous closes the rendezvous session once signaling is over and
+// goes back to complete offers and answers, which ICE restarts rely on.
+// Caller must hold c.mu
+func (c *ChatClient) leaveRendezvous() {
+	c.peer.OnICECandidate(nil)
+	c.session.Close()
+	c.session = nil
+}
+
+// candidateRelay sends local candidates to the rendezvous server, holding
+// those gathered before it has a session to send them on
+type candidateRelay struct {
+	mu      sync.Mutex
+	session *signaling.Session
+	pending []string
+}
+
+func (r *candidateRelay) send(candidate string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.session == nil {
+		r.pending = append(r.pending, candidate)
+		return
+	}
+
+	// Once the session is gone signaling is over and candidates are not needed
+	r.session.SendCandidate(candidate)
+}
+
+// attach sends the held candidates and every later one on session
+func (r *candidateRelay) attach(session *signaling.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, candidate := range r.pending {
+		if err := session.SendCandidate(candidate); err != nil {
+			return err
+		}
+	}
+	r.pending = nil
+	r.session = session
+	return nil
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// State is where the client is in the life of a chat session
+type State int
+
+const (
+	// StateIdle: created, no room yet
+	StateIdle State = iota
+	// StateOffering: CreateRoom is gathering candidates for the room code
+	StateOffering
+	// StateAwaitingAnswer: the room code is out, waiting for AcceptAnswer
+	StateAwaitingAnswer
+	// StateConnecting: offer and answer are exchanged, ICE and DTLS are running
+	StateConnecting
+	// StateConnected: ready to chat
+	StateConnected
+	// StateReconnecting: the connection dropped and an ICE restart is under way
+	StateReconnecting
+	// StateFailed: the connection could not be established or recovered
+	StateFailed
+	// StateClosed: Disconnect was called, the client cannot be used again
+	StateClosed
+)
+
+// ErrInvalidTransition is returned when an operation is not allowed in the current state
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+// transitions lists the states each state can move to
+var transitions = map[State][]State{
+	StateIdle:           {StateOffering, StateConnecting, StateClosed},
+	StateOffering:       {StateAwaitingAnswer, StateIdle, StateClosed},
+	StateAwaitingAnswer: {StateConnecting, StateFailed, StateClosed},
+	StateConnecting:     {StateConnected, StateFailed, StateClosed},
+	StateConnected:      {StateReconnecting, StateFailed, StateClosed},
+	StateReconnecting:   {StateConnected, StateFailed, StateClosed},
+	StateFailed:         {StateClosed},
+	StateClosed:         {},
+}
+
+var stateNames = map[State]string{
+	StateIdle:           "idle",
+	StateOffering:       "offering",
+	StateAwaitingAnswer: "awaiting-answer",
+	StateConnecting:     "connecting",
+	StateConnected:      "connected",
+	StateReconnecting:   "reconnecting",
+	StateFailed:         "failed",
+	StateClosed:         "closed",
+}
+
+var stateDescriptions = map[State]string{
+	StateIdle:           "Not connected",
+	StateOffering:       "Creating room...",
+	StateAwaitingAnswer: "Room created - waiting for connection...",
+	StateConnecting:     "Connecting...",
+	StateConnected:      "Connected - ready to chat!",
+	StateReconnecting:   "Connection lost - reconnecting...",
+	StateFailed:         "Connection failed",
+	StateClosed:         "Disconnected",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Description returns a user-friendly sentence for the state
+func (s State) Description() string {
+	return stateDescriptions[s]
+}
+
+// CanTransitionTo reports whether the state machine allows moving from s to next
+func (s State) CanTransitionTo(next State) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// StateChange describes one transition of the client's state machine
+type StateChange struct {
+	From State
+	To   State
+
+	// Err is why the client moved to StateFailed, nil otherwise
+	Err error
+}
+
+// State returns the current state of the client
+func (c *ChatClient) State() State {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.state
+}
+
+// setState moves the state machine to next. err is recorded as the reason
+// when next is StateFailed. Caller must hold c.mu
+func (c *ChatClient) setState(next State, err error) error {
+	current := c.state
+	if !current.CanTransitionTo(next) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, current, next)
+	}
+
+	c.state = next
+	c.logger.Printf("Client state: %s -> %s", current, next)
+	if c.session != nil && (next == StateConnected || next == StateFailed || next == StateClosed) {
+		c.leaveRendezvous()
+	}
+	c.publish(Event{Type: EventStateChange, State: StateChange{From: current, To: next, Err: err}})
+	return nil
+}
+
+// fireCallbacks adapts state changes to the OnConnected, OnDisconnected,
+// OnReconnecting and OnReconnected callbacks
+func (c *ChatClient) fireCallbacks(change StateChange) {
+	c.mu.RLock()
+	onConnected := c.onConnected
+	onDisconnected := c.onDisconnected
+	onReconnecting := c.onReconnecting
+	onReconnected := c.onReconnected
+	c.mu.RUnlock()
+
+	var callback func()
+	switch {
+	case change.To == StateConnected && change.From == StateReconnecting:
+		callback = onReconnected
+	case change.To == StateConnected:
+		callback = onConnected
+	case change.To == StateReconnecting:
+		callback = onReconnecting
+	case change.To == StateFailed || change.To == StateClosed:
+		callback = onDisconnected
+	}
+
+	if callback != nil {
+		callback()
+	}
+}
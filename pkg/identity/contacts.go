@@ -0,0 +1,212 @@
+package identity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrFingerprintChanged is returned when a known contact presents a
+// different certificate than the one remembered for them
+var ErrFingerprintChanged = errors.New("fingerprint changed")
+
+// FingerprintMismatchError tells which contact changed fingerprint. It
+// matches ErrFingerprintChanged with errors.Is
+type FingerprintMismatchError struct {
+	Contact   string
+	Known     string
+	Presented string
+
+	// Verified tells whether the known fingerprint had been verified
+	Verified bool
+}
+
+func (e *FingerprintMismatchError) Error() string {
+	return fmt.Sprintf("%s: %q presented %s but was known as %s, this may not be the same person",
+		ErrFingerprintChanged, e.Contact, e.Presented, e.Known)
+}
+
+func (e *FingerprintMismatchError) Unwrap() error {
+	return ErrFingerprintChanged
+}
+
+// Contact is what a contact book remembers about a peer
+type Contact struct {
+	Name        string    `json:"name"`
+	Fingerprint string    `json:"fingerprint"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+
+	// Verified is set when the users compared safety codes while the
+	// contact presented Fingerprint
+	Verified bool `json:"verified,omitempty"`
+}
+
+// ContactBook remembers the fingerprint of every peer a local identity has
+// talked to (trust on first use). It is safe for concurrent use
+type ContactBook struct {
+	mu       sync.Mutex
+	path     string
+	contacts map[string]Contact
+}
+
+type contactFile struct {
+	Contacts []Contact `json:"contacts"`
+}
+
+// Contacts opens the contact book of the identity called name
+func (k *Keystore) Contacts(name string) (*ContactBook, error) {
+	path, err := k.path(name, ".contacts.json")
+	if err != nil {
+		return nil, err
+	}
+
+	book := &ContactBook{path: path, contacts: make(map[string]Contact)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return book, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contacts of %q: %w", name, err)
+	}
+
+	var file contactFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid contacts of %q: %w", name, err)
+	}
+	for _, contact := range file.Contacts {
+		book.contacts[contact.Name] = contact
+	}
+
+	return book, nil
+}
+
+// Lookup returns what is remembered about a contact
+func (b *ContactBook) Lookup(name string) (Contact, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	contact, ok := b.contacts[name]
+	return contact, ok
+}
+
+// List returns every contact, sorted by name
+func (b *ContactBook) List() []Contact {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := make([]Contact, 0, len(b.contacts))
+	for _, contact := range b.contacts {
+		list = append(list, contact)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Observe records that name presented fingerprint. A new contact is
+// remembered, a known one gets its LastSeen updated. If the fingerprint
+// differs from the remembered one the book is left unchanged and a
+// *FingerprintMismatchError is returned; call Trust to accept the new one
+func (b *ContactBook) Observe(name, fingerprint string) (Contact, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	contact, known := b.contacts[name]
+	if known && contact.Fingerprint != fingerprint {
+		return contact, &FingerprintMismatchError{
+			Contact:   name,
+			Known:     contact.Fingerprint,
+			Presented: fingerprint,
+			Verified:  contact.Verified,
+		}
+	}
+
+	if !known {
+		contact = Contact{Name: name, Fingerprint: fingerprint, FirstSeen: now}
+	}
+	contact.LastSeen = now
+	b.contacts[name] = contact
+
+	return contact, b.save()
+}
+
+// Trust replaces the fingerprint remembered for name, e.g. after the user
+// confirmed that the contact really did get a new certificate. The contact
+// is no longer verified
+func (b *ContactBook) Trust(name, fingerprint string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.contacts[name] = Contact{Name: name, Fingerprint: fingerprint, FirstSeen: now, LastSeen: now}
+	return b.save()
+}
+
+// Verify marks name as verified with fingerprint, after the users compared
+// safety codes. A verified fingerprint replaces a different remembered one
+func (b *ContactBook) Verify(name, fingerprint string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	contact, known := b.contacts[name]
+	if !known || contact.Fingerprint != fingerprint {
+		contact = Contact{Name: name, Fingerprint: fingerprint, FirstSeen: now}
+	}
+	contact.LastSeen = now
+	contact.Verified = true
+	b.contacts[name] = contact
+
+	return b.save()
+}
+
+// Unverify clears the verified mark of name, e.g. when the safety codes did
+// not match
+func (b *ContactBook) Unverify(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	contact, known := b.contacts[name]
+	if !known || !contact.Verified {
+		return nil
+	}
+	contact.Verified = false
+	b.contacts[name] = contact
+
+	return b.save()
+}
+
+// Forget removes a contact
+func (b *ContactBook) Forget(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.contacts, name)
+	return b.save()
+}
+
+// save writes the book to disk. Caller must hold b.mu
+func (b *ContactBook) save() error {
+	var file contactFile
+	for _, contact := range b.contacts {
+		file.Contacts = append(file.Contacts, contact)
+	}
+	sort.Slice(file.Contacts, func(i, j int) bool { return file.Contacts[i].Name < file.Contacts[j].Name })
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode contacts: %w", err)
+	}
+
+	if err := writeFile(b.path, data); err != nil {
+		return fmt.Errorf("failed to save contacts: %w", err)
+	}
+	return nil
+}
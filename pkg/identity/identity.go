@@ -0,0 +1,106 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
+)
+
+// Lifetime is how long a generated identity certificate is valid
+const Lifetime = 10 * 365 * 24 * time.Hour
+
+// Identity is a local user's long-lived DTLS certificate. Handing it to
+// every peer makes the fingerprint in the SDP stable across sessions
+type Identity struct {
+	Name        string
+	Certificate tls.Certificate
+}
+
+// Generate creates a new identity with a self-signed ECDSA P-256 certificate
+func Generate(name string) (*Identity, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "p2p-chat"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(Lifetime),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	return &Identity{
+		Name: name,
+		Certificate: tls.Certificate{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+			Leaf:        leaf,
+		},
+	}, nil
+}
+
+// Fingerprint returns the fingerprint peers see in the SDP ("sha-256 AB:CD:...")
+func (id *Identity) Fingerprint() string {
+	return webrtc.CertificateFingerprint(id.Certificate.Leaf)
+}
+
+// Expires returns when the identity certificate stops being valid
+func (id *Identity) Expires() time.Time {
+	return id.Certificate.Leaf.NotAfter
+}
+
+// MarshalPEM encodes the certificate and its private key as PEM
+func (id *Identity) MarshalPEM() ([]byte, error) {
+	key, err := x509.MarshalPKCS8PrivateKey(id.Certificate.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: id.Certificate.Certificate[0]})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})...)
+	return data, nil
+}
+
+// ParsePEM decodes an identity written by MarshalPEM
+func ParsePEM(name string, data []byte) (*Identity, error) {
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity %q: %w", name, err)
+	}
+
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity %q: %w", name, err)
+		}
+		cert.Leaf = leaf
+	}
+
+	return &Identity{Name: name, Certificate: cert}, nil
+}
@@ -0,0 +1,173 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expiredPEM encodes an identity whose certificate expired a minute ago
+func expiredPEM(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(-time.Minute),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	id := &Identity{Certificate: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+	data, err := id.MarshalPEM()
+	require.NoError(t, err)
+	return data
+}
+
+func TestGenerate(t *testing.T) {
+	id, err := Generate("alice")
+	require.NoError(t, err)
+
+	assert.Equal(t, "alice", id.Name)
+	assert.Regexp(t, `^sha-256 ([0-9A-F]{2}:){31}[0-9A-F]{2}$`, id.Fingerprint())
+	assert.WithinDuration(t, time.Now().Add(Lifetime), id.Expires(), time.Hour)
+
+	data, err := id.MarshalPEM()
+	require.NoError(t, err)
+
+	parsed, err := ParsePEM("alice", data)
+	require.NoError(t, err)
+	assert.Equal(t, id.Fingerprint(), parsed.Fingerprint())
+	assert.NotNil(t, parsed.Certificate.PrivateKey)
+
+	_, err = ParsePEM("alice", []byte("garbage"))
+	assert.Error(t, err)
+}
+
+func TestKeystore_Load(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "identity")
+	keystore, err := OpenKeystore(dir)
+	require.NoError(t, err)
+
+	first, err := keystore.Load("alice")
+	require.NoError(t, err)
+
+	// The same identity comes back on the next run
+	reopened, err := OpenKeystore(dir)
+	require.NoError(t, err)
+	second, err := reopened.Load("alice")
+	require.NoError(t, err)
+	assert.Equal(t, first.Fingerprint(), second.Fingerprint())
+
+	other, err := keystore.Load("bob")
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Fingerprint(), other.Fingerprint())
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Join(dir, "alice.pem"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	}
+}
+
+func TestKeystore_Names(t *testing.T) {
+	dir := t.TempDir()
+	keystore, err := OpenKeystore(dir)
+	require.NoError(t, err)
+
+	_, err = keystore.Load("")
+	assert.Error(t, err)
+
+	_, err = keystore.Load(string(make([]byte, MaxNameLength+1)))
+	assert.Error(t, err)
+
+	// Names are usernames and must not escape the keystore
+	for _, name := range []string{"../evil", "..", "a/b", "Jane Doe"} {
+		_, err := keystore.Load(name)
+		require.NoError(t, err, name)
+	}
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	assert.ElementsMatch(t, []string{"%2E.%2Fevil.pem", "%2E..pem", "a%2Fb.pem", "Jane%20Doe.pem"}, names)
+}
+
+func TestKeystore_Expired(t *testing.T) {
+	dir := t.TempDir()
+	keystore, err := OpenKeystore(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "alice.pem"), expiredPEM(t), 0o600))
+
+	_, err = keystore.Load("alice")
+	assert.ErrorContains(t, err, "expired")
+}
+
+func TestContactBook(t *testing.T) {
+	keystore, err := OpenKeystore(t.TempDir())
+	require.NoError(t, err)
+
+	book, err := keystore.Contacts("alice")
+	require.NoError(t, err)
+
+	_, ok := book.Lookup("bob")
+	assert.False(t, ok)
+
+	// Trust on first use
+	contact, err := book.Observe("bob", "sha-256 AA")
+	require.NoError(t, err)
+	assert.Equal(t, "sha-256 AA", contact.Fingerprint)
+	assert.False(t, contact.FirstSeen.IsZero())
+
+	_, err = book.Observe("bob", "sha-256 AA")
+	require.NoError(t, err)
+
+	// A different certificate is flagged and not remembered
+	_, err = book.Observe("bob", "sha-256 BB")
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrFingerprintChanged))
+
+	var mismatch *FingerprintMismatchError
+	require.True(t, errors.As(err, &mismatch))
+	assert.Equal(t, "bob", mismatch.Contact)
+	assert.Equal(t, "sha-256 AA", mismatch.Known)
+	assert.Equal(t, "sha-256 BB", mismatch.Presented)
+
+	// The book survives a restart
+	reopened, err := keystore.Contacts("alice")
+	require.NoError(t, err)
+	contact, ok = reopened.Lookup("bob")
+	require.True(t, ok)
+	assert.Equal(t, "sha-256 AA", contact.Fingerprint)
+
+	// Contact books are per identity
+	other, err := keystore.Contacts("carol")
+	require.NoError(t, err)
+	assert.Empty(t, other.List())
+
+	require.NoError(t, reopened.Trust("bob", "sha-256 BB"))
+	_, err = reopened.Observe("bob", "sha-256 BB")
+	assert.NoError(t, err)
+
+	require.NoError(t, reopened.Forget("bob"))
+	assert.Empty(t, reopened.List())
+}
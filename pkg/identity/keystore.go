@@ -0,0 +1,139 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MaxNameLength is the longest identity name a keystore accepts
+const MaxNameLength = 64
+
+// Keystore keeps identities and contact books in a private directory
+type Keystore struct {
+	dir string
+}
+
+// DefaultDir returns the keystore directory under the user's config directory
+func DefaultDir() (string, error) {
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find config directory: %w", err)
+	}
+	return filepath.Join(config, "p2p-chat", "identity"), nil
+}
+
+// OpenKeystore opens the keystore in dir, creating the directory if needed
+func OpenKeystore(dir string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create keystore: %w", err)
+	}
+	return &Keystore{dir: dir}, nil
+}
+
+// Dir returns the keystore directory
+func (k *Keystore) Dir() string {
+	return k.dir
+}
+
+// Load returns the identity called name, generating and saving it on first
+// use. An expired identity is an error rather than silently replaced, since
+// a new certificate changes the fingerprint every contact remembers
+func (k *Keystore) Load(name string) (*Identity, error) {
+	path, err := k.path(name, ".pem")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return k.create(name, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity %q: %w", name, err)
+	}
+
+	id, err := ParsePEM(name, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Now().After(id.Expires()) {
+		return nil, fmt.Errorf("identity %q expired on %s, delete %s to create a new one",
+			name, id.Expires().Format(time.DateOnly), path)
+	}
+
+	return id, nil
+}
+
+// create generates a new identity and writes it to path
+func (k *Keystore) create(name, path string) (*Identity, error) {
+	id, err := Generate(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := id.MarshalPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFile(path, data); err != nil {
+		return nil, fmt.Errorf("failed to save identity %q: %w", name, err)
+	}
+
+	return id, nil
+}
+
+// path maps a name to a file in the keystore. Names are free text (they are
+// usernames), so anything that is not safe in a file name is escaped
+func (k *Keystore) path(name, suffix string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("identity name cannot be empty")
+	}
+	if len(name) > MaxNameLength {
+		return "", fmt.Errorf("identity name too long (max %d characters)", MaxNameLength)
+	}
+
+	var file strings.Builder
+	for i := 0; i < len(name); i++ {
+		b := name[i]
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9',
+			b == '-', b == '_', b == '.' && i > 0:
+			file.WriteByte(b)
+		default:
+			fmt.Fprintf(&file, "%%%02X", b)
+		}
+	}
+
+	return filepath.Join(k.dir, file.String()+suffix), nil
+}
+
+// writeFile replaces path with data, readable only by the owner. It writes
+// to a temporary file first so a crash never leaves a truncated file behind
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
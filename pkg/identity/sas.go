@@ -0,0 +1,86 @@
+package identity
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Symbol is one emoji of a safety code with a name to read out loud
+type Symbol struct {
+	Emoji string
+	Name  string
+}
+
+// symbols are the 64 emoji a safety code is written with, chosen to be
+// easy to tell apart and to name
+var symbols = [64]Symbol{
+	{"🐶", "Dog"}, {"🐱", "Cat"}, {"🦁", "Lion"}, {"🐎", "Horse"},
+	{"🦄", "Unicorn"}, {"🐷", "Pig"}, {"🐘", "Elephant"}, {"🐰", "Rabbit"},
+	{"🐼", "Panda"}, {"🐓", "Rooster"}, {"🐧", "Penguin"}, {"🐢", "Turtle"},
+	{"🐟", "Fish"}, {"🐙", "Octopus"}, {"🦋", "Butterfly"}, {"🌷", "Flower"},
+	{"🌳", "Tree"}, {"🌵", "Cactus"}, {"🍄", "Mushroom"}, {"🌏", "Globe"},
+	{"🌙", "Moon"}, {"☁️", "Cloud"}, {"🔥", "Fire"}, {"🍌", "Banana"},
+	{"🍎", "Apple"}, {"🍓", "Strawberry"}, {"🌽", "Corn"}, {"🍕", "Pizza"},
+	{"🎂", "Cake"}, {"❤️", "Heart"}, {"😀", "Smiley"}, {"🤖", "Robot"},
+	{"🎩", "Hat"}, {"👓", "Glasses"}, {"🔧", "Spanner"}, {"🎅", "Santa"},
+	{"👍", "Thumbs Up"}, {"☂️", "Umbrella"}, {"⌛", "Hourglass"}, {"⏰", "Clock"},
+	{"🎁", "Gift"}, {"💡", "Light Bulb"}, {"📕", "Book"}, {"✏️", "Pencil"},
+	{"📎", "Paperclip"}, {"✂️", "Scissors"}, {"🔒", "Lock"}, {"🔑", "Key"},
+	{"🔨", "Hammer"}, {"☎️", "Telephone"}, {"🏁", "Flag"}, {"🚂", "Train"},
+	{"🚲", "Bicycle"}, {"✈️", "Aeroplane"}, {"🚀", "Rocket"}, {"🏆", "Trophy"},
+	{"⚽", "Ball"}, {"🎸", "Guitar"}, {"🎺", "Trumpet"}, {"🔔", "Bell"},
+	{"⚓", "Anchor"}, {"🎧", "Headphones"}, {"📁", "Folder"}, {"📌", "Pin"},
+}
+
+const (
+	// safetyCodeGroups is how many 4-digit groups the numeric code has (78 bits)
+	safetyCodeGroups = 6
+	// safetyCodeSymbols is how many emoji the emoji code has (48 bits)
+	safetyCodeSymbols = 8
+)
+
+// SafetyCode is a short authentication string derived from the DTLS
+// fingerprints of both ends of a connection. If the room or answer code was
+// swapped on the way, each side is talking to a different certificate and
+// the two users see different codes
+type SafetyCode struct {
+	sum [sha256.Size]byte
+}
+
+// NewSafetyCode derives the safety code of a connection from the two
+// fingerprints. The order does not matter, so both sides get the same code
+func NewSafetyCode(local, remote string) SafetyCode {
+	if remote < local {
+		local, remote = remote, local
+	}
+	return SafetyCode{sum: sha256.Sum256([]byte("p2p-chat safety code v1\n" + local + "\n" + remote))}
+}
+
+// Numeric returns the code as groups of 4 digits, e.g. "4821 1093 ..."
+func (c SafetyCode) Numeric() string {
+	groups := make([]string, safetyCodeGroups)
+	for i := range groups {
+		// 13 bits per group, shifted to always have 4 digits
+		value := binary.BigEndian.Uint16(c.sum[2*i:]) >> 3
+		groups[i] = fmt.Sprintf("%d", 1000+int(value))
+	}
+	return strings.Join(groups, " ")
+}
+
+// Emoji returns the code as emoji, 6 bits each
+func (c SafetyCode) Emoji() []Symbol {
+	bits := binary.BigEndian.Uint64(c.sum[2*safetyCodeGroups:])
+
+	code := make([]Symbol, safetyCodeSymbols)
+	for i := range code {
+		code[i] = symbols[bits>>(58-6*i)&63]
+	}
+	return code
+}
+
+// String returns the numeric code
+func (c SafetyCode) String() string {
+	return c.Numeric()
+}
@@ -0,0 +1,78 @@
+package identity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafetyCode(t *testing.T) {
+	alice := "sha-256 AA:AA"
+	bob := "sha-256 BB:BB"
+	mallory := "sha-256 CC:CC"
+
+	// Both sides see the same code
+	code := NewSafetyCode(alice, bob)
+	assert.Equal(t, code, NewSafetyCode(bob, alice))
+	assert.Regexp(t, `^([1-9][0-9]{3} ){5}[1-9][0-9]{3}$`, code.Numeric())
+	assert.Equal(t, code.Numeric(), code.String())
+	assert.Len(t, code.Emoji(), safetyCodeSymbols)
+
+	// A swapped code gives each side a different one
+	intercepted := NewSafetyCode(alice, mallory)
+	assert.NotEqual(t, code.Numeric(), intercepted.Numeric())
+	assert.NotEqual(t, code.Emoji(), intercepted.Emoji())
+}
+
+func TestSafetyCode_Symbols(t *testing.T) {
+	emoji := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, symbol := range symbols {
+		require.NotEmpty(t, symbol.Emoji)
+		require.NotEmpty(t, symbol.Name)
+		emoji[symbol.Emoji] = true
+		names[symbol.Name] = true
+	}
+	assert.Len(t, emoji, len(symbols))
+	assert.Len(t, names, len(symbols))
+}
+
+func TestContactBook_Verify(t *testing.T) {
+	keystore, err := OpenKeystore(t.TempDir())
+	require.NoError(t, err)
+	book, err := keystore.Contacts("alice")
+	require.NoError(t, err)
+
+	_, err = book.Observe("bob", "sha-256 AA")
+	require.NoError(t, err)
+	require.NoError(t, book.Verify("bob", "sha-256 AA"))
+
+	// The mark is persisted and survives later sessions
+	reopened, err := keystore.Contacts("alice")
+	require.NoError(t, err)
+	contact, err := reopened.Observe("bob", "sha-256 AA")
+	require.NoError(t, err)
+	assert.True(t, contact.Verified)
+
+	// A changed fingerprint reports that the old one was verified
+	_, err = reopened.Observe("bob", "sha-256 BB")
+	var mismatch *FingerprintMismatchError
+	require.ErrorAs(t, err, &mismatch)
+	assert.True(t, mismatch.Verified)
+
+	// Trusting a new fingerprint drops the mark, verifying it sets it again
+	require.NoError(t, reopened.Trust("bob", "sha-256 BB"))
+	contact, _ = reopened.Lookup("bob")
+	assert.False(t, contact.Verified)
+
+	require.NoError(t, reopened.Verify("bob", "sha-256 CC"))
+	contact, _ = reopened.Lookup("bob")
+	assert.True(t, contact.Verified)
+	assert.Equal(t, "sha-256 CC", contact.Fingerprint)
+
+	require.NoError(t, reopened.Unverify("bob"))
+	contact, _ = reopened.Lookup("bob")
+	assert.False(t, contact.Verified)
+	assert.Equal(t, "sha-256 CC", contact.Fingerprint)
+}
@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ControlMessage carries session management data between peers, such as the
+// offer/answer of an ICE restart. It travels on its own data channel so it
+// never mixes with chat messages
+type ControlMessage struct {
+	Kind string `json:"kind"`
+	SDP  string `json:"sdp,omitempty"`
+}
+
+const (
+	// Control message kinds
+	ControlOffer          = "offer"
+	ControlAnswer         = "answer"
+	ControlRestartRequest = "restart-request"
+
+	// An SDP with many candidates is far bigger than a chat message
+	MaxControlSize = 64 * 1024
+)
+
+// NewControlMessage creates a control message of the given kind
+func NewControlMessage(kind, sdp string) ControlMessage {
+	return ControlMessage{
+		Kind: kind,
+		SDP:  sdp,
+	}
+}
+
+// MarshalControl converts a ControlMessage to JSON bytes with a trailing newline
+func MarshalControl(msg ControlMessage) []byte {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return []byte("{}\n")
+	}
+
+	return append(data, '\n')
+}
+
+// UnmarshalControl parses JSON data into a ControlMessage with validation
+func UnmarshalControl(data []byte) (ControlMessage, error) {
+	if len(data) > MaxControlSize {
+		return ControlMessage{}, errors.New("control message exceeds maximum size")
+	}
+
+	var msg ControlMessage
+	if err := json.Unmarshal([]byte(strings.TrimSuffix(string(data), "\n")), &msg); err != nil {
+		return ControlMessage{}, errors.New("invalid JSON format")
+	}
+
+	if err := validateControl(msg); err != nil {
+		return ControlMessage{}, err
+	}
+
+	return msg, nil
+}
+
+// validateControl checks the kind and that offers and answers carry an SDP
+func validateControl(msg ControlMessage) error {
+	switch msg.Kind {
+	case "":
+		return errors.New("control message kind is required")
+	case ControlOffer, ControlAnswer:
+		if msg.SDP == "" {
+			return errors.New("control message requires an SDP")
+		}
+	case ControlRestartRequest:
+	default:
+		return errors.New("invalid control message kind")
+	}
+
+	return nil
+}
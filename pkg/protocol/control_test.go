@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlMessage_RoundTrip(t *testing.T) {
+	sdp := `{"type":"offer","sdp":"v=0\r\na=ice-ufrag:abcd\r\n"}`
+	original := NewControlMessage(ControlOffer, sdp)
+
+	data := MarshalControl(original)
+	assert.True(t, strings.HasSuffix(string(data), "\n"))
+
+	parsed, err := UnmarshalControl(data)
+	require.NoError(t, err)
+	assert.Equal(t, original, parsed)
+}
+
+func TestUnmarshalControl_Invalid(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"invalid json", `{"kind":`, "invalid JSON format"},
+		{"missing kind", `{"sdp":"x"}`, "control message kind is required"},
+		{"unknown kind", `{"kind":"reboot"}`, "invalid control message kind"},
+		{"offer without sdp", `{"kind":"offer"}`, "control message requires an SDP"},
+		{"answer without sdp", `{"kind":"answer"}`, "control message requires an SDP"},
+		{"too large", `{"kind":"offer","sdp":"` + strings.Repeat("a", MaxControlSize) + `"}`, "control message exceeds maximum size"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := UnmarshalControl([]byte(tc.data))
+			require.Error(t, err)
+			assert.Equal(t, tc.err, err.Error())
+		})
+	}
+}
+
+func TestUnmarshalControl_RestartRequest(t *testing.T) {
+	msg, err := UnmarshalControl(MarshalControl(NewControlMessage(ControlRestartRequest, "")))
+	require.NoError(t, err)
+	assert.Equal(t, ControlRestartRequest, msg.Kind)
+	assert.Empty(t, msg.SDP)
+}
@@ -9,6 +9,8 @@ import (
 
 // Message represents a chat message in the protocol
 type Message struct {
+	// ID is optional; clients set it to tell messages apart
+	ID        string `json:"id,omitempty"`
 	Type      string `json:"type"`
 	From      string `json:"from"`
 	Text      string `json:"text"`
@@ -21,8 +23,13 @@ const (
 	TypeJoin  = "join"
 	TypeLeave = "leave"
 
+	// Ephemeral message types, sent without retransmission (latest value wins)
+	TypeTyping   = "typing"
+	TypePresence = "presence"
+
 	// Validation constraints
 	MaxTextLength = 1000
+	MaxIDLength   = 64
 )
 
 // NewMessage creates a new message with the current timestamp
@@ -81,7 +88,7 @@ func validateMessage(msg Message) error {
 	}
 	
 	// Validate message type
-	if msg.Type != TypeChat && msg.Type != TypeJoin && msg.Type != TypeLeave {
+	if msg.Type != TypeChat && msg.Type != TypeJoin && msg.Type != TypeLeave && !IsEphemeral(msg.Type) {
 		return errors.New("invalid message type")
 	}
 	
@@ -90,6 +97,10 @@ func validateMessage(msg Message) error {
 		return errors.New("message text exceeds maximum length")
 	}
 	
+	if len(msg.ID) > MaxIDLength {
+		return errors.New("message id exceeds maximum length")
+	}
+	
 	// Timestamp validation (should be positive)
 	if msg.Timestamp < 0 {
 		return errors.New("invalid timestamp")
@@ -98,6 +109,12 @@ func validateMessage(msg Message) error {
 	return nil
 }
 
+// IsEphemeral reports whether a message type may be lost or superseded
+// (typing indicators, presence pings) rather than delivered reliably
+func IsEphemeral(msgType string) bool {
+	return msgType == TypeTyping || msgType == TypePresence
+}
+
 // IsValid checks if a message is valid without returning specific error details
 func (m Message) IsValid() bool {
 	return validateMessage(m) == nil
@@ -354,4 +354,45 @@ func (suite *MessageTestSuite) TestEdgeCases() {
 		}
 		assert.True(t, msg.IsValid())
 	})
-}
\ No newline at end of file
+}
+
+// Test ephemeral message types
+func (suite *MessageTestSuite) TestEphemeralTypes() {
+	t := suite.T()
+
+	assert.True(t, IsEphemeral(TypeTyping))
+	assert.True(t, IsEphemeral(TypePresence))
+	assert.False(t, IsEphemeral(TypeChat))
+	assert.False(t, IsEphemeral(TypeJoin))
+	assert.False(t, IsEphemeral("invalid"))
+
+	msg := NewMessage(TypeTyping, "alice", "")
+	data := Marshal(msg)
+
+	result, err := Unmarshal(data)
+	require.NoError(t, err)
+	assert.Equal(t, msg, result)
+
+	presence := NewMessage(TypePresence, "bob", "away")
+	assert.True(t, presence.IsValid())
+}
+
+// Test the optional message id
+func (suite *MessageTestSuite) TestMessageID() {
+	t := suite.T()
+
+	// Messages without an id keep the original wire format
+	msg := Message{Type: TypeChat, From: "alice", Text: "hi", Timestamp: 1}
+	assert.NotContains(t, string(Marshal(msg)), `"id"`)
+
+	msg.ID = "a1b2c3"
+	data := Marshal(msg)
+	assert.Contains(t, string(data), `"id":"a1b2c3"`)
+
+	result, err := Unmarshal(data)
+	require.NoError(t, err)
+	assert.Equal(t, "a1b2c3", result.ID)
+
+	msg.ID = strings.Repeat("x", MaxIDLength+1)
+	assert.False(t, msg.IsValid())
+}
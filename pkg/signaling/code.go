@@ -0,0 +1,236 @@
+package signaling
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Errors returned when a code cannot be used. They are wrapped, check them
+// with errors.Is
+var (
+	ErrWrongKind          = errors.New("wrong kind of code")
+	ErrExpired            = errors.New("code has expired")
+	ErrCorrupted          = errors.New("code is corrupted or incomplete")
+	ErrUnsupportedVersion = errors.New("code was made by an unsupported version of p2p-chat")
+)
+
+// Kind tells room codes (offers) from answer codes
+type Kind byte
+
+const (
+	KindOffer  Kind = 'o'
+	KindAnswer Kind = 'a'
+)
+
+// String names the kind the way users know it
+func (k Kind) String() string {
+	switch k {
+	case KindOffer:
+		return "room code"
+	case KindAnswer:
+		return "answer code"
+	default:
+		return "unknown code"
+	}
+}
+
+const (
+	// codePrefix starts every code, followed by the version, the kind and a dash
+	codePrefix = "p2p"
+
+	// codeVersion is the current code format
+	codeVersion = '1'
+
+	// MaxCodeAge is how long a code can be used after it was created
+	MaxCodeAge = 24 * time.Hour
+
+	// maxClockSkew tolerates codes from peers whose clock is ahead
+	maxClockSkew = 10 * time.Minute
+)
+
+// now is the clock codes are stamped and checked with
+var now = time.Now
+
+// Code is a decoded room or answer code
+type Code struct {
+	Kind        Kind
+	Created     time.Time
+	Description string
+}
+
+// EncodeOffer makes a room code from an offer
+func EncodeOffer(description string) (string, error) {
+	return encodeCode(KindOffer, description)
+}
+
+// EncodeAnswer makes an answer code from an answer
+func EncodeAnswer(description string) (string, error) {
+	return encodeCode(KindAnswer, description)
+}
+
+// encodeCode builds "p2p1o-" + base64url(timestamp, description, checksum).
+// The description is minified when possible and gzipped otherwise
+func encodeCode(kind Kind, description string) (string, error) {
+	if description == "" {
+		return "", fmt.Errorf("SDP cannot be empty")
+	}
+
+	body, err := compact(description)
+	if err != nil {
+		return "", err
+	}
+
+	header := codeHeader(codeVersion, kind)
+	payload := binary.BigEndian.AppendUint32(nil, uint32(now().Unix()))
+	payload = append(payload, body...)
+	payload = binary.BigEndian.AppendUint32(payload, checksum(header, payload))
+
+	return header + base64.RawURLEncoding.EncodeToString(payload), nil
+}
+
+// DecodeOffer reads a room code and fails with ErrWrongKind for anything else
+func DecodeOffer(code string) (string, error) {
+	return decodeKind(code, KindOffer)
+}
+
+// DecodeAnswer reads an answer code and fails with ErrWrongKind for anything else
+func DecodeAnswer(code string) (string, error) {
+	return decodeKind(code, KindAnswer)
+}
+
+func decodeKind(code string, want Kind) (string, error) {
+	parsed, err := ParseCode(code)
+	if err != nil {
+		return "", err
+	}
+
+	// Codes without a header predate kinds
+	if parsed.Kind != 0 && parsed.Kind != want {
+		return "", fmt.Errorf("%w: this is %s %s, not %s %s", ErrWrongKind,
+			article(parsed.Kind), parsed.Kind, article(want), want)
+	}
+	return parsed.Description, nil
+}
+
+// ParseCode decodes any code, spelled as words or not. Whitespace and line
+// breaks inserted by messaging apps are ignored. Codes from before
+// versioning are still read, with no kind and no creation time
+func ParseCode(code string) (*Code, error) {
+	if isWords(code) {
+		text, err := FromWords(code)
+		if err != nil {
+			return nil, err
+		}
+		code = text
+	}
+	code = stripSpaces(code)
+
+	if !strings.HasPrefix(code, codePrefix) {
+		description, err := decodeBody(code)
+		if err != nil {
+			return nil, err
+		}
+		return &Code{Description: description}, nil
+	}
+
+	rest := code[len(codePrefix):]
+	if len(rest) < 3 || rest[2] != '-' {
+		return nil, fmt.Errorf("%w: invalid header", ErrCorrupted)
+	}
+	if rest[0] != codeVersion {
+		return nil, fmt.Errorf("%w: version %c", ErrUnsupportedVersion, rest[0])
+	}
+
+	header := code[:len(codePrefix)+3]
+	payload, err := base64.RawURLEncoding.DecodeString(rest[3:])
+	if err != nil || len(payload) < 8 {
+		return nil, fmt.Errorf("%w: invalid characters or truncated", ErrCorrupted)
+	}
+	data, sum := payload[:len(payload)-4], binary.BigEndian.Uint32(payload[len(payload)-4:])
+	if checksum(header, data) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+
+	kind := Kind(rest[1])
+	if kind != KindOffer && kind != KindAnswer {
+		return nil, fmt.Errorf("%w: kind %q", ErrUnsupportedVersion, rest[1])
+	}
+
+	created := time.Unix(int64(binary.BigEndian.Uint32(data)), 0)
+	if age := now().Sub(created); age > MaxCodeAge {
+		return nil, fmt.Errorf("%w: created %s ago, codes last %s", ErrExpired, age.Round(time.Minute), MaxCodeAge)
+	} else if age < -maxClockSkew {
+		return nil, fmt.Errorf("%w: created in the future, check your clock", ErrCorrupted)
+	}
+
+	description, err := expandBody(data[4:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCorrupted, err)
+	}
+	return &Code{Kind: kind, Created: created, Description: description}, nil
+}
+
+func codeHeader(version byte, kind Kind) string {
+	return fmt.Sprintf("%s%c%c-", codePrefix, version, kind)
+}
+
+// checksum covers the header too, so an edited kind is caught
+func checksum(header string, data []byte) uint32 {
+	sum := crc32.ChecksumIEEE([]byte(header))
+	return crc32.Update(sum, crc32.IEEETable, data)
+}
+
+// compact is the binary of EncodeCompact: minified, or gzip as a fallback
+func compact(description string) ([]byte, error) {
+	data, err := Minify(description)
+	if errors.Is(err, ErrNotMinifiable) {
+		if len(description) > MaxSDPSize {
+			return nil, fmt.Errorf("SDP too large: %d bytes (max %d)", len(description), MaxSDPSize)
+		}
+		data, err = compressString(description)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress SDP: %w", err)
+		}
+	}
+	return data, err
+}
+
+// expandBody reverses compact
+func expandBody(data []byte) (string, error) {
+	if isMinified(data) {
+		return Expand(data)
+	}
+
+	description, err := decompressBytes(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decompress data: %w", err)
+	}
+	if len(description) > MaxSDPSize {
+		return "", fmt.Errorf("decompressed SDP too large: %d bytes (max %d)", len(description), MaxSDPSize)
+	}
+	return description, nil
+}
+
+// stripSpaces drops whitespace, including the zero width kinds chat apps
+// use to break long words
+func stripSpaces(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '\u200b' || r == '\u200c' || r == '\u200d' || r == '\ufeff' || r == '\u00ad' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func article(kind Kind) string {
+	if kind == KindAnswer {
+		return "an"
+	}
+	return "a"
+}
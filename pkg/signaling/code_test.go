@@ -0,0 +1,200 @@
+package signaling
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setNow fakes the clock for one test
+func setNow(t *testing.T, at time.Time) {
+	t.Helper()
+
+	previous := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = previous })
+}
+
+func TestEncodeOffer(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	setNow(t, created)
+
+	// Minified, and gzipped
+	for _, description := range []string{wrap("offer", minimalSDP), "Hello, World!"} {
+		code, err := EncodeOffer(description)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(code, "p2p1o-"), code)
+
+		parsed, err := ParseCode(code)
+		require.NoError(t, err)
+		assert.Equal(t, KindOffer, parsed.Kind)
+		assert.True(t, created.Equal(parsed.Created), parsed.Created)
+
+		decoded, err := DecodeOffer(code)
+		require.NoError(t, err)
+		assert.Equal(t, parsed.Description, decoded)
+
+		decoded, err = Decode(code)
+		require.NoError(t, err)
+		assert.Equal(t, parsed.Description, decoded)
+
+		if strings.HasPrefix(description, "{") {
+			assertSameDescription(t, description, decoded)
+		} else {
+			assert.Equal(t, description, decoded)
+		}
+	}
+
+	answer, err := EncodeAnswer(wrap("answer", realisticSDP))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(answer, "p2p1a-"), answer)
+
+	_, err = EncodeOffer("")
+	assert.Error(t, err)
+}
+
+func TestDecodeOffer_WrongKind(t *testing.T) {
+	offer, err := EncodeOffer(wrap("offer", realisticSDP))
+	require.NoError(t, err)
+	answer, err := EncodeAnswer(wrap("answer", realisticSDP))
+	require.NoError(t, err)
+
+	_, err = DecodeAnswer(offer)
+	assert.ErrorIs(t, err, ErrWrongKind)
+	assert.EqualError(t, err, "wrong kind of code: this is a room code, not an answer code")
+
+	_, err = DecodeOffer(answer)
+	assert.ErrorIs(t, err, ErrWrongKind)
+	assert.EqualError(t, err, "wrong kind of code: this is an answer code, not a room code")
+
+	// Codes from before kinds are accepted either way
+	legacy, err := Encode(realisticSDP)
+	require.NoError(t, err)
+	decoded, err := DecodeAnswer(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, realisticSDP, decoded)
+
+	parsed, err := ParseCode(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, Kind(0), parsed.Kind)
+	assert.True(t, parsed.Created.IsZero())
+}
+
+func TestParseCode_Expired(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	setNow(t, created)
+	code, err := EncodeOffer(wrap("offer", realisticSDP))
+	require.NoError(t, err)
+
+	setNow(t, created.Add(MaxCodeAge))
+	_, err = DecodeOffer(code)
+	assert.NoError(t, err, "at the limit")
+
+	setNow(t, created.Add(MaxCodeAge+time.Minute))
+	_, err = DecodeOffer(code)
+	assert.ErrorIs(t, err, ErrExpired)
+
+	// A peer whose clock is a little ahead is fine, far ahead is not
+	setNow(t, created.Add(-5*time.Minute))
+	_, err = DecodeOffer(code)
+	assert.NoError(t, err)
+
+	setNow(t, created.Add(-time.Hour))
+	_, err = DecodeOffer(code)
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestParseCode_Corrupted(t *testing.T) {
+	code, err := EncodeOffer(wrap("offer", realisticSDP))
+	require.NoError(t, err)
+
+	// Changing any character is caught by the checksum
+	for i := len("p2p1o-"); i < len(code); i++ {
+		replacement := byte('A')
+		if code[i] == 'A' {
+			replacement = 'B'
+		}
+		edited := code[:i] + string(replacement) + code[i+1:]
+
+		_, err := Decode(edited)
+		assert.ErrorIs(t, err, ErrCorrupted, "character %d", i)
+	}
+
+	tests := map[string]string{
+		"truncated":         code[:len(code)-10],
+		"cut to the header": "p2p1o-",
+		"no header dash":    "p2p1o" + code[6:],
+		"invalid character": code[:20] + "!" + code[21:],
+		"kind edited":       "p2p1a-" + code[6:],
+		"legacy garbage":    "dGhpcyBpcyBub3QgZ3ppcCBkYXRh",
+		"legacy characters": "invalid+chars/here=",
+	}
+	for name, edited := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Decode(edited)
+			assert.ErrorIs(t, err, ErrCorrupted)
+		})
+	}
+
+	// A valid checksum around bytes that are not a description
+	setNow(t, time.Unix(0, 0))
+	header := codeHeader(codeVersion, KindOffer)
+	data := []byte{0, 0, 0, 0, minifiedVersion, 1, 2, 3}
+	data = binary.BigEndian.AppendUint32(data, checksum(header, data))
+	_, err = Decode(header + base64.RawURLEncoding.EncodeToString(data))
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestParseCode_UnsupportedVersion(t *testing.T) {
+	code, err := EncodeOffer(wrap("offer", realisticSDP))
+	require.NoError(t, err)
+
+	_, err = Decode("p2p2o-" + code[6:])
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	// A kind this version does not know, with a valid checksum
+	header := codeHeader(codeVersion, 'x')
+	payload, err := base64.RawURLEncoding.DecodeString(code[6:])
+	require.NoError(t, err)
+	data := payload[:len(payload)-4]
+	data = binary.BigEndian.AppendUint32(data, checksum(header, data))
+	_, err = Decode(header + base64.RawURLEncoding.EncodeToString(data))
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestParseCode_Whitespace(t *testing.T) {
+	description := wrap("answer", realisticSDP)
+	code, err := EncodeAnswer(description)
+	require.NoError(t, err)
+
+	// Line breaks, indentation and the zero width spaces chat apps insert
+	var mangled strings.Builder
+	mangled.WriteString("  ")
+	for i, r := range code {
+		mangled.WriteRune(r)
+		switch i % 23 {
+		case 5:
+			mangled.WriteString("\r\n")
+		case 11:
+			mangled.WriteString("\u200b")
+		case 17:
+			mangled.WriteString(" \t")
+		}
+	}
+	mangled.WriteString("\n")
+
+	decoded, err := DecodeAnswer(mangled.String())
+	require.NoError(t, err)
+	assertSameDescription(t, description, decoded)
+
+	legacy, err := Encode(description)
+	require.NoError(t, err)
+	decoded, err = Decode(legacy[:10] + "\n" + legacy[10:])
+	require.NoError(t, err)
+	assert.Equal(t, description, decoded)
+}
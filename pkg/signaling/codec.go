@@ -43,20 +43,33 @@ func Encode(sdp string) (string, error) {
 	return encoded, nil
 }
 
-// Decode takes a base64url encoded string and returns the original SDP
-// Reverses the process: base64url decode -> gzip decompress -> original SDP
+// Decode takes a code and returns the original SDP. It reads room and answer
+// codes of any kind, and the bare base64url strings Encode produces.
+// Whitespace is ignored. Errors wrap ErrCorrupted, ErrExpired or
+// ErrUnsupportedVersion
 func Decode(encoded string) (string, error) {
+	code, err := ParseCode(encoded)
+	if err != nil {
+		return "", err
+	}
+	
+	return code.Description, nil
+}
+
+// decodeBody reverses Encode and EncodeCompact:
+// base64url decode -> gzip decompress (or expand) -> original SDP
+func decodeBody(encoded string) (string, error) {
 	if encoded == "" {
 		return "", fmt.Errorf("encoded string cannot be empty")
 	}
 	
 	if len(encoded) < MinEncodedLength {
-		return "", fmt.Errorf("encoded string too short: %d characters (min %d)", len(encoded), MinEncodedLength)
+		return "", fmt.Errorf("%w: encoded string too short: %d characters (min %d)", ErrCorrupted, len(encoded), MinEncodedLength)
 	}
 	
 	// Validate that it looks like base64url
 	if !isValidBase64URL(encoded) {
-		return "", fmt.Errorf("invalid base64url characters in encoded string")
+		return "", fmt.Errorf("%w: invalid base64url characters in encoded string", ErrCorrupted)
 	}
 	
 	// Add padding back if needed for base64 decoding
@@ -65,24 +78,19 @@ func Decode(encoded string) (string, error) {
 	// Decode from base64url
 	compressed, err := base64.URLEncoding.DecodeString(encoded)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode base64url: %w", err)
+		return "", fmt.Errorf("%w: failed to decode base64url: %w", ErrCorrupted, err)
 	}
 	
-	// Decompress with gzip
-	sdp, err := decompressBytes(compressed)
+	// Decompress with gzip, or expand a minified description
+	sdp, err := expandBody(compressed)
 	if err != nil {
-		return "", fmt.Errorf("failed to decompress data: %w", err)
-	}
-	
-	// Basic validation of the result
-	if len(sdp) > MaxSDPSize {
-		return "", fmt.Errorf("decompressed SDP too large: %d bytes (max %d)", len(sdp), MaxSDPSize)
+		return "", fmt.Errorf("%w: %w", ErrCorrupted, err)
 	}
 	
 	// Basic validation - should look like SDP or JSON containing SDP
 	// We're lenient here since this codec can be used for any text, not just SDP
 	if len(sdp) > 0 && !isPrintableText(sdp) {
-		return "", fmt.Errorf("result contains non-printable characters")
+		return "", fmt.Errorf("%w: result contains non-printable characters", ErrCorrupted)
 	}
 	
 	return sdp, nil
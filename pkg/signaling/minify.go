@@ -0,0 +1,624 @@
+package signaling
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// minifiedVersion is the first byte of a minified description. v1 codes
+// start with the gzip magic number instead
+const minifiedVersion = 0x02
+
+// ErrNotMinifiable means a description uses something the minified format
+// cannot represent. EncodeCompact falls back to Encode then
+var ErrNotMinifiable = errors.New("description cannot be minified")
+
+// Description types, setup roles, candidate types and TCP types, by their
+// index in the minified format
+var (
+	descriptionTypes = []string{"offer", "answer", "pranswer", "rollback"}
+	setupRoles       = []string{"actpass", "active", "passive", "holdconn"}
+	candidateTypes   = []string{"host", "srflx", "prflx", "relay"}
+	tcpTypes         = []string{"", "active", "passive", "so"}
+	hashFunctions    = []string{"sha-256", "sha-1", "sha-224", "sha-384", "sha-512"}
+)
+
+// Header flags
+const (
+	flagEndOfCandidates = 1 << 7
+	flagSCTPPort        = 1 << 6
+	flagMaxMessageSize  = 1 << 5
+	flagTrickle         = 1 << 4
+)
+
+// Candidate flags. The low 2 bits are the candidate type and bits 3-4 the
+// TCP type
+const (
+	candidateTCP        = 1 << 2
+	candidateRelated    = 1 << 5
+	candidateTwin       = 1 << 6 // followed by the same candidate for component 2
+	candidateFoundation = 1 << 7 // string foundation followed by the component
+)
+
+const defaultSCTPPort = 5000
+
+// sessionDescription is the JSON form of an offer or answer, as produced by Peer
+type sessionDescription struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+// minified holds the parts of a data channel only description that matter
+type minified struct {
+	kind            byte
+	setup           byte
+	ufrag, pwd      string
+	hash            byte
+	fingerprint     []byte
+	mid             string
+	sctpPort        uint16
+	maxMessageSize  uint64
+	endOfCandidates bool
+	trickle         bool
+	candidates      []candidate
+}
+
+type candidate struct {
+	foundation     string
+	component      int
+	protocol       string
+	priority       uint32
+	address        string
+	port           uint16
+	kind           string
+	relatedAddress string
+	relatedPort    uint16
+	tcpType        string
+}
+
+// EncodeCompact encodes a JSON session description, as produced by Peer, with
+// the minified format: only the ICE credentials, DTLS fingerprint, setup
+// role, SCTP port and candidates are kept, in binary. Descriptions it cannot
+// represent are gzipped like Encode does. Decode reads both
+func EncodeCompact(description string) (string, error) {
+	if description == "" {
+		return "", fmt.Errorf("SDP cannot be empty")
+	}
+
+	data, err := compact(description)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Minify packs a JSON session description into the minified binary format
+func Minify(description string) ([]byte, error) {
+	var desc sessionDescription
+	if err := json.Unmarshal([]byte(description), &desc); err != nil || desc.SDP == "" {
+		return nil, fmt.Errorf("%w: not a JSON session description", ErrNotMinifiable)
+	}
+
+	m, err := parseSDP(desc)
+	if err != nil {
+		return nil, err
+	}
+	return m.marshal(), nil
+}
+
+// Expand rebuilds the JSON session description packed by Minify
+func Expand(data []byte) (string, error) {
+	m, err := unmarshalMinified(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to read minified description: %w", err)
+	}
+
+	encoded, err := json.Marshal(sessionDescription{Type: descriptionTypes[m.kind], SDP: m.sdp()})
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// isMinified tells minified data from gzip data
+func isMinified(data []byte) bool {
+	return len(data) > 0 && data[0] == minifiedVersion
+}
+
+// parseSDP keeps what the minified format stores and rejects anything else
+// that would be lost
+func parseSDP(desc sessionDescription) (*minified, error) {
+	m := &minified{sctpPort: defaultSCTPPort}
+
+	kind := indexOf(descriptionTypes, desc.Type)
+	if kind < 0 {
+		return nil, fmt.Errorf("%w: unknown type %q", ErrNotMinifiable, desc.Type)
+	}
+	m.kind = byte(kind)
+
+	media := 0
+	for _, line := range strings.Split(strings.ReplaceAll(desc.SDP, "\r\n", "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+
+		switch key {
+		case "v", "o", "s", "t", "c":
+			// Rebuilt from a template
+			continue
+		case "m":
+			media++
+			if media > 1 || value != "application 9 UDP/DTLS/SCTP webrtc-datachannel" {
+				return nil, fmt.Errorf("%w: media %q", ErrNotMinifiable, value)
+			}
+			continue
+		case "a":
+		default:
+			return nil, fmt.Errorf("%w: line %q", ErrNotMinifiable, line)
+		}
+
+		name, attr, _ := strings.Cut(value, ":")
+		switch name {
+		case "msid-semantic", "extmap-allow-mixed", "group", "sendrecv":
+			// Rebuilt from a template
+		case "ice-ufrag":
+			m.ufrag = attr
+		case "ice-pwd":
+			m.pwd = attr
+		case "mid":
+			m.mid = attr
+		case "setup":
+			setup := indexOf(setupRoles, attr)
+			if setup < 0 {
+				return nil, fmt.Errorf("%w: setup %q", ErrNotMinifiable, attr)
+			}
+			m.setup = byte(setup)
+		case "fingerprint":
+			if m.fingerprint != nil {
+				return nil, fmt.Errorf("%w: several fingerprints", ErrNotMinifiable)
+			}
+			if err := m.parseFingerprint(attr); err != nil {
+				return nil, err
+			}
+		case "sctp-port":
+			port, err := strconv.ParseUint(attr, 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("%w: sctp-port %q", ErrNotMinifiable, attr)
+			}
+			m.sctpPort = uint16(port)
+		case "max-message-size":
+			size, err := strconv.ParseUint(attr, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: max-message-size %q", ErrNotMinifiable, attr)
+			}
+			m.maxMessageSize = size
+		case "candidate":
+			c, err := parseCandidate(attr)
+			if err != nil {
+				return nil, err
+			}
+			m.candidates = append(m.candidates, c)
+		case "ice-options":
+			if attr != "trickle" {
+				return nil, fmt.Errorf("%w: ice-options %q", ErrNotMinifiable, attr)
+			}
+			m.trickle = true
+		case "end-of-candidates":
+			m.endOfCandidates = true
+		default:
+			return nil, fmt.Errorf("%w: attribute %q", ErrNotMinifiable, value)
+		}
+	}
+
+	if media != 1 || m.ufrag == "" || m.pwd == "" || m.fingerprint == nil {
+		return nil, fmt.Errorf("%w: incomplete description", ErrNotMinifiable)
+	}
+	for _, s := range []string{m.ufrag, m.pwd, m.mid} {
+		if len(s) > 255 {
+			return nil, fmt.Errorf("%w: %q is too long", ErrNotMinifiable, s)
+		}
+	}
+	return m, nil
+}
+
+// parseFingerprint reads "sha-256 AB:CD:..."
+func (m *minified) parseFingerprint(attr string) error {
+	hash, digest, _ := strings.Cut(attr, " ")
+	index := indexOf(hashFunctions, strings.ToLower(hash))
+	fingerprint, err := hex.DecodeString(strings.ReplaceAll(digest, ":", ""))
+	if index < 0 || err != nil || len(fingerprint) == 0 || len(fingerprint) > 255 ||
+		strings.ToUpper(digest) != digest {
+		return fmt.Errorf("%w: fingerprint %q", ErrNotMinifiable, attr)
+	}
+
+	m.hash = byte(index)
+	m.fingerprint = fingerprint
+	return nil
+}
+
+// parseCandidate reads "foundation component protocol priority address port
+// typ type [raddr address rport port] [tcptype type]"
+func parseCandidate(attr string) (candidate, error) {
+	invalid := fmt.Errorf("%w: candidate %q", ErrNotMinifiable, attr)
+
+	fields := strings.Fields(attr)
+	if len(fields) < 8 || fields[6] != "typ" {
+		return candidate{}, invalid
+	}
+
+	component, err := strconv.Atoi(fields[1])
+	if err != nil || (component != 1 && component != 2) {
+		return candidate{}, invalid
+	}
+	priority, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return candidate{}, invalid
+	}
+	port, err := strconv.ParseUint(fields[5], 10, 16)
+	if err != nil {
+		return candidate{}, invalid
+	}
+
+	c := candidate{
+		foundation: fields[0],
+		component:  component,
+		protocol:   strings.ToLower(fields[2]),
+		priority:   uint32(priority),
+		address:    fields[4],
+		port:       uint16(port),
+		kind:       fields[7],
+	}
+	if (c.protocol != "udp" && c.protocol != "tcp") || indexOf(candidateTypes, c.kind) < 0 ||
+		len(c.foundation) > 255 || len(c.address) > 127 {
+		return candidate{}, invalid
+	}
+
+	rest := fields[8:]
+	for len(rest) >= 2 {
+		switch rest[0] {
+		case "raddr":
+			c.relatedAddress = rest[1]
+		case "rport":
+			port, err := strconv.ParseUint(rest[1], 10, 16)
+			if err != nil {
+				return candidate{}, invalid
+			}
+			c.relatedPort = uint16(port)
+		case "tcptype":
+			c.tcpType = rest[1]
+		default:
+			return candidate{}, invalid
+		}
+		rest = rest[2:]
+	}
+
+	if len(rest) > 0 || indexOf(tcpTypes, c.tcpType) < 0 || (c.tcpType != "") != (c.protocol == "tcp") ||
+		len(c.relatedAddress) > 127 {
+		return candidate{}, invalid
+	}
+	return c, nil
+}
+
+// marshal packs the description. The layout is:
+//
+//	version, flags | kind<<2 | setup, ufrag, pwd, hash, fingerprint, mid,
+//	[sctp port], [max message size], candidate count, candidates
+//
+// Strings and the fingerprint are length prefixed.
+func (m *minified) marshal() []byte {
+	var buf bytes.Buffer
+
+	flags := m.kind<<2 | m.setup
+	if m.endOfCandidates {
+		flags |= flagEndOfCandidates
+	}
+	if m.sctpPort != defaultSCTPPort {
+		flags |= flagSCTPPort
+	}
+	if m.maxMessageSize != 0 {
+		flags |= flagMaxMessageSize
+	}
+	if m.trickle {
+		flags |= flagTrickle
+	}
+	buf.WriteByte(minifiedVersion)
+	buf.WriteByte(flags)
+
+	writeString(&buf, m.ufrag)
+	writeString(&buf, m.pwd)
+	buf.WriteByte(m.hash)
+	writeString(&buf, string(m.fingerprint))
+	writeString(&buf, m.mid)
+
+	if flags&flagSCTPPort != 0 {
+		buf.Write(binary.BigEndian.AppendUint16(nil, m.sctpPort))
+	}
+	if flags&flagMaxMessageSize != 0 {
+		buf.Write(binary.AppendUvarint(nil, m.maxMessageSize))
+	}
+
+	// Pion lists every candidate twice, for components 1 and 2. The second
+	// one is only a flag
+	var candidates []candidate
+	var twins []bool
+	for i := 0; i < len(m.candidates); i++ {
+		c := m.candidates[i]
+		twin := false
+		if c.component == 1 && i+1 < len(m.candidates) {
+			next := m.candidates[i+1]
+			next.component = 1
+			if next == c {
+				twin = true
+				i++
+			}
+		}
+		candidates = append(candidates, c)
+		twins = append(twins, twin)
+	}
+
+	buf.Write(binary.AppendUvarint(nil, uint64(len(candidates))))
+	for i, c := range candidates {
+		writeCandidate(&buf, c, twins[i])
+	}
+	return buf.Bytes()
+}
+
+func writeCandidate(buf *bytes.Buffer, c candidate, twin bool) {
+	flags := byte(indexOf(candidateTypes, c.kind)) | byte(indexOf(tcpTypes, c.tcpType))<<3
+	if c.protocol == "tcp" {
+		flags |= candidateTCP
+	}
+	if c.relatedAddress != "" {
+		flags |= candidateRelated
+	}
+	if twin {
+		flags |= candidateTwin
+	}
+	foundation, err := strconv.ParseUint(c.foundation, 10, 32)
+	if err != nil || strconv.FormatUint(foundation, 10) != c.foundation {
+		flags |= candidateFoundation
+	}
+	if c.component != 1 {
+		// Only string foundations carry the component
+		flags |= candidateFoundation
+	}
+	buf.WriteByte(flags)
+
+	if flags&candidateFoundation != 0 {
+		writeString(buf, c.foundation)
+		buf.WriteByte(byte(c.component))
+	} else {
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(foundation)))
+	}
+	buf.Write(binary.BigEndian.AppendUint32(nil, c.priority))
+	writeAddress(buf, c.address, c.port)
+	if c.relatedAddress != "" {
+		writeAddress(buf, c.relatedAddress, c.relatedPort)
+	}
+}
+
+// writeAddress writes an IP as 4 or 16 bytes after its length, or a host
+// name (mDNS) after its length with the high bit set, then the port
+func writeAddress(buf *bytes.Buffer, address string, port uint16) {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil || ip.String() != address:
+		buf.WriteByte(0x80 | byte(len(address)))
+		buf.WriteString(address)
+	case ip.To4() != nil:
+		buf.WriteByte(net.IPv4len)
+		buf.Write(ip.To4())
+	default:
+		buf.WriteByte(net.IPv6len)
+		buf.Write(ip.To16())
+	}
+	buf.Write(binary.BigEndian.AppendUint16(nil, port))
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteByte(byte(len(s)))
+	buf.WriteString(s)
+}
+
+// reader reads the minified format and remembers the first error
+type reader struct {
+	data []byte
+	err  error
+}
+
+var errTruncated = errors.New("data is truncated")
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.data) {
+		r.err = errTruncated
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	return r.bytes(1)[0]
+}
+
+func (r *reader) string() string {
+	return string(r.bytes(int(r.byte())))
+}
+
+func (r *reader) uint16() uint16 {
+	return binary.BigEndian.Uint16(r.bytes(2))
+}
+
+func (r *reader) uint32() uint32 {
+	return binary.BigEndian.Uint32(r.bytes(4))
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *reader) address() (string, uint16) {
+	length := r.byte()
+	var address string
+	switch {
+	case length&0x80 != 0:
+		address = string(r.bytes(int(length &^ 0x80)))
+	case length == net.IPv4len || length == net.IPv6len:
+		address = net.IP(r.bytes(int(length))).String()
+	default:
+		r.err = fmt.Errorf("invalid address length %d", length)
+	}
+	return address, r.uint16()
+}
+
+func unmarshalMinified(data []byte) (*minified, error) {
+	if !isMinified(data) {
+		return nil, fmt.Errorf("unsupported version")
+	}
+	r := &reader{data: data[1:]}
+
+	flags := r.byte()
+	m := &minified{
+		kind:            flags >> 2 & 0x03,
+		setup:           flags & 0x03,
+		endOfCandidates: flags&flagEndOfCandidates != 0,
+		trickle:         flags&flagTrickle != 0,
+		ufrag:           r.string(),
+		pwd:             r.string(),
+		hash:            r.byte(),
+		fingerprint:     []byte(r.string()),
+		mid:             r.string(),
+		sctpPort:        defaultSCTPPort,
+	}
+	if int(m.hash) >= len(hashFunctions) {
+		return nil, fmt.Errorf("unknown hash function %d", m.hash)
+	}
+	if flags&flagSCTPPort != 0 {
+		m.sctpPort = r.uint16()
+	}
+	if flags&flagMaxMessageSize != 0 {
+		m.maxMessageSize = r.uvarint()
+	}
+
+	count := r.uvarint()
+	if count > uint64(len(r.data)) {
+		return nil, errTruncated
+	}
+	for range count {
+		flags := r.byte()
+		c := candidate{
+			kind:      candidateTypes[flags&0x03],
+			tcpType:   tcpTypes[flags>>3&0x03],
+			protocol:  "udp",
+			component: 1,
+		}
+		if flags&candidateTCP != 0 {
+			c.protocol = "tcp"
+		}
+		if flags&candidateFoundation != 0 {
+			c.foundation = r.string()
+			c.component = int(r.byte())
+		} else {
+			c.foundation = strconv.FormatUint(uint64(r.uint32()), 10)
+		}
+		c.priority = r.uint32()
+		c.address, c.port = r.address()
+		if flags&candidateRelated != 0 {
+			c.relatedAddress, c.relatedPort = r.address()
+		}
+
+		m.candidates = append(m.candidates, c)
+		if flags&candidateTwin != 0 {
+			c.component = 2
+			m.candidates = append(m.candidates, c)
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if len(r.data) > 0 {
+		return nil, fmt.Errorf("%d unexpected trailing bytes", len(r.data))
+	}
+	return m, nil
+}
+
+// sdp writes the description back in the layout pion uses
+func (m *minified) sdp() string {
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+
+	digest := make([]string, len(m.fingerprint))
+	for i, octet := range m.fingerprint {
+		digest[i] = fmt.Sprintf("%02X", octet)
+	}
+
+	line("v=0")
+	line("o=- 0 0 IN IP4 0.0.0.0")
+	line("s=-")
+	line("t=0 0")
+	line("a=msid-semantic:WMS*")
+	line("a=fingerprint:%s %s", hashFunctions[m.hash], strings.Join(digest, ":"))
+	line("a=extmap-allow-mixed")
+	line("a=group:BUNDLE %s", m.mid)
+	line("m=application 9 UDP/DTLS/SCTP webrtc-datachannel")
+	line("c=IN IP4 0.0.0.0")
+	line("a=setup:%s", setupRoles[m.setup])
+	line("a=mid:%s", m.mid)
+	line("a=sendrecv")
+	line("a=sctp-port:%d", m.sctpPort)
+	if m.maxMessageSize != 0 {
+		line("a=max-message-size:%d", m.maxMessageSize)
+	}
+	line("a=ice-ufrag:%s", m.ufrag)
+	line("a=ice-pwd:%s", m.pwd)
+	if m.trickle {
+		line("a=ice-options:trickle")
+	}
+
+	for _, c := range m.candidates {
+		candidate := fmt.Sprintf("a=candidate:%s %d %s %d %s %d typ %s",
+			c.foundation, c.component, c.protocol, c.priority, c.address, c.port, c.kind)
+		if c.relatedAddress != "" {
+			candidate += fmt.Sprintf(" raddr %s rport %d", c.relatedAddress, c.relatedPort)
+		}
+		if c.tcpType != "" {
+			candidate += " tcptype " + c.tcpType
+		}
+		line("%s", candidate)
+	}
+	if m.endOfCandidates {
+		line("a=end-of-candidates")
+	}
+	return b.String()
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,178 @@
+package signaling
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
+)
+
+// wrap turns an SDP into the JSON description Peer produces
+func wrap(kind, sdp string) string {
+	data, _ := json.Marshal(sessionDescription{Type: kind, SDP: strings.ReplaceAll(sdp, "\n", "\r\n")})
+	return string(data)
+}
+
+// assertSameDescription compares what the minified format keeps
+func assertSameDescription(t *testing.T, want, got string) {
+	t.Helper()
+
+	var wantDesc, gotDesc sessionDescription
+	require.NoError(t, json.Unmarshal([]byte(want), &wantDesc))
+	require.NoError(t, json.Unmarshal([]byte(got), &gotDesc))
+
+	wantMinified, err := parseSDP(wantDesc)
+	require.NoError(t, err)
+	gotMinified, err := parseSDP(gotDesc)
+	require.NoError(t, err)
+	assert.Equal(t, wantMinified, gotMinified)
+}
+
+func TestEncodeCompact_RealPeer(t *testing.T) {
+	offerer, err := webrtc.NewRealPeer()
+	require.NoError(t, err)
+	defer offerer.Close()
+	answerer, err := webrtc.NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	received := make(chan string, 1)
+	answerer.OnMessage(func(data []byte) { received <- string(data) })
+
+	offer, err := offerer.CreateOffer()
+	require.NoError(t, err)
+	offerCode, err := EncodeCompact(offer)
+	require.NoError(t, err)
+	decodedOffer, err := Decode(offerCode)
+	require.NoError(t, err)
+	assertSameDescription(t, offer, decodedOffer)
+
+	answer, err := answerer.CreateAnswer(decodedOffer)
+	require.NoError(t, err)
+	answerCode, err := EncodeCompact(answer)
+	require.NoError(t, err)
+	decodedAnswer, err := Decode(answerCode)
+	require.NoError(t, err)
+	assertSameDescription(t, answer, decodedAnswer)
+
+	require.NoError(t, offerer.SetRemoteAnswer(decodedAnswer))
+	require.Eventually(t, func() bool {
+		return offerer.Send([]byte("hello")) == nil
+	}, 10*time.Second, 50*time.Millisecond)
+
+	select {
+	case text := <-received:
+		assert.Equal(t, "hello", text)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not delivered")
+	}
+
+	// Much shorter than gzip
+	for _, description := range []string{offer, answer} {
+		compact, err := EncodeCompact(description)
+		require.NoError(t, err)
+		gzipped, err := Encode(description)
+		require.NoError(t, err)
+		assert.Less(t, len(compact)*2, len(gzipped), "compact %d, gzip %d", len(compact), len(gzipped))
+	}
+}
+
+func TestMinify(t *testing.T) {
+	fingerprint := "a=fingerprint:sha-256 00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF:00:11:22:33:44:55:66:77:88:99:AA:BB:CC:DD:EE:FF"
+
+	tests := []struct {
+		name string
+		sdp  string
+	}{
+		{name: "realistic", sdp: realisticSDP},
+		{name: "minimal", sdp: minimalSDP},
+		{name: "privacy mode", sdp: strings.Join([]string{
+			"v=0", "o=- 1 2 IN IP4 0.0.0.0", "s=-", "t=0 0", fingerprint,
+			"m=application 9 UDP/DTLS/SCTP webrtc-datachannel", "a=setup:passive", "a=mid:data",
+			"a=sctp-port:5001", "a=ice-ufrag:u", "a=ice-pwd:p",
+			"a=candidate:1 1 udp 16777215 203.0.113.5 50000 typ relay raddr 0.0.0.0 rport 0",
+			"a=candidate:1 2 udp 16777215 203.0.113.5 50000 typ relay raddr 0.0.0.0 rport 0",
+			"a=end-of-candidates",
+		}, "\n")},
+		{name: "mdns, ipv6, port mapping and lone component", sdp: strings.Join([]string{
+			"v=0", "o=- 1 2 IN IP4 0.0.0.0", "s=-", "t=0 0", fingerprint,
+			"m=application 9 UDP/DTLS/SCTP webrtc-datachannel", "a=setup:actpass", "a=mid:0",
+			"a=ice-ufrag:u", "a=ice-pwd:p",
+			"a=candidate:1 1 udp 2130706431 8c1b47e0-2d9c-4d6f-9a93-1f8e3a0b5c7d.local 50000 typ host",
+			"a=candidate:2 1 udp 2130706431 2001:db8::1 50001 typ host",
+			"a=candidate:portmap 1 udp 1694498815 198.51.100.1 40000 typ srflx raddr 192.168.1.2 rport 50000",
+			"a=candidate:3 2 udp 2130706431 10.0.0.1 50002 typ host",
+			"a=candidate:007 1 tcp 1 10.0.0.1 9 typ host tcptype so",
+		}, "\n")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, kind := range []string{"offer", "answer"} {
+				description := wrap(kind, tt.sdp)
+
+				data, err := Minify(description)
+				require.NoError(t, err)
+				expanded, err := Expand(data)
+				require.NoError(t, err)
+				assertSameDescription(t, description, expanded)
+
+				// Minifying again gives the same bytes
+				again, err := Minify(expanded)
+				require.NoError(t, err)
+				assert.Equal(t, data, again)
+			}
+		})
+	}
+}
+
+func TestEncodeCompact_Fallback(t *testing.T) {
+	fallbacks := map[string]string{
+		"plain text":            "Hello, World!",
+		"raw SDP":               realisticSDP,
+		"unknown attribute":     wrap("offer", minimalSDP+"\na=ice-lite"),
+		"audio":                 wrap("offer", strings.Replace(minimalSDP, "application 9 UDP/DTLS/SCTP webrtc-datachannel", "audio 9 UDP/TLS/RTP/SAVPF 111", 1)),
+		"candidate extension":   wrap("offer", minimalSDP+"\na=candidate:1 1 udp 1 10.0.0.1 9 typ host generation 0"),
+		"lowercase fingerprint": wrap("offer", strings.Replace(minimalSDP, "AB:CD:EF", "ab:cd:ef", 1)),
+	}
+
+	for name, description := range fallbacks {
+		t.Run(name, func(t *testing.T) {
+			_, err := Minify(description)
+			assert.ErrorIs(t, err, ErrNotMinifiable)
+
+			code, err := EncodeCompact(description)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(code, "H4sI"), "gzip code")
+
+			decoded, err := Decode(code)
+			require.NoError(t, err)
+			assert.Equal(t, description, decoded)
+		})
+	}
+}
+
+func TestExpand_Corrupted(t *testing.T) {
+	data, err := Minify(wrap("offer", realisticSDP))
+	require.NoError(t, err)
+
+	for i := 1; i < len(data); i++ {
+		_, err := Expand(data[:i])
+		assert.Error(t, err, "truncated to %d bytes", i)
+	}
+
+	_, err = Expand(append(data, 0))
+	assert.Error(t, err, "trailing byte")
+
+	_, err = Expand([]byte{0x03, 0})
+	assert.Error(t, err, "unknown version")
+
+	_, err = Decode(base64.RawURLEncoding.EncodeToString(data[:len(data)/2]))
+	assert.Error(t, err)
+}
@@ -0,0 +1,332 @@
+package signaling
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	multiqr "github.com/makiuchi-d/gozxing/multi/qrcode"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// MaxQRPartLength is the most characters put in one QR symbol. Longer codes
+// are split over several symbols, which stay small enough for any phone
+// camera to read
+const MaxQRPartLength = 300
+
+// MaxQRParts is the most symbols a code is split over. Part headers read
+// from an image that claim more are rejected
+const MaxQRParts = 64
+
+// qrLevel is the error correction of generated symbols: 15% of the modules
+// can be wrong
+const qrLevel = qrcode.Medium
+
+// DefaultQRScale is the size of a module in pixels
+const DefaultQRScale = 6
+
+var (
+	// ErrNoQRCode means an image has no readable QR symbol
+	ErrNoQRCode = errors.New("no QR code found")
+
+	// ErrMissingQRParts means some symbols of a split code were not found
+	ErrMissingQRParts = errors.New("some parts of the QR code are missing")
+)
+
+// QRCode shows a code as one or more QR symbols, side by side
+type QRCode struct {
+	code    string
+	bitmaps [][][]bool
+}
+
+// NewQRCode encodes a code. Codes longer than MaxQRPartLength are split in
+// parts, each prefixed with "index/count:" so they can be put back together.
+// Word codes are encoded in their shorter text form
+func NewQRCode(code string) (*QRCode, error) {
+	if isWords(code) {
+		text, err := FromWords(code)
+		if err != nil {
+			return nil, err
+		}
+		code = text
+	}
+	code = stripSpaces(code)
+	if code == "" {
+		return nil, fmt.Errorf("code cannot be empty")
+	}
+
+	parts := splitQR(code, MaxQRPartLength)
+	if len(parts) > MaxQRParts {
+		return nil, fmt.Errorf("code too long for QR: %d parts (max %d)", len(parts), MaxQRParts)
+	}
+	q := &QRCode{code: code}
+	for _, part := range parts {
+		symbol, err := qrcode.New(part, qrLevel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode QR code: %w", err)
+		}
+		q.bitmaps = append(q.bitmaps, symbol.Bitmap())
+	}
+	return q, nil
+}
+
+// splitQR cuts code into parts of about the same length
+func splitQR(code string, limit int) []string {
+	if len(code) <= limit {
+		return []string{code}
+	}
+
+	// Leave room for the "index/count:" header
+	count := (len(code) + limit - 9) / (limit - 8)
+	size := (len(code) + count - 1) / count
+
+	var parts []string
+	for i := 0; i*size < len(code); i++ {
+		end := min((i+1)*size, len(code))
+		parts = append(parts, fmt.Sprintf("%d/%d:%s", i+1, count, code[i*size:end]))
+	}
+	return parts
+}
+
+// Parts is the number of QR symbols
+func (q *QRCode) Parts() int {
+	return len(q.bitmaps)
+}
+
+// Code returns the encoded code
+func (q *QRCode) Code() string {
+	return q.code
+}
+
+// modules returns all symbols side by side, quiet zones included, as one
+// bitmap indexed [y][x]
+func (q *QRCode) modules() [][]bool {
+	height := 0
+	for _, bitmap := range q.bitmaps {
+		height = max(height, len(bitmap))
+	}
+
+	rows := make([][]bool, height)
+	for _, bitmap := range q.bitmaps {
+		for y := range height {
+			if y < len(bitmap) {
+				rows[y] = append(rows[y], bitmap[y]...)
+			} else {
+				rows[y] = append(rows[y], make([]bool, len(bitmap))...)
+			}
+		}
+	}
+	return rows
+}
+
+// Image draws the symbols with scale pixels per module
+func (q *QRCode) Image(scale int) image.Image {
+	if scale <= 0 {
+		scale = DefaultQRScale
+	}
+
+	modules := q.modules()
+	img := image.NewGray(image.Rect(0, 0, len(modules[0])*scale, len(modules)*scale))
+	for y := range img.Bounds().Dy() {
+		for x := range img.Bounds().Dx() {
+			if modules[y/scale][x/scale] {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+// PNG encodes Image as a PNG file
+func (q *QRCode) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, q.Image(scale)); err != nil {
+		return nil, fmt.Errorf("failed to encode PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG draws the symbols as an SVG document, one unit per module
+func (q *QRCode) SVG() string {
+	modules := q.modules()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		len(modules[0]), len(modules))
+	b.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
+
+// Terminal draws the symbols with ANSI colors, two modules per character,
+// so they can be scanned from any terminal theme
+func (q *QRCode) Terminal() string {
+	const (
+		black = 30
+		white = 97
+	)
+
+	modules := q.modules()
+	var b strings.Builder
+	for y := 0; y < len(modules); y += 2 {
+		for x := range modules[y] {
+			top, bottom := white, white
+			if modules[y][x] {
+				top = black
+			}
+			if y+1 < len(modules) && modules[y+1][x] {
+				bottom = black
+			}
+			// Background colors are the foreground ones plus 10
+			fmt.Fprintf(&b, "\x1b[%d;%dm▀", top, bottom+10)
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
+
+// DecodeQR reads a code from the QR symbols of an image, putting split
+// codes back together. See DecodeQRFiles for the images it can read
+func DecodeQR(img image.Image) (string, error) {
+	contents, err := scanQR(img)
+	if err != nil {
+		return "", err
+	}
+	return joinQR(contents)
+}
+
+// DecodeQRFiles reads a code from PNG, JPEG or GIF images. The parts of a
+// split code can be in one image or spread over several. Screenshots and
+// saved QR images read best; scaled, blurred or compressed copies work too
+func DecodeQRFiles(paths ...string) (string, error) {
+	var contents []string
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		img, _, err := image.Decode(file)
+		file.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read image %s: %w", path, err)
+		}
+
+		found, err := scanQR(img)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		contents = append(contents, found...)
+	}
+	return joinQR(contents)
+}
+
+// scanQR returns the contents of every symbol in img
+func scanQR(img image.Image) ([]string, error) {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, ErrNoQRCode
+	}
+
+	// Transparent pixels count as white
+	flat := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, bounds.Min, draw.Over)
+
+	// The two binarizers miss different symbols of blurry images, so the
+	// contents found by either count
+	source := gozxing.NewLuminanceSourceFromImage(flat)
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_TRY_HARDER: true}
+	var contents []string
+	for _, binarizer := range []gozxing.Binarizer{gozxing.NewHybridBinarizer(source), gozxing.NewGlobalHistgramBinarizer(source)} {
+		bitmap, err := gozxing.NewBinaryBitmap(binarizer)
+		if err != nil {
+			continue
+		}
+		results, _ := multiqr.NewQRCodeMultiReader().DecodeMultiple(bitmap, hints)
+		for _, result := range results {
+			if !slices.Contains(contents, result.GetText()) {
+				contents = append(contents, result.GetText())
+			}
+		}
+	}
+
+	if len(contents) == 0 {
+		return nil, ErrNoQRCode
+	}
+	return contents, nil
+}
+
+// joinQR puts the parts of a split code back in order. Contents without
+// a part header are a whole code, which must then be the only code found
+func joinQR(contents []string) (string, error) {
+	if len(contents) == 0 {
+		return "", ErrNoQRCode
+	}
+
+	var whole string
+	var parts []string
+	count := 0
+	for _, content := range contents {
+		var index, total int
+		header, chunk, found := strings.Cut(content, ":")
+		if _, err := fmt.Sscanf(header, "%d/%d", &index, &total); !found || err != nil {
+			if whole != "" && whole != content {
+				return "", fmt.Errorf("%w: several QR codes in one scan", ErrCorrupted)
+			}
+			whole = content
+			continue
+		}
+
+		if total > MaxQRParts {
+			return "", fmt.Errorf("%w: QR part of %d parts (max %d)", ErrCorrupted, total, MaxQRParts)
+		}
+		if total < 1 || index < 1 || index > total || (count != 0 && total != count) {
+			return "", fmt.Errorf("%w: QR parts from different codes", ErrCorrupted)
+		}
+		if count == 0 {
+			count = total
+			parts = make([]string, total)
+		}
+		if parts[index-1] != "" && parts[index-1] != chunk {
+			return "", fmt.Errorf("%w: QR parts from different codes", ErrCorrupted)
+		}
+		parts[index-1] = chunk
+	}
+
+	if whole != "" {
+		if count != 0 {
+			return "", fmt.Errorf("%w: several QR codes in one scan", ErrCorrupted)
+		}
+		return stripSpaces(whole), nil
+	}
+
+	var missing []string
+	for i, part := range parts {
+		if part == "" {
+			missing = append(missing, fmt.Sprint(i+1))
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%w: part %s of %d", ErrMissingQRParts, strings.Join(missing, ", "), count)
+	}
+	return strings.Join(parts, ""), nil
+}
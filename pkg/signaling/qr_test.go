@@ -0,0 +1,331 @@
+package signaling
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/makiuchi-d/gozxing"
+	zxingqr "github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// longCode is a code that needs several QR symbols
+func longCode(t *testing.T) string {
+	t.Helper()
+
+	random := rand.New(rand.NewSource(1))
+	text := make([]byte, 900)
+	for i := range text {
+		text[i] = "abcdefghijklmnopqrstuvwxyz0123456789"[random.Intn(36)]
+	}
+	code, err := EncodeOffer(string(text))
+	require.NoError(t, err)
+	return code
+}
+
+// rotate90 turns img by 90 degrees clockwise
+func rotate90(img image.Image) image.Image {
+	bounds := img.Bounds()
+	rotated := image.NewGray(image.Rect(0, 0, bounds.Dy(), bounds.Dx()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			rotated.Set(bounds.Max.Y-1-y, x-bounds.Min.X, img.At(x, y))
+		}
+	}
+	return rotated
+}
+
+// resize scales img by factor, interpolating between pixels, so modules no
+// longer fall on whole pixels
+func resize(img image.Image, factor float64) image.Image {
+	bounds := img.Bounds()
+	src := image.NewGray(bounds)
+	draw.Draw(src, bounds, img, bounds.Min, draw.Src)
+
+	w, h := int(float64(bounds.Dx())*factor), int(float64(bounds.Dy())*factor)
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			fx := min(max((float64(x)+0.5)/factor-0.5, 0), float64(bounds.Dx()-1))
+			fy := min(max((float64(y)+0.5)/factor-0.5, 0), float64(bounds.Dy()-1))
+			x0, y0 := int(fx), int(fy)
+			x1, y1 := min(x0+1, bounds.Dx()-1), min(y0+1, bounds.Dy()-1)
+			dx, dy := fx-float64(x0), fy-float64(y0)
+
+			at := func(x, y int) float64 { return float64(src.GrayAt(bounds.Min.X+x, bounds.Min.Y+y).Y) }
+			top := at(x0, y0)*(1-dx) + at(x1, y0)*dx
+			bottom := at(x0, y1)*(1-dx) + at(x1, y1)*dx
+			dst.SetGray(x, y, color.Gray{Y: uint8(top*(1-dy) + bottom*dy + 0.5)})
+		}
+	}
+	return dst
+}
+
+// blur averages every pixel with its neighbours within radius
+func blur(img image.Image, radius int) image.Image {
+	bounds := img.Bounds()
+	src := image.NewGray(bounds)
+	draw.Draw(src, bounds, img, bounds.Min, draw.Src)
+
+	dst := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := range bounds.Dy() {
+		for x := range bounds.Dx() {
+			sum, count := 0, 0
+			for ny := max(y-radius, 0); ny <= min(y+radius, bounds.Dy()-1); ny++ {
+				for nx := max(x-radius, 0); nx <= min(x+radius, bounds.Dx()-1); nx++ {
+					sum += int(src.GrayAt(bounds.Min.X+nx, bounds.Min.Y+ny).Y)
+					count++
+				}
+			}
+			dst.SetGray(x, y, color.Gray{Y: uint8(sum / count)})
+		}
+	}
+	return dst
+}
+
+// compress saves img as a JPEG of the given quality and reads it back
+func compress(t *testing.T, img image.Image, quality int) image.Image {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}))
+	decoded, err := jpeg.Decode(&buf)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestQRCode_RoundTrip(t *testing.T) {
+	offer, err := EncodeOffer(wrap("offer", realisticSDP))
+	require.NoError(t, err)
+
+	for name, code := range map[string]string{"room code": offer, "split code": longCode(t)} {
+		t.Run(name, func(t *testing.T) {
+			qr, err := NewQRCode(code)
+			require.NoError(t, err)
+			if name == "split code" {
+				assert.Equal(t, 3, qr.Parts())
+			} else {
+				assert.Equal(t, 1, qr.Parts())
+			}
+
+			for _, scale := range []int{1, 3, DefaultQRScale} {
+				decoded, err := DecodeQR(qr.Image(scale))
+				require.NoError(t, err, "scale %d", scale)
+				assert.Equal(t, code, decoded)
+			}
+
+			img := qr.Image(4)
+			for turn := 1; turn < 4; turn++ {
+				img = rotate90(img)
+				decoded, err := DecodeQR(img)
+				require.NoError(t, err, "turned %d times", turn)
+				assert.Equal(t, code, decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeQR_Screenshot(t *testing.T) {
+	code := longCode(t)
+	qr, err := NewQRCode(code)
+	require.NoError(t, err)
+
+	// The symbols in the middle of a gray window, saved as a JPEG
+	symbols := qr.Image(5)
+	screen := image.NewRGBA(image.Rect(0, 0, symbols.Bounds().Dx()+300, symbols.Bounds().Dy()+200))
+	draw.Draw(screen, screen.Bounds(), &image.Uniform{C: color.RGBA{R: 60, G: 60, B: 70, A: 255}}, image.Point{}, draw.Src)
+	draw.Draw(screen, symbols.Bounds().Add(image.Pt(170, 90)), symbols, image.Point{}, draw.Src)
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, screen, &jpeg.Options{Quality: 60}))
+	img, err := jpeg.Decode(&buf)
+	require.NoError(t, err)
+
+	decoded, err := DecodeQR(img)
+	require.NoError(t, err)
+	assert.Equal(t, code, decoded)
+}
+
+func TestDecodeQR_Degraded(t *testing.T) {
+	// Codes carry their creation time, which would change the symbols
+	setNow(t, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	offer, err := EncodeOffer(wrap("offer", realisticSDP))
+	require.NoError(t, err)
+
+	for name, code := range map[string]string{"room code": offer, "split code": longCode(t)} {
+		qr, err := NewQRCode(code)
+		require.NoError(t, err)
+		img := qr.Image(4)
+
+		degraded := map[string]image.Image{
+			"enlarged":              resize(img, 1.7),
+			"shrunk":                resize(img, 0.7),
+			"blurred":               blur(img, 1),
+			"jpeg":                  compress(t, img, 15),
+			"enlarged blurred jpeg": compress(t, blur(resize(img, 1.5), 1), 30),
+		}
+		for how, degradedImg := range degraded {
+			t.Run(name+" "+how, func(t *testing.T) {
+				decoded, err := DecodeQR(degradedImg)
+				require.NoError(t, err)
+				assert.Equal(t, code, decoded)
+			})
+		}
+	}
+}
+
+func TestDecodeQR_OtherEncoder(t *testing.T) {
+	offer, err := EncodeOffer(wrap("offer", realisticSDP))
+	require.NoError(t, err)
+
+	// Symbols from ZXing's encoder, at every error correction level and at
+	// a size that is not a whole number of pixels per module
+	for _, level := range []string{"L", "M", "Q", "H"} {
+		hints := map[gozxing.EncodeHintType]interface{}{gozxing.EncodeHintType_ERROR_CORRECTION: level}
+		matrix, err := zxingqr.NewQRCodeWriter().Encode(offer, gozxing.BarcodeFormat_QR_CODE, 500, 500, hints)
+		require.NoError(t, err, level)
+
+		decoded, err := DecodeQR(matrix)
+		require.NoError(t, err, level)
+		assert.Equal(t, offer, decoded, level)
+
+		decoded, err = DecodeQR(compress(t, rotate90(matrix), 50))
+		require.NoError(t, err, level)
+		assert.Equal(t, offer, decoded, level)
+	}
+}
+
+func TestDecodeQR_ErrorCorrection(t *testing.T) {
+	setNow(t, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	code, err := EncodeAnswer(wrap("answer", realisticSDP))
+	require.NoError(t, err)
+	qr, err := NewQRCode(code)
+	require.NoError(t, err)
+
+	// Flip data modules, away from the finder patterns
+	bitmap := qr.bitmaps[0]
+	random := rand.New(rand.NewSource(2))
+	size := len(bitmap)
+	for range 12 {
+		x, y := 14+random.Intn(size-28), 14+random.Intn(size-28)
+		bitmap[y][x] = !bitmap[y][x]
+	}
+
+	decoded, err := DecodeQR(qr.Image(3))
+	require.NoError(t, err)
+	assert.Equal(t, code, decoded)
+}
+
+func TestDecodeQRFiles(t *testing.T) {
+	code := longCode(t)
+	qr, err := NewQRCode(code)
+	require.NoError(t, err)
+	dir := t.TempDir()
+
+	// One file per part, in any order
+	var paths []string
+	for i := range qr.Parts() {
+		part := &QRCode{bitmaps: qr.bitmaps[i : i+1]}
+		data, err := part.PNG(4)
+		require.NoError(t, err)
+
+		path := filepath.Join(dir, string(rune('a'+i))+".png")
+		require.NoError(t, os.WriteFile(path, data, 0o600))
+		paths = append([]string{path}, paths...)
+	}
+
+	decoded, err := DecodeQRFiles(paths...)
+	require.NoError(t, err)
+	assert.Equal(t, code, decoded)
+
+	_, err = DecodeQRFiles(paths[0], paths[2])
+	assert.ErrorIs(t, err, ErrMissingQRParts)
+	assert.Contains(t, err.Error(), "of 3")
+
+	// A picture with no QR code
+	var blank bytes.Buffer
+	require.NoError(t, png.Encode(&blank, image.NewGray(image.Rect(0, 0, 50, 50))))
+	blankPath := filepath.Join(dir, "blank.png")
+	require.NoError(t, os.WriteFile(blankPath, blank.Bytes(), 0o600))
+	_, err = DecodeQRFiles(blankPath)
+	assert.ErrorIs(t, err, ErrNoQRCode)
+
+	_, err = DecodeQRFiles(filepath.Join(dir, "missing.png"))
+	assert.Error(t, err)
+}
+
+func TestQRCode_Outputs(t *testing.T) {
+	qr, err := NewQRCode("p2p1o-\nAAAA BBBB")
+	require.NoError(t, err)
+	assert.Equal(t, "p2p1o-AAAABBBB", qr.Code())
+	size := len(qr.bitmaps[0])
+
+	svg := qr.SVG()
+	assert.True(t, strings.HasPrefix(svg, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 `))
+	assert.Contains(t, svg, "M4 4h1v1h-1z", "finder corner")
+
+	terminal := qr.Terminal()
+	lines := strings.Split(strings.TrimSuffix(terminal, "\n"), "\n")
+	assert.Len(t, lines, (size+1)/2)
+	assert.Equal(t, size, strings.Count(lines[0], "▀"))
+	assert.Contains(t, terminal, "\x1b[30;")
+
+	_, err = NewQRCode(" ")
+	assert.Error(t, err)
+}
+
+func TestSplitQR(t *testing.T) {
+	assert.Equal(t, []string{"short"}, splitQR("short", 10))
+
+	long := strings.Repeat("0123456789", 5)
+	parts := splitQR(long, 20)
+	assert.Equal(t, []string{"1/5:0123456789", "2/5:0123456789", "3/5:0123456789", "4/5:0123456789", "5/5:0123456789"}, parts)
+
+	joined, err := joinQR([]string{parts[4], parts[0], parts[2], parts[1], parts[3]})
+	require.NoError(t, err)
+	assert.Equal(t, long, joined)
+
+	_, err = joinQR([]string{"1/2:a", "1/3:b"})
+	assert.ErrorIs(t, err, ErrCorrupted)
+	_, err = joinQR([]string{"1/2:a", "1/2:b"})
+	assert.ErrorIs(t, err, ErrCorrupted)
+
+	// A header claiming a huge part count is refused before anything is allocated
+	_, err = joinQR([]string{"1/1000000000:x"})
+	assert.ErrorIs(t, err, ErrCorrupted)
+
+	// A whole code must be the only code found
+	_, err = joinQR([]string{"p2p1o-AAAA", "p2p1o-BBBB"})
+	assert.ErrorIs(t, err, ErrCorrupted)
+	_, err = joinQR([]string{"p2p1o-AAAA", parts[0]})
+	assert.ErrorIs(t, err, ErrCorrupted)
+	joined, err = joinQR([]string{"p2p1o-AAAA", "p2p1o-AAAA"})
+	require.NoError(t, err)
+	assert.Equal(t, "p2p1o-AAAA", joined)
+
+	_, err = NewQRCode(strings.Repeat("A", MaxQRParts*MaxQRPartLength))
+	assert.Error(t, err)
+}
+
+func TestDecodeQR_SeveralCodes(t *testing.T) {
+	first, err := NewQRCode("p2p1o-AAAA")
+	require.NoError(t, err)
+	second, err := NewQRCode("p2p1a-BBBB")
+	require.NoError(t, err)
+
+	// Two unrelated codes side by side are not a split code
+	both := &QRCode{bitmaps: append(first.bitmaps, second.bitmaps...)}
+	_, err = DecodeQR(both.Image(4))
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
@@ -0,0 +1,269 @@
+package signaling
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// EnvSignalServer is the URL of the rendezvous server the chat app offers,
+// e.g. http://example.com:8080
+const EnvSignalServer = "P2P_CHAT_SIGNAL"
+
+// Session is a client's connection to a rendezvous server, for one room.
+// Remote candidates are handed to the onCandidate callback given to
+// HostRoom or JoinRoom, in order, on the session's goroutine
+type Session struct {
+	conn        *websocket.Conn
+	room        string
+	onCandidate func(string)
+
+	// The guest's answer, for the host
+	answers chan string
+
+	done      chan struct{}
+	mu        sync.Mutex
+	err       error
+	closeOnce sync.Once
+}
+
+// HostRoom registers offer on the rendezvous server at serverURL and
+// returns once the server has given the room an ID. Local candidates are
+// sent with SendCandidate, the guest's answer is returned by WaitAnswer
+func HostRoom(ctx context.Context, serverURL, offer string, onCandidate func(string)) (*Session, error) {
+	if offer == "" {
+		return nil, fmt.Errorf("offer cannot be empty")
+	}
+
+	s, reply, err := dialRendezvous(ctx, serverURL, rendezvousMessage{Type: typeHost, Description: offer}, onCandidate)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type != typeRoom || reply.Room == "" {
+		s.conn.Close()
+		return nil, fmt.Errorf("unexpected %s message from rendezvous server", reply.Type)
+	}
+
+	s.room = reply.Room
+	go s.readLoop()
+	return s, nil
+}
+
+// JoinRoom joins a room on the rendezvous server at serverURL and returns
+// the host's offer. The answer and local candidates are sent with
+// SendAnswer and SendCandidate
+func JoinRoom(ctx context.Context, serverURL, room string, onCandidate func(string)) (*Session, string, error) {
+	room = NormalizeRoomID(room)
+	if room == "" {
+		return nil, "", fmt.Errorf("room ID cannot be empty")
+	}
+
+	s, reply, err := dialRendezvous(ctx, serverURL, rendezvousMessage{Type: typeJoin, Room: room}, onCandidate)
+	if err != nil {
+		return nil, "", err
+	}
+	if reply.Type != typeOffer || reply.Description == "" {
+		s.conn.Close()
+		return nil, "", fmt.Errorf("unexpected %s message from rendezvous server", reply.Type)
+	}
+
+	s.room = room
+	go s.readLoop()
+	return s, reply.Description, nil
+}
+
+// dialRendezvous connects, sends hello and reads the server's reply
+func dialRendezvous(ctx context.Context, serverURL string, hello rendezvousMessage, onCandidate func(string)) (*Session, rendezvousMessage, error) {
+	var reply rendezvousMessage
+
+	config, err := rendezvousConfig(serverURL)
+	if err != nil {
+		return nil, reply, err
+	}
+	conn, err := config.DialContext(ctx)
+	if err != nil {
+		return nil, reply, fmt.Errorf("failed to connect to rendezvous server: %w", err)
+	}
+	conn.MaxPayloadBytes = maxFrameSize
+
+	// Bound the handshake by ctx
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	err = websocket.JSON.Send(conn, hello)
+	if err == nil {
+		err = websocket.JSON.Receive(conn, &reply)
+	}
+	if !stop() {
+		conn.Close()
+		return nil, reply, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, reply, fmt.Errorf("failed to reach rendezvous server: %w", err)
+	}
+	if reply.Type == typeError {
+		conn.Close()
+		return nil, reply, rendezvousError(reply.Error)
+	}
+
+	s := &Session{
+		conn:        conn,
+		onCandidate: onCandidate,
+		answers:     make(chan string, 1),
+		done:        make(chan struct{}),
+	}
+	return s, reply, nil
+}
+
+// rendezvousConfig accepts http(s)://, ws(s):// or bare host:port server
+// URLs. The WebSocket path defaults to /ws
+func rendezvousConfig(serverURL string) (*websocket.Config, error) {
+	if !strings.Contains(serverURL, "://") {
+		serverURL = "ws://" + serverURL
+	}
+	location, err := url.Parse(serverURL)
+	if err != nil || location.Host == "" {
+		return nil, fmt.Errorf("invalid rendezvous server URL %q", serverURL)
+	}
+
+	switch location.Scheme {
+	case "http", "ws":
+		location.Scheme = "ws"
+	case "https", "wss":
+		location.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("invalid rendezvous server URL %q: unsupported scheme", serverURL)
+	}
+	if location.Path == "" || location.Path == "/" {
+		location.Path = "/ws"
+	}
+
+	origin := *location
+	origin.Scheme = strings.Replace(location.Scheme, "ws", "http", 1)
+	origin.Path = ""
+	return websocket.NewConfig(location.String(), origin.String())
+}
+
+// rendezvousError turns an error frame back into one of the rendezvous errors
+func rendezvousError(message string) error {
+	for _, err := range rendezvousErrors {
+		if message == err.Error() {
+			return err
+		}
+	}
+	return fmt.Errorf("rendezvous server: %s", message)
+}
+
+// Room returns the room ID, to give to the guest
+func (s *Session) Room() string {
+	return s.room
+}
+
+// SendCandidate relays a local candidate; "" ends the candidates
+func (s *Session) SendCandidate(candidate string) error {
+	return s.send(rendezvousMessage{Type: typeCandidate, Candidate: candidate})
+}
+
+// SendAnswer relays the guest's answer to the host
+func (s *Session) SendAnswer(answer string) error {
+	if answer == "" {
+		return fmt.Errorf("answer cannot be empty")
+	}
+	return s.send(rendezvousMessage{Type: typeAnswer, Description: answer})
+}
+
+func (s *Session) send(msg rendezvousMessage) error {
+	select {
+	case <-s.done:
+		return s.Err()
+	default:
+	}
+
+	if err := send(s.conn, msg); err != nil {
+		return fmt.Errorf("failed to send to rendezvous server: %w", err)
+	}
+	return nil
+}
+
+// WaitAnswer returns the guest's answer (host only). Guests that leave
+// before answering do not end the wait; the room is open for another one
+func (s *Session) WaitAnswer(ctx context.Context) (string, error) {
+	select {
+	case answer := <-s.answers:
+		return answer, nil
+	case <-s.done:
+		// An answer read just before the server hung up still counts
+		select {
+		case answer := <-s.answers:
+			return answer, nil
+		default:
+			return "", s.Err()
+		}
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Done is closed when the session ends, see Err
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err is why the session ended: ErrRoomExpired, ErrRoomClosed, net.ErrClosed
+// after Close, or a connection error
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close leaves the room. For the host this closes the room
+func (s *Session) Close() error {
+	s.closeOnce.Do(func() {
+		s.setErr(net.ErrClosed)
+		s.conn.Close()
+	})
+	return nil
+}
+
+func (s *Session) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// readLoop dispatches the server's frames until the connection ends
+func (s *Session) readLoop() {
+	defer close(s.done)
+
+	for {
+		var msg rendezvousMessage
+		if err := websocket.JSON.Receive(s.conn, &msg); err != nil {
+			s.setErr(fmt.Errorf("lost connection to rendezvous server: %w", err))
+			s.conn.Close()
+			return
+		}
+
+		switch msg.Type {
+		case typeCandidate:
+			if s.onCandidate != nil {
+				s.onCandidate(msg.Candidate)
+			}
+		case typeAnswer:
+			select {
+			case s.answers <- msg.Description:
+			default:
+			}
+		case typeClosed:
+			s.setErr(ErrRoomClosed)
+		case typeError:
+			s.setErr(rendezvousError(msg.Error))
+		}
+	}
+}
@@ -0,0 +1,509 @@
+package signaling
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/websocket"
+)
+
+// The rendezvous protocol replaces copy/paste with a server: the host
+// registers its offer under a short room ID, the guest joins with the ID
+// and the answer and trickled candidates are relayed between them. Every
+// frame is a JSON rendezvousMessage over a WebSocket at /ws
+
+const (
+	// DefaultRoomTTL is how long a room waits for an answer
+	DefaultRoomTTL = 10 * time.Minute
+
+	// DefaultRoomLifetime is how long any room lives, answered or not
+	DefaultRoomLifetime = 30 * time.Minute
+
+	// DefaultMaxRooms caps the rooms a server keeps open at once
+	DefaultMaxRooms = 1000
+
+	// DefaultMaxRoomsPerClient caps the rooms one IP address keeps open
+	DefaultMaxRoomsPerClient = 10
+
+	// MaxRoomCandidates caps the candidates each side of a room may send;
+	// a peer gathers a handful
+	MaxRoomCandidates = 100
+
+	// RoomIDLength is the number of characters of a room ID
+	RoomIDLength = 6
+
+	// roomAlphabet leaves out 0, 1, I, L and O, which are easy to mix up
+	roomAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+	// maxFrameSize bounds a frame; descriptions are a few kilobytes
+	maxFrameSize = 64 << 10
+
+	// writeTimeout drops peers that stop reading
+	writeTimeout = 10 * time.Second
+)
+
+// Errors the server reports to clients, returned by HostRoom, JoinRoom and
+// Session.Err
+var (
+	ErrRoomNotFound = errors.New("room not found")
+	ErrRoomFull     = errors.New("room is full")
+	ErrRoomExpired  = errors.New("room has expired")
+	ErrRoomClosed   = errors.New("room was closed")
+	ErrServerFull   = errors.New("server has too many rooms")
+
+	ErrTooManyRooms      = errors.New("too many rooms from this address")
+	ErrTooManyCandidates = errors.New("too many candidates")
+)
+
+// rendezvousErrors lets clients turn error frames back into the errors above
+var rendezvousErrors = []error{
+	ErrRoomNotFound, ErrRoomFull, ErrRoomExpired, ErrRoomClosed, ErrServerFull,
+	ErrTooManyRooms, ErrTooManyCandidates,
+}
+
+// Frame types
+const (
+	typeHost      = "host"      // host -> server: register Description, get a room
+	typeJoin      = "join"      // guest -> server: join Room, get the offer
+	typeRoom      = "room"      // server -> host: the room ID
+	typeOffer     = "offer"     // server -> guest: the host's Description
+	typeAnswer    = "answer"    // guest -> host, through the server
+	typeCandidate = "candidate" // both ways, "" ends the candidates
+	typeJoined    = "joined"    // server -> host: a guest joined
+	typeLeft      = "left"      // server -> host: the guest left before answering
+	typeClosed    = "closed"    // server -> guest: the host closed the room
+	typeError     = "error"     // server -> either, then the server hangs up
+)
+
+// rendezvousMessage is a frame of the rendezvous protocol
+type rendezvousMessage struct {
+	Type        string `json:"type"`
+	Room        string `json:"room,omitempty"`
+	Description string `json:"description,omitempty"`
+	Candidate   string `json:"candidate,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ServerConfig tunes a rendezvous Server
+type ServerConfig struct {
+	// RoomTTL closes rooms that got no answer in time. Zero means DefaultRoomTTL
+	RoomTTL time.Duration
+
+	// RoomLifetime closes every room this long after it opened, answered or
+	// not. Zero means DefaultRoomLifetime; it is never shorter than RoomTTL
+	RoomLifetime time.Duration
+
+	// MaxRooms caps the rooms open at once. Zero means DefaultMaxRooms
+	MaxRooms int
+
+	// MaxRoomsPerClient caps the rooms open at once from one IP address, so
+	// a single client cannot use up MaxRooms. Zero means
+	// DefaultMaxRoomsPerClient
+	MaxRoomsPerClient int
+}
+
+// Server is a rendezvous server. It is an http.Handler serving:
+//
+//	GET /ws          the WebSocket of the rendezvous protocol
+//	GET /rooms/{id}  whether a room exists and is waiting for a guest
+//	GET /healthz     "ok"
+type Server struct {
+	config ServerConfig
+	mux    *http.ServeMux
+
+	mu      sync.Mutex
+	rooms   map[string]*room
+	clients map[string]int
+	conns   map[*websocket.Conn]struct{}
+	closed  bool
+}
+
+// room is a host waiting for, or talking to, a guest
+type room struct {
+	id     string
+	offer  string
+	client string
+
+	// expires is the deadline for an answer, closes the end of the room's
+	// lifetime. timer fires at the earlier one that applies
+	expires time.Time
+	closes  time.Time
+	timer   *time.Timer
+
+	// mu orders the frames sent to host and guest
+	mu         sync.Mutex
+	host       *websocket.Conn
+	guest      *websocket.Conn
+	candidates []string
+	answered   bool
+
+	// Candidates relayed from the guest, see MaxRoomCandidates
+	guestCandidates int
+}
+
+// NewServer creates a rendezvous server. Serve it with http.ListenAndServe
+// or httptest.NewServer
+func NewServer(config ServerConfig) *Server {
+	if config.RoomTTL <= 0 {
+		config.RoomTTL = DefaultRoomTTL
+	}
+	if config.RoomLifetime <= 0 {
+		config.RoomLifetime = DefaultRoomLifetime
+	}
+	config.RoomLifetime = max(config.RoomLifetime, config.RoomTTL)
+	if config.MaxRooms <= 0 {
+		config.MaxRooms = DefaultMaxRooms
+	}
+	if config.MaxRoomsPerClient <= 0 {
+		config.MaxRoomsPerClient = DefaultMaxRoomsPerClient
+	}
+
+	s := &Server{
+		config:  config,
+		mux:     http.NewServeMux(),
+		rooms:   make(map[string]*room),
+		clients: make(map[string]int),
+		conns:   make(map[*websocket.Conn]struct{}),
+	}
+
+	// Native clients send no Origin header, so any origin is accepted
+	s.mux.Handle("GET /ws", websocket.Server{
+		Handler:   s.serveConn,
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+	})
+	s.mux.HandleFunc("GET /rooms/{id}", s.serveRoom)
+	s.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Rooms returns the number of open rooms
+func (s *Server) Rooms() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.rooms)
+}
+
+// Close hangs up on every client. The http.Server serving s is the
+// caller's to shut down
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	conns := make([]*websocket.Conn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// serveRoom reports a room without joining it, so a UI can check an ID
+func (s *Server) serveRoom(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	rm := s.rooms[NormalizeRoomID(r.PathValue("id"))]
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if rm == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": ErrRoomNotFound.Error()})
+		return
+	}
+
+	rm.mu.Lock()
+	full := rm.guest != nil || rm.answered
+	expires := rm.expires
+	if rm.answered {
+		expires = rm.closes
+	}
+	rm.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]any{"room": rm.id, "full": full, "expires": expires})
+}
+
+// serveConn runs one client, host or guest, until it hangs up
+func (s *Server) serveConn(conn *websocket.Conn) {
+	conn.MaxPayloadBytes = maxFrameSize
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	// The first frame says who the client is
+	conn.SetReadDeadline(time.Now().Add(writeTimeout))
+	var first rendezvousMessage
+	if err := websocket.JSON.Receive(conn, &first); err != nil {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	switch first.Type {
+	case typeHost:
+		s.serveHost(conn, first)
+	case typeJoin:
+		s.serveGuest(conn, first)
+	default:
+		sendError(conn, errors.New("expected a host or join message"))
+	}
+}
+
+// serveHost opens a room for conn and relays its candidates to the guest
+func (s *Server) serveHost(conn *websocket.Conn, first rendezvousMessage) {
+	if first.Description == "" {
+		sendError(conn, errors.New("host message has no description"))
+		return
+	}
+
+	rm, err := s.openRoom(conn, first.Description)
+	if err != nil {
+		sendError(conn, err)
+		return
+	}
+	defer s.closeRoom(rm, nil)
+
+	rm.mu.Lock()
+	err = send(conn, rendezvousMessage{Type: typeRoom, Room: rm.id})
+	rm.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	for {
+		var msg rendezvousMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case typeCandidate:
+			rm.mu.Lock()
+			if len(rm.candidates) >= MaxRoomCandidates {
+				rm.mu.Unlock()
+				sendError(conn, ErrTooManyCandidates)
+				return
+			}
+			rm.candidates = append(rm.candidates, msg.Candidate)
+			if rm.guest != nil {
+				send(rm.guest, rendezvousMessage{Type: typeCandidate, Candidate: msg.Candidate})
+			}
+			rm.mu.Unlock()
+		default:
+			sendError(conn, errors.New("unexpected "+msg.Type+" message"))
+			return
+		}
+	}
+}
+
+// serveGuest hands the offer and the host's candidates to conn and relays
+// its answer and candidates to the host
+func (s *Server) serveGuest(conn *websocket.Conn, first rendezvousMessage) {
+	s.mu.Lock()
+	rm := s.rooms[NormalizeRoomID(first.Room)]
+	s.mu.Unlock()
+	if rm == nil {
+		sendError(conn, ErrRoomNotFound)
+		return
+	}
+
+	rm.mu.Lock()
+	if rm.guest != nil || rm.answered || rm.host == nil {
+		rm.mu.Unlock()
+		sendError(conn, ErrRoomFull)
+		return
+	}
+	rm.guest = conn
+	send(conn, rendezvousMessage{Type: typeOffer, Room: rm.id, Description: rm.offer})
+	for _, candidate := range rm.candidates {
+		send(conn, rendezvousMessage{Type: typeCandidate, Candidate: candidate})
+	}
+	send(rm.host, rendezvousMessage{Type: typeJoined})
+	rm.mu.Unlock()
+
+	// A guest that leaves before answering frees the room for another one
+	defer func() {
+		rm.mu.Lock()
+		defer rm.mu.Unlock()
+		if rm.guest == conn {
+			rm.guest = nil
+			if !rm.answered && rm.host != nil {
+				send(rm.host, rendezvousMessage{Type: typeLeft})
+			}
+		}
+	}()
+
+	for {
+		var msg rendezvousMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return
+		}
+
+		rm.mu.Lock()
+		switch {
+		case msg.Type == typeAnswer && !rm.answered && msg.Description != "":
+			rm.answered = true
+		case msg.Type == typeCandidate && rm.guestCandidates < MaxRoomCandidates:
+			rm.guestCandidates++
+		case msg.Type == typeCandidate:
+			rm.mu.Unlock()
+			sendError(conn, ErrTooManyCandidates)
+			return
+		default:
+			rm.mu.Unlock()
+			sendError(conn, errors.New("unexpected "+msg.Type+" message"))
+			return
+		}
+		if rm.host != nil {
+			send(rm.host, rendezvousMessage{Type: msg.Type, Description: msg.Description, Candidate: msg.Candidate})
+		}
+		rm.mu.Unlock()
+	}
+}
+
+// openRoom registers offer under a new room ID
+func (s *Server) openRoom(host *websocket.Conn, offer string) (*room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client := clientAddress(host)
+	if len(s.rooms) >= s.config.MaxRooms {
+		return nil, ErrServerFull
+	}
+	if s.clients[client] >= s.config.MaxRoomsPerClient {
+		return nil, ErrTooManyRooms
+	}
+
+	id := newRoomID()
+	for s.rooms[id] != nil {
+		id = newRoomID()
+	}
+
+	now := time.Now()
+	rm := &room{
+		id:      id,
+		offer:   offer,
+		client:  client,
+		host:    host,
+		expires: now.Add(s.config.RoomTTL),
+		closes:  now.Add(s.config.RoomLifetime),
+	}
+	rm.mu.Lock()
+	rm.timer = time.AfterFunc(s.config.RoomTTL, func() { s.expire(rm) })
+	rm.mu.Unlock()
+
+	s.rooms[id] = rm
+	s.clients[client]++
+	return rm, nil
+}
+
+// expire closes rm when nobody answered within the room TTL, and answered
+// rooms when their lifetime is up
+func (s *Server) expire(rm *room) {
+	rm.mu.Lock()
+	if left := time.Until(rm.closes); rm.answered && left > 0 {
+		rm.timer.Reset(left)
+		rm.mu.Unlock()
+		return
+	}
+	rm.mu.Unlock()
+
+	s.closeRoom(rm, ErrRoomExpired)
+}
+
+// closeRoom removes rm and hangs up on its guest, and on its host with
+// reason when the server is the one closing it
+func (s *Server) closeRoom(rm *room, reason error) {
+	s.mu.Lock()
+	if s.rooms[rm.id] == rm {
+		delete(s.rooms, rm.id)
+		if s.clients[rm.client]--; s.clients[rm.client] <= 0 {
+			delete(s.clients, rm.client)
+		}
+	}
+	s.mu.Unlock()
+	rm.timer.Stop()
+
+	rm.mu.Lock()
+	host, guest := rm.host, rm.guest
+	rm.host, rm.guest = nil, nil
+	rm.mu.Unlock()
+
+	if guest != nil {
+		send(guest, rendezvousMessage{Type: typeClosed})
+		guest.Close()
+	}
+	if host != nil && reason != nil {
+		sendError(host, reason)
+		host.Close()
+	}
+}
+
+// clientAddress is the IP address conn comes from. Behind a reverse proxy
+// this is the proxy's
+func clientAddress(conn *websocket.Conn) string {
+	host, _, err := net.SplitHostPort(conn.Request().RemoteAddr)
+	if err != nil {
+		return conn.Request().RemoteAddr
+	}
+	return host
+}
+
+// send writes a frame, giving up on peers that stop reading
+func send(conn *websocket.Conn, msg rendezvousMessage) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return websocket.JSON.Send(conn, msg)
+}
+
+func sendError(conn *websocket.Conn, err error) {
+	send(conn, rendezvousMessage{Type: typeError, Error: err.Error()})
+}
+
+// newRoomID returns RoomIDLength random characters of roomAlphabet
+func newRoomID() string {
+	b := make([]byte, RoomIDLength)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand does not fail on supported platforms
+		panic(err)
+	}
+
+	// 256 is not a multiple of the alphabet size; the bias is negligible
+	// for IDs that only live for minutes
+	for i, v := range b {
+		b[i] = roomAlphabet[int(v)%len(roomAlphabet)]
+	}
+	return string(b)
+}
+
+// NormalizeRoomID uppercases a typed room ID and drops spaces and dashes
+func NormalizeRoomID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(id))
+}
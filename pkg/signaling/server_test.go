@@ -0,0 +1,317 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rendezvousTimeout = 2 * time.Second
+
+// startRendezvous serves a rendezvous server for one test
+func startRendezvous(t *testing.T, config ServerConfig) (*Server, string) {
+	t.Helper()
+
+	server := NewServer(config)
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(func() {
+		server.Close()
+		httpServer.Close()
+	})
+	return server, httpServer.URL
+}
+
+// candidates collects the candidates a session receives
+func candidates() (func(string), chan string) {
+	ch := make(chan string, 16)
+	return func(candidate string) { ch <- candidate }, ch
+}
+
+func receive(t *testing.T, ch chan string, what string) string {
+	t.Helper()
+
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(rendezvousTimeout):
+		t.Fatalf("timed out waiting for %s", what)
+		return ""
+	}
+}
+
+func waitDone(t *testing.T, session *Session) {
+	t.Helper()
+
+	select {
+	case <-session.Done():
+	case <-time.After(rendezvousTimeout):
+		t.Fatal("session did not end")
+	}
+}
+
+func TestRendezvous_Exchange(t *testing.T) {
+	server, url := startRendezvous(t, ServerConfig{})
+	ctx := context.Background()
+
+	onHostCandidate, hostCandidates := candidates()
+	host, err := HostRoom(ctx, url, "the offer", onHostCandidate)
+	require.NoError(t, err)
+	defer host.Close()
+	assert.Regexp(t, "^["+roomAlphabet+"]{6}$", host.Room())
+	assert.Equal(t, 1, server.Rooms())
+
+	// Candidates gathered before the guest shows up are kept for it
+	require.NoError(t, host.SendCandidate("host 1"))
+
+	onGuestCandidate, guestCandidates := candidates()
+	typed := strings.ToLower(host.Room()[:3] + "-" + host.Room()[3:])
+	guest, offer, err := JoinRoom(ctx, url, typed, onGuestCandidate)
+	require.NoError(t, err)
+	defer guest.Close()
+	assert.Equal(t, "the offer", offer)
+	assert.Equal(t, host.Room(), guest.Room())
+	assert.Equal(t, "host 1", receive(t, guestCandidates, "early host candidate"))
+
+	require.NoError(t, host.SendCandidate("host 2"))
+	require.NoError(t, host.SendCandidate(""))
+	assert.Equal(t, "host 2", receive(t, guestCandidates, "host candidate"))
+	assert.Equal(t, "", receive(t, guestCandidates, "end of host candidates"))
+
+	require.NoError(t, guest.SendAnswer("the answer"))
+	require.NoError(t, guest.SendCandidate("guest 1"))
+	require.NoError(t, guest.SendCandidate(""))
+
+	waitCtx, cancel := context.WithTimeout(ctx, rendezvousTimeout)
+	defer cancel()
+	answer, err := host.WaitAnswer(waitCtx)
+	require.NoError(t, err)
+	assert.Equal(t, "the answer", answer)
+	assert.Equal(t, "guest 1", receive(t, hostCandidates, "guest candidate"))
+	assert.Equal(t, "", receive(t, hostCandidates, "end of guest candidates"))
+
+	// The host leaving closes the room
+	host.Close()
+	waitDone(t, guest)
+	assert.ErrorIs(t, guest.Err(), ErrRoomClosed)
+	assert.ErrorIs(t, host.Err(), net.ErrClosed)
+	assert.Eventually(t, func() bool { return server.Rooms() == 0 }, rendezvousTimeout, 10*time.Millisecond)
+}
+
+func TestRendezvous_RoomErrors(t *testing.T) {
+	_, url := startRendezvous(t, ServerConfig{})
+	ctx := context.Background()
+
+	_, _, err := JoinRoom(ctx, url, "ABCDEF", nil)
+	assert.ErrorIs(t, err, ErrRoomNotFound)
+
+	host, err := HostRoom(ctx, url, "the offer", nil)
+	require.NoError(t, err)
+	defer host.Close()
+
+	first, _, err := JoinRoom(ctx, url, host.Room(), nil)
+	require.NoError(t, err)
+	_, _, err = JoinRoom(ctx, url, host.Room(), nil)
+	assert.ErrorIs(t, err, ErrRoomFull)
+
+	// A guest leaving before answering frees the room
+	first.Close()
+	assert.Eventually(t, func() bool {
+		second, _, err := JoinRoom(ctx, url, host.Room(), nil)
+		if err != nil {
+			return false
+		}
+		defer second.Close()
+		return assert.NoError(t, second.SendAnswer("the answer"))
+	}, rendezvousTimeout, 10*time.Millisecond)
+
+	answer, err := host.WaitAnswer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "the answer", answer)
+
+	// Nobody joins an answered room
+	_, _, err = JoinRoom(ctx, url, host.Room(), nil)
+	assert.ErrorIs(t, err, ErrRoomFull)
+
+	_, err = HostRoom(ctx, url, "", nil)
+	assert.Error(t, err)
+	_, _, err = JoinRoom(ctx, url, " - ", nil)
+	assert.Error(t, err)
+}
+
+func TestRendezvous_Limits(t *testing.T) {
+	_, url := startRendezvous(t, ServerConfig{MaxRooms: 1, RoomTTL: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	host, err := HostRoom(ctx, url, "the offer", nil)
+	require.NoError(t, err)
+	defer host.Close()
+
+	_, err = HostRoom(ctx, url, "another offer", nil)
+	assert.ErrorIs(t, err, ErrServerFull)
+
+	// Rooms nobody answers expire
+	_, err = host.WaitAnswer(ctx)
+	assert.ErrorIs(t, err, ErrRoomExpired)
+
+	_, _, err = JoinRoom(ctx, url, host.Room(), nil)
+	assert.ErrorIs(t, err, ErrRoomNotFound)
+
+	again, err := HostRoom(ctx, url, "another offer", nil)
+	require.NoError(t, err)
+	again.Close()
+
+	// Unreachable servers and cancelled handshakes
+	_, err = HostRoom(ctx, "127.0.0.1:1", "the offer", nil)
+	assert.Error(t, err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = HostRoom(cancelled, url, "the offer", nil)
+	assert.Error(t, err)
+}
+
+func TestServer_HTTP(t *testing.T) {
+	_, url := startRendezvous(t, ServerConfig{})
+
+	host, err := HostRoom(context.Background(), url, "the offer", nil)
+	require.NoError(t, err)
+	defer host.Close()
+
+	resp, err := http.Get(url + "/rooms/" + strings.ToLower(host.Room()))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var status struct {
+		Room string `json:"room"`
+		Full bool   `json:"full"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.Equal(t, host.Room(), status.Room)
+	assert.False(t, status.Full)
+
+	resp, err = http.Get(url + "/rooms/ZZZZZZ")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(url + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRendezvousConfig(t *testing.T) {
+	tests := map[string]string{
+		"http://example.com":       "ws://example.com/ws",
+		"https://example.com:8443": "wss://example.com:8443/ws",
+		"wss://example.com/signal": "wss://example.com/signal",
+		"example.com:8080":         "ws://example.com:8080/ws",
+		"http://example.com/":      "ws://example.com/ws",
+	}
+	for serverURL, want := range tests {
+		config, err := rendezvousConfig(serverURL)
+		require.NoError(t, err, serverURL)
+		assert.Equal(t, want, config.Location.String())
+	}
+
+	for _, invalid := range []string{"", "ftp://example.com", "http://"} {
+		_, err := rendezvousConfig(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	assert.Equal(t, "K7QF3M", NormalizeRoomID(" k7q-f3m\n"))
+}
+
+func TestRendezvous_CandidateLimit(t *testing.T) {
+	server, url := startRendezvous(t, ServerConfig{})
+	ctx := context.Background()
+
+	// The guest's candidates are capped too
+	host, err := HostRoom(ctx, url, "the offer", nil)
+	require.NoError(t, err)
+	defer host.Close()
+	guest, _, err := JoinRoom(ctx, url, host.Room(), nil)
+	require.NoError(t, err)
+	defer guest.Close()
+
+	for range MaxRoomCandidates + 1 {
+		if guest.SendCandidate("guest") != nil {
+			break
+		}
+	}
+	waitDone(t, guest)
+	assert.ErrorIs(t, guest.Err(), ErrTooManyCandidates)
+
+	// Candidates sent before anyone joins are kept, but only so many
+	lonely, err := HostRoom(ctx, url, "the offer", nil)
+	require.NoError(t, err)
+	defer lonely.Close()
+
+	for range MaxRoomCandidates + 1 {
+		if lonely.SendCandidate("host") != nil {
+			break
+		}
+	}
+	waitDone(t, lonely)
+	assert.ErrorIs(t, lonely.Err(), ErrTooManyCandidates)
+	assert.Eventually(t, func() bool { return server.Rooms() == 1 }, rendezvousTimeout, 10*time.Millisecond)
+}
+
+func TestRendezvous_AnsweredLifetime(t *testing.T) {
+	server, url := startRendezvous(t, ServerConfig{RoomTTL: 50 * time.Millisecond, RoomLifetime: 300 * time.Millisecond})
+	ctx := context.Background()
+
+	host, err := HostRoom(ctx, url, "the offer", nil)
+	require.NoError(t, err)
+	defer host.Close()
+	guest, _, err := JoinRoom(ctx, url, host.Room(), nil)
+	require.NoError(t, err)
+	defer guest.Close()
+	require.NoError(t, guest.SendAnswer("the answer"))
+	_, err = host.WaitAnswer(ctx)
+	require.NoError(t, err)
+
+	// Answered rooms outlive the room TTL, but not the room lifetime
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, server.Rooms())
+
+	waitDone(t, host)
+	assert.ErrorIs(t, host.Err(), ErrRoomExpired)
+	waitDone(t, guest)
+	assert.ErrorIs(t, guest.Err(), ErrRoomClosed)
+	assert.Equal(t, 0, server.Rooms())
+}
+
+func TestRendezvous_ClientLimit(t *testing.T) {
+	_, url := startRendezvous(t, ServerConfig{MaxRoomsPerClient: 2})
+	ctx := context.Background()
+
+	first, err := HostRoom(ctx, url, "the offer", nil)
+	require.NoError(t, err)
+	second, err := HostRoom(ctx, url, "the offer", nil)
+	require.NoError(t, err)
+	defer second.Close()
+
+	_, err = HostRoom(ctx, url, "the offer", nil)
+	assert.ErrorIs(t, err, ErrTooManyRooms)
+
+	// Closing a room makes room for another
+	first.Close()
+	assert.Eventually(t, func() bool {
+		third, err := HostRoom(ctx, url, "the offer", nil)
+		if err != nil {
+			return false
+		}
+		third.Close()
+		return true
+	}, rendezvousTimeout, 10*time.Millisecond)
+}
@@ -0,0 +1,253 @@
+package signaling
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Word codes spell a room or answer code as English words, for reading it
+// aloud. The first word is the kind ("room" or "answer"), then each byte of
+// the code's payload is one word of wordList. The payload ends with the
+// code's CRC-32, which also settles typos that match two words equally
+
+const (
+	// wordsPerLine groups the words so a listener can keep track
+	wordsPerLine = 8
+
+	// maxTypos is how many wrong, missing, extra or swapped letters a word
+	// can have and still be recognized
+	maxTypos = 2
+
+	// maxWordGuesses bounds the combinations tried when typos are ambiguous
+	maxWordGuesses = 256
+)
+
+// kindWords starts word codes
+var kindWords = map[Kind]string{KindOffer: "room", KindAnswer: "answer"}
+
+// wordList maps each byte to a word. Any two words differ by at least three
+// letters and in their first four, so a word with one typo or cut after four
+// letters is still found
+var wordList = [256]string{
+	"acorn", "adult", "agent", "alarm", "alien", "alpha", "amber", "anchor",
+	"angel", "apple", "armor", "atlas", "attic", "autumn", "avocado", "badge",
+	"baker", "bamboo", "banana", "banjo", "barn", "beach", "berry", "bicycle",
+	"bishop", "blanket", "blender", "blossom", "bonus", "border", "bottle", "bread",
+	"brick", "bronze", "broom", "bubble", "bucket", "buffalo", "butter", "cabin",
+	"cactus", "camel", "candle", "canvas", "canyon", "cargo", "carpet", "cellar",
+	"cement", "cereal", "chalk", "channel", "chess", "chicken", "chimney", "circle",
+	"cliff", "clock", "clover", "cobalt", "cobra", "coconut", "coffee", "compass",
+	"cookie", "copper", "cotton", "couch", "cougar", "cowboy", "coyote", "crab",
+	"crater", "credit", "crown", "crystal", "cuckoo", "cupcake", "cupid", "curtain",
+	"cushion", "dagger", "daisy", "decade", "denim", "desert", "diamond", "dingo",
+	"dinner", "dolphin", "domino", "donkey", "dove", "dragon", "drum", "eagle",
+	"earth", "echo", "eclipse", "elbow", "emerald", "engine", "falcon", "feather",
+	"fence", "fiddle", "flame", "forest", "fossil", "galaxy", "garden", "garlic",
+	"gazelle", "geyser", "giant", "giraffe", "glacier", "goblin", "goose", "gorilla",
+	"granite", "grape", "guitar", "harvest", "helmet", "hippo", "hornet", "hotel",
+	"husky", "igloo", "iguana", "iris", "island", "ivory", "jaguar", "jasmine",
+	"jelly", "jester", "jewel", "jigsaw", "jungle", "kayak", "kitten", "kiwi",
+	"koala", "ladder", "lagoon", "lantern", "laptop", "lasso", "lemon", "leopard",
+	"lily", "lizard", "lobster", "lynx", "mammoth", "marble", "meadow", "mermaid",
+	"mesa", "meteor", "mirror", "mocha", "mosaic", "muffin", "mustard", "napkin",
+	"nectar", "needle", "nugget", "nutmeg", "oasis", "ocean", "olive", "onion",
+	"opal", "orange", "orbit", "orchid", "palace", "panda", "panther", "parrot",
+	"parsley", "peanut", "pearl", "pelican", "pencil", "pickle", "pigeon", "pillow",
+	"pine", "pirate", "pizza", "poetry", "potato", "pretzel", "pumpkin", "puppet",
+	"puzzle", "python", "quartz", "quilt", "rabbit", "radar", "raft", "raven",
+	"reef", "rhino", "rubber", "ruby", "salsa", "saucer", "scarf", "silver",
+	"skate", "sketch", "slipper", "sloth", "snail", "spider", "spoon", "spruce",
+	"squid", "summit", "sunset", "swan", "sweater", "tablet", "taco", "tadpole",
+	"teapot", "temple", "tennis", "thistle", "thunder", "tiger", "toast", "tractor",
+	"trout", "tulip", "tundra", "tunnel", "turkey", "turtle", "tuxedo", "unicorn",
+	"valley", "violin", "volcano", "waffle", "walnut", "window", "wolf", "yogurt",
+}
+
+var wordIndex = func() map[string]byte {
+	index := make(map[string]byte, len(wordList))
+	for i, word := range wordList {
+		index[word] = byte(i)
+	}
+	return index
+}()
+
+// ToWords spells a room or answer code as words. Decode, DecodeOffer and
+// DecodeAnswer read the words back, typos included
+func ToWords(code string) (string, error) {
+	parsed, err := ParseCode(code)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Kind == 0 {
+		return "", fmt.Errorf("only room and answer codes can be spelled as words")
+	}
+
+	if isWords(code) {
+		if code, err = FromWords(code); err != nil {
+			return "", err
+		}
+	}
+	code = stripSpaces(code)
+	payload, err := base64.RawURLEncoding.DecodeString(code[len(codeHeader(codeVersion, parsed.Kind)):])
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid characters", ErrCorrupted)
+	}
+
+	var b strings.Builder
+	b.WriteString(kindWords[parsed.Kind])
+	for i, v := range payload {
+		if i%wordsPerLine == 0 {
+			b.WriteByte('\n')
+		} else {
+			b.WriteByte(' ')
+		}
+		b.WriteString(wordList[v])
+	}
+	return b.String(), nil
+}
+
+// FromWords turns words back into the text form of the code. Case,
+// punctuation and numbering are ignored, and misspelled words are corrected
+// to the closest ones that give a valid checksum
+func FromWords(words string) (string, error) {
+	fields := wordFields(words)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%w: no words", ErrCorrupted)
+	}
+
+	var kind Kind
+	for k, word := range kindWords {
+		if fields[0] == word {
+			kind = k
+		}
+	}
+	if kind == 0 {
+		return "", fmt.Errorf("%w: word codes start with %q or %q", ErrCorrupted, kindWords[KindOffer], kindWords[KindAnswer])
+	}
+
+	guesses := 1
+	candidates := make([][]byte, len(fields)-1)
+	for i, field := range fields[1:] {
+		candidates[i] = matchWord(field)
+		if len(candidates[i]) == 0 {
+			return "", fmt.Errorf("%w: unknown word %q (word %d)", ErrCorrupted, field, i+2)
+		}
+		guesses *= len(candidates[i])
+		if guesses > maxWordGuesses {
+			return "", fmt.Errorf("%w: too many misspelled words", ErrCorrupted)
+		}
+	}
+	if len(candidates) < 8 {
+		return "", fmt.Errorf("%w: too few words", ErrCorrupted)
+	}
+
+	// Try every reading of the ambiguous words until the checksum matches
+	header := codeHeader(codeVersion, kind)
+	choice := make([]int, len(candidates))
+	payload := make([]byte, len(candidates))
+	for range guesses {
+		for i, c := range choice {
+			payload[i] = candidates[i][c]
+		}
+		data, sum := payload[:len(payload)-4], binary.BigEndian.Uint32(payload[len(payload)-4:])
+		if checksum(header, data) == sum {
+			return header + base64.RawURLEncoding.EncodeToString(payload), nil
+		}
+
+		for i := range choice {
+			choice[i]++
+			if choice[i] < len(candidates[i]) {
+				break
+			}
+			choice[i] = 0
+		}
+	}
+	return "", fmt.Errorf("%w: checksum mismatch, a word is wrong, missing or out of order", ErrCorrupted)
+}
+
+// isWords tells word codes from text codes, which never start with a kind word
+func isWords(code string) bool {
+	notLetter := func(r rune) bool { return !unicode.IsLetter(r) }
+	code = strings.TrimLeftFunc(code, notLetter)
+	if end := strings.IndexFunc(code, notLetter); end >= 0 {
+		code = code[:end]
+	}
+
+	for _, word := range kindWords {
+		if strings.EqualFold(code, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// wordFields splits lowercased text on everything but letters
+func wordFields(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+}
+
+// matchWord returns the bytes a possibly misspelled or shortened word can
+// stand for: one when it is known or a unique prefix, the closest words
+// otherwise
+func matchWord(word string) []byte {
+	if v, ok := wordIndex[word]; ok {
+		return []byte{v}
+	}
+
+	if len(word) >= 4 {
+		var prefixed []byte
+		for i, candidate := range wordList {
+			if strings.HasPrefix(candidate, word) {
+				prefixed = append(prefixed, byte(i))
+			}
+		}
+		if len(prefixed) == 1 {
+			return prefixed
+		}
+	}
+
+	var closest []byte
+	best := min(maxTypos, len(word)/2)
+	for i, candidate := range wordList {
+		distance := typoDistance(word, candidate)
+		if distance < best {
+			best, closest = distance, nil
+		}
+		if distance == best {
+			closest = append(closest, byte(i))
+		}
+	}
+	return closest
+}
+
+// typoDistance counts the letters to insert, delete, replace or swap with
+// their neighbour to turn a into b
+func typoDistance(a, b string) int {
+	rows := make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(a)][len(b)]
+}
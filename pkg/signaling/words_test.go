@@ -0,0 +1,151 @@
+package signaling
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWordList(t *testing.T) {
+	for i, a := range wordList {
+		assert.Regexp(t, "^[a-z]{4,7}$", a)
+		for _, b := range wordList[i+1:] {
+			assert.GreaterOrEqual(t, typoDistance(a, b), 3, "%s and %s", a, b)
+			assert.NotEqual(t, a[:4], b[:4], "%s and %s", a, b)
+		}
+	}
+}
+
+func TestToWords(t *testing.T) {
+	description := wrap("offer", realisticSDP)
+	code, err := EncodeOffer(description)
+	require.NoError(t, err)
+
+	words, err := ToWords(code)
+	require.NoError(t, err)
+	fields := strings.Fields(words)
+	assert.Equal(t, "room", fields[0])
+	for _, word := range fields[1:] {
+		assert.Contains(t, wordIndex, word)
+	}
+	lines := strings.Split(words, "\n")
+	assert.Len(t, strings.Fields(lines[1]), wordsPerLine)
+
+	text, err := FromWords(words)
+	require.NoError(t, err)
+	assert.Equal(t, code, text)
+
+	decoded, err := DecodeOffer(words)
+	require.NoError(t, err)
+	assertSameDescription(t, description, decoded)
+
+	// Words of words are the same words, and they make the same QR code
+	again, err := ToWords(words)
+	require.NoError(t, err)
+	assert.Equal(t, words, again)
+	qr, err := NewQRCode(words)
+	require.NoError(t, err)
+	assert.Equal(t, code, qr.Code())
+
+	answer, err := EncodeAnswer(wrap("answer", realisticSDP))
+	require.NoError(t, err)
+	words, err = ToWords(answer)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(words, "answer\n"), words)
+
+	_, err = DecodeOffer(words)
+	assert.ErrorIs(t, err, ErrWrongKind)
+
+	legacy, err := Encode(realisticSDP)
+	require.NoError(t, err)
+	_, err = ToWords(legacy)
+	assert.Error(t, err)
+}
+
+func TestFromWords_Typos(t *testing.T) {
+	code, err := EncodeAnswer(wrap("answer", realisticSDP))
+	require.NoError(t, err)
+	words, err := ToWords(code)
+	require.NoError(t, err)
+	fields := strings.Fields(words)
+
+	edits := map[string]func(string) string{
+		"replaced letter": func(w string) string { return w[:1] + "x" + w[2:] },
+		"missing letter":  func(w string) string { return w[:2] + w[3:] },
+		"extra letter":    func(w string) string { return w[:2] + "e" + w[2:] },
+		"swapped letters": func(w string) string { return w[:1] + w[2:3] + w[1:2] + w[3:] },
+		"first letters":   func(w string) string { return w[:4] },
+		"upper case":      strings.ToUpper,
+	}
+	for name, edit := range edits {
+		t.Run(name, func(t *testing.T) {
+			typed := append([]string{}, fields...)
+			for i := 1; i < len(typed); i += 3 {
+				typed[i] = edit(typed[i])
+			}
+
+			text, err := FromWords(strings.Join(typed, " "))
+			require.NoError(t, err)
+			assert.Equal(t, code, text)
+		})
+	}
+
+	// Numbered lines, commas and dashes, as someone might write them down
+	var written strings.Builder
+	for i, line := range strings.Split(words, "\n") {
+		fmt.Fprintf(&written, "%d. %s -\n", i+1, strings.ReplaceAll(line, " ", ", "))
+	}
+	text, err := FromWords(written.String())
+	require.NoError(t, err)
+	assert.Equal(t, code, text)
+}
+
+func TestFromWords_Errors(t *testing.T) {
+	code, err := EncodeOffer(wrap("offer", realisticSDP))
+	require.NoError(t, err)
+	words, err := ToWords(code)
+	require.NoError(t, err)
+	fields := strings.Fields(words)
+
+	tests := map[string][]string{
+		"unknown word": append([]string{fields[0], fields[1], "xylophone"}, fields[3:]...),
+		"missing word": append(append([]string{}, fields[:5]...), fields[6:]...),
+		"swapped words": append(append([]string{}, fields[:3]...),
+			append([]string{fields[4], fields[3]}, fields[5:]...)...),
+		"too few words": fields[:5],
+		"only the kind": fields[:1],
+	}
+	if fields[3] == fields[4] {
+		delete(tests, "swapped words")
+	}
+	for name, edited := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := FromWords(strings.Join(edited, " "))
+			assert.ErrorIs(t, err, ErrCorrupted)
+		})
+	}
+
+	_, err = FromWords("lobby " + strings.Join(fields[1:], " "))
+	assert.ErrorIs(t, err, ErrCorrupted)
+
+	_, err = DecodeOffer(strings.Join(tests["unknown word"], " "))
+	assert.ErrorContains(t, err, `unknown word "xylophone" (word 3)`)
+}
+
+func TestMatchWord(t *testing.T) {
+	index := func(word string) byte { return wordIndex[word] }
+
+	assert.Equal(t, []byte{index("tiger")}, matchWord("tiger"))
+	assert.Equal(t, []byte{index("tiger")}, matchWord("tigr"))
+	assert.Equal(t, []byte{index("tiger")}, matchWord("tige"))
+	assert.Equal(t, []byte{index("volcano")}, matchWord("volcanoe"))
+	assert.Empty(t, matchWord("xylophone"))
+	assert.Empty(t, matchWord("ab"))
+
+	assert.Equal(t, 0, typoDistance("koala", "koala"))
+	assert.Equal(t, 1, typoDistance("koala", "kaola"))
+	assert.Equal(t, 2, typoDistance("koala", "kolas"))
+}
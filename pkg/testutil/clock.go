@@ -0,0 +1,104 @@
+package testutil
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FakeClock is a manually advanced clock. Timers created with AfterFunc
+// only fire when Advance moves the clock past their deadline
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at      time.Time
+	fn      func()
+	stopped bool
+}
+
+// NewFakeClock returns a clock set to start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc runs f once the clock has been advanced by d. The returned
+// function cancels it and reports whether f was still pending
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	timer := &fakeTimer{at: c.now.Add(d), fn: f}
+	c.timers = append(c.timers, timer)
+
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		pending := !timer.stopped
+		timer.stopped = true
+		return pending
+	}
+}
+
+// Advance moves the clock forward by d and runs, on the caller's goroutine
+// and in deadline order, every timer that became due
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+
+	var due, pending []*fakeTimer
+	for _, timer := range c.timers {
+		switch {
+		case timer.stopped:
+		case !timer.at.After(c.now):
+			timer.stopped = true
+			due = append(due, timer)
+		default:
+			pending = append(pending, timer)
+		}
+	}
+	c.timers = pending
+	c.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].at.Before(due[j].at)
+	})
+	for _, timer := range due {
+		timer.fn()
+	}
+}
+
+// Pending returns the number of timers that have not fired or been stopped
+func (c *FakeClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := 0
+	for _, timer := range c.timers {
+		if !timer.stopped {
+			count++
+		}
+	}
+	return count
+}
+
+// SequentialIDs returns an id generator producing prefix-1, prefix-2, ...
+func SequentialIDs(prefix string) func() string {
+	var next atomic.Uint64
+	return func() string {
+		return fmt.Sprintf("%s-%d", prefix, next.Add(1))
+	}
+}
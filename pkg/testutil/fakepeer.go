@@ -0,0 +1,981 @@
+package testutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
+	pion "github.com/pion/webrtc/v3"
+)
+
+// Operations that can be made to fail with FailNext
+const (
+	OpCreateOffer     = "CreateOffer"
+	OpCreateAnswer    = "CreateAnswer"
+	OpSetRemoteAnswer = "SetRemoteAnswer"
+	OpSetRemoteOffer  = "SetRemoteOffer"
+	OpRestartICE      = "RestartICE"
+	OpOpenChannel     = "OpenChannel"
+	OpSend            = "Send"
+	OpClose           = "Close"
+)
+
+// PairOptions configures the simulated link between two fake peers
+type PairOptions struct {
+	// Latency delays every message before the other peer receives it
+	Latency time.Duration
+
+	// DropRate is the fraction (0 to 1) of messages silently lost
+	DropRate float64
+
+	// Seed makes the DropRate decisions repeatable
+	Seed int64
+
+	// ManualConnect keeps the pair from connecting by itself once the
+	// offer/answer exchange completes; call Connect to bring the link up
+	ManualConnect bool
+
+	// Relay makes Stats report TURN relay candidates instead of host ones
+	Relay bool
+}
+
+// pairCounter numbers pairs so every pair has its own SDP identifiers
+var pairCounter atomic.Uint64
+
+// link is the state shared by both peers of a pair
+type link struct {
+	id      uint64
+	options PairOptions
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// drop decides whether the next message is lost
+func (l *link) drop() bool {
+	if l.options.DropRate <= 0 {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rand.Float64() < l.options.DropRate
+}
+
+// fakeChannel is one side of a simulated data channel
+type fakeChannel struct {
+	options webrtc.ChannelOptions
+	open    bool
+	stats   webrtc.ChannelStats
+	sent    [][]byte
+}
+
+// FakePeer is an in-memory webrtc.Peer linked to a partner created by
+// NewPeerPair. The offer/answer exchange, state changes and data channels
+// behave like RealPeer's, but nothing touches the network.
+//
+// Callbacks run one at a time on the peer's own goroutine, in the order the
+// events happened. Use Flush to wait for them.
+type FakePeer struct {
+	name    string
+	link    *link
+	partner *FakePeer
+	queue   *eventQueue
+	port    int
+
+	mu sync.Mutex
+
+	fingerprint string
+	state       webrtc.ConnectionState
+	signaling   webrtc.SignalingState
+	closed      bool
+	offerer     bool
+	restarts    int
+	localSDP    string
+	remoteSDP   string
+
+	channels        map[string]*fakeChannel
+	channelHandlers map[string]func([]byte)
+
+	onChannelOpen          func(string)
+	onStateChange          func(webrtc.ConnectionState)
+	onICEStateChange       func(webrtc.ICEState)
+	onGatheringStateChange func(webrtc.GatheringState)
+	onSignalingStateChange func(webrtc.SignalingState)
+	onICECandidate         func(string)
+
+	remoteCandidates []string
+
+	// Scripted behaviour
+	failures    map[string][]error
+	dropNext    int
+	sendBlocked bool
+	unblocked   chan struct{}
+}
+
+var _ webrtc.Peer = (*FakePeer)(nil)
+
+// NewPeerPair returns two linked fake peers that connect instantly and never
+// lose messages
+func NewPeerPair() (*FakePeer, *FakePeer) {
+	return NewPeerPairWithOptions(PairOptions{})
+}
+
+// NewPeerPairWithOptions returns two linked fake peers with the given link behaviour
+func NewPeerPairWithOptions(options PairOptions) (*FakePeer, *FakePeer) {
+	l := &link{
+		id:      pairCounter.Add(1),
+		options: options,
+		rand:    rand.New(rand.NewSource(options.Seed)),
+	}
+
+	a := newFakePeer("alice", l, 50000)
+	b := newFakePeer("bob", l, 50001)
+	a.partner = b
+	b.partner = a
+
+	return a, b
+}
+
+func newFakePeer(name string, l *link, port int) *FakePeer {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", name, l.id)))
+	digest := make([]string, len(sum))
+	for i, b := range sum {
+		digest[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return &FakePeer{
+		fingerprint:     "sha-256 " + strings.Join(digest, ":"),
+		name:            name,
+		link:            l,
+		queue:           newEventQueue(),
+		port:            port,
+		state:           webrtc.ConnectionStateNew,
+		signaling:       webrtc.SignalingStateStable,
+		channels:        make(map[string]*fakeChannel),
+		channelHandlers: make(map[string]func([]byte)),
+		failures:        make(map[string][]error),
+		unblocked:       make(chan struct{}),
+	}
+}
+
+// Partner returns the other peer of the pair
+func (p *FakePeer) Partner() *FakePeer {
+	return p.partner
+}
+
+// Name returns "alice" or "bob", handy in test failure messages
+func (p *FakePeer) Name() string {
+	return p.name
+}
+
+// Creates an offer that only the partner can answer
+func (p *FakePeer) CreateOffer() (string, error) {
+	return p.CreateOfferContext(context.Background())
+}
+
+// Like CreateOffer, but fails if ctx is already done
+func (p *FakePeer) CreateOfferContext(ctx context.Context) (string, error) {
+	if err := p.takeFailure(OpCreateOffer); err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return "", fmt.Errorf("peer is closed")
+	}
+
+	// Like RealPeer, the offerer creates the "chat" channel
+	if _, ok := p.channels[webrtc.DefaultChannel]; !ok {
+		p.channels[webrtc.DefaultChannel] = newFakeChannel(webrtc.DefaultChannel, webrtc.ReliableChannel())
+	}
+
+	p.offerer = true
+	p.localSDP = p.sdp("offer")
+	offer := p.localSDP
+	p.setSignalingState(webrtc.SignalingStateHaveLocalOffer)
+	p.gather()
+	p.mu.Unlock()
+
+	p.trickle()
+	return offer, nil
+}
+
+// Creates an offer with new ICE credentials for an established session
+func (p *FakePeer) RestartICE(ctx context.Context) (string, error) {
+	if err := p.takeFailure(OpRestartICE); err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.remoteSDP == "" {
+		return "", fmt.Errorf("cannot restart ICE before the connection has been negotiated")
+	}
+
+	p.restarts++
+	p.offerer = true
+	p.localSDP = p.sdp("offer")
+	p.setSignalingState(webrtc.SignalingStateHaveLocalOffer)
+	return p.localSDP, nil
+}
+
+// Applies the partner's answer. Unless ManualConnect is set the pair then connects
+func (p *FakePeer) SetRemoteAnswer(sdp string) error {
+	if err := p.takeFailure(OpSetRemoteAnswer); err != nil {
+		return err
+	}
+
+	if err := p.checkRemote(sdp, "answer"); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if !p.offerer || p.localSDP == "" {
+		p.mu.Unlock()
+		return fmt.Errorf("cannot set an answer without a local offer")
+	}
+	p.remoteSDP = sdp
+	p.setSignalingState(webrtc.SignalingStateStable)
+	p.mu.Unlock()
+
+	if !p.link.options.ManualConnect {
+		p.Connect()
+	}
+	return nil
+}
+
+// Creates an answer for the partner's offer
+func (p *FakePeer) CreateAnswer(offer string) (string, error) {
+	return p.CreateAnswerContext(context.Background(), offer)
+}
+
+// Like CreateAnswer, but fails if ctx is already done
+func (p *FakePeer) CreateAnswerContext(ctx context.Context, offer string) (string, error) {
+	if err := p.takeFailure(OpCreateAnswer); err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if err := p.SetRemoteOffer(offer); err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	p.offerer = false
+	p.localSDP = p.sdp("answer")
+	answer := p.localSDP
+	p.setSignalingState(webrtc.SignalingStateStable)
+	if p.restarts == 0 {
+		p.gather()
+	}
+	p.mu.Unlock()
+
+	p.trickle()
+	return answer, nil
+}
+
+// Applies the partner's offer
+func (p *FakePeer) SetRemoteOffer(sdp string) error {
+	if err := p.takeFailure(OpSetRemoteOffer); err != nil {
+		return err
+	}
+
+	if err := p.checkRemote(sdp, "offer"); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.remoteSDP != "" {
+		p.restarts++
+	}
+	p.remoteSDP = sdp
+	p.setSignalingState(webrtc.SignalingStateHaveRemoteOffer)
+	return nil
+}
+
+// Sends over the "chat" channel
+func (p *FakePeer) Send(data []byte) error {
+	return p.SendChannel(webrtc.DefaultChannel, data)
+}
+
+// Like Send, but gives up when ctx is done while sends are blocked
+func (p *FakePeer) SendContext(ctx context.Context, data []byte) error {
+	return p.SendChannelContext(ctx, webrtc.DefaultChannel, data)
+}
+
+// Like Send, but returns webrtc.ErrWouldBlock while sends are blocked
+func (p *FakePeer) TrySend(data []byte) error {
+	return p.TrySendChannel(webrtc.DefaultChannel, data)
+}
+
+// Registers the "chat" channel handler
+func (p *FakePeer) OnMessage(callback func([]byte)) {
+	p.OnChannelMessage(webrtc.DefaultChannel, callback)
+}
+
+// Opens a named channel. Before the pair connects it becomes part of the
+// offer; afterwards it opens on both peers straight away
+func (p *FakePeer) OpenChannel(label string, options webrtc.ChannelOptions) error {
+	if err := p.takeFailure(OpOpenChannel); err != nil {
+		return err
+	}
+
+	if label == "" {
+		return fmt.Errorf("channel label cannot be empty")
+	}
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if _, ok := p.channels[label]; ok {
+		p.mu.Unlock()
+		return fmt.Errorf("data channel '%s' already exists", label)
+	}
+	p.channels[label] = newFakeChannel(label, options)
+	connected := p.state == webrtc.ConnectionStateConnected
+	p.mu.Unlock()
+
+	if connected {
+		p.openChannels()
+	}
+	return nil
+}
+
+// Sends over the named channel, waiting while sends are blocked
+func (p *FakePeer) SendChannel(label string, data []byte) error {
+	return p.SendChannelContext(context.Background(), label, data)
+}
+
+// Like SendChannel, but gives up when ctx is done
+func (p *FakePeer) SendChannelContext(ctx context.Context, label string, data []byte) error {
+	return p.send(ctx, label, data, true)
+}
+
+// Like SendChannel, but returns webrtc.ErrWouldBlock while sends are blocked
+func (p *FakePeer) TrySendChannel(label string, data []byte) error {
+	return p.send(context.Background(), label, data, false)
+}
+
+// Registers the handler for the named channel
+func (p *FakePeer) OnChannelMessage(label string, callback func([]byte)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.channelHandlers[label] = callback
+}
+
+// Registers a callback fired whenever a channel opens
+func (p *FakePeer) OnChannelOpen(callback func(string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onChannelOpen = callback
+}
+
+// Registers the connection state callback
+func (p *FakePeer) OnStateChange(callback func(webrtc.ConnectionState)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onStateChange = callback
+}
+
+// Registers the ICE state callback. ICE states follow the connection state
+func (p *FakePeer) OnICEStateChange(callback func(webrtc.ICEState)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onICEStateChange = callback
+}
+
+// Registers the gathering state callback. Every offer and answer gathers
+// instantly: "gathering" then "complete"
+func (p *FakePeer) OnGatheringStateChange(callback func(webrtc.GatheringState)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onGatheringStateChange = callback
+}
+
+// Registers the signaling state callback
+func (p *FakePeer) OnSignalingStateChange(callback func(webrtc.SignalingState)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onSignalingStateChange = callback
+}
+
+// Returns the simulated candidate pair and the per-channel counters
+func (p *FakePeer) Stats() webrtc.Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := webrtc.Stats{
+		State: p.state,
+	}
+
+	if p.state == webrtc.ConnectionStateConnected {
+		stats.Local = p.candidate(p.port)
+		stats.Remote = p.candidate(p.partner.port)
+		stats.RTT = 2 * p.link.options.Latency
+	}
+
+	labels := make([]string, 0, len(p.channels))
+	for label := range p.channels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		channel := p.channels[label].stats
+		stats.MessagesSent += channel.MessagesSent
+		stats.MessagesReceived += channel.MessagesReceived
+		stats.BytesSent += channel.BytesSent
+		stats.BytesReceived += channel.BytesReceived
+		stats.Channels = append(stats.Channels, channel)
+	}
+
+	return stats
+}
+
+// Returns the fake certificate fingerprint, unique per peer and pair unless
+// set with SetFingerprint
+func (p *FakePeer) LocalFingerprint() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fingerprint
+}
+
+// Returns the fingerprint from the partner's description, "" before it is set
+func (p *FakePeer) RemoteFingerprint() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return webrtc.Fingerprint(p.remoteSDP)
+}
+
+// Registers a trickle ICE callback. Offers and answers created afterwards
+// trickle one candidate followed by the end-of-candidates marker
+func (p *FakePeer) OnICECandidate(callback func(string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onICECandidate = callback
+}
+
+// Records a remote candidate, see RemoteCandidates
+func (p *FakePeer) AddICECandidate(candidate string) error {
+	if candidate != "" && !json.Valid([]byte(candidate)) {
+		return fmt.Errorf("invalid ICE candidate: %q", candidate)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.remoteCandidates = append(p.remoteCandidates, candidate)
+	return nil
+}
+
+// Closes the peer. The partner sees its connection go "disconnected"
+func (p *FakePeer) Close() error {
+	if err := p.takeFailure(OpClose); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	for _, channel := range p.channels {
+		channel.open = false
+		channel.stats.State = "closed"
+	}
+	p.mu.Unlock()
+
+	p.setState(webrtc.ConnectionStateClosed)
+	p.queue.close()
+
+	p.partner.mu.Lock()
+	partnerClosed := p.partner.closed
+	p.partner.mu.Unlock()
+
+	if !partnerClosed {
+		p.partner.setState(webrtc.ConnectionStateDisconnected)
+	}
+	return nil
+}
+
+// Connect brings the link up: both peers go "connecting" then "connected"
+// and every channel either side has opened becomes open on both. The pair
+// does this by itself after SetRemoteAnswer unless ManualConnect is set
+func (p *FakePeer) Connect() {
+	for _, peer := range []*FakePeer{p, p.partner} {
+		peer.mu.Lock()
+		state := peer.state
+		closed := peer.closed
+		peer.mu.Unlock()
+
+		if closed || state == webrtc.ConnectionStateConnected {
+			continue
+		}
+		if state == webrtc.ConnectionStateNew {
+			peer.setState(webrtc.ConnectionStateConnecting)
+		}
+		peer.setState(webrtc.ConnectionStateConnected)
+	}
+
+	p.openChannels()
+}
+
+// SetState scripts a connection state change, e.g. "disconnected" to
+// simulate a network change. It only affects this peer
+func (p *FakePeer) SetState(state webrtc.ConnectionState) {
+	p.setState(state)
+}
+
+// SetLinkState sets the state of both peers, e.g. to drop the link for both
+func (p *FakePeer) SetLinkState(state webrtc.ConnectionState) {
+	p.setState(state)
+	p.partner.setState(state)
+}
+
+// State returns the current connection state
+func (p *FakePeer) State() webrtc.ConnectionState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// FailNext makes the next call of op (one of the Op constants) return err.
+// Calls queue up: FailNext twice fails the next two calls
+func (p *FakePeer) FailNext(op string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures[op] = append(p.failures[op], err)
+}
+
+// DropNext silently loses the next n messages this peer sends
+func (p *FakePeer) DropNext(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dropNext += n
+}
+
+// BlockSends simulates a full send buffer: TrySend returns
+// webrtc.ErrWouldBlock and Send waits until UnblockSends
+func (p *FakePeer) BlockSends() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sendBlocked = true
+}
+
+// UnblockSends releases senders waiting after BlockSends
+func (p *FakePeer) UnblockSends() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sendBlocked {
+		p.sendBlocked = false
+		close(p.unblocked)
+		p.unblocked = make(chan struct{})
+	}
+}
+
+// SetFingerprint makes the peer present a different certificate fingerprint
+// ("sha-256 AB:CD:..."), e.g. the same one across two pairs to simulate a
+// persistent identity. It applies to descriptions created afterwards
+func (p *FakePeer) SetFingerprint(fingerprint string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fingerprint = fingerprint
+}
+
+// Sent returns a copy of every message sent on the channel, dropped ones included
+func (p *FakePeer) Sent(label string) [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	channel := p.channels[label]
+	if channel == nil {
+		return nil
+	}
+	return append([][]byte(nil), channel.sent...)
+}
+
+// RemoteCandidates returns the candidates passed to AddICECandidate
+func (p *FakePeer) RemoteCandidates() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.remoteCandidates...)
+}
+
+// Restarts reports how many ICE restarts this peer took part in
+func (p *FakePeer) Restarts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.restarts
+}
+
+// Flush waits until both peers have run every pending callback and
+// delivered every message in flight (including Latency).
+// It must not be called from inside a peer callback
+func (p *FakePeer) Flush() {
+	for {
+		p.queue.flush()
+		p.partner.queue.flush()
+
+		if p.queue.idle() && p.partner.queue.idle() {
+			return
+		}
+	}
+}
+
+// send implements every send variant
+func (p *FakePeer) send(ctx context.Context, label string, data []byte, wait bool) error {
+	if err := p.takeFailure(OpSend); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	for {
+		channel := p.channels[label]
+		if p.closed || channel == nil || !channel.open {
+			p.mu.Unlock()
+			return pion.ErrDataChannelNotOpen
+		}
+
+		if !p.sendBlocked {
+			break
+		}
+
+		if !wait {
+			p.mu.Unlock()
+			return webrtc.ErrWouldBlock
+		}
+
+		unblocked := p.unblocked
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-unblocked:
+		}
+
+		p.mu.Lock()
+	}
+
+	message := append([]byte(nil), data...)
+	channel := p.channels[label]
+	channel.sent = append(channel.sent, message)
+	channel.stats.MessagesSent++
+	channel.stats.BytesSent += uint64(len(message))
+
+	drop := p.dropNext > 0
+	if drop {
+		p.dropNext--
+	}
+	p.mu.Unlock()
+
+	if drop || p.link.drop() {
+		return nil
+	}
+
+	partner := p.partner
+	partner.queue.push(p.link.options.Latency, func() {
+		partner.receive(label, message)
+	})
+	return nil
+}
+
+// receive hands a message from the partner to the channel's handler
+func (p *FakePeer) receive(label string, data []byte) {
+	p.mu.Lock()
+	channel := p.channels[label]
+	if p.closed || channel == nil || !channel.open {
+		p.mu.Unlock()
+		return
+	}
+	channel.stats.MessagesReceived++
+	channel.stats.BytesReceived += uint64(len(data))
+	callback := p.channelHandlers[label]
+	p.mu.Unlock()
+
+	if callback != nil {
+		callback(data)
+	}
+}
+
+// openChannels opens, on both peers, every channel either peer has created
+func (p *FakePeer) openChannels() {
+	labels := make(map[string]webrtc.ChannelOptions)
+	for _, peer := range []*FakePeer{p, p.partner} {
+		peer.mu.Lock()
+		for label, channel := range peer.channels {
+			labels[label] = channel.options
+		}
+		peer.mu.Unlock()
+	}
+
+	sorted := make([]string, 0, len(labels))
+	for label := range labels {
+		sorted = append(sorted, label)
+	}
+	sort.Strings(sorted)
+
+	for _, peer := range []*FakePeer{p, p.partner} {
+		for _, label := range sorted {
+			peer.mu.Lock()
+			channel := peer.channels[label]
+			if channel == nil {
+				channel = newFakeChannel(label, labels[label])
+				peer.channels[label] = channel
+			}
+			opened := !channel.open && !peer.closed
+			if opened {
+				channel.open = true
+				channel.stats.State = "open"
+			}
+			peer.mu.Unlock()
+
+			if opened {
+				peer.queue.push(0, func() {
+					peer.mu.Lock()
+					callback := peer.onChannelOpen
+					peer.mu.Unlock()
+
+					if callback != nil {
+						callback(label)
+					}
+				})
+			}
+		}
+	}
+}
+
+// setState changes the state and queues the ICE and connection state callbacks
+func (p *FakePeer) setState(state webrtc.ConnectionState) {
+	p.mu.Lock()
+	if p.state == state || (p.closed && state != webrtc.ConnectionStateClosed) {
+		p.mu.Unlock()
+		return
+	}
+	p.state = state
+	p.mu.Unlock()
+
+	p.queue.push(0, func() {
+		p.mu.Lock()
+		iceCallback := p.onICEStateChange
+		callback := p.onStateChange
+		p.mu.Unlock()
+
+		if iceCallback != nil {
+			iceCallback(iceStateOf(state))
+		}
+		if callback != nil {
+			callback(state)
+		}
+	})
+}
+
+// setSignalingState changes the signaling state and queues its callback.
+// Caller must hold p.mu
+func (p *FakePeer) setSignalingState(state webrtc.SignalingState) {
+	if p.signaling == state {
+		return
+	}
+	p.signaling = state
+
+	p.queue.push(0, func() {
+		p.mu.Lock()
+		callback := p.onSignalingStateChange
+		p.mu.Unlock()
+
+		if callback != nil {
+			callback(state)
+		}
+	})
+}
+
+// gather queues the gathering state callbacks of an instant gathering.
+// Caller must hold p.mu
+func (p *FakePeer) gather() {
+	for _, state := range []webrtc.GatheringState{webrtc.GatheringStateGathering, webrtc.GatheringStateComplete} {
+		state := state
+		p.queue.push(0, func() {
+			p.mu.Lock()
+			callback := p.onGatheringStateChange
+			p.mu.Unlock()
+
+			if callback != nil {
+				callback(state)
+			}
+		})
+	}
+}
+
+// trickle queues a fake candidate and the end-of-candidates marker when a
+// trickle callback is registered
+func (p *FakePeer) trickle() {
+	p.mu.Lock()
+	registered := p.onICECandidate != nil
+	p.mu.Unlock()
+
+	if !registered {
+		return
+	}
+
+	candidate := fmt.Sprintf(`{"candidate":"candidate:1 1 udp 2130706431 127.0.0.1 %d typ host","sdpMid":"0","sdpMLineIndex":0}`, p.port)
+	for _, c := range []string{candidate, ""} {
+		c := c
+		p.queue.push(0, func() {
+			p.mu.Lock()
+			callback := p.onICECandidate
+			p.mu.Unlock()
+
+			if callback != nil {
+				callback(c)
+			}
+		})
+	}
+}
+
+// takeFailure pops the next scripted failure for op
+func (p *FakePeer) takeFailure(op string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	queued := p.failures[op]
+	if len(queued) == 0 {
+		return nil
+	}
+
+	p.failures[op] = queued[1:]
+	return queued[0]
+}
+
+// checkRemote verifies that sdp is a description of the expected type
+// created by the partner, the way a real peer rejects foreign ICE credentials
+func (p *FakePeer) checkRemote(sdp, sdpType string) error {
+	var desc struct {
+		Type string `json:"type"`
+		SDP  string `json:"sdp"`
+	}
+	if err := json.Unmarshal([]byte(sdp), &desc); err != nil {
+		return fmt.Errorf("invalid session description: %w", err)
+	}
+
+	if desc.Type != sdpType {
+		return fmt.Errorf("expected an %s, got %q", sdpType, desc.Type)
+	}
+
+	p.partner.mu.Lock()
+	expected := sdpAttribute(p.partner.localSDP, "ice-ufrag")
+	p.partner.mu.Unlock()
+
+	if expected == "" || sdpAttribute(sdp, "ice-ufrag") != expected {
+		return errors.New("session description was not created by this peer's partner")
+	}
+	return nil
+}
+
+// sdp builds a small but realistic data-channel-only session description.
+// Caller must hold p.mu
+func (p *FakePeer) sdp(sdpType string) string {
+	setup := "actpass"
+	if sdpType == "answer" {
+		setup = "active"
+	}
+
+	ufrag := fmt.Sprintf("%s%dr%d", p.name, p.link.id, p.restarts)
+
+	lines := []string{
+		"v=0",
+		fmt.Sprintf("o=- %d%04d %d IN IP4 127.0.0.1", p.link.id, p.restarts, p.restarts+1),
+		"s=-",
+		"t=0 0",
+		"a=fingerprint:" + p.fingerprint,
+		"a=group:BUNDLE 0",
+		"m=application 9 UDP/DTLS/SCTP webrtc-datachannel",
+		"c=IN IP4 0.0.0.0",
+		"a=ice-ufrag:" + ufrag,
+		"a=ice-pwd:" + strings.Repeat(ufrag, 24/len(ufrag)+1)[:24],
+		fmt.Sprintf("a=candidate:1 1 udp 2130706431 127.0.0.1 %d typ host", p.port),
+		"a=end-of-candidates",
+		"a=setup:" + setup,
+		"a=mid:0",
+		"a=sctp-port:5000",
+	}
+
+	desc, _ := json.Marshal(map[string]string{
+		"type": sdpType,
+		"sdp":  strings.Join(lines, "\r\n") + "\r\n",
+	})
+	return string(desc)
+}
+
+// candidate describes this pair's simulated candidate on port
+func (p *FakePeer) candidate(port int) *webrtc.CandidateInfo {
+	candidateType := webrtc.CandidateHost
+	if p.link.options.Relay {
+		candidateType = webrtc.CandidateRelay
+	}
+
+	return &webrtc.CandidateInfo{
+		Type:     candidateType,
+		Address:  "127.0.0.1",
+		Port:     port,
+		Protocol: "udp",
+	}
+}
+
+func newFakeChannel(label string, options webrtc.ChannelOptions) *fakeChannel {
+	return &fakeChannel{
+		options: options,
+		stats: webrtc.ChannelStats{
+			Label: label,
+			State: "connecting",
+		},
+	}
+}
+
+// iceStateOf returns the ICE state matching a connection state
+func iceStateOf(state webrtc.ConnectionState) webrtc.ICEState {
+	if state == webrtc.ConnectionStateConnecting {
+		return webrtc.ICEStateChecking
+	}
+	return webrtc.ICEState(state)
+}
+
+// sdpAttribute returns the value of "a=<name>:" in a JSON session description
+func sdpAttribute(sdp, name string) string {
+	var desc struct {
+		SDP string `json:"sdp"`
+	}
+	if err := json.Unmarshal([]byte(sdp), &desc); err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(desc.SDP, "\r\n") {
+		if value, ok := strings.CutPrefix(line, "a="+name+":"); ok {
+			return value
+		}
+	}
+	return ""
+}
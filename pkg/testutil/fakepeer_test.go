@@ -0,0 +1,335 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/signaling"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
+	pion "github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder collects callback values from a peer
+type recorder[T any] struct {
+	mu     sync.Mutex
+	values []T
+}
+
+func (r *recorder[T]) add(value T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values = append(r.values, value)
+}
+
+func (r *recorder[T]) get() []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]T(nil), r.values...)
+}
+
+// connect runs the offer/answer exchange through the signaling codec
+func connect(t *testing.T, host, guest *FakePeer) {
+	t.Helper()
+
+	offer, err := host.CreateOffer()
+	require.NoError(t, err)
+	offerCode, err := signaling.Encode(offer)
+	require.NoError(t, err)
+
+	decodedOffer, err := signaling.Decode(offerCode)
+	require.NoError(t, err)
+	answer, err := guest.CreateAnswer(decodedOffer)
+	require.NoError(t, err)
+	answerCode, err := signaling.Encode(answer)
+	require.NoError(t, err)
+
+	decodedAnswer, err := signaling.Decode(answerCode)
+	require.NoError(t, err)
+	require.NoError(t, host.SetRemoteAnswer(decodedAnswer))
+}
+
+func TestPeerPair_ConnectAndExchange(t *testing.T) {
+	host, guest := NewPeerPair()
+	defer host.Close()
+	defer guest.Close()
+
+	var hostStates, guestStates recorder[webrtc.ConnectionState]
+	var opened, received recorder[string]
+	host.OnStateChange(hostStates.add)
+	guest.OnStateChange(guestStates.add)
+	guest.OnChannelOpen(opened.add)
+	guest.OnMessage(func(data []byte) { received.add(string(data)) })
+
+	connect(t, host, guest)
+	host.Flush()
+
+	assert.Equal(t, []webrtc.ConnectionState{webrtc.ConnectionStateConnecting, webrtc.ConnectionStateConnected}, hostStates.get())
+	assert.Equal(t, []webrtc.ConnectionState{webrtc.ConnectionStateConnecting, webrtc.ConnectionStateConnected}, guestStates.get())
+	assert.Equal(t, []string{webrtc.DefaultChannel}, opened.get())
+
+	require.NoError(t, host.Send([]byte("hello")))
+	require.NoError(t, host.Send([]byte("world")))
+	host.Flush()
+
+	assert.Equal(t, []string{"hello", "world"}, received.get())
+
+	stats := guest.Stats()
+	assert.Equal(t, webrtc.ConnectionStateConnected, stats.State)
+	require.NotNil(t, stats.Local)
+	assert.Equal(t, webrtc.CandidateHost, stats.Local.Type)
+	assert.Equal(t, uint32(2), stats.MessagesReceived)
+	assert.Equal(t, uint64(10), stats.BytesReceived)
+}
+
+func TestPeerPair_RejectsForeignAnswer(t *testing.T) {
+	host, guest := NewPeerPair()
+	_, stranger := NewPeerPair()
+
+	offer, err := host.CreateOffer()
+	require.NoError(t, err)
+
+	_, err = stranger.CreateAnswer(offer)
+	assert.Error(t, err)
+
+	answer, err := guest.CreateAnswer(offer)
+	require.NoError(t, err)
+
+	// An offer is not an answer
+	assert.Error(t, host.SetRemoteAnswer(offer))
+	assert.NoError(t, host.SetRemoteAnswer(answer))
+}
+
+func TestPeerPair_NamedChannels(t *testing.T) {
+	host, guest := NewPeerPair()
+
+	require.NoError(t, host.OpenChannel("typing", webrtc.EphemeralChannel()))
+	assert.Error(t, host.OpenChannel("typing", webrtc.EphemeralChannel()))
+
+	var typing recorder[string]
+	guest.OnChannelMessage("typing", func(data []byte) { typing.add(string(data)) })
+
+	connect(t, host, guest)
+	host.Flush()
+
+	require.NoError(t, host.SendChannel("typing", []byte("...")))
+
+	// Channels opened after connecting open on both sides right away
+	require.NoError(t, guest.OpenChannel("files", webrtc.ReliableChannel()))
+	host.Flush()
+	require.NoError(t, host.SendChannel("files", []byte("data")))
+	host.Flush()
+
+	assert.Equal(t, []string{"..."}, typing.get())
+	assert.Equal(t, [][]byte{[]byte("data")}, host.Sent("files"))
+}
+
+func TestPeerPair_Latency(t *testing.T) {
+	host, guest := NewPeerPairWithOptions(PairOptions{Latency: 30 * time.Millisecond})
+	connect(t, host, guest)
+	host.Flush()
+
+	var received recorder[string]
+	guest.OnMessage(func(data []byte) { received.add(string(data)) })
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, host.Send([]byte(fmt.Sprint(i))))
+	}
+	assert.Empty(t, received.get())
+
+	host.Flush()
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, received.get())
+	assert.Equal(t, 60*time.Millisecond, guest.Stats().RTT)
+}
+
+func TestPeerPair_Drops(t *testing.T) {
+	run := func() []string {
+		host, guest := NewPeerPairWithOptions(PairOptions{DropRate: 0.5, Seed: 42})
+		connect(t, host, guest)
+
+		var received recorder[string]
+		guest.OnMessage(func(data []byte) { received.add(string(data)) })
+
+		for i := 0; i < 20; i++ {
+			require.NoError(t, host.Send([]byte(fmt.Sprint(i))))
+		}
+		host.Flush()
+		return received.get()
+	}
+
+	first := run()
+	assert.NotEmpty(t, first)
+	assert.Less(t, len(first), 20)
+	assert.Equal(t, first, run(), "the same seed must drop the same messages")
+
+	host, guest := NewPeerPair()
+	connect(t, host, guest)
+
+	var received recorder[string]
+	guest.OnMessage(func(data []byte) { received.add(string(data)) })
+
+	host.DropNext(1)
+	require.NoError(t, host.Send([]byte("lost")))
+	require.NoError(t, host.Send([]byte("kept")))
+	host.Flush()
+
+	assert.Equal(t, []string{"kept"}, received.get())
+	assert.Len(t, host.Sent(webrtc.DefaultChannel), 2)
+}
+
+func TestPeerPair_FailNext(t *testing.T) {
+	host, guest := NewPeerPair()
+	boom := errors.New("boom")
+
+	host.FailNext(OpCreateOffer, boom)
+	_, err := host.CreateOffer()
+	assert.ErrorIs(t, err, boom)
+
+	connect(t, host, guest)
+
+	guest.FailNext(OpSend, boom)
+	assert.ErrorIs(t, guest.Send([]byte("x")), boom)
+	assert.NoError(t, guest.Send([]byte("x")))
+}
+
+func TestPeerPair_ManualConnectAndStates(t *testing.T) {
+	host, guest := NewPeerPairWithOptions(PairOptions{ManualConnect: true, Relay: true})
+
+	var guestStates recorder[webrtc.ConnectionState]
+	guest.OnStateChange(guestStates.add)
+
+	connect(t, host, guest)
+	host.Flush()
+	assert.Equal(t, webrtc.ConnectionStateNew, host.State())
+	assert.ErrorIs(t, host.Send([]byte("x")), pion.ErrDataChannelNotOpen)
+
+	host.Connect()
+	host.Flush()
+	assert.True(t, host.Stats().UsingRelay())
+
+	host.SetLinkState(webrtc.ConnectionStateDisconnected)
+	host.Flush()
+	assert.Equal(t, []webrtc.ConnectionState{webrtc.ConnectionStateConnecting, webrtc.ConnectionStateConnected, webrtc.ConnectionStateDisconnected}, guestStates.get())
+}
+
+func TestPeerPair_Close(t *testing.T) {
+	host, guest := NewPeerPair()
+	connect(t, host, guest)
+	host.Flush()
+
+	var guestStates recorder[webrtc.ConnectionState]
+	guest.OnStateChange(guestStates.add)
+
+	require.NoError(t, host.Close())
+	require.NoError(t, host.Close())
+	guest.Flush()
+
+	assert.Equal(t, webrtc.ConnectionStateClosed, host.State())
+	assert.Equal(t, []webrtc.ConnectionState{webrtc.ConnectionStateDisconnected}, guestStates.get())
+	assert.ErrorIs(t, host.Send([]byte("x")), pion.ErrDataChannelNotOpen)
+}
+
+func TestPeerPair_RestartICE(t *testing.T) {
+	host, guest := NewPeerPair()
+
+	_, err := host.RestartICE(context.Background())
+	assert.Error(t, err, "restart before negotiation")
+
+	connect(t, host, guest)
+
+	offer, err := host.RestartICE(context.Background())
+	require.NoError(t, err)
+	answer, err := guest.CreateAnswer(offer)
+	require.NoError(t, err)
+	require.NoError(t, host.SetRemoteAnswer(answer))
+
+	assert.Equal(t, 1, host.Restarts())
+	assert.Equal(t, 1, guest.Restarts())
+}
+
+func TestPeerPair_BlockedSends(t *testing.T) {
+	host, guest := NewPeerPair()
+	connect(t, host, guest)
+
+	host.BlockSends()
+	assert.ErrorIs(t, host.TrySend([]byte("x")), webrtc.ErrWouldBlock)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, host.SendContext(ctx, []byte("x")), context.DeadlineExceeded)
+
+	done := make(chan error, 1)
+	go func() { done <- host.Send([]byte("x")) }()
+
+	select {
+	case <-done:
+		t.Fatal("send returned while blocked")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	host.UnblockSends()
+	require.NoError(t, <-done)
+}
+
+func TestPeerPair_Trickle(t *testing.T) {
+	host, guest := NewPeerPair()
+
+	var candidates recorder[string]
+	host.OnICECandidate(candidates.add)
+
+	_, err := host.CreateOffer()
+	require.NoError(t, err)
+	host.Flush()
+
+	trickled := candidates.get()
+	require.Len(t, trickled, 2)
+	assert.Equal(t, "", trickled[1])
+
+	for _, candidate := range trickled {
+		require.NoError(t, guest.AddICECandidate(candidate))
+	}
+	assert.Equal(t, trickled, guest.RemoteCandidates())
+	assert.Error(t, guest.AddICECandidate("not json"))
+}
+
+func TestPeerPair_StateEvents(t *testing.T) {
+	host, guest := NewPeerPair()
+
+	var ice recorder[webrtc.ICEState]
+	var gathering recorder[webrtc.GatheringState]
+	var hostSignaling, guestSignaling recorder[webrtc.SignalingState]
+	host.OnICEStateChange(ice.add)
+	host.OnGatheringStateChange(gathering.add)
+	host.OnSignalingStateChange(hostSignaling.add)
+	guest.OnSignalingStateChange(guestSignaling.add)
+
+	connect(t, host, guest)
+	host.Flush()
+
+	assert.Equal(t, []webrtc.ICEState{webrtc.ICEStateChecking, webrtc.ICEStateConnected}, ice.get())
+	assert.Equal(t, []webrtc.GatheringState{webrtc.GatheringStateGathering, webrtc.GatheringStateComplete}, gathering.get())
+	assert.Equal(t, []webrtc.SignalingState{webrtc.SignalingStateHaveLocalOffer, webrtc.SignalingStateStable}, hostSignaling.get())
+	assert.Equal(t, []webrtc.SignalingState{webrtc.SignalingStateHaveRemoteOffer, webrtc.SignalingStateStable}, guestSignaling.get())
+}
+
+func TestPeerPair_Fingerprints(t *testing.T) {
+	host, guest := NewPeerPair()
+	otherHost, _ := NewPeerPair()
+
+	assert.NotEqual(t, host.LocalFingerprint(), guest.LocalFingerprint())
+	assert.NotEqual(t, host.LocalFingerprint(), otherHost.LocalFingerprint())
+	assert.Equal(t, "", host.RemoteFingerprint())
+
+	guest.SetFingerprint("sha-256 AA:BB")
+	connect(t, host, guest)
+
+	assert.Equal(t, "sha-256 AA:BB", host.RemoteFingerprint())
+	assert.Equal(t, host.LocalFingerprint(), guest.RemoteFingerprint())
+}
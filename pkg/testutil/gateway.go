@@ -0,0 +1,452 @@
+package testutil
+
+import (
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GatewayOptions selects what a FakeGateway speaks and how it behaves
+type GatewayOptions struct {
+	// UPnP answers SSDP searches and serves an Internet Gateway Device
+	UPnP bool
+
+	// PCP and NATPMP answer on the PCP/NAT-PMP port. With NATPMP only,
+	// PCP requests get the NAT-PMP "unsupported version" answer
+	PCP    bool
+	NATPMP bool
+
+	// ExternalIP is the public address reported. Nil means 203.0.113.1
+	ExternalIP net.IP
+
+	// PermanentOnly makes UPnP refuse leases other than 0, like some routers
+	PermanentOnly bool
+
+	// TakenPorts are external ports already forwarded to another host
+	TakenPorts []int
+}
+
+// GatewayMapping is a port forwarded by a FakeGateway
+type GatewayMapping struct {
+	Method         string // "upnp", "pcp" or "natpmp"
+	InternalClient string
+	InternalPort   int
+	ExternalPort   int
+	Lifetime       time.Duration
+
+	// Requests counts the requests that created or renewed the mapping
+	Requests int
+
+	nonce string
+}
+
+// FakeGateway is a home router on loopback that forwards ports with
+// UPnP-IGD, PCP and NAT-PMP. It only records mappings, it forwards nothing
+type FakeGateway struct {
+	options GatewayOptions
+
+	ssdp *net.UDPConn
+	pmp  *net.UDPConn
+	http *http.Server
+	url  string
+
+	mu       sync.Mutex
+	mappings map[int]*GatewayMapping
+	wg       sync.WaitGroup
+}
+
+// NewFakeGateway starts a gateway on 127.0.0.1
+func NewFakeGateway(options GatewayOptions) (*FakeGateway, error) {
+	if options.ExternalIP == nil {
+		options.ExternalIP = net.IPv4(203, 0, 113, 1)
+	}
+
+	g := &FakeGateway{options: options, mappings: make(map[int]*GatewayMapping)}
+	for _, port := range options.TakenPorts {
+		g.mappings[port] = &GatewayMapping{Method: "upnp", InternalClient: "192.0.2.99", InternalPort: port, ExternalPort: port}
+	}
+
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+	var err error
+	if g.ssdp, err = net.ListenUDP("udp4", loopback); err != nil {
+		return nil, err
+	}
+	if g.pmp, err = net.ListenUDP("udp4", loopback); err != nil {
+		g.ssdp.Close()
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		g.ssdp.Close()
+		g.pmp.Close()
+		return nil, err
+	}
+	g.url = "http://" + listener.Addr().String()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", g.serveDescription)
+	mux.HandleFunc("/ctl/IPConn", g.serveControl)
+	g.http = &http.Server{Handler: mux}
+
+	g.wg.Add(3)
+	go func() { defer g.wg.Done(); g.http.Serve(listener) }()
+	go func() { defer g.wg.Done(); g.serveSSDP() }()
+	go func() { defer g.wg.Done(); g.servePMP() }()
+
+	return g, nil
+}
+
+// SSDPAddress is where UPnP searches must be sent
+func (g *FakeGateway) SSDPAddress() string {
+	return g.ssdp.LocalAddr().String()
+}
+
+// PMPPort is the gateway's PCP/NAT-PMP port on 127.0.0.1
+func (g *FakeGateway) PMPPort() int {
+	return g.pmp.LocalAddr().(*net.UDPAddr).Port
+}
+
+// ExternalIP is the public address the gateway reports
+func (g *FakeGateway) ExternalIP() net.IP {
+	return g.options.ExternalIP
+}
+
+// Mappings returns the current mappings by external port, without the
+// TakenPorts ones
+func (g *FakeGateway) Mappings() []GatewayMapping {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var mappings []GatewayMapping
+	for _, mapping := range g.mappings {
+		if mapping.InternalClient != "192.0.2.99" {
+			mappings = append(mappings, *mapping)
+		}
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].ExternalPort < mappings[j].ExternalPort })
+	return mappings
+}
+
+// Close stops the gateway
+func (g *FakeGateway) Close() error {
+	g.ssdp.Close()
+	g.pmp.Close()
+	g.http.Close()
+	g.wg.Wait()
+	return nil
+}
+
+// add creates or renews a mapping, returning the external port used or 0
+// if the port is taken by someone else
+func (g *FakeGateway) add(mapping GatewayMapping) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	existing, ok := g.mappings[mapping.ExternalPort]
+	if ok && (existing.InternalClient != mapping.InternalClient || existing.InternalPort != mapping.InternalPort) {
+		return 0
+	}
+	if ok {
+		mapping.Requests = existing.Requests
+	}
+	mapping.Requests++
+	g.mappings[mapping.ExternalPort] = &mapping
+	return mapping.ExternalPort
+}
+
+// find returns the external port mapped to an internal client and port
+func (g *FakeGateway) find(client string, internalPort int) (*GatewayMapping, bool) {
+	for _, mapping := range g.mappings {
+		if mapping.InternalClient == client && mapping.InternalPort == internalPort {
+			return mapping, true
+		}
+	}
+	return nil, false
+}
+
+func (g *FakeGateway) serveSSDP() {
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := g.ssdp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !g.options.UPnP || !strings.Contains(string(buf[:n]), "InternetGatewayDevice") {
+			continue
+		}
+
+		response := "HTTP/1.1 200 OK\r\n" +
+			"CACHE-CONTROL: max-age=120\r\n" +
+			"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+			"USN: uuid:fake-gateway::urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+			"LOCATION: " + g.url + "/rootDesc.xml\r\n\r\n"
+		g.ssdp.WriteToUDP([]byte(response), from)
+	}
+}
+
+const gatewayDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <serviceList>
+      <service><serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType><controlURL>/ctl/L3F</controlURL></service>
+    </serviceList>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service><serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType><controlURL>/ctl/IPConn</controlURL></service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+func (g *FakeGateway) serveDescription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/xml")
+	io.WriteString(w, gatewayDescription)
+}
+
+func (g *FakeGateway) serveControl(w http.ResponseWriter, r *http.Request) {
+	_, action, _ := strings.Cut(strings.Trim(r.Header.Get("SOAPAction"), `"`), "#")
+	args := xmlValues(r.Body)
+	port, _ := strconv.Atoi(args["NewExternalPort"])
+
+	switch action {
+	case "GetExternalIPAddress":
+		soapReply(w, action, "NewExternalIPAddress", g.options.ExternalIP.String())
+
+	case "AddPortMapping":
+		internalPort, _ := strconv.Atoi(args["NewInternalPort"])
+		lease, _ := strconv.Atoi(args["NewLeaseDuration"])
+		if args["NewProtocol"] != "UDP" || internalPort == 0 || port == 0 {
+			soapFault(w, 402, "Invalid Args")
+			return
+		}
+		if g.options.PermanentOnly && lease != 0 {
+			soapFault(w, 725, "OnlyPermanentLeasesSupported")
+			return
+		}
+		if g.add(GatewayMapping{
+			Method:         "upnp",
+			InternalClient: args["NewInternalClient"],
+			InternalPort:   internalPort,
+			ExternalPort:   port,
+			Lifetime:       time.Duration(lease) * time.Second,
+		}) == 0 {
+			soapFault(w, 718, "ConflictInMappingEntry")
+			return
+		}
+		soapReply(w, action)
+
+	case "DeletePortMapping":
+		g.mu.Lock()
+		_, ok := g.mappings[port]
+		delete(g.mappings, port)
+		g.mu.Unlock()
+		if !ok {
+			soapFault(w, 714, "NoSuchEntryInArray")
+			return
+		}
+		soapReply(w, action)
+
+	default:
+		soapFault(w, 401, "Invalid Action")
+	}
+}
+
+// soapReply writes a response with name/value pairs
+func soapReply(w http.ResponseWriter, action string, values ...string) {
+	var body strings.Builder
+	for i := 0; i+1 < len(values); i += 2 {
+		fmt.Fprintf(&body, "<%s>%s</%s>", values[i], values[i+1], values[i])
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+		`<u:%sResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">%s</u:%sResponse></s:Body></s:Envelope>`,
+		action, body.String(), action)
+}
+
+// soapFault writes a UPnP error
+func soapFault(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault>`+
+		`<faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring><detail>`+
+		`<UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode><errorDescription>%s</errorDescription></UPnPError>`+
+		`</detail></s:Fault></s:Body></s:Envelope>`, code, description)
+}
+
+// xmlValues collects the text of the leaf elements of a request body
+func xmlValues(r io.Reader) map[string]string {
+	values := make(map[string]string)
+	decoder := xml.NewDecoder(r)
+
+	var name, text string
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return values
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name, text = t.Name.Local, ""
+		case xml.CharData:
+			text += string(t)
+		case xml.EndElement:
+			if t.Name.Local == name {
+				values[name] = text
+			}
+			name = ""
+		}
+	}
+}
+
+func (g *FakeGateway) servePMP() {
+	buf := make([]byte, 1100)
+	for {
+		n, from, err := g.pmp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if n < 2 {
+			continue
+		}
+
+		var response []byte
+		switch {
+		case buf[0] == 2 && g.options.PCP:
+			response = g.handlePCP(buf[:n], from)
+		case buf[0] == 0 && g.options.NATPMP:
+			response = g.handleNATPMP(buf[:n], from)
+		case g.options.NATPMP:
+			// NAT-PMP's answer to versions it does not know
+			response = []byte{0, buf[1] | 0x80, 0, 1, 0, 0, 0, 0}
+		case g.options.PCP:
+			response = make([]byte, 24)
+			response[0], response[1], response[3] = 2, buf[1]|0x80, 1
+		default:
+			continue
+		}
+		g.pmp.WriteToUDP(response, from)
+	}
+}
+
+// handlePCP answers ANNOUNCE and MAP requests
+func (g *FakeGateway) handlePCP(request []byte, from *net.UDPAddr) []byte {
+	response := make([]byte, 24, 60)
+	response[0] = 2
+	response[1] = request[1] | 0x80
+
+	switch {
+	case request[1] == 0 && len(request) >= 24:
+		return response
+	case request[1] != 1 || len(request) < 60:
+		response[3] = 3 // MALFORMED_REQUEST
+		return response
+	}
+
+	lifetime := binary.BigEndian.Uint32(request[4:8])
+	nonce := string(request[24:36])
+	internalPort := int(binary.BigEndian.Uint16(request[40:42]))
+	port := int(binary.BigEndian.Uint16(request[42:44]))
+	client := from.IP.String()
+
+	response = append(response, request[24:60]...)
+
+	g.mu.Lock()
+	existing, ok := g.find(client, internalPort)
+	g.mu.Unlock()
+
+	if lifetime == 0 {
+		g.mu.Lock()
+		if ok && existing.nonce == nonce {
+			delete(g.mappings, existing.ExternalPort)
+		}
+		g.mu.Unlock()
+		binary.BigEndian.PutUint16(response[42:44], 0)
+		return response
+	}
+
+	if ok {
+		if existing.nonce != nonce {
+			response[3] = 2 // NOT_AUTHORIZED
+			return response
+		}
+		port = existing.ExternalPort
+	}
+	if port == 0 {
+		port = internalPort
+	}
+	for g.add(GatewayMapping{Method: "pcp", InternalClient: client, InternalPort: internalPort, ExternalPort: port,
+		Lifetime: time.Duration(lifetime) * time.Second, nonce: nonce}) == 0 {
+		port++
+	}
+
+	binary.BigEndian.PutUint32(response[4:8], lifetime)
+	binary.BigEndian.PutUint16(response[42:44], uint16(port))
+	copy(response[44:60], g.options.ExternalIP.To16())
+	return response
+}
+
+// handleNATPMP answers external address and UDP mapping requests
+func (g *FakeGateway) handleNATPMP(request []byte, from *net.UDPAddr) []byte {
+	switch {
+	case request[1] == 0:
+		response := make([]byte, 12)
+		response[1] = 128
+		copy(response[8:12], g.options.ExternalIP.To4())
+		return response
+	case request[1] != 1 || len(request) < 12:
+		return []byte{0, request[1] | 0x80, 0, 5, 0, 0, 0, 0} // unsupported opcode
+	}
+
+	internalPort := int(binary.BigEndian.Uint16(request[4:6]))
+	port := int(binary.BigEndian.Uint16(request[6:8]))
+	lifetime := binary.BigEndian.Uint32(request[8:12])
+	client := from.IP.String()
+
+	response := make([]byte, 16)
+	response[1] = 129
+	copy(response[8:10], request[4:6])
+
+	g.mu.Lock()
+	existing, ok := g.find(client, internalPort)
+	if lifetime == 0 && ok {
+		delete(g.mappings, existing.ExternalPort)
+	}
+	g.mu.Unlock()
+	if lifetime == 0 {
+		return response
+	}
+
+	if ok {
+		port = existing.ExternalPort
+	}
+	if port == 0 {
+		port = internalPort
+	}
+	for g.add(GatewayMapping{Method: "natpmp", InternalClient: client, InternalPort: internalPort, ExternalPort: port,
+		Lifetime: time.Duration(lifetime) * time.Second}) == 0 {
+		port++
+	}
+
+	binary.BigEndian.PutUint16(response[10:12], uint16(port))
+	binary.BigEndian.PutUint32(response[12:16], lifetime)
+	return response
+}
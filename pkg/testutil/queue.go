@@ -0,0 +1,96 @@
+package testutil
+
+import (
+	"sync"
+	"time"
+)
+
+// event is a callback scheduled on a peer's event queue
+type event struct {
+	at time.Time
+	fn func()
+}
+
+// eventQueue runs a fake peer's callbacks one at a time, in order, on its
+// own goroutine, the way pion runs its handlers off the caller's goroutine
+type eventQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	events  []event
+	running bool
+	closed  bool
+}
+
+func newEventQueue() *eventQueue {
+	q := &eventQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+// push schedules fn to run after delay, behind everything already queued
+func (q *eventQueue) push(delay time.Duration, fn func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+
+	q.events = append(q.events, event{at: time.Now().Add(delay), fn: fn})
+	q.cond.Broadcast()
+}
+
+// run executes events until the queue is closed and empty
+func (q *eventQueue) run() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for len(q.events) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.events) == 0 {
+			return
+		}
+
+		next := q.events[0]
+		q.events = q.events[1:]
+		q.running = true
+		q.mu.Unlock()
+
+		if wait := time.Until(next.at); wait > 0 {
+			time.Sleep(wait)
+		}
+		next.fn()
+
+		q.mu.Lock()
+		q.running = false
+		q.cond.Broadcast()
+	}
+}
+
+// flush blocks until every queued event has run
+func (q *eventQueue) flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.events) > 0 || q.running {
+		q.cond.Wait()
+	}
+}
+
+// idle reports whether nothing is queued or running
+func (q *eventQueue) idle() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.events) == 0 && !q.running
+}
+
+// close rejects new events; the ones already queued still run
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,35 @@
+package turn
+
+import (
+	"net"
+	"time"
+
+	pionturn "github.com/pion/turn/v2"
+)
+
+// authHandler accepts static users and, with a secret, time-limited
+// credentials. now is the clock expiry is checked against
+func authHandler(config Config, now func() time.Time) pionturn.AuthHandler {
+	realm := config.realm()
+
+	keys := make(map[string][]byte, len(config.Users))
+	for user, password := range config.Users {
+		keys[user] = pionturn.GenerateAuthKey(user, realm, password)
+	}
+
+	return func(username, _ string, _ net.Addr) ([]byte, bool) {
+		if key, ok := keys[username]; ok {
+			return key, true
+		}
+
+		if config.Secret == "" {
+			return nil, false
+		}
+
+		expiry, ok := credentialExpiry(username)
+		if !ok || now().After(expiry) {
+			return nil, false
+		}
+		return pionturn.GenerateAuthKey(username, realm, restPassword(config.Secret, username)), true
+	}
+}
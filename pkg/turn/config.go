@@ -0,0 +1,198 @@
+package turn
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
+)
+
+// DefaultRealm is the realm used when the config does not set one
+const DefaultRealm = "p2p-chat"
+
+// DefaultListenUDP is the STUN/TURN port clients use when nothing else is configured
+const DefaultListenUDP = "0.0.0.0:3478"
+
+// Config describes a self-hosted STUN/TURN relay. It is read from JSON:
+//
+//	{
+//	  "realm": "chat.example.com",
+//	  "publicIP": "203.0.113.10",
+//	  "host": "turn.example.com",
+//	  "listenUDP": "0.0.0.0:3478",
+//	  "listenTCP": "0.0.0.0:3478",
+//	  "listenTLS": "0.0.0.0:5349",
+//	  "certFile": "/etc/relay/cert.pem",
+//	  "keyFile": "/etc/relay/key.pem",
+//	  "relayPortMin": 49152,
+//	  "relayPortMax": 65535,
+//	  "users": {"alice": "secret"},
+//	  "secret": "shared-secret-for-time-limited-credentials",
+//	  "maxAllocations": 100,
+//	  "bandwidthLimit": 125000,
+//	  "allocationQuota": 1073741824
+//	}
+type Config struct {
+	// Realm is the authentication realm. Empty means DefaultRealm
+	Realm string `json:"realm,omitempty"`
+
+	// PublicIP is the address relayed candidates advertise; it must be
+	// reachable by both peers
+	PublicIP string `json:"publicIP"`
+
+	// Host is the name or address clients dial. Empty means PublicIP
+	Host string `json:"host,omitempty"`
+
+	// ListenUDP, ListenTCP and ListenTLS are the addresses the server
+	// accepts clients on. Empty ListenUDP means DefaultListenUDP; empty
+	// ListenTCP or ListenTLS disables that transport
+	ListenUDP string `json:"listenUDP,omitempty"`
+	ListenTCP string `json:"listenTCP,omitempty"`
+	ListenTLS string `json:"listenTLS,omitempty"`
+
+	// CertFile and KeyFile hold the TLS certificate for ListenTLS
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+
+	// RelayBind is the local address relay sockets bind to. Empty means all
+	RelayBind string `json:"relayBind,omitempty"`
+
+	// RelayPortMin and RelayPortMax bound the relay ports. Zero means any
+	RelayPortMin uint16 `json:"relayPortMin,omitempty"`
+	RelayPortMax uint16 `json:"relayPortMax,omitempty"`
+
+	// Users maps static usernames to passwords
+	Users map[string]string `json:"users,omitempty"`
+
+	// Secret enables time-limited credentials: the username is
+	// "<expiry unix time>[:<name>]" and the password its base64
+	// HMAC-SHA1 under Secret, as with coturn's use-auth-secret
+	Secret string `json:"secret,omitempty"`
+
+	// MaxAllocations caps the relays open at once. Zero means no limit
+	MaxAllocations int `json:"maxAllocations,omitempty"`
+
+	// BandwidthLimit caps each allocation, in bytes per second in each
+	// direction. Packets over the limit are dropped. Zero means no limit
+	BandwidthLimit int64 `json:"bandwidthLimit,omitempty"`
+
+	// AllocationQuota is the most bytes an allocation may relay in total;
+	// later packets are dropped. Zero means no limit
+	AllocationQuota int64 `json:"allocationQuota,omitempty"`
+}
+
+// LoadConfig reads a relay config file
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// Validate checks that a server can be started from the config
+func (c Config) Validate() error {
+	if net.ParseIP(c.PublicIP) == nil {
+		return fmt.Errorf("publicIP must be an IP address, got %q", c.PublicIP)
+	}
+
+	if len(c.Users) == 0 && c.Secret == "" {
+		return fmt.Errorf("no credentials: set users or secret")
+	}
+	for user, password := range c.Users {
+		if user == "" || password == "" {
+			return fmt.Errorf("users need a name and a password")
+		}
+	}
+
+	if c.ListenTLS != "" && (c.CertFile == "" || c.KeyFile == "") {
+		return fmt.Errorf("listenTLS requires certFile and keyFile")
+	}
+
+	if (c.RelayPortMin == 0) != (c.RelayPortMax == 0) || c.RelayPortMin > c.RelayPortMax {
+		return fmt.Errorf("invalid relay port range %d-%d", c.RelayPortMin, c.RelayPortMax)
+	}
+
+	if c.MaxAllocations < 0 || c.BandwidthLimit < 0 || c.AllocationQuota < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+
+	return nil
+}
+
+// realm returns the configured realm or the default
+func (c Config) realm() string {
+	if c.Realm == "" {
+		return DefaultRealm
+	}
+	return c.Realm
+}
+
+// host returns the name clients dial
+func (c Config) host() string {
+	if c.Host == "" {
+		return c.PublicIP
+	}
+	return c.Host
+}
+
+// listenUDP returns the configured UDP address or the default
+func (c Config) listenUDP() string {
+	if c.ListenUDP == "" {
+		return DefaultListenUDP
+	}
+	return c.ListenUDP
+}
+
+// ICEServers returns the servers a chat client should use to reach this
+// relay with the given credentials: STUN, and TURN over every enabled
+// transport
+func (c Config) ICEServers(username, credential string) []webrtc.ICEServer {
+	host := c.host()
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+
+	udpPort := port(c.listenUDP())
+	turnURLs := []string{fmt.Sprintf("turn:%s:%s?transport=udp", host, udpPort)}
+	if c.ListenTCP != "" {
+		turnURLs = append(turnURLs, fmt.Sprintf("turn:%s:%s?transport=tcp", host, port(c.ListenTCP)))
+	}
+	if c.ListenTLS != "" {
+		turnURLs = append(turnURLs, fmt.Sprintf("turns:%s:%s?transport=tcp", host, port(c.ListenTLS)))
+	}
+
+	return []webrtc.ICEServer{
+		{URLs: []string{fmt.Sprintf("stun:%s:%s", host, udpPort)}},
+		{URLs: turnURLs, Username: username, Credential: credential},
+	}
+}
+
+// ClientConfig returns a chat client config using this relay, ready to be
+// written to a file for P2P_CHAT_CONFIG
+func (c Config) ClientConfig(username, credential string) webrtc.PeerConfig {
+	config := webrtc.DefaultPeerConfig()
+	config.ICEServers = c.ICEServers(username, credential)
+	return config
+}
+
+// port returns the port of a host:port address
+func port(address string) string {
+	_, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return p
+}
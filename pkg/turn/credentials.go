@@ -0,0 +1,171 @@
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
+)
+
+// Environment variables read by LoadPeerConfig
+const (
+	// EnvSecret is the relay's shared secret. When set, TURN servers get
+	// time-limited credentials derived from it
+	EnvSecret = "P2P_CHAT_TURN_SECRET"
+
+	// EnvCredentialTTL is how long each credential is valid, a Go
+	// duration such as "12h"
+	EnvCredentialTTL = "P2P_CHAT_TURN_TTL"
+)
+
+// DefaultCredentialTTL is how long a time-limited credential is valid when
+// nothing else is configured. TURN allocations stop being refreshed once
+// their credential expires, so it should outlast a chat session
+const DefaultCredentialTTL = 24 * time.Hour
+
+var (
+	// ErrInvalidCredential is returned for a malformed username or a
+	// password that does not match the secret
+	ErrInvalidCredential = errors.New("invalid TURN credential")
+
+	// ErrCredentialExpired is returned for a credential past its expiry
+	ErrCredentialExpired = errors.New("TURN credential expired")
+)
+
+// Credential is a time-limited TURN username and password in the "TURN
+// REST API" format understood by this package's server and by coturn's
+// use-auth-secret
+type Credential struct {
+	Username string
+	Password string
+	Expires  time.Time
+}
+
+// NewCredential creates a credential for name valid for ttl from now.
+// name may be empty; it only shows up in the relay's logs
+func NewCredential(secret, name string, ttl time.Duration) Credential {
+	return newCredential(secret, name, time.Now().Add(ttl))
+}
+
+// newCredential creates a credential expiring at expires
+func newCredential(secret, name string, expires time.Time) Credential {
+	username := strconv.FormatInt(expires.Unix(), 10)
+	if name != "" {
+		username += ":" + name
+	}
+
+	return Credential{
+		Username: username,
+		Password: restPassword(secret, username),
+		Expires:  time.Unix(expires.Unix(), 0),
+	}
+}
+
+// ValidateCredential checks a time-limited username and password against
+// the shared secret at the given time
+func ValidateCredential(secret, username, password string, now time.Time) error {
+	expiry, ok := credentialExpiry(username)
+	if !ok {
+		return fmt.Errorf("%w: username %q has no expiry", ErrInvalidCredential, username)
+	}
+
+	if !hmac.Equal([]byte(password), []byte(restPassword(secret, username))) {
+		return fmt.Errorf("%w: wrong password for %q", ErrInvalidCredential, username)
+	}
+
+	if now.After(expiry) {
+		return fmt.Errorf("%w on %s", ErrCredentialExpired, expiry.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// credentialExpiry parses the expiry of a time-limited username,
+// "<unix time>" or "<unix time>:<name>"
+func credentialExpiry(username string) (time.Time, bool) {
+	timestamp, _, _ := strings.Cut(username, ":")
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// restPassword is the password of a time-limited username: the base64
+// HMAC-SHA1 of the username under the shared secret
+func restPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Refresher hands out time-limited credentials, creating a new one when
+// the current one has less than a quarter of its lifetime left. It is a
+// webrtc.CredentialSource, so every new connection and ICE restart of a
+// RealPeer gets a fresh credential
+type Refresher struct {
+	secret string
+	name   string
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	current Credential
+}
+
+// NewRefresher creates a refresher for name. A ttl of zero means
+// DefaultCredentialTTL
+func NewRefresher(secret, name string, ttl time.Duration) *Refresher {
+	if ttl <= 0 {
+		ttl = DefaultCredentialTTL
+	}
+	return &Refresher{secret: secret, name: name, ttl: ttl, now: time.Now}
+}
+
+// Credential returns a credential with at least three quarters of its
+// lifetime left
+func (r *Refresher) Credential() Credential {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if r.current.Expires.Sub(now) < r.ttl/4 {
+		r.current = newCredential(r.secret, r.name, now.Add(r.ttl))
+	}
+	return r.current
+}
+
+// TURNCredential implements webrtc.CredentialSource
+func (r *Refresher) TURNCredential() (string, string, error) {
+	credential := r.Credential()
+	return credential.Username, credential.Password, nil
+}
+
+// LoadPeerConfig is webrtc.LoadPeerConfig with time-limited credentials for
+// name when P2P_CHAT_TURN_SECRET is set
+func LoadPeerConfig(name string) (webrtc.PeerConfig, error) {
+	secret := os.Getenv(EnvSecret)
+	if secret == "" {
+		return webrtc.LoadPeerConfig()
+	}
+
+	ttl := DefaultCredentialTTL
+	if value := os.Getenv(EnvCredentialTTL); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return webrtc.PeerConfig{}, fmt.Errorf("invalid %s: %q", EnvCredentialTTL, value)
+		}
+		ttl = parsed
+	}
+
+	return webrtc.LoadPeerConfigWithCredentials(NewRefresher(secret, name, ttl))
+}
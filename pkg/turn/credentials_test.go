@@ -0,0 +1,116 @@
+package turn
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
+)
+
+func TestCredential(t *testing.T) {
+	expires := time.Unix(1700000000, 500)
+	credential := newCredential(testSecret, "bob", expires)
+
+	assert.Equal(t, "1700000000:bob", credential.Username)
+	assert.Equal(t, time.Unix(1700000000, 0), credential.Expires)
+	assert.NotEqual(t, credential.Password, newCredential("other-secret", "bob", expires).Password)
+	assert.Equal(t, "1700000000", newCredential(testSecret, "", expires).Username)
+
+	before := expires.Add(-time.Minute)
+	assert.NoError(t, ValidateCredential(testSecret, credential.Username, credential.Password, before))
+	assert.ErrorIs(t, ValidateCredential(testSecret, credential.Username, credential.Password, expires.Add(time.Minute)), ErrCredentialExpired)
+	assert.ErrorIs(t, ValidateCredential("other-secret", credential.Username, credential.Password, before), ErrInvalidCredential)
+	assert.ErrorIs(t, ValidateCredential(testSecret, "1700000001:bob", credential.Password, before), ErrInvalidCredential)
+	assert.ErrorIs(t, ValidateCredential(testSecret, "bob", credential.Password, before), ErrInvalidCredential)
+
+	fresh := NewCredential(testSecret, "bob", time.Hour)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), fresh.Expires, time.Second)
+	assert.NoError(t, ValidateCredential(testSecret, fresh.Username, fresh.Password, time.Now()))
+}
+
+func TestRefresher(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	refresher := NewRefresher(testSecret, "bob", 4*time.Hour)
+	refresher.now = func() time.Time { return now }
+
+	first := refresher.Credential()
+	assert.Equal(t, now.Add(4*time.Hour), first.Expires)
+
+	// Reused while more than a quarter of the lifetime is left
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, first, refresher.Credential())
+
+	now = now.Add(time.Hour + time.Second)
+	second := refresher.Credential()
+	assert.Equal(t, now.Add(4*time.Hour), second.Expires)
+	assert.NoError(t, ValidateCredential(testSecret, second.Username, second.Password, now))
+
+	username, password, err := refresher.TURNCredential()
+	require.NoError(t, err)
+	assert.Equal(t, second.Username, username)
+	assert.Equal(t, second.Password, password)
+
+	assert.Equal(t, DefaultCredentialTTL, NewRefresher(testSecret, "", 0).ttl)
+}
+
+func TestRefresher_Concurrent(t *testing.T) {
+	refresher := NewRefresher(testSecret, "bob", time.Hour)
+
+	var wg sync.WaitGroup
+	credentials := make([]Credential, 8)
+	for i := range credentials {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			credentials[i] = refresher.Credential()
+		}()
+	}
+	wg.Wait()
+
+	for _, credential := range credentials {
+		assert.Equal(t, credentials[0], credential)
+	}
+}
+
+func TestRefresher_ChatThroughRelay(t *testing.T) {
+	server := startServer(t, func(c *Config) { c.ListenTCP = "" })
+
+	// The client config carries no credential, only the refresher does
+	config := server.ClientConfig("", "")
+	config.ICETransportPolicy = webrtc.TransportPolicyRelay
+	config.Credentials = NewRefresher(testSecret, "bob", time.Hour)
+	require.NoError(t, config.Validate())
+
+	assertRelayed(t, config)
+}
+
+func TestLoadPeerConfig(t *testing.T) {
+	for _, key := range []string{webrtc.EnvConfigFile, webrtc.EnvTURNUsername, webrtc.EnvTURNCredential, webrtc.EnvICETransportPolicy, webrtc.EnvGatheringTimeout, webrtc.EnvPrivacy} {
+		t.Setenv(key, "")
+	}
+	t.Setenv(webrtc.EnvICEServers, "turn:turn.example.com:3478")
+
+	t.Setenv(EnvSecret, "")
+	_, err := LoadPeerConfig("bob")
+	assert.Error(t, err, "no credentials for the TURN server")
+
+	t.Setenv(EnvSecret, testSecret)
+	t.Setenv(EnvCredentialTTL, "2h")
+	config, err := LoadPeerConfig("bob")
+	require.NoError(t, err)
+	require.NotNil(t, config.Credentials)
+
+	username, password, err := config.Credentials.TURNCredential()
+	require.NoError(t, err)
+	assert.Regexp(t, `^\d+:bob$`, username)
+	assert.NoError(t, ValidateCredential(testSecret, username, password, time.Now().Add(119*time.Minute)))
+	assert.ErrorIs(t, ValidateCredential(testSecret, username, password, time.Now().Add(121*time.Minute)), ErrCredentialExpired)
+
+	t.Setenv(EnvCredentialTTL, "-1h")
+	_, err = LoadPeerConfig("bob")
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package turn
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pionturn "github.com/pion/turn/v2"
+)
+
+// errTooManyAllocations rejects an allocation past Config.MaxAllocations
+var errTooManyAllocations = errors.New("too many allocations")
+
+// limitedGenerator wraps a RelayAddressGenerator to cap the number of open
+// allocations and to meter the traffic of each one
+type limitedGenerator struct {
+	pionturn.RelayAddressGenerator
+
+	maxAllocations int
+	bandwidth      int64
+	quota          int64
+	now            func() time.Time
+
+	open atomic.Int64
+}
+
+func (g *limitedGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	if open := g.open.Add(1); g.maxAllocations > 0 && open > int64(g.maxAllocations) {
+		g.open.Add(-1)
+		return nil, nil, errTooManyAllocations
+	}
+
+	conn, addr, err := g.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		g.open.Add(-1)
+		return nil, nil, err
+	}
+
+	return &meteredConn{
+		PacketConn: conn,
+		bandwidth:  g.bandwidth,
+		quota:      g.quota,
+		now:        g.now,
+		onClose:    func() { g.open.Add(-1) },
+	}, addr, nil
+}
+
+// meteredConn is a relay socket that drops packets over the allocation's
+// bandwidth limit or quota. Dropping rather than blocking keeps UDP
+// semantics; the peers' congestion control backs off
+type meteredConn struct {
+	net.PacketConn
+
+	bandwidth int64
+	quota     int64
+	now       func() time.Time
+	onClose   func()
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	relayed  int64
+	inbound  bucket
+	outbound bucket
+}
+
+// bucket is a token bucket holding up to one second of bandwidth
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// ReadFrom returns the next packet from a peer that fits the limits
+func (c *meteredConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil || c.admit(&c.inbound, n) {
+			return n, addr, err
+		}
+	}
+}
+
+// WriteTo relays a packet to a peer, or silently drops it over the limits
+func (c *meteredConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if !c.admit(&c.outbound, len(p)) {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func (c *meteredConn) Close() error {
+	c.closeOnce.Do(c.onClose)
+	return c.PacketConn.Close()
+}
+
+// admit reports whether n more bytes fit the quota and the direction's bandwidth
+func (c *meteredConn) admit(b *bucket, n int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.quota > 0 && c.relayed+int64(n) > c.quota {
+		return false
+	}
+
+	if c.bandwidth > 0 {
+		now := c.now()
+		if b.last.IsZero() {
+			b.tokens = float64(c.bandwidth)
+		} else {
+			b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*float64(c.bandwidth), float64(c.bandwidth))
+		}
+		b.last = now
+
+		if b.tokens < float64(n) {
+			return false
+		}
+		b.tokens -= float64(n)
+	}
+
+	c.relayed += int64(n)
+	return true
+}
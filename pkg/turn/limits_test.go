@@ -0,0 +1,61 @@
+package turn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sinkConn is a PacketConn that counts what is written to it
+type sinkConn struct {
+	net.PacketConn
+	written int
+}
+
+func (c *sinkConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	c.written += len(p)
+	return len(p), nil
+}
+
+func (c *sinkConn) Close() error {
+	return nil
+}
+
+func TestMeteredConn_Bandwidth(t *testing.T) {
+	now := time.Now()
+	sink := &sinkConn{}
+	conn := &meteredConn{PacketConn: sink, bandwidth: 1000, now: func() time.Time { return now }, onClose: func() {}}
+
+	packet := make([]byte, 400)
+	for i := 0; i < 5; i++ {
+		n, err := conn.WriteTo(packet, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, len(packet), n, "drops look like successful writes")
+	}
+	assert.Equal(t, 800, sink.written, "one second of bandwidth")
+
+	// Tokens refill over time
+	now = now.Add(500 * time.Millisecond)
+	conn.WriteTo(packet, nil)
+	assert.Equal(t, 1200, sink.written)
+	conn.WriteTo(packet, nil)
+	assert.Equal(t, 1200, sink.written)
+}
+
+func TestMeteredConn_Quota(t *testing.T) {
+	sink := &sinkConn{}
+	closed := 0
+	conn := &meteredConn{PacketConn: sink, quota: 1000, now: time.Now, onClose: func() { closed++ }}
+
+	packet := make([]byte, 300)
+	for i := 0; i < 5; i++ {
+		conn.WriteTo(packet, nil)
+	}
+	assert.Equal(t, 900, sink.written)
+
+	conn.Close()
+	conn.Close()
+	assert.Equal(t, 1, closed)
+}
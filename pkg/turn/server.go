@@ -0,0 +1,134 @@
+package turn
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	pionturn "github.com/pion/turn/v2"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
+)
+
+// Server is a running STUN/TURN relay
+type Server struct {
+	config    Config
+	server    *pionturn.Server
+	generator *limitedGenerator
+}
+
+// NewServer starts a relay on the addresses in config
+func NewServer(config Config) (*Server, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	relayIP := net.ParseIP(config.PublicIP)
+	bind := config.RelayBind
+	if bind == "" {
+		bind = "0.0.0.0"
+	}
+
+	var relay pionturn.RelayAddressGenerator = &pionturn.RelayAddressGeneratorStatic{
+		RelayAddress: relayIP,
+		Address:      bind,
+	}
+	if config.RelayPortMin != 0 {
+		relay = &pionturn.RelayAddressGeneratorPortRange{
+			RelayAddress: relayIP,
+			Address:      bind,
+			MinPort:      config.RelayPortMin,
+			MaxPort:      config.RelayPortMax,
+		}
+	}
+
+	generator := &limitedGenerator{
+		RelayAddressGenerator: relay,
+		maxAllocations:        config.MaxAllocations,
+		bandwidth:             config.BandwidthLimit,
+		quota:                 config.AllocationQuota,
+		now:                   time.Now,
+	}
+
+	// Listeners opened so far, closed again if a later step fails
+	var closers []func() error
+	fail := func(err error) (*Server, error) {
+		for _, close := range closers {
+			close()
+		}
+		return nil, err
+	}
+
+	udp, err := net.ListenPacket("udp", config.listenUDP())
+	if err != nil {
+		return fail(fmt.Errorf("failed to listen on UDP %s: %w", config.listenUDP(), err))
+	}
+	closers = append(closers, udp.Close)
+	config.ListenUDP = udp.LocalAddr().String()
+
+	var listeners []pionturn.ListenerConfig
+	if config.ListenTCP != "" {
+		tcp, err := net.Listen("tcp", config.ListenTCP)
+		if err != nil {
+			return fail(fmt.Errorf("failed to listen on TCP %s: %w", config.ListenTCP, err))
+		}
+		closers = append(closers, tcp.Close)
+		config.ListenTCP = tcp.Addr().String()
+		listeners = append(listeners, pionturn.ListenerConfig{Listener: tcp, RelayAddressGenerator: generator})
+	}
+
+	if config.ListenTLS != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return fail(fmt.Errorf("failed to load TLS certificate: %w", err))
+		}
+
+		tlsListener, err := tls.Listen("tcp", config.ListenTLS, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+		if err != nil {
+			return fail(fmt.Errorf("failed to listen on TLS %s: %w", config.ListenTLS, err))
+		}
+		closers = append(closers, tlsListener.Close)
+		config.ListenTLS = tlsListener.Addr().String()
+		listeners = append(listeners, pionturn.ListenerConfig{Listener: tlsListener, RelayAddressGenerator: generator})
+	}
+
+	server, err := pionturn.NewServer(pionturn.ServerConfig{
+		Realm:       config.realm(),
+		AuthHandler: authHandler(config, time.Now),
+		PacketConnConfigs: []pionturn.PacketConnConfig{
+			{PacketConn: udp, RelayAddressGenerator: generator},
+		},
+		ListenerConfigs: listeners,
+	})
+	if err != nil {
+		return fail(fmt.Errorf("failed to start relay: %w", err))
+	}
+
+	return &Server{config: config, server: server, generator: generator}, nil
+}
+
+// Config returns the server's config, with the listen addresses resolved
+// to the ports actually bound (relevant when a port was 0)
+func (s *Server) Config() Config {
+	return s.config
+}
+
+// ClientConfig returns a chat client config that reaches this server with
+// the given credentials
+func (s *Server) ClientConfig(username, credential string) webrtc.PeerConfig {
+	return s.config.ClientConfig(username, credential)
+}
+
+// Allocations returns the number of relays currently open
+func (s *Server) Allocations() int {
+	return int(s.generator.open.Load())
+}
+
+// Close stops the server and releases every allocation
+func (s *Server) Close() error {
+	return s.server.Close()
+}
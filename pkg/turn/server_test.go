@@ -0,0 +1,220 @@
+package turn
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pionturn "github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
+)
+
+const testSecret = "shared-secret"
+
+// startServer runs a relay on loopback with a static user alice and time-limited credentials
+func startServer(t *testing.T, configure func(*Config)) *Server {
+	t.Helper()
+
+	config := Config{
+		PublicIP:  "127.0.0.1",
+		ListenUDP: "127.0.0.1:0",
+		ListenTCP: "127.0.0.1:0",
+		RelayBind: "127.0.0.1",
+		Users:     map[string]string{"alice": "wonderland"},
+		Secret:    testSecret,
+	}
+	if configure != nil {
+		configure(&config)
+	}
+
+	server, err := NewServer(config)
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// allocate requests a relay from server. Closing the returned conn releases it
+func allocate(t *testing.T, server *Server, username, password string) (net.PacketConn, error) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := pionturn.NewClient(&pionturn.ClientConfig{
+		STUNServerAddr: server.Config().ListenUDP,
+		TURNServerAddr: server.Config().ListenUDP,
+		Username:       username,
+		Password:       password,
+		Realm:          DefaultRealm,
+		Conn:           conn,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+	t.Cleanup(func() {
+		client.Close()
+		conn.Close()
+	})
+
+	return client.Allocate()
+}
+
+// timeLimited returns a time-limited username expiring at expiry and its password
+func timeLimited(expiry time.Time, secret string) (string, string) {
+	credential := newCredential(secret, "bob", expiry)
+	return credential.Username, credential.Password
+}
+
+func TestServer_Credentials(t *testing.T) {
+	server := startServer(t, nil)
+
+	_, err := allocate(t, server, "alice", "wonderland")
+	assert.NoError(t, err, "static user")
+
+	_, err = allocate(t, server, "alice", "looking-glass")
+	assert.Error(t, err, "wrong password")
+
+	_, err = allocate(t, server, "mallory", "wonderland")
+	assert.Error(t, err, "unknown user")
+
+	username, password := timeLimited(time.Now().Add(time.Hour), testSecret)
+	_, err = allocate(t, server, username, password)
+	assert.NoError(t, err, "time-limited")
+
+	username, password = timeLimited(time.Now().Add(-time.Minute), testSecret)
+	_, err = allocate(t, server, username, password)
+	assert.Error(t, err, "expired")
+
+	username, password = timeLimited(time.Now().Add(time.Hour), "other-secret")
+	_, err = allocate(t, server, username, password)
+	assert.Error(t, err, "signed with another secret")
+}
+
+func TestServer_MaxAllocations(t *testing.T) {
+	server := startServer(t, func(c *Config) { c.MaxAllocations = 1 })
+
+	first, err := allocate(t, server, "alice", "wonderland")
+	require.NoError(t, err)
+	assert.Equal(t, 1, server.Allocations())
+
+	_, err = allocate(t, server, "alice", "wonderland")
+	assert.Error(t, err, "over the limit")
+
+	// Releasing the allocation frees the slot
+	first.Close()
+	require.Eventually(t, func() bool {
+		_, err := allocate(t, server, "alice", "wonderland")
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestServer_ChatThroughRelay(t *testing.T) {
+	server := startServer(t, nil)
+	username, password := timeLimited(time.Now().Add(time.Hour), testSecret)
+
+	for _, transport := range []string{"udp", "tcp"} {
+		t.Run(transport, func(t *testing.T) {
+			config := server.ClientConfig(username, password)
+			config.ICETransportPolicy = webrtc.TransportPolicyRelay
+
+			// Only keep the TURN URL of this transport
+			turnServer := &config.ICEServers[1]
+			var urls []string
+			for _, url := range turnServer.URLs {
+				if strings.HasSuffix(url, "transport="+transport) {
+					urls = append(urls, url)
+				}
+			}
+			turnServer.URLs = urls
+			require.Len(t, urls, 1)
+
+			assertRelayed(t, config)
+		})
+	}
+}
+
+// assertRelayed connects two peers with config and checks a message gets
+// through the relay
+func assertRelayed(t *testing.T, config webrtc.PeerConfig) {
+	t.Helper()
+
+	offerer, err := webrtc.NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer offerer.Close()
+	answerer, err := webrtc.NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	received := make(chan string, 1)
+	answerer.OnMessage(func(data []byte) { received <- string(data) })
+
+	offer, err := offerer.CreateOffer()
+	require.NoError(t, err)
+	answer, err := answerer.CreateAnswer(offer)
+	require.NoError(t, err)
+	require.NoError(t, offerer.SetRemoteAnswer(answer))
+
+	require.Eventually(t, func() bool {
+		return offerer.Send([]byte("hello")) == nil
+	}, 10*time.Second, 50*time.Millisecond)
+
+	select {
+	case text := <-received:
+		assert.Equal(t, "hello", text)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not relayed")
+	}
+	assert.True(t, offerer.Stats().UsingRelay())
+}
+
+func TestConfig(t *testing.T) {
+	config := Config{
+		PublicIP:  "203.0.113.10",
+		Host:      "turn.example.com",
+		ListenTCP: "0.0.0.0:3478",
+		ListenTLS: "0.0.0.0:443",
+		CertFile:  "cert.pem",
+		KeyFile:   "key.pem",
+		Users:     map[string]string{"alice": "wonderland"},
+	}
+	require.NoError(t, config.Validate())
+
+	client := config.ClientConfig("alice", "wonderland")
+	require.NoError(t, client.Validate())
+	assert.Equal(t, []webrtc.ICEServer{
+		{URLs: []string{"stun:turn.example.com:3478"}},
+		{
+			URLs: []string{
+				"turn:turn.example.com:3478?transport=udp",
+				"turn:turn.example.com:3478?transport=tcp",
+				"turns:turn.example.com:443?transport=tcp",
+			},
+			Username:   "alice",
+			Credential: "wonderland",
+		},
+	}, client.ICEServers)
+
+	invalid := []func(*Config){
+		func(c *Config) { c.PublicIP = "turn.example.com" },
+		func(c *Config) { c.Users = nil },
+		func(c *Config) { c.CertFile = "" },
+		func(c *Config) { c.RelayPortMin = 50000 },
+		func(c *Config) { c.MaxAllocations = -1 },
+	}
+	for i, mutate := range invalid {
+		broken := config
+		mutate(&broken)
+		assert.Error(t, broken.Validate(), "case %d", i)
+	}
+
+	path := filepath.Join(t.TempDir(), "relay.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"publicIP": "203.0.113.10", "secret": "s"}`), 0o600))
+	loaded, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "s", loaded.Secret)
+}
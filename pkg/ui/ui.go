@@ -1,18 +1,28 @@
 package ui
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
+	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/widget"
 
 	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/client"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/identity"
 	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/protocol"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/signaling"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/turn"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/upnp"
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/webrtc"
 )
 
 // ChatApp represents the main chat application UI
@@ -22,8 +32,16 @@ type ChatApp struct {
 	client   *client.ChatClient
 	username string
 
+	// Fingerprints of known peers, nil without a keystore
+	contacts *identity.ContactBook
+
+	// Rendezvous server from P2P_CHAT_SIGNAL, empty to only use codes
+	signalServer string
+
 	// UI components
 	usernameEntry    *widget.Entry
+	privacyCheck     *widget.Check
+	portMapCheck     *widget.Check
 	connectContainer *fyne.Container
 	chatContainer    *fyne.Container
 	messageList      *widget.List
@@ -38,8 +56,23 @@ type ChatApp struct {
 
 	// Data
 	messages []string
+
+	// Typing indicator state
+	lastTypingSent time.Time
+	typingTimer    *time.Timer
 }
 
+const (
+	// How long creating or joining a room on the rendezvous server may take
+	rendezvousTimeout = 30 * time.Second
+
+	// How often we tell the peer we are still typing
+	typingSendInterval = 2 * time.Second
+
+	// How long the "is typing..." hint stays without a new typing message
+	typingDisplayDuration = 3 * time.Second
+)
+
 // NewChatApp creates a new chat application
 func NewChatApp() *ChatApp {
 	a := app.New()
@@ -47,9 +80,10 @@ func NewChatApp() *ChatApp {
 	w.Resize(fyne.NewSize(600, 500))
 
 	return &ChatApp{
-		app:      a,
-		window:   w,
-		messages: make([]string, 0),
+		app:          a,
+		window:       w,
+		messages:     make([]string, 0),
+		signalServer: os.Getenv(signaling.EnvSignalServer),
 	}
 }
 
@@ -74,6 +108,12 @@ func (ca *ChatApp) createComponents() {
 	ca.usernameEntry = widget.NewEntry()
 	ca.usernameEntry.SetPlaceHolder("Enter your username...")
 
+	// Privacy mode (relay only), also turned on by P2P_CHAT_PRIVACY
+	ca.privacyCheck = widget.NewCheck("Hide my IP address (needs a TURN server)", nil)
+
+	// Opt-in UPnP / NAT-PMP port forwarding for direct connections
+	ca.portMapCheck = widget.NewCheck("Open a port on my router (UPnP / NAT-PMP)", nil)
+
 	// Status label
 	ca.statusLabel = widget.NewLabel("Enter your username to get started")
 
@@ -99,6 +139,11 @@ func (ca *ChatApp) createComponents() {
 	ca.messageEntry.OnSubmitted = func(text string) {
 		ca.sendMessage(text)
 	}
+	ca.messageEntry.OnChanged = func(text string) {
+		if text != "" {
+			ca.notifyTyping()
+		}
+	}
 
 	// Room code entry (for joining)
 	ca.roomCodeEntry = widget.NewEntry()
@@ -125,6 +170,8 @@ func (ca *ChatApp) showUsernameView() {
 	content := container.NewVBox(
 		widget.NewCard("Welcome to P2P Chat", "", container.NewVBox(
 			ca.usernameEntry,
+			ca.privacyCheck,
+			ca.portMapCheck,
 			usernameBtn,
 		)),
 		ca.statusLabel,
@@ -135,8 +182,33 @@ func (ca *ChatApp) showUsernameView() {
 
 // createClient creates a new chat client and shows connection options
 func (ca *ChatApp) createClient(username string) {
-	var err error
-	ca.client, err = client.NewChatClient(username)
+	// ICE servers come from P2P_CHAT_CONFIG / P2P_CHAT_* env vars, if set.
+	// With P2P_CHAT_TURN_SECRET, TURN credentials are time-limited ones
+	peerConfig, err := turn.LoadPeerConfig(username)
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("invalid connection settings: %v", err), ca.window)
+		return
+	}
+
+	// Privacy mode only works with a TURN server to relay through
+	privacy := ca.privacyCheck.Checked
+	if privacy && !peerConfig.Privacy {
+		check := peerConfig
+		check.Privacy = true
+		if err := check.Validate(); err != nil {
+			dialog.ShowError(fmt.Errorf("cannot hide your IP address: %v. Configure one with %s or %s",
+				err, webrtc.EnvICEServers, webrtc.EnvConfigFile), ca.window)
+			return
+		}
+	}
+
+	// A forwarded port would reveal the address privacy mode hides
+	if ca.portMapCheck.Checked && !privacy {
+		peerConfig.PortMapper = upnp.NewMapper(upnp.Options{})
+	}
+
+	opts := append(ca.identityOptions(username), client.WithPrivacy(privacy))
+	ca.client, err = client.NewChatClientWithConfig(username, peerConfig, opts...)
 	if err != nil {
 		dialog.ShowError(fmt.Errorf("failed to create client: %v", err), ca.window)
 		return
@@ -147,15 +219,80 @@ func (ca *ChatApp) createClient(username string) {
 	ca.showConnectionView()
 }
 
+// identityOptions loads the user's persistent certificate and contact book.
+// Without them the chat still works, only fingerprints are not remembered
+func (ca *ChatApp) identityOptions(username string) []client.Option {
+	dir, err := identity.DefaultDir()
+	if err != nil {
+		log.Printf("No identity keystore: %v", err)
+		return nil
+	}
+
+	keystore, err := identity.OpenKeystore(dir)
+	if err != nil {
+		log.Printf("No identity keystore: %v", err)
+		return nil
+	}
+
+	id, err := keystore.Load(username)
+	if err != nil {
+		log.Printf("Failed to load identity: %v", err)
+		return nil
+	}
+
+	contacts, err := keystore.Contacts(username)
+	if err != nil {
+		log.Printf("Failed to load contacts: %v", err)
+		return []client.Option{client.WithIdentity(id)}
+	}
+
+	ca.contacts = contacts
+	return []client.Option{client.WithIdentity(id), client.WithContacts(contacts)}
+}
+
+// warnFingerprintChanged asks whether to trust a contact's new certificate
+func (ca *ChatApp) warnFingerprintChanged(mismatch *identity.FingerprintMismatchError) {
+	what := "a different certificate than last time"
+	if mismatch.Verified {
+		what = "a different certificate than the one you verified"
+	}
+	message := fmt.Sprintf("%s is using %s.\n\n"+
+		"Known: %s\nNow: %s\n\n"+
+		"Someone may have swapped the room or answer code. Only trust the new "+
+		"certificate if %s confirms it changed.", mismatch.Contact, what, mismatch.Known, mismatch.Presented, mismatch.Contact)
+
+	dialog.ShowConfirm("Security Warning", message, func(trust bool) {
+		if !trust || ca.contacts == nil {
+			return
+		}
+		if err := ca.contacts.Trust(mismatch.Contact, mismatch.Presented); err != nil {
+			dialog.ShowError(err, ca.window)
+		}
+	}, ca.window)
+}
+
 // setupClientEventHandlers sets up event handlers for the chat client
 func (ca *ChatApp) setupClientEventHandlers() {
 	ca.client.OnMessage(func(msg protocol.Message) {
+		// Typing indicators only touch the status line
+		if msg.Type == protocol.TypeTyping {
+			fyne.Do(func() {
+				ca.showTyping(msg.From)
+			})
+			return
+		}
+
 		var displayText string
 		switch msg.Type {
 		case protocol.TypeChat:
 			displayText = fmt.Sprintf("%s: %s", msg.From, msg.Text)
 		case protocol.TypeJoin:
 			displayText = fmt.Sprintf("*** %s joined the chat", msg.From)
+			if ca.client.Verified() {
+				displayText += " (verified)"
+			} else {
+				displayText += " - compare safety codes with Verify"
+			}
 		case protocol.TypeLeave:
 			displayText = fmt.Sprintf("*** %s left the chat", msg.From)
 		default:
@@ -173,6 +310,7 @@ func (ca *ChatApp) setupClientEventHandlers() {
 		fyne.Do(func() {
 			ca.statusLabel.SetText("Connected! You can now chat.")
 			ca.showChatView()
+			ca.addMessage("*** " + ca.connectionRoute())
 		})
 	})
 
@@ -184,10 +322,31 @@ func (ca *ChatApp) setupClientEventHandlers() {
 		})
 	})
 
+	ca.client.OnReconnecting(func() {
+		// Ensure UI updates happen on the main thread
+		fyne.Do(func() {
+			ca.statusLabel.SetText("Connection lost, reconnecting...")
+			ca.addMessage("*** Connection interrupted, trying to reconnect")
+		})
+	})
+
+	ca.client.OnReconnected(func() {
+		// Ensure UI updates happen on the main thread
+		fyne.Do(func() {
+			ca.statusLabel.SetText("Connected! You can now chat.")
+			ca.addMessage("*** Reconnected. " + ca.connectionRoute())
+		})
+	})
+
 	ca.client.OnError(func(err error) {
 		// Ensure UI updates happen on the main thread
 		fyne.Do(func() {
 			ca.addMessage(fmt.Sprintf("*** Error: %v", err))
+
+			var mismatch *identity.FingerprintMismatchError
+			if errors.As(err, &mismatch) {
+				ca.warnFingerprintChanged(mismatch)
+			}
 		})
 		log.Printf("Client error: %v", err)
 	})
@@ -198,12 +357,19 @@ func (ca *ChatApp) showConnectionView() {
 	createBtn := widget.NewButton("Create Room", ca.showCreateRoomView)
 	joinBtn := widget.NewButton("Join Room", ca.showJoinRoomView)
 
+	options := container.NewVBox(
+		widget.NewLabel("Choose an option:"),
+		createBtn,
+		joinBtn,
+	)
+	if ca.signalServer != "" {
+		options.Add(widget.NewSeparator())
+		options.Add(widget.NewButton("Create Room on Server", ca.showServerRoomView))
+		options.Add(widget.NewButton("Join by Room ID", ca.showJoinByIDView))
+	}
+
 	ca.connectContainer = container.NewVBox(
-		widget.NewCard("Connection", fmt.Sprintf("Hello, %s!", ca.username), container.NewVBox(
-			widget.NewLabel("Choose an option:"),
-			createBtn,
-			joinBtn,
-		)),
+		widget.NewCard("Connection", fmt.Sprintf("Hello, %s!", ca.username), options),
 		ca.statusLabel,
 	)
 
@@ -226,7 +392,7 @@ func (ca *ChatApp) showCreateRoomView() {
 	roomCodeDisplay.Wrapping = fyne.TextWrapWord
 
 	copyBtn := widget.NewButton("Copy Room Code", func() {
-		ca.window.Clipboard().SetContent(roomCode)
+		ca.window.Clipboard().SetContent(roomCodeDisplay.Text)
 		ca.statusLabel.SetText("Room code copied to clipboard!")
 	})
 
@@ -246,7 +412,8 @@ func (ca *ChatApp) showCreateRoomView() {
 		widget.NewCard("Room Created", "Share this code with your friend", container.NewVBox(
 			widget.NewLabel("Room Code:"),
 			roomCodeDisplay,
-			copyBtn,
+			container.NewHBox(copyBtn, ca.wordsButton(roomCodeDisplay, roomCode)),
+			ca.qrImage(roomCode),
 			widget.NewSeparator(),
 			widget.NewLabel("Click Continue after sharing the code"),
 			container.NewHBox(backBtn, nextBtn),
@@ -258,6 +425,84 @@ func (ca *ChatApp) showCreateRoomView() {
 	ca.window.SetContent(ca.roomCreationContainer)
 }
 
+// showServerRoomView creates a room on the rendezvous server and shows its
+// ID; the answer arrives through the server
+func (ca *ChatApp) showServerRoomView() {
+	ctx, cancel := context.WithTimeout(context.Background(), rendezvousTimeout)
+	defer cancel()
+
+	roomID, err := ca.client.CreateRoomOnServer(ctx, ca.signalServer)
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("failed to create room: %v", err), ca.window)
+		return
+	}
+
+	copyBtn := widget.NewButton("Copy Room ID", func() {
+		ca.window.Clipboard().SetContent(roomID)
+		ca.statusLabel.SetText("Room ID copied to clipboard!")
+	})
+
+	backBtn := widget.NewButton("Back", func() {
+		// Cancel the room creation
+		if ca.client != nil {
+			ca.client.Disconnect()
+		}
+		ca.showConnectionView()
+	})
+
+	ca.roomCreationContainer = container.NewVBox(
+		widget.NewCard("Room Created", "Tell your friend this room ID", container.NewVBox(
+			widget.NewLabelWithStyle(roomID, fyne.TextAlignCenter, fyne.TextStyle{Bold: true, Monospace: true}),
+			copyBtn,
+			widget.NewSeparator(),
+			widget.NewLabel("The chat opens when your friend joins"),
+			backBtn,
+		)),
+		ca.statusLabel,
+	)
+
+	ca.statusLabel.SetText("Waiting for your friend to join...")
+	ca.window.SetContent(ca.roomCreationContainer)
+}
+
+// showJoinByIDView joins a room on the rendezvous server by its ID
+func (ca *ChatApp) showJoinByIDView() {
+	roomIDEntry := widget.NewEntry()
+	roomIDEntry.SetPlaceHolder("Room ID, e.g. K7QF3M")
+
+	backBtn := widget.NewButton("Back", func() {
+		ca.showConnectionView()
+	})
+
+	joinBtn := widget.NewButton("Join Room", func() {
+		roomID := strings.TrimSpace(roomIDEntry.Text)
+		if roomID == "" {
+			dialog.ShowError(fmt.Errorf("room ID cannot be empty"), ca.window)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), rendezvousTimeout)
+		defer cancel()
+		if err := ca.client.JoinRoomOnServer(ctx, ca.signalServer, roomID); err != nil {
+			dialog.ShowError(fmt.Errorf("failed to join room: %v", err), ca.window)
+			return
+		}
+		ca.statusLabel.SetText("Joined! Establishing connection...")
+	})
+
+	ca.roomJoiningContainer = container.NewVBox(
+		widget.NewCard("Join Room", "Enter the room ID from your friend", container.NewVBox(
+			widget.NewLabel("Room ID:"),
+			roomIDEntry,
+			container.NewHBox(backBtn, joinBtn),
+		)),
+		ca.statusLabel,
+	)
+
+	ca.statusLabel.SetText("Enter the room ID to join...")
+	ca.window.SetContent(ca.roomJoiningContainer)
+}
+
 // showWaitingForAnswerView shows interface waiting for answer code
 func (ca *ChatApp) showWaitingForAnswerView() {
 	backBtn := widget.NewButton("Back", func() {
@@ -280,6 +525,7 @@ func (ca *ChatApp) showWaitingForAnswerView() {
 		widget.NewCard("Waiting for Friend", "Enter the answer code from your friend", container.NewVBox(
 			widget.NewLabel("Answer Code:"),
 			ca.answerCodeEntry,
+			ca.loadQRButton(ca.answerCodeEntry),
 			container.NewHBox(backBtn, connectBtn),
 		)),
 		ca.statusLabel,
@@ -311,6 +557,7 @@ func (ca *ChatApp) showJoinRoomView() {
 		widget.NewCard("Join Room", "Enter the room code from your friend", container.NewVBox(
 			widget.NewLabel("Room Code:"),
 			ca.roomCodeEntry,
+			ca.loadQRButton(ca.roomCodeEntry),
 			container.NewHBox(backBtn, joinBtn),
 		)),
 		ca.statusLabel,
@@ -340,7 +587,7 @@ func (ca *ChatApp) showAnswerCodeView(answerCode string) {
 	answerCodeDisplay.Wrapping = fyne.TextWrapWord
 
 	copyBtn := widget.NewButton("Copy Answer Code", func() {
-		ca.window.Clipboard().SetContent(answerCode)
+		ca.window.Clipboard().SetContent(answerCodeDisplay.Text)
 		ca.statusLabel.SetText("Answer code copied to clipboard!")
 	})
 
@@ -356,7 +603,8 @@ func (ca *ChatApp) showAnswerCodeView(answerCode string) {
 		widget.NewCard("Share Answer Code", "Send this code to the room creator", container.NewVBox(
 			widget.NewLabel("Answer Code:"),
 			answerCodeDisplay,
-			copyBtn,
+			container.NewHBox(copyBtn, ca.wordsButton(answerCodeDisplay, answerCode)),
+			ca.qrImage(answerCode),
 			widget.NewSeparator(),
 			widget.NewLabel("Waiting for connection..."),
 			backBtn,
@@ -368,6 +616,67 @@ func (ca *ChatApp) showAnswerCodeView(answerCode string) {
 	ca.window.SetContent(answerContainer)
 }
 
+// qrImage shows a code as QR symbols, to scan from a phone or screenshot
+func (ca *ChatApp) qrImage(code string) fyne.CanvasObject {
+	qr, err := signaling.NewQRCode(code)
+	if err != nil {
+		log.Printf("Failed to make QR code: %v", err)
+		return widget.NewLabel("QR code unavailable")
+	}
+
+	image := canvas.NewImageFromImage(qr.Image(signaling.DefaultQRScale))
+	image.FillMode = canvas.ImageFillContain
+	image.ScaleMode = canvas.ImageScalePixels
+	image.SetMinSize(fyne.NewSize(float32(200*qr.Parts()), 200))
+	return image
+}
+
+// wordsButton switches display between a code and its words, which are
+// easier to read aloud over a call
+func (ca *ChatApp) wordsButton(display *widget.Entry, code string) *widget.Button {
+	var button *widget.Button
+	button = widget.NewButton("Show as Words", func() {
+		if display.Text != code {
+			display.SetText(code)
+			button.SetText("Show as Words")
+			return
+		}
+
+		words, err := signaling.ToWords(code)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("failed to spell code: %v", err), ca.window)
+			return
+		}
+		display.SetText(words)
+		button.SetText("Show as Code")
+	})
+	return button
+}
+
+// loadQRButton reads a code from a QR image file into entry
+func (ca *ChatApp) loadQRButton(entry *widget.Entry) *widget.Button {
+	return widget.NewButton("Load QR Image...", func() {
+		dialog.ShowFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil {
+				dialog.ShowError(err, ca.window)
+				return
+			}
+			if reader == nil {
+				return
+			}
+			reader.Close()
+
+			code, err := signaling.DecodeQRFiles(reader.URI().Path())
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("failed to read QR code: %v", err), ca.window)
+				return
+			}
+			entry.SetText(code)
+			ca.statusLabel.SetText("Code loaded from QR image")
+		}, ca.window)
+	})
+}
+
 // acceptAnswer accepts an answer code
 func (ca *ChatApp) acceptAnswer(answerCode string) {
 	err := ca.client.AcceptAnswer(answerCode)
@@ -397,8 +706,18 @@ func (ca *ChatApp) showChatView() {
 		ca.disconnect()
 	})
 
+	// Connection details button
+	infoBtn := widget.NewButton("Info", func() {
+		ca.showConnectionInfo()
+	})
+
+	// Safety code comparison button
+	verifyBtn := widget.NewButton("Verify", func() {
+		ca.showVerifyDialog()
+	})
+
 	// Status area
-	statusArea := container.NewBorder(nil, nil, nil, disconnectBtn, ca.statusLabel)
+	statusArea := container.NewBorder(nil, nil, nil, container.NewHBox(verifyBtn, infoBtn, disconnectBtn), ca.statusLabel)
 
 	// Main chat container
 	ca.chatContainer = container.NewBorder(
@@ -415,6 +734,92 @@ func (ca *ChatApp) showChatView() {
 	ca.window.Canvas().Focus(ca.messageEntry)
 }
 
+// connectionRoute describes whether traffic goes directly or through a relay
+func (ca *ChatApp) connectionRoute() string {
+	stats := ca.client.Stats()
+
+	switch {
+	case stats.Local == nil:
+		return "Connection route unknown"
+	case stats.UsingRelay():
+		return "Connected through a TURN relay"
+	default:
+		return fmt.Sprintf("Connected directly (%s to %s)", stats.Local.Type, stats.Remote.Type)
+	}
+}
+
+// showConnectionInfo shows the selected candidates and traffic counters
+func (ca *ChatApp) showConnectionInfo() {
+	if ca.client == nil {
+		return
+	}
+
+	stats := ca.client.Stats()
+
+	var info strings.Builder
+	fmt.Fprintf(&info, "State: %s\n", stats.State)
+	if ca.client.Privacy() {
+		fmt.Fprintf(&info, "Privacy mode: your IP address is hidden behind the relay\n")
+	}
+	fmt.Fprintf(&info, "%s\n", ca.connectionRoute())
+	if stats.Local != nil && stats.Remote != nil {
+		fmt.Fprintf(&info, "Local: %s %s:%d (%s)\n", stats.Local.Type, stats.Local.Address, stats.Local.Port, stats.Local.Protocol)
+		fmt.Fprintf(&info, "Remote: %s %s:%d (%s)\n", stats.Remote.Type, stats.Remote.Address, stats.Remote.Port, stats.Remote.Protocol)
+	}
+	fmt.Fprintf(&info, "Your fingerprint: %s\n", ca.client.LocalFingerprint())
+	fmt.Fprintf(&info, "Peer fingerprint: %s\n", ca.client.RemoteFingerprint())
+	fmt.Fprintf(&info, "Verified: %t\n", ca.client.Verified())
+	fmt.Fprintf(&info, "Round trip: %s\n", stats.RTT)
+	fmt.Fprintf(&info, "Sent: %d messages, %d bytes\n", stats.MessagesSent, stats.BytesSent)
+	fmt.Fprintf(&info, "Received: %d messages, %d bytes\n", stats.MessagesReceived, stats.BytesReceived)
+	for _, channel := range stats.Channels {
+		fmt.Fprintf(&info, "Channel %q: %s, %d bytes buffered\n", channel.Label, channel.State, channel.BufferedAmount)
+	}
+
+	dialog.ShowInformation("Connection Info", info.String(), ca.window)
+}
+
+// showVerifyDialog shows the safety code and records whether it matched the
+// one the other person sees
+func (ca *ChatApp) showVerifyDialog() {
+	if ca.client == nil {
+		return
+	}
+
+	code, err := ca.client.SafetyCode()
+	if err != nil {
+		dialog.ShowError(err, ca.window)
+		return
+	}
+
+	var emoji, names []string
+	for _, symbol := range code.Emoji() {
+		emoji = append(emoji, symbol.Emoji)
+		names = append(names, symbol.Name)
+	}
+
+	peer := ca.client.PeerName()
+	if peer == "" {
+		peer = "the other person"
+	}
+
+	message := fmt.Sprintf("Compare this code with %s over a call or in person:\n\n%s\n%s\n\n%s\n\n"+
+		"If it differs, someone may have swapped the room or answer code.",
+		peer, strings.Join(emoji, " "), strings.Join(names, ", "), code.Numeric())
+
+	dialog.ShowCustomConfirm("Verify Safety Code", "They match", "They differ", widget.NewLabel(message), func(match bool) {
+		if err := ca.client.MarkVerified(match); err != nil {
+			dialog.ShowError(err, ca.window)
+			return
+		}
+		if match {
+			ca.addMessage(fmt.Sprintf("*** Safety codes match, %s is verified", peer))
+		} else {
+			ca.addMessage("*** Safety codes differ, this connection may be intercepted")
+		}
+	}, ca.window)
+}
+
 // sendMessage sends a message to the peer
 func (ca *ChatApp) sendMessage(text string) {
 	if ca.client == nil || !ca.client.IsConnected() {
@@ -433,6 +838,41 @@ func (ca *ChatApp) sendMessage(text string) {
 	ca.messageEntry.SetText("")
 }
 
+// notifyTyping sends a typing indicator, at most once per typingSendInterval
+func (ca *ChatApp) notifyTyping() {
+	if ca.client == nil || !ca.client.IsConnected() {
+		return
+	}
+
+	if time.Since(ca.lastTypingSent) < typingSendInterval {
+		return
+	}
+	ca.lastTypingSent = time.Now()
+
+	// Typing indicators are best effort, a failure is not worth a dialog
+	if err := ca.client.SendTyping(); err != nil {
+		log.Printf("Failed to send typing indicator: %v", err)
+	}
+}
+
+// showTyping shows "<name> is typing..." until no indicator arrives for a while
+func (ca *ChatApp) showTyping(from string) {
+	typingText := fmt.Sprintf("%s is typing...", from)
+	ca.statusLabel.SetText(typingText)
+
+	if ca.typingTimer != nil {
+		ca.typingTimer.Stop()
+	}
+	ca.typingTimer = time.AfterFunc(typingDisplayDuration, func() {
+		fyne.Do(func() {
+			// Only clear our own hint, not a newer status
+			if ca.statusLabel.Text == typingText {
+				ca.statusLabel.SetText("Connected! You can now chat.")
+			}
+		})
+	})
+}
+
 // addMessage adds a message to the message list and scrolls to bottom
 func (ca *ChatApp) addMessage(message string) {
 	ca.messages = append(ca.messages, message)
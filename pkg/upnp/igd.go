@@ -0,0 +1,363 @@
+package upnp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ssdpTimeout bounds UPnP discovery when the context has no deadline
+const ssdpTimeout = 3 * time.Second
+
+// Search targets of the Internet Gateway Device versions
+var igdSearchTargets = []string{
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+	"urn:schemas-upnp-org:device:InternetGatewayDevice:2",
+}
+
+// Services that can forward ports
+var igdServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:",
+	"urn:schemas-upnp-org:service:WANPPPConnection:",
+}
+
+// UPnP error codes handled by AddMapping
+const (
+	upnpConflict       = 718
+	upnpPermanentLease = 725
+)
+
+// soapError is an error returned by the gateway's control URL
+type soapError struct {
+	Code        int
+	Description string
+}
+
+func (e *soapError) Error() string {
+	return fmt.Sprintf("upnp error %d: %s", e.Code, e.Description)
+}
+
+// igdGateway forwards ports with UPnP-IGD SOAP calls
+type igdGateway struct {
+	controlURL  string
+	serviceType string
+	localIP     net.IP
+	description string
+	client      *http.Client
+
+	// External port of every internal port mapped so far
+	mu    sync.Mutex
+	ports map[int]int
+}
+
+// igdDevice is a device of a UPnP description, with its embedded devices
+type igdDevice struct {
+	Services []igdService `xml:"serviceList>service"`
+	Devices  []igdDevice  `xml:"deviceList>device"`
+}
+
+type igdService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// findService returns the first port forwarding service of the device tree
+func (d igdDevice) findService() (igdService, bool) {
+	for _, service := range d.Services {
+		for _, serviceType := range igdServiceTypes {
+			if strings.HasPrefix(service.ServiceType, serviceType) {
+				return service, true
+			}
+		}
+	}
+	for _, device := range d.Devices {
+		if service, ok := device.findService(); ok {
+			return service, true
+		}
+	}
+	return igdService{}, false
+}
+
+// discoverIGD sends SSDP searches and returns the first gateway whose
+// description has a port forwarding service
+func discoverIGD(ctx context.Context, options Options) (Gateway, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ssdpTimeout)
+		defer cancel()
+	}
+
+	target, err := net.ResolveUDPAddr("udp4", options.ssdpAddress())
+	if err != nil {
+		return nil, fmt.Errorf("invalid SSDP address: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SSDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	for _, st := range igdSearchTargets {
+		search := "M-SEARCH * HTTP/1.1\r\n" +
+			"HOST: " + DefaultSSDPAddress + "\r\n" +
+			"ST: " + st + "\r\n" +
+			"MAN: \"ssdp:discover\"\r\n" +
+			"MX: 2\r\n\r\n"
+		if _, err := conn.WriteToUDP([]byte(search), target); err != nil {
+			return nil, fmt.Errorf("failed to send SSDP search: %w", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("no UPnP gateway answered: %w", ctx.Err())
+			}
+			return nil, fmt.Errorf("failed to read SSDP response: %w", err)
+		}
+
+		response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		location := response.Header.Get("Location")
+		if location == "" || seen[location] {
+			continue
+		}
+		seen[location] = true
+
+		if gateway, err := newIGDGateway(ctx, location, options); err == nil {
+			return gateway, nil
+		}
+	}
+}
+
+// newIGDGateway reads the device description at location
+func newIGDGateway(ctx context.Context, location string, options Options) (*igdGateway, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch device description: %w", err)
+	}
+	defer response.Body.Close()
+
+	var root struct {
+		URLBase string    `xml:"URLBase"`
+		Device  igdDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(response.Body).Decode(&root); err != nil {
+		return nil, fmt.Errorf("invalid device description: %w", err)
+	}
+
+	service, ok := root.Device.findService()
+	if !ok {
+		return nil, fmt.Errorf("%s has no port forwarding service", location)
+	}
+
+	base := location
+	if root.URLBase != "" {
+		base = root.URLBase
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device URL: %w", err)
+	}
+	controlURL, err := baseURL.Parse(service.ControlURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control URL: %w", err)
+	}
+
+	addrs, err := net.DefaultResolver.LookupIP(ctx, "ip4", controlURL.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return nil, fmt.Errorf("failed to resolve gateway %s: %w", controlURL.Hostname(), err)
+	}
+	localIP, err := localIPFor(addrs[0])
+	if err != nil {
+		return nil, fmt.Errorf("no route to gateway: %w", err)
+	}
+
+	return &igdGateway{
+		controlURL:  controlURL.String(),
+		serviceType: service.ServiceType,
+		localIP:     localIP,
+		description: options.description(),
+		client:      client,
+		ports:       make(map[int]int),
+	}, nil
+}
+
+func (g *igdGateway) Method() Method {
+	return MethodUPnP
+}
+
+func (g *igdGateway) AddMapping(ctx context.Context, internalPort int, lifetime time.Duration) (Mapping, error) {
+	g.mu.Lock()
+	externalPort, renewing := g.ports[internalPort]
+	g.mu.Unlock()
+	if !renewing {
+		externalPort = internalPort
+	}
+
+	lease := int(lifetime / time.Second)
+	for attempt := 0; ; attempt++ {
+		_, err := g.call(ctx, "AddPortMapping",
+			"NewRemoteHost", "",
+			"NewExternalPort", strconv.Itoa(externalPort),
+			"NewProtocol", "UDP",
+			"NewInternalPort", strconv.Itoa(internalPort),
+			"NewInternalClient", g.localIP.String(),
+			"NewEnabled", "1",
+			"NewPortMappingDescription", g.description,
+			"NewLeaseDuration", strconv.Itoa(lease),
+		)
+
+		var upnpErr *soapError
+		switch {
+		case err == nil:
+		case errors.As(err, &upnpErr) && upnpErr.Code == upnpPermanentLease && lease != 0:
+			// Some gateways only do permanent mappings; Lease removes it
+			lease = 0
+			continue
+		case errors.As(err, &upnpErr) && upnpErr.Code == upnpConflict && attempt < 5:
+			externalPort = 1024 + rand.IntN(65535-1024)
+			continue
+		default:
+			return Mapping{}, fmt.Errorf("failed to add port mapping: %w", err)
+		}
+		break
+	}
+
+	values, err := g.call(ctx, "GetExternalIPAddress")
+	if err != nil {
+		return Mapping{}, fmt.Errorf("failed to get external address: %w", err)
+	}
+	externalIP := net.ParseIP(values["NewExternalIPAddress"])
+	if externalIP == nil {
+		return Mapping{}, fmt.Errorf("gateway has no external address")
+	}
+
+	g.mu.Lock()
+	g.ports[internalPort] = externalPort
+	g.mu.Unlock()
+
+	return Mapping{
+		Method:       MethodUPnP,
+		InternalPort: internalPort,
+		ExternalIP:   externalIP,
+		ExternalPort: externalPort,
+		Lifetime:     time.Duration(lease) * time.Second,
+	}, nil
+}
+
+func (g *igdGateway) DeleteMapping(ctx context.Context, mapping Mapping) error {
+	g.mu.Lock()
+	delete(g.ports, mapping.InternalPort)
+	g.mu.Unlock()
+
+	_, err := g.call(ctx, "DeletePortMapping",
+		"NewRemoteHost", "",
+		"NewExternalPort", strconv.Itoa(mapping.ExternalPort),
+		"NewProtocol", "UDP",
+	)
+	if err != nil {
+		return fmt.Errorf("failed to delete port mapping: %w", err)
+	}
+	return nil
+}
+
+// call invokes a SOAP action with name/value argument pairs and returns
+// the values of the response
+func (g *igdGateway) call(ctx context.Context, action string, args ...string) (map[string]string, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + g.serviceType + `">`)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&body, "<%s>%s</%s>", args[i], html.EscapeString(args[i+1]), args[i])
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, g.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	request.Header.Set("SOAPAction", `"`+g.serviceType+"#"+action+`"`)
+
+	response, err := g.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	values, err := soapValues(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s response: %w", action, err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		code, _ := strconv.Atoi(values["errorCode"])
+		if code == 0 {
+			return nil, fmt.Errorf("%s failed: %s", action, response.Status)
+		}
+		return nil, &soapError{Code: code, Description: values["errorDescription"]}
+	}
+	return values, nil
+}
+
+// soapValues collects the text of every leaf element of a SOAP envelope
+// by local name
+func soapValues(r io.Reader) (map[string]string, error) {
+	values := make(map[string]string)
+	decoder := xml.NewDecoder(r)
+
+	var name string
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name = t.Name.Local
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if t.Name.Local == name {
+				values[name] = strings.TrimSpace(text.String())
+			}
+			name = ""
+		}
+	}
+}
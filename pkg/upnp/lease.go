@@ -0,0 +1,180 @@
+package upnp
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// requestTimeout bounds each renewal and the removal of a mapping
+	requestTimeout = 5 * time.Second
+
+	// renewRetry is the wait after a failed renewal, doubled on each failure
+	renewRetry = 5 * time.Second
+)
+
+// Lease keeps a mapping alive, renewing it at half its lifetime, until
+// Close removes it
+type Lease struct {
+	gateway  Gateway
+	lifetime time.Duration
+
+	mu      sync.Mutex
+	mapping Mapping
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Map forwards an external UDP port of the gateway to internalPort and
+// keeps the mapping alive until the lease is closed
+func Map(ctx context.Context, gateway Gateway, internalPort int, lifetime time.Duration) (*Lease, error) {
+	mapping, err := gateway.AddMapping(ctx, internalPort, lifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	lease := &Lease{
+		gateway:  gateway,
+		lifetime: lifetime,
+		mapping:  mapping,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go lease.renew()
+	return lease, nil
+}
+
+// Mapping returns the current mapping
+func (l *Lease) Mapping() Mapping {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.mapping
+}
+
+// renew refreshes the mapping at half of the lifetime the gateway granted.
+// Failed renewals are retried with a backoff that stays within what is left
+// of the lifetime. Permanent mappings (lifetime zero) are left alone
+func (l *Lease) renew() {
+	defer close(l.done)
+
+	granted := l.Mapping().Lifetime
+	if granted <= 0 {
+		<-l.stop
+		return
+	}
+	expires := time.Now().Add(granted)
+	wait := granted / 2
+	backoff := renewRetry
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-time.After(wait):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		mapping, err := l.gateway.AddMapping(ctx, l.Mapping().InternalPort, l.lifetime)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to renew port mapping: %v", err)
+			wait = retryWait(backoff, granted, time.Until(expires))
+			backoff = min(2*backoff, granted)
+			continue
+		}
+
+		l.mu.Lock()
+		if mapping.ExternalPort != l.mapping.ExternalPort || !mapping.ExternalIP.Equal(l.mapping.ExternalIP) {
+			log.Printf("Port mapping moved to %s", mapping.External())
+		}
+		l.mapping = mapping
+		l.mu.Unlock()
+
+		if mapping.Lifetime <= 0 {
+			<-l.stop
+			return
+		}
+		granted = mapping.Lifetime
+		expires = time.Now().Add(granted)
+		wait = granted / 2
+		backoff = renewRetry
+	}
+}
+
+// retryWait is how long to wait after a failed renewal: the backoff, but
+// at most half of the remaining lifetime so several retries fit in before
+// the mapping expires. Once it has expired retries carry on at the backoff,
+// up to half the lifetime
+func retryWait(backoff, granted, remaining time.Duration) time.Duration {
+	wait := min(backoff, granted/2)
+	if remaining > 0 {
+		wait = min(wait, max(remaining/2, granted/32))
+	}
+	return wait
+}
+
+// Close stops renewing and removes the mapping from the gateway
+func (l *Lease) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		err = l.gateway.DeleteMapping(ctx, l.Mapping())
+	})
+	return err
+}
+
+// Mapper discovers the gateway once and maps ports on it. It implements
+// webrtc.PortMapper
+type Mapper struct {
+	options Options
+
+	mu      sync.Mutex
+	gateway Gateway
+}
+
+// NewMapper creates a mapper. Nothing is sent before the first MapUDP
+func NewMapper(options Options) *Mapper {
+	return &Mapper{options: options}
+}
+
+// Gateway returns the discovered gateway, looking for it on first use
+func (m *Mapper) Gateway(ctx context.Context) (Gateway, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.gateway == nil {
+		gateway, err := Discover(ctx, m.options)
+		if err != nil {
+			return nil, err
+		}
+		m.gateway = gateway
+	}
+	return m.gateway, nil
+}
+
+// MapUDP forwards an external port to the local UDP port until release is
+// called
+func (m *Mapper) MapUDP(ctx context.Context, port int) (*net.UDPAddr, func() error, error) {
+	gateway, err := m.Gateway(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lease, err := Map(ctx, gateway, port, m.options.lifetime())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mapping := lease.Mapping()
+	log.Printf("Mapped %s port %d to %s", mapping.Method, port, mapping.External())
+	return mapping.External(), lease.Close, nil
+}
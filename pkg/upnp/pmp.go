@@ -0,0 +1,302 @@
+package upnp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// PCP (RFC 6887) and NAT-PMP (RFC 6886) share the gateway's port 5351. A
+// gateway that only knows NAT-PMP answers a PCP request with its own
+// version number, so PCP is tried first
+
+const (
+	pcpVersion    = 2
+	natpmpVersion = 0
+
+	pcpOpAnnounce = 0
+	pcpOpMap      = 1
+
+	natpmpOpExternalAddress = 0
+	natpmpOpMapUDP          = 1
+
+	// Result code of both protocols for a version the gateway does not speak
+	resultUnsupportedVersion = 1
+
+	protocolUDP = 17
+)
+
+// Retransmission of PCP/NAT-PMP requests: the first waits this long, each
+// retry twice as long as the one before
+const (
+	pmpInitialTimeout = 250 * time.Millisecond
+	pmpAttempts       = 4
+)
+
+// errUnsupportedVersion is returned when the gateway speaks the other protocol
+var errUnsupportedVersion = errors.New("unsupported version")
+
+// pmpGateway forwards ports with PCP or NAT-PMP
+type pmpGateway struct {
+	addr    *net.UDPAddr
+	localIP net.IP
+	pcp     bool
+
+	// PCP identifies a mapping by the nonce it was created with
+	mu     sync.Mutex
+	nonces map[int][12]byte
+	ports  map[int]int
+}
+
+// discoverPMP asks the gateway whether it speaks PCP, then NAT-PMP
+func discoverPMP(ctx context.Context, options Options) (Gateway, error) {
+	gatewayIP := options.Gateway
+	if gatewayIP == nil {
+		var err error
+		if gatewayIP, err = defaultGateway(); err != nil {
+			return nil, err
+		}
+	}
+
+	localIP, err := localIPFor(gatewayIP)
+	if err != nil {
+		return nil, fmt.Errorf("no route to gateway: %w", err)
+	}
+
+	gateway := &pmpGateway{
+		addr:    &net.UDPAddr{IP: gatewayIP, Port: options.pmpPort()},
+		localIP: localIP,
+		nonces:  make(map[int][12]byte),
+		ports:   make(map[int]int),
+	}
+
+	_, err = gateway.exchange(ctx, gateway.pcpRequest(pcpOpAnnounce, 0, nil))
+	if err == nil {
+		gateway.pcp = true
+		return gateway, nil
+	}
+	if !errors.Is(err, errUnsupportedVersion) {
+		return nil, fmt.Errorf("no PCP gateway answered: %w", err)
+	}
+
+	if _, err := gateway.externalAddress(ctx); err != nil {
+		return nil, fmt.Errorf("no NAT-PMP gateway answered: %w", err)
+	}
+	return gateway, nil
+}
+
+func (g *pmpGateway) Method() Method {
+	if g.pcp {
+		return MethodPCP
+	}
+	return MethodNATPMP
+}
+
+func (g *pmpGateway) AddMapping(ctx context.Context, internalPort int, lifetime time.Duration) (Mapping, error) {
+	g.mu.Lock()
+	nonce, renewing := g.nonces[internalPort]
+	suggested, ok := g.ports[internalPort]
+	g.mu.Unlock()
+
+	if !renewing {
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return Mapping{}, err
+		}
+	}
+	if !ok {
+		suggested = internalPort
+	}
+
+	var mapping Mapping
+	var err error
+	if g.pcp {
+		mapping, err = g.mapPCP(ctx, nonce, internalPort, suggested, lifetime)
+	} else {
+		mapping, err = g.mapNATPMP(ctx, internalPort, suggested, lifetime)
+	}
+	if err != nil {
+		return Mapping{}, fmt.Errorf("failed to add port mapping: %w", err)
+	}
+
+	g.mu.Lock()
+	g.nonces[internalPort] = nonce
+	g.ports[internalPort] = mapping.ExternalPort
+	g.mu.Unlock()
+
+	return mapping, nil
+}
+
+func (g *pmpGateway) DeleteMapping(ctx context.Context, mapping Mapping) error {
+	g.mu.Lock()
+	nonce := g.nonces[mapping.InternalPort]
+	delete(g.nonces, mapping.InternalPort)
+	delete(g.ports, mapping.InternalPort)
+	g.mu.Unlock()
+
+	var err error
+	if g.pcp {
+		_, err = g.mapPCP(ctx, nonce, mapping.InternalPort, 0, 0)
+	} else {
+		_, err = g.mapNATPMP(ctx, mapping.InternalPort, 0, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete port mapping: %w", err)
+	}
+	return nil
+}
+
+// mapPCP sends a PCP MAP request. A zero lifetime deletes the mapping
+func (g *pmpGateway) mapPCP(ctx context.Context, nonce [12]byte, internalPort, suggested int, lifetime time.Duration) (Mapping, error) {
+	payload := make([]byte, 36)
+	copy(payload[0:12], nonce[:])
+	payload[12] = protocolUDP
+	binary.BigEndian.PutUint16(payload[16:18], uint16(internalPort))
+	binary.BigEndian.PutUint16(payload[18:20], uint16(suggested))
+	copy(payload[20:36], net.IPv4zero.To16())
+
+	response, err := g.exchange(ctx, g.pcpRequest(pcpOpMap, lifetime, payload))
+	if err != nil {
+		return Mapping{}, err
+	}
+	if len(response) < 60 || [12]byte(response[24:36]) != nonce {
+		return Mapping{}, fmt.Errorf("invalid PCP MAP response")
+	}
+
+	return Mapping{
+		Method:       MethodPCP,
+		InternalPort: int(binary.BigEndian.Uint16(response[40:42])),
+		ExternalIP:   net.IP(append([]byte(nil), response[44:60]...)),
+		ExternalPort: int(binary.BigEndian.Uint16(response[42:44])),
+		Lifetime:     time.Duration(binary.BigEndian.Uint32(response[4:8])) * time.Second,
+	}, nil
+}
+
+// mapNATPMP sends a NAT-PMP UDP mapping request. A zero lifetime deletes
+// the mapping
+func (g *pmpGateway) mapNATPMP(ctx context.Context, internalPort, suggested int, lifetime time.Duration) (Mapping, error) {
+	request := make([]byte, 12)
+	request[0] = natpmpVersion
+	request[1] = natpmpOpMapUDP
+	binary.BigEndian.PutUint16(request[4:6], uint16(internalPort))
+	binary.BigEndian.PutUint16(request[6:8], uint16(suggested))
+	binary.BigEndian.PutUint32(request[8:12], uint32(lifetime/time.Second))
+
+	response, err := g.exchange(ctx, request)
+	if err != nil {
+		return Mapping{}, err
+	}
+	if len(response) < 16 {
+		return Mapping{}, fmt.Errorf("invalid NAT-PMP mapping response")
+	}
+
+	mapping := Mapping{
+		Method:       MethodNATPMP,
+		InternalPort: int(binary.BigEndian.Uint16(response[8:10])),
+		ExternalPort: int(binary.BigEndian.Uint16(response[10:12])),
+		Lifetime:     time.Duration(binary.BigEndian.Uint32(response[12:16])) * time.Second,
+	}
+	if lifetime == 0 {
+		return mapping, nil
+	}
+
+	if mapping.ExternalIP, err = g.externalAddress(ctx); err != nil {
+		return Mapping{}, err
+	}
+	return mapping, nil
+}
+
+// externalAddress asks a NAT-PMP gateway for its public address
+func (g *pmpGateway) externalAddress(ctx context.Context) (net.IP, error) {
+	response, err := g.exchange(ctx, []byte{natpmpVersion, natpmpOpExternalAddress})
+	if err != nil {
+		return nil, err
+	}
+	if len(response) < 12 {
+		return nil, fmt.Errorf("invalid NAT-PMP address response")
+	}
+	return net.IPv4(response[8], response[9], response[10], response[11]), nil
+}
+
+// pcpRequest builds a PCP request header followed by the opcode payload
+func (g *pmpGateway) pcpRequest(opcode byte, lifetime time.Duration, payload []byte) []byte {
+	request := make([]byte, 24, 24+len(payload))
+	request[0] = pcpVersion
+	request[1] = opcode
+	binary.BigEndian.PutUint32(request[4:8], uint32(lifetime/time.Second))
+	copy(request[8:24], g.localIP.To16())
+	return append(request, payload...)
+}
+
+// exchange sends a request, retransmitting it until the gateway answers,
+// and checks the response's version, opcode and result code
+func (g *pmpGateway) exchange(ctx context.Context, request []byte) ([]byte, error) {
+	conn, err := net.DialUDP("udp4", nil, g.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, 1100)
+	timeout := pmpInitialTimeout
+	for attempt := 0; attempt < pmpAttempts; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(timeout)
+		timeout *= 2
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		conn.SetReadDeadline(deadline)
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				// ICMP port unreachable: nothing listens on the gateway
+				return nil, err
+			}
+
+			response := buf[:n]
+			// Skip short packets and answers to an earlier request
+			if n < 4 || (response[0] == request[0] && response[1] != request[1]|0x80) {
+				continue
+			}
+			return checkResult(request[0], response)
+		}
+	}
+	return nil, fmt.Errorf("gateway %s did not answer", g.addr)
+}
+
+// checkResult turns the result code of a response into an error
+func checkResult(version byte, response []byte) ([]byte, error) {
+	var result int
+	if response[0] == pcpVersion {
+		result = int(response[3])
+	} else {
+		result = int(binary.BigEndian.Uint16(response[2:4]))
+	}
+
+	switch {
+	case response[0] != version || result == resultUnsupportedVersion:
+		return nil, errUnsupportedVersion
+	case result != 0:
+		return nil, fmt.Errorf("gateway refused the request with result code %d", result)
+	}
+	return append([]byte(nil), response...), nil
+}
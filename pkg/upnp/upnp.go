@@ -0,0 +1,186 @@
+// Package upnp asks the user's router to forward a UDP port, so a peer
+// behind it can be reached directly instead of through a TURN relay. It
+// speaks UPnP-IGD, PCP and NAT-PMP, whichever the gateway answers first.
+package upnp
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Defaults used when Options leaves a field at zero
+const (
+	DefaultSSDPAddress = "239.255.255.250:1900"
+	DefaultPMPPort     = 5351
+	DefaultLifetime    = time.Hour
+	DefaultDescription = "p2p-chat"
+)
+
+// ErrNoGateway is returned when no gateway answered any of the protocols
+var ErrNoGateway = errors.New("no UPnP, PCP or NAT-PMP gateway found")
+
+// Method is the protocol a mapping was made with
+type Method string
+
+const (
+	MethodUPnP   Method = "upnp"
+	MethodPCP    Method = "pcp"
+	MethodNATPMP Method = "natpmp"
+)
+
+// Mapping is a UDP port forwarded by the gateway
+type Mapping struct {
+	Method       Method
+	InternalPort int
+	ExternalIP   net.IP
+	ExternalPort int
+
+	// Lifetime is how long the gateway keeps the mapping without renewal
+	Lifetime time.Duration
+}
+
+// External returns the address peers should send to
+func (m Mapping) External() *net.UDPAddr {
+	return &net.UDPAddr{IP: m.ExternalIP, Port: m.ExternalPort}
+}
+
+// Gateway is a router that forwards ports
+type Gateway interface {
+	// Method reports the protocol the gateway speaks
+	Method() Method
+
+	// AddMapping forwards an external UDP port to internalPort on this
+	// host. It also renews an existing mapping of the same port
+	AddMapping(ctx context.Context, internalPort int, lifetime time.Duration) (Mapping, error)
+
+	// DeleteMapping removes a mapping made by AddMapping
+	DeleteMapping(ctx context.Context, mapping Mapping) error
+}
+
+// Options configures discovery and mappings
+type Options struct {
+	// Gateway is the address PCP and NAT-PMP requests go to. Nil means
+	// the default route's gateway
+	Gateway net.IP
+
+	// PMPPort is the PCP/NAT-PMP port of the gateway. Zero means 5351
+	PMPPort int
+
+	// SSDPAddress is where UPnP discovery is sent. Empty means the SSDP
+	// multicast group
+	SSDPAddress string
+
+	// Lifetime of each mapping, renewed at half-time. Zero means an hour
+	Lifetime time.Duration
+
+	// Description is shown in the router's port forwarding list
+	Description string
+}
+
+func (o Options) pmpPort() int {
+	if o.PMPPort == 0 {
+		return DefaultPMPPort
+	}
+	return o.PMPPort
+}
+
+func (o Options) ssdpAddress() string {
+	if o.SSDPAddress == "" {
+		return DefaultSSDPAddress
+	}
+	return o.SSDPAddress
+}
+
+func (o Options) lifetime() time.Duration {
+	if o.Lifetime <= 0 {
+		return DefaultLifetime
+	}
+	return o.Lifetime
+}
+
+func (o Options) description() string {
+	if o.Description == "" {
+		return DefaultDescription
+	}
+	return o.Description
+}
+
+// Discover looks for a gateway with UPnP-IGD and PCP/NAT-PMP at the same
+// time and returns the first that answers
+func Discover(ctx context.Context, options Options) (Gateway, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		gateway Gateway
+		err     error
+	}
+	results := make(chan result, 2)
+
+	go func() {
+		gateway, err := discoverIGD(ctx, options)
+		results <- result{gateway, err}
+	}()
+	go func() {
+		gateway, err := discoverPMP(ctx, options)
+		results <- result{gateway, err}
+	}()
+
+	var errs []error
+	for range 2 {
+		r := <-results
+		if r.err == nil {
+			return r.gateway, nil
+		}
+		errs = append(errs, r.err)
+	}
+	return nil, fmt.Errorf("%w: %w", ErrNoGateway, errors.Join(errs...))
+}
+
+// defaultGateway returns the IPv4 gateway of the default route. It reads
+// /proc/net/route where there is one and otherwise guesses the ".1" address
+// of the local network
+func defaultGateway() (net.IP, error) {
+	if file, err := os.Open("/proc/net/route"); err == nil {
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// Iface Destination Gateway Flags ...
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 3 || fields[1] != "00000000" {
+				continue
+			}
+			raw, err := hex.DecodeString(fields[2])
+			if err != nil || len(raw) != 4 {
+				continue
+			}
+			// Little endian
+			return net.IPv4(raw[3], raw[2], raw[1], raw[0]), nil
+		}
+	}
+
+	local, err := localIPFor(net.IPv4(8, 8, 8, 8))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the default gateway: %w", err)
+	}
+	ip := local.To4()
+	return net.IPv4(ip[0], ip[1], ip[2], 1), nil
+}
+
+// localIPFor returns the local address used to reach remote. Nothing is sent
+func localIPFor(remote net.IP) (net.IP, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: remote, Port: 9})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,219 @@
+package upnp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Jouini-Mohamed-Chaker/p2p-chat/pkg/testutil"
+)
+
+// startGateway runs a fake gateway and returns options that reach it
+func startGateway(t *testing.T, gatewayOptions testutil.GatewayOptions) (*testutil.FakeGateway, Options) {
+	t.Helper()
+
+	gateway, err := testutil.NewFakeGateway(gatewayOptions)
+	require.NoError(t, err)
+	t.Cleanup(func() { gateway.Close() })
+
+	return gateway, Options{
+		Gateway:     net.IPv4(127, 0, 0, 1),
+		PMPPort:     gateway.PMPPort(),
+		SSDPAddress: gateway.SSDPAddress(),
+	}
+}
+
+// discover finds the fake gateway
+func discover(t *testing.T, options Options) Gateway {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	gateway, err := Discover(ctx, options)
+	require.NoError(t, err)
+	return gateway
+}
+
+var methods = map[Method]testutil.GatewayOptions{
+	MethodUPnP:   {UPnP: true},
+	MethodPCP:    {PCP: true},
+	MethodNATPMP: {NATPMP: true},
+}
+
+func TestDiscover(t *testing.T) {
+	for method, gatewayOptions := range methods {
+		t.Run(string(method), func(t *testing.T) {
+			_, options := startGateway(t, gatewayOptions)
+			assert.Equal(t, method, discover(t, options).Method())
+		})
+	}
+
+	t.Run("none", func(t *testing.T) {
+		_, options := startGateway(t, testutil.GatewayOptions{})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		_, err := Discover(ctx, options)
+		assert.ErrorIs(t, err, ErrNoGateway)
+	})
+}
+
+func TestMap(t *testing.T) {
+	for method, gatewayOptions := range methods {
+		t.Run(string(method), func(t *testing.T) {
+			fake, options := startGateway(t, gatewayOptions)
+
+			lease, err := Map(context.Background(), discover(t, options), 40000, time.Hour)
+			require.NoError(t, err)
+
+			mapping := lease.Mapping()
+			assert.Equal(t, method, mapping.Method)
+			assert.Equal(t, 40000, mapping.InternalPort)
+			assert.Equal(t, 40000, mapping.ExternalPort)
+			assert.True(t, fake.ExternalIP().Equal(mapping.ExternalIP), mapping.ExternalIP)
+			assert.Equal(t, time.Hour, mapping.Lifetime)
+
+			mappings := fake.Mappings()
+			require.Len(t, mappings, 1)
+			assert.Equal(t, string(method), mappings[0].Method)
+			assert.Equal(t, "127.0.0.1", mappings[0].InternalClient)
+			assert.Equal(t, 40000, mappings[0].InternalPort)
+
+			require.NoError(t, lease.Close())
+			assert.Empty(t, fake.Mappings())
+			assert.NoError(t, lease.Close(), "closing twice")
+		})
+	}
+}
+
+func TestMap_PortTaken(t *testing.T) {
+	for method, gatewayOptions := range methods {
+		t.Run(string(method), func(t *testing.T) {
+			gatewayOptions.TakenPorts = []int{40000}
+			fake, options := startGateway(t, gatewayOptions)
+
+			lease, err := Map(context.Background(), discover(t, options), 40000, time.Hour)
+			require.NoError(t, err)
+			defer lease.Close()
+
+			mapping := lease.Mapping()
+			assert.NotEqual(t, 40000, mapping.ExternalPort)
+			require.Len(t, fake.Mappings(), 1)
+			assert.Equal(t, mapping.ExternalPort, fake.Mappings()[0].ExternalPort)
+		})
+	}
+}
+
+func TestMap_PermanentOnly(t *testing.T) {
+	fake, options := startGateway(t, testutil.GatewayOptions{UPnP: true, PermanentOnly: true})
+
+	lease, err := Map(context.Background(), discover(t, options), 40000, time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, lease.Mapping().Lifetime)
+
+	require.NoError(t, lease.Close())
+	assert.Empty(t, fake.Mappings())
+}
+
+func TestLease_Renew(t *testing.T) {
+	for method, gatewayOptions := range methods {
+		t.Run(string(method), func(t *testing.T) {
+			fake, options := startGateway(t, gatewayOptions)
+
+			lease, err := Map(context.Background(), discover(t, options), 40000, 2*time.Second)
+			require.NoError(t, err)
+			defer lease.Close()
+
+			// Renewed at half of the lifetime, on the same external port
+			require.Eventually(t, func() bool {
+				mappings := fake.Mappings()
+				return len(mappings) == 1 && mappings[0].Requests >= 2
+			}, 3*time.Second, 50*time.Millisecond)
+			assert.Equal(t, 40000, lease.Mapping().ExternalPort)
+		})
+	}
+}
+
+// flakyGateway fails the next renewals it is told to
+type flakyGateway struct {
+	Gateway
+
+	mu       sync.Mutex
+	failures int
+	renewed  time.Time
+}
+
+func (g *flakyGateway) AddMapping(ctx context.Context, internalPort int, lifetime time.Duration) (Mapping, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.failures > 0 {
+		g.failures--
+		return Mapping{}, errors.New("gateway busy")
+	}
+	g.renewed = time.Now()
+	return g.Gateway.AddMapping(ctx, internalPort, lifetime)
+}
+
+func TestLease_RenewRetry(t *testing.T) {
+	_, options := startGateway(t, testutil.GatewayOptions{UPnP: true})
+	gateway := &flakyGateway{Gateway: discover(t, options)}
+
+	start := time.Now()
+	lease, err := Map(context.Background(), gateway, 40000, 2*time.Second)
+	require.NoError(t, err)
+	defer lease.Close()
+
+	// Two failed renewals still leave time to renew before the mapping expires
+	gateway.mu.Lock()
+	gateway.failures = 2
+	gateway.renewed = time.Time{}
+	gateway.mu.Unlock()
+
+	require.Eventually(t, func() bool {
+		gateway.mu.Lock()
+		defer gateway.mu.Unlock()
+		return !gateway.renewed.IsZero()
+	}, 3*time.Second, 20*time.Millisecond)
+
+	gateway.mu.Lock()
+	defer gateway.mu.Unlock()
+	assert.Less(t, gateway.renewed.Sub(start), 2*time.Second)
+}
+
+func TestRetryWait(t *testing.T) {
+	// An hour-long mapping is retried within seconds, not at the next half-life
+	assert.Equal(t, 5*time.Second, retryWait(renewRetry, time.Hour, 30*time.Minute))
+	assert.Equal(t, 10*time.Minute, retryWait(80*time.Minute, time.Hour, 20*time.Minute))
+	assert.Equal(t, time.Hour/32, retryWait(time.Hour, time.Hour, time.Minute))
+
+	// Expired mappings are retried at the backoff
+	assert.Equal(t, 20*time.Second, retryWait(20*time.Second, time.Hour, -time.Minute))
+	assert.Equal(t, 30*time.Minute, retryWait(time.Hour, time.Hour, -time.Minute))
+}
+
+func TestMapper(t *testing.T) {
+	fake, options := startGateway(t, testutil.GatewayOptions{NATPMP: true})
+	mapper := NewMapper(options)
+
+	external, release, err := mapper.MapUDP(context.Background(), 40000)
+	require.NoError(t, err)
+	assert.Equal(t, "203.0.113.1:40000", external.String())
+
+	// The gateway is only discovered once
+	second, releaseSecond, err := mapper.MapUDP(context.Background(), 40001)
+	require.NoError(t, err)
+	assert.Equal(t, 40001, second.Port)
+	assert.Len(t, fake.Mappings(), 2)
+
+	require.NoError(t, release())
+	require.NoError(t, releaseSecond())
+	assert.Empty(t, fake.Mappings())
+}
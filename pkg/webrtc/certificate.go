@@ -0,0 +1,122 @@
+package webrtc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// CertificateFingerprint formats the SHA-256 fingerprint of a certificate
+// the way it appears in the SDP: "sha-256 AB:CD:..."
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return "sha-256 " + strings.Join(hex, ":")
+}
+
+// Fingerprint returns the DTLS fingerprint ("sha-256 AB:CD:...") announced
+// in a session description, either JSON-wrapped or raw SDP. It returns ""
+// if there is none
+func Fingerprint(sdp string) string {
+	var desc struct {
+		SDP string `json:"sdp"`
+	}
+	if json.Unmarshal([]byte(sdp), &desc) == nil && desc.SDP != "" {
+		sdp = desc.SDP
+	}
+
+	for _, line := range strings.Split(sdp, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "a=fingerprint:"); ok {
+			algorithm, digest, found := strings.Cut(value, " ")
+			if !found {
+				return ""
+			}
+			return strings.ToLower(algorithm) + " " + strings.ToUpper(digest)
+		}
+	}
+	return ""
+}
+
+// Returns the fingerprint of the certificate this peer presents
+func (p *RealPeer) LocalFingerprint() string {
+	return p.fingerprint
+}
+
+// Returns the fingerprint the remote peer announced, or "" before the
+// remote description is set
+func (p *RealPeer) RemoteFingerprint() string {
+	remote := p.conn().RemoteDescription()
+	if remote == nil {
+		return ""
+	}
+	return Fingerprint(remote.SDP)
+}
+
+// peerCertificate returns the certificate every connection of a peer uses:
+// the configured one, or a freshly generated one so that ICE restart
+// standbys keep the fingerprint of the original connection
+func peerCertificate(config PeerConfig) (webrtc.Certificate, string, error) {
+	if config.Certificate != nil {
+		leaf, err := leafCertificate(config.Certificate)
+		if err != nil {
+			return webrtc.Certificate{}, "", err
+		}
+		return webrtc.CertificateFromX509(config.Certificate.PrivateKey, leaf), CertificateFingerprint(leaf), nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return webrtc.Certificate{}, "", fmt.Errorf("failed to generate certificate key: %w", err)
+	}
+
+	certificate, err := webrtc.GenerateCertificate(key)
+	if err != nil {
+		return webrtc.Certificate{}, "", fmt.Errorf("failed to generate certificate: %w", err)
+	}
+
+	fingerprints, err := certificate.GetFingerprints()
+	if err != nil || len(fingerprints) == 0 {
+		return webrtc.Certificate{}, "", fmt.Errorf("failed to fingerprint certificate: %w", err)
+	}
+
+	return *certificate, fingerprints[0].Algorithm + " " + strings.ToUpper(fingerprints[0].Value), nil
+}
+
+// leafCertificate parses and checks the certificate of a tls.Certificate
+func leafCertificate(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.PrivateKey == nil {
+		return nil, fmt.Errorf("certificate has no private key")
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return nil, fmt.Errorf("certificate is empty")
+		}
+
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		leaf = parsed
+	}
+
+	if time.Now().After(leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expired on %s", leaf.NotAfter.Format(time.DateOnly))
+	}
+
+	return leaf, nil
+}
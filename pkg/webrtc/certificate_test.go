@@ -0,0 +1,113 @@
+package webrtc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate creates a self-signed certificate valid until notAfter
+func testCertificate(t *testing.T, notAfter time.Time) *tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestFingerprint(t *testing.T) {
+	raw := "v=0\r\na=fingerprint:SHA-256 ab:cd:ef\r\na=setup:actpass\r\n"
+	assert.Equal(t, "sha-256 AB:CD:EF", Fingerprint(raw))
+	assert.Equal(t, "sha-256 AB:CD:EF", Fingerprint(`{"type":"offer","sdp":"v=0\r\na=fingerprint:sha-256 AB:CD:EF\r\n"}`))
+	assert.Equal(t, "", Fingerprint("v=0\r\n"))
+}
+
+func TestRealPeer_Fingerprints(t *testing.T) {
+	offerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	assert.Regexp(t, `^sha-256 ([0-9A-F]{2}:){31}[0-9A-F]{2}$`, offerer.LocalFingerprint())
+	assert.NotEqual(t, offerer.LocalFingerprint(), answerer.LocalFingerprint())
+	assert.Equal(t, "", offerer.RemoteFingerprint())
+
+	offer, err := offerer.CreateOffer()
+	require.NoError(t, err)
+	assert.Equal(t, offerer.LocalFingerprint(), Fingerprint(offer))
+
+	answer, err := answerer.CreateAnswer(offer)
+	require.NoError(t, err)
+	require.NoError(t, offerer.SetRemoteAnswer(answer))
+
+	assert.Equal(t, offerer.LocalFingerprint(), answerer.RemoteFingerprint())
+	assert.Equal(t, answerer.LocalFingerprint(), offerer.RemoteFingerprint())
+}
+
+func TestRealPeer_ConfiguredCertificate(t *testing.T) {
+	cert := testCertificate(t, time.Now().AddDate(1, 0, 0))
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	config := DefaultPeerConfig()
+	config.Certificate = cert
+
+	// The same certificate gives the same fingerprint on every run
+	first, err := NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer first.Close()
+
+	second, err := NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer second.Close()
+
+	assert.Equal(t, CertificateFingerprint(leaf), first.LocalFingerprint())
+	assert.Equal(t, first.LocalFingerprint(), second.LocalFingerprint())
+
+	other, err := NewRealPeer()
+	require.NoError(t, err)
+	defer other.Close()
+
+	connectPeers(t, first, other)
+
+	// ICE restarts keep the certificate
+	restart, err := first.RestartICE(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, first.LocalFingerprint(), Fingerprint(restart))
+}
+
+func TestPeerConfig_ValidateCertificate(t *testing.T) {
+	config := DefaultPeerConfig()
+
+	config.Certificate = testCertificate(t, time.Now().Add(-time.Minute))
+	assert.ErrorContains(t, config.Validate(), "expired")
+
+	config.Certificate = &tls.Certificate{Certificate: testCertificate(t, time.Now().Add(time.Hour)).Certificate}
+	assert.ErrorContains(t, config.Validate(), "private key")
+
+	config.Certificate = testCertificate(t, time.Now().Add(time.Hour))
+	assert.NoError(t, config.Validate())
+}
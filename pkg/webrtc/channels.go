@@ -0,0 +1,171 @@
+package webrtc
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// DefaultChannel is the label of the channel used by Send and OnMessage
+const DefaultChannel = "chat"
+
+// ChannelOptions configures a named data channel
+type ChannelOptions struct {
+	// Ordered delivers messages in the order they were sent
+	Ordered bool
+
+	// MaxRetransmits limits how often a lost message is resent.
+	// nil means fully reliable delivery
+	MaxRetransmits *uint16
+
+	// MaxPacketLifeTime (milliseconds) limits how long a message is resent
+	// for. Cannot be combined with MaxRetransmits
+	MaxPacketLifeTime *uint16
+}
+
+// ReliableChannel returns options for an ordered, fully reliable channel,
+// which is what the "chat" channel uses
+func ReliableChannel() ChannelOptions {
+	return ChannelOptions{Ordered: true}
+}
+
+// EphemeralChannel returns options for latest-value-wins data such as typing
+// indicators: unordered and never retransmitted, so a lost or late update
+// never holds up the next one
+func EphemeralChannel() ChannelOptions {
+	noRetransmits := uint16(0)
+	return ChannelOptions{
+		Ordered:        false,
+		MaxRetransmits: &noRetransmits,
+	}
+}
+
+// Reliable reports whether every message is guaranteed to arrive
+func (o ChannelOptions) Reliable() bool {
+	return o.MaxRetransmits == nil && o.MaxPacketLifeTime == nil
+}
+
+// Validate checks that the options can be used to open a channel
+func (o ChannelOptions) Validate() error {
+	if o.MaxRetransmits != nil && o.MaxPacketLifeTime != nil {
+		return fmt.Errorf("MaxRetransmits and MaxPacketLifeTime cannot both be set")
+	}
+	return nil
+}
+
+// toPion converts the options into pion's DataChannelInit
+func (o ChannelOptions) toPion() *webrtc.DataChannelInit {
+	ordered := o.Ordered
+	return &webrtc.DataChannelInit{
+		Ordered:           &ordered,
+		MaxRetransmits:    o.MaxRetransmits,
+		MaxPacketLifeTime: o.MaxPacketLifeTime,
+	}
+}
+
+// Opens a named data channel with its own delivery settings
+func (p *RealPeer) OpenChannel(label string, options ChannelOptions) error {
+	if label == "" {
+		return fmt.Errorf("channel label cannot be empty")
+	}
+
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	if p.hasChannel(label) {
+		return fmt.Errorf("data channel '%s' already exists", label)
+	}
+
+	dc, err := p.conn().CreateDataChannel(label, options.toPion())
+	if err != nil {
+		return err
+	}
+
+	p.addChannel(dc)
+	return nil
+}
+
+// Sends raw bytes over the named data channel, waiting while its buffer is full
+func (p *RealPeer) SendChannel(label string, data []byte) error {
+	return p.SendChannelContext(context.Background(), label, data)
+}
+
+// Registers a callback for messages arriving on the named channel.
+// It can be set before the channel exists
+func (p *RealPeer) OnChannelMessage(label string, callback func([]byte)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.channelHandlers[label] = callback
+}
+
+// Registers a callback fired whenever a data channel opens
+func (p *RealPeer) OnChannelOpen(callback func(string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onChannelOpen = callback
+}
+
+// hasChannel reports whether a channel with the label exists
+func (p *RealPeer) hasChannel(label string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.channels[label]
+	return ok
+}
+
+// addChannel tracks a local or remote data channel and wires its events
+func (p *RealPeer) addChannel(dc *webrtc.DataChannel) {
+	p.mu.Lock()
+	p.channels[dc.Label()] = dc
+	p.mu.Unlock()
+
+	p.setupDataChannelHandlers(dc)
+}
+
+// Sets up event handlers for a data channel
+func (p *RealPeer) setupDataChannelHandlers(dc *webrtc.DataChannel) {
+	label := dc.Label()
+	p.setupFlowControl(dc)
+
+	dc.OnOpen(func() {
+		log.Printf("Data channel '%s' opened", label)
+
+		// Channels of an ICE restart standby replace existing ones, they are not new
+		if p.isStandbyChannel(dc) {
+			p.tryPromote()
+			return
+		}
+
+		p.mu.RLock()
+		callback := p.onChannelOpen
+		p.mu.RUnlock()
+
+		if callback != nil {
+			callback(label)
+		}
+	})
+
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		log.Printf("Received %d bytes on '%s'", len(msg.Data), label)
+
+		p.mu.RLock()
+		callback := p.channelHandlers[label]
+		p.mu.RUnlock()
+
+		if callback != nil {
+			callback(msg.Data)
+		}
+	})
+
+	dc.OnClose(func() {
+		log.Printf("Data channel '%s' closed", label)
+		p.removeFlowControl(dc)
+	})
+
+	dc.OnError(func(err error) {
+		log.Printf("Data channel '%s' error: %v", label, err)
+	})
+}
@@ -0,0 +1,213 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectPeers runs the full offer/answer exchange and waits until the
+// default channel is usable from both sides
+func connectPeers(t *testing.T, offerer, answerer *RealPeer) {
+	t.Helper()
+
+	offer, err := offerer.CreateOffer()
+	require.NoError(t, err)
+
+	answer, err := answerer.CreateAnswer(offer)
+	require.NoError(t, err)
+
+	require.NoError(t, offerer.SetRemoteAnswer(answer))
+
+	require.Eventually(t, func() bool {
+		return channelOpen(offerer, DefaultChannel) && channelOpen(answerer, DefaultChannel)
+	}, 10*time.Second, 20*time.Millisecond, "data channel never opened")
+}
+
+// channelOpen reports whether the peer has an open channel with the label
+func channelOpen(p *RealPeer, label string) bool {
+	p.mu.RLock()
+	dc := p.channels[label]
+	p.mu.RUnlock()
+	return dc != nil && dc.ReadyState().String() == "open"
+}
+
+func TestRealPeer_OpenChannelValidation(t *testing.T) {
+	peer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer peer.Close()
+
+	assert.Error(t, peer.OpenChannel("", ReliableChannel()))
+
+	require.NoError(t, peer.OpenChannel("files", ReliableChannel()))
+	assert.Error(t, peer.OpenChannel("files", ReliableChannel()), "duplicate labels are rejected")
+
+	// Not connected yet
+	assert.Error(t, peer.SendChannel("files", []byte("data")))
+	assert.Error(t, peer.SendChannel("missing", []byte("data")))
+}
+
+func TestRealPeer_MultipleChannels(t *testing.T) {
+	offerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	unordered := ChannelOptions{Ordered: false, MaxRetransmits: new(uint16)}
+	require.NoError(t, offerer.OpenChannel("files", ReliableChannel()))
+	require.NoError(t, offerer.OpenChannel("presence", unordered))
+
+	opened := make(chan string, 8)
+	answerer.OnChannelOpen(func(label string) {
+		opened <- label
+	})
+
+	chatMessages := make(chan string, 1)
+	fileMessages := make(chan string, 1)
+	answerer.OnMessage(func(data []byte) {
+		chatMessages <- string(data)
+	})
+	answerer.OnChannelMessage("files", func(data []byte) {
+		fileMessages <- string(data)
+	})
+
+	connectPeers(t, offerer, answerer)
+	require.Eventually(t, func() bool {
+		return channelOpen(offerer, "files") && channelOpen(answerer, "files")
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// The answerer learns about every channel from the offer
+	seen := map[string]bool{}
+	timeout := time.After(5 * time.Second)
+	for len(seen) < 3 {
+		select {
+		case label := <-opened:
+			seen[label] = true
+		case <-timeout:
+			t.Fatalf("only saw channels %v", seen)
+		}
+	}
+	assert.True(t, seen[DefaultChannel] && seen["files"] && seen["presence"])
+
+	require.NoError(t, offerer.SendChannel("files", []byte("chunk-1")))
+	require.NoError(t, offerer.Send([]byte("hi")))
+
+	select {
+	case msg := <-fileMessages:
+		assert.Equal(t, "chunk-1", msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("file message not received")
+	}
+
+	select {
+	case msg := <-chatMessages:
+		assert.Equal(t, "hi", msg, "chat and file traffic go to separate handlers")
+	case <-time.After(5 * time.Second):
+		t.Fatal("chat message not received")
+	}
+}
+
+func TestRealPeer_OpenChannelAfterConnect(t *testing.T) {
+	offerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	connectPeers(t, offerer, answerer)
+
+	received := make(chan string, 1)
+	offerer.OnChannelMessage("control", func(data []byte) {
+		received <- string(data)
+	})
+
+	// Channels opened later are negotiated in-band, the answerer can open them too
+	require.NoError(t, answerer.OpenChannel("control", ReliableChannel()))
+	require.Eventually(t, func() bool {
+		return answerer.SendChannel("control", []byte("ping")) == nil
+	}, 5*time.Second, 20*time.Millisecond)
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "ping", msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message on late channel not received")
+	}
+}
+
+func TestChannelOptions_Validate(t *testing.T) {
+	lifetime := uint16(500)
+	retransmits := uint16(2)
+
+	assert.NoError(t, ReliableChannel().Validate())
+	assert.NoError(t, EphemeralChannel().Validate())
+	assert.NoError(t, ChannelOptions{MaxPacketLifeTime: &lifetime}.Validate())
+	assert.Error(t, ChannelOptions{MaxRetransmits: &retransmits, MaxPacketLifeTime: &lifetime}.Validate())
+
+	assert.True(t, ReliableChannel().Reliable())
+	assert.False(t, EphemeralChannel().Reliable())
+	assert.False(t, EphemeralChannel().Ordered)
+
+	peer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer peer.Close()
+
+	err = peer.OpenChannel("bad", ChannelOptions{MaxRetransmits: &retransmits, MaxPacketLifeTime: &lifetime})
+	assert.Error(t, err)
+}
+
+func TestRealPeer_PartiallyReliableChannels(t *testing.T) {
+	offerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	lifetime := uint16(250)
+	require.NoError(t, offerer.OpenChannel("typing", EphemeralChannel()))
+	require.NoError(t, offerer.OpenChannel("cursor", ChannelOptions{Ordered: false, MaxPacketLifeTime: &lifetime}))
+
+	received := make(chan string, 1)
+	answerer.OnChannelMessage("typing", func(data []byte) {
+		received <- string(data)
+	})
+
+	connectPeers(t, offerer, answerer)
+	require.Eventually(t, func() bool {
+		return channelOpen(answerer, "typing") && channelOpen(answerer, "cursor")
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// The remote side sees the same delivery settings
+	answerer.mu.RLock()
+	typing := answerer.channels["typing"]
+	cursor := answerer.channels["cursor"]
+	answerer.mu.RUnlock()
+
+	assert.False(t, typing.Ordered())
+	require.NotNil(t, typing.MaxRetransmits())
+	assert.Equal(t, uint16(0), *typing.MaxRetransmits())
+
+	assert.False(t, cursor.Ordered())
+	require.NotNil(t, cursor.MaxPacketLifeTime())
+	assert.Equal(t, lifetime, *cursor.MaxPacketLifeTime())
+
+	require.Eventually(t, func() bool {
+		return offerer.SendChannel("typing", []byte("alice")) == nil
+	}, 5*time.Second, 20*time.Millisecond)
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "alice", msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ephemeral message not received")
+	}
+}
@@ -0,0 +1,394 @@
+package webrtc
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Environment variables read by LoadPeerConfig
+const (
+	// EnvConfigFile points to a JSON config file (see LoadPeerConfigFile)
+	EnvConfigFile = "P2P_CHAT_CONFIG"
+
+	// EnvICEServers is a comma separated list of STUN/TURN URLs
+	EnvICEServers = "P2P_CHAT_ICE_SERVERS"
+
+	// EnvTURNUsername and EnvTURNCredential are applied to every turn: / turns: URL
+	EnvTURNUsername   = "P2P_CHAT_TURN_USERNAME"
+	EnvTURNCredential = "P2P_CHAT_TURN_CREDENTIAL"
+
+	// EnvICETransportPolicy is either "all" or "relay"
+	EnvICETransportPolicy = "P2P_CHAT_ICE_TRANSPORT_POLICY"
+
+	// EnvGatheringTimeout is a Go duration such as "5s"
+	EnvGatheringTimeout = "P2P_CHAT_GATHERING_TIMEOUT"
+
+	// EnvPrivacy turns privacy mode on ("1", "true") or off ("0", "false")
+	EnvPrivacy = "P2P_CHAT_PRIVACY"
+)
+
+// DefaultGatheringTimeout bounds how long offer/answer creation waits for
+// ICE gathering when the config does not say otherwise
+const DefaultGatheringTimeout = 10 * time.Second
+
+// ICETransportPolicy limits which candidates ICE is allowed to use
+type ICETransportPolicy string
+
+const (
+	// TransportPolicyAll uses every gathered candidate (host, srflx and relay)
+	TransportPolicyAll ICETransportPolicy = "all"
+
+	// TransportPolicyRelay only uses TURN relay candidates
+	TransportPolicyRelay ICETransportPolicy = "relay"
+)
+
+// CredentialSource supplies TURN credentials that change over time, such as
+// the time-limited ones of pkg/turn. It is asked again for every new
+// connection, ICE restarts included
+type CredentialSource interface {
+	TURNCredential() (username, credential string, err error)
+}
+
+// ICEServer describes a single STUN or TURN server.
+// Field names follow the browser RTCIceServer so config files look familiar.
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// PeerConfig holds everything needed to create a RealPeer
+type PeerConfig struct {
+	ICEServers         []ICEServer        `json:"iceServers"`
+	ICETransportPolicy ICETransportPolicy `json:"iceTransportPolicy,omitempty"`
+
+	// Privacy keeps the user's addresses out of room and answer codes: ICE
+	// is relay-only whatever ICETransportPolicy says, and host and server
+	// reflexive candidates are stripped from every SDP and trickled
+	// candidate. Requires a TURN server
+	Privacy bool `json:"privacy,omitempty"`
+
+	// GatheringTimeout is the longest CreateOffer/CreateAnswer wait for ICE
+	// gathering. Zero means DefaultGatheringTimeout
+	GatheringTimeout Duration `json:"gatheringTimeout,omitempty"`
+
+	// MaxBufferedAmount is how many bytes each data channel may queue before
+	// Send waits and TrySend returns ErrWouldBlock. Zero means DefaultMaxBufferedAmount
+	MaxBufferedAmount uint64 `json:"maxBufferedAmount,omitempty"`
+
+	// Network selects the interfaces, addresses and ports ICE uses
+	Network NetworkConfig `json:"network,omitzero"`
+
+	// Certificate is the DTLS certificate presented to the remote peer, e.g.
+	// a persistent identity. Nil means a fresh one for every RealPeer
+	Certificate *tls.Certificate `json:"-"`
+
+	// Credentials fills in the username and credential of TURN servers
+	// that have none
+	Credentials CredentialSource `json:"-"`
+
+	// PortMapper forwards a port of the user's router to the peer, which
+	// then offers the forwarded address as an extra candidate. Ignored in
+	// privacy mode
+	PortMapper PortMapper `json:"-"`
+}
+
+// Duration is a time.Duration that reads and writes JSON as "10s", "500ms", ...
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts a Go duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5s\": %w", err)
+	}
+
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// DefaultPeerConfig returns the config used when nothing else is provided:
+// Google's public STUN server and no TURN
+func DefaultPeerConfig() PeerConfig {
+	return PeerConfig{
+		ICEServers: []ICEServer{
+			{
+				URLs: []string{"stun:stun.l.google.com:19302"},
+			},
+		},
+		ICETransportPolicy: TransportPolicyAll,
+		GatheringTimeout:   Duration(DefaultGatheringTimeout),
+		MaxBufferedAmount:  DefaultMaxBufferedAmount,
+	}
+}
+
+// LoadPeerConfig builds a PeerConfig from the environment.
+// It starts from DefaultPeerConfig, replaces it with the file named by
+// P2P_CHAT_CONFIG if set, then applies the P2P_CHAT_* overrides on top.
+func LoadPeerConfig() (PeerConfig, error) {
+	return LoadPeerConfigWithCredentials(nil)
+}
+
+// LoadPeerConfigWithCredentials is LoadPeerConfig with TURN credentials
+// taken from source, so the config may list TURN servers without any
+func LoadPeerConfigWithCredentials(source CredentialSource) (PeerConfig, error) {
+	config := DefaultPeerConfig()
+
+	if path := os.Getenv(EnvConfigFile); path != "" {
+		fileConfig, err := LoadPeerConfigFile(path)
+		if err != nil {
+			return PeerConfig{}, err
+		}
+		config = fileConfig
+	}
+
+	if urls := os.Getenv(EnvICEServers); urls != "" {
+		config.ICEServers = parseServerList(urls)
+	}
+
+	username := os.Getenv(EnvTURNUsername)
+	credential := os.Getenv(EnvTURNCredential)
+	if username != "" || credential != "" {
+		for i := range config.ICEServers {
+			if config.ICEServers[i].isTURN() {
+				config.ICEServers[i].Username = username
+				config.ICEServers[i].Credential = credential
+			}
+		}
+	}
+
+	if policy := os.Getenv(EnvICETransportPolicy); policy != "" {
+		config.ICETransportPolicy = ICETransportPolicy(strings.ToLower(strings.TrimSpace(policy)))
+	}
+
+	if privacy := os.Getenv(EnvPrivacy); privacy != "" {
+		enabled, err := strconv.ParseBool(strings.TrimSpace(privacy))
+		if err != nil {
+			return PeerConfig{}, fmt.Errorf("invalid %s: %w", EnvPrivacy, err)
+		}
+		config.Privacy = enabled
+	}
+
+	if timeout := os.Getenv(EnvGatheringTimeout); timeout != "" {
+		parsed, err := time.ParseDuration(timeout)
+		if err != nil {
+			return PeerConfig{}, fmt.Errorf("invalid %s: %w", EnvGatheringTimeout, err)
+		}
+		config.GatheringTimeout = Duration(parsed)
+	}
+
+	config.Credentials = source
+
+	if err := config.Validate(); err != nil {
+		return PeerConfig{}, err
+	}
+
+	return config, nil
+}
+
+// LoadPeerConfigFile reads a JSON config file such as:
+//
+//	{
+//	  "iceServers": [
+//	    {"urls": ["stun:stun.example.com:3478"]},
+//	    {"urls": ["turn:turn.example.com:3478?transport=udp", "turns:turn.example.com:443?transport=tcp"],
+//	     "username": "alice", "credential": "secret"}
+//	  ],
+//	  "iceTransportPolicy": "all",
+//	  "privacy": false,
+//	  "gatheringTimeout": "5s",
+//	  "maxBufferedAmount": 1048576,
+//	  "network": {
+//	    "excludeInterfaces": ["docker*", "utun*"],
+//	    "portMin": 50000, "portMax": 50100,
+//	    "ipVersion": "ipv4"
+//	  }
+//	}
+func LoadPeerConfigFile(path string) (PeerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PeerConfig{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var config PeerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return PeerConfig{}, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	if config.ICETransportPolicy == "" {
+		config.ICETransportPolicy = TransportPolicyAll
+	}
+
+	if err := config.Validate(); err != nil {
+		return PeerConfig{}, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// Validate checks that the config can be used to create a peer
+func (c PeerConfig) Validate() error {
+	hasTURN := false
+
+	for i, server := range c.ICEServers {
+		if len(server.URLs) == 0 {
+			return fmt.Errorf("ice server %d has no URLs", i)
+		}
+
+		for _, url := range server.URLs {
+			scheme, _, found := strings.Cut(url, ":")
+			if !found {
+				return fmt.Errorf("invalid ice server URL: %q", url)
+			}
+
+			switch scheme {
+			case "stun", "stuns":
+			case "turn", "turns":
+				hasTURN = true
+				if (server.Username == "" || server.Credential == "") && c.Credentials == nil {
+					return fmt.Errorf("turn server %q requires a username and credential", url)
+				}
+			default:
+				return fmt.Errorf("unsupported ice server scheme %q in %q", scheme, url)
+			}
+		}
+	}
+
+	if err := c.Network.validate(); err != nil {
+		return err
+	}
+
+	if c.Certificate != nil {
+		if _, err := leafCertificate(c.Certificate); err != nil {
+			return err
+		}
+	}
+
+	if c.GatheringTimeout < 0 {
+		return fmt.Errorf("gathering timeout cannot be negative")
+	}
+
+	if c.Privacy && !hasTURN {
+		return fmt.Errorf("privacy mode requires at least one TURN server")
+	}
+
+	switch c.ICETransportPolicy {
+	case "", TransportPolicyAll:
+	case TransportPolicyRelay:
+		if !hasTURN {
+			return fmt.Errorf("relay transport policy requires at least one TURN server")
+		}
+	default:
+		return fmt.Errorf("invalid ice transport policy %q", c.ICETransportPolicy)
+	}
+
+	return nil
+}
+
+// toPion converts the config into pion's Configuration
+func (c PeerConfig) toPion() webrtc.Configuration {
+	config := webrtc.Configuration{
+		ICETransportPolicy: webrtc.ICETransportPolicyAll,
+	}
+
+	if c.relayOnly() {
+		config.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
+
+	for _, server := range c.ICEServers {
+		pionServer := webrtc.ICEServer{
+			URLs: append([]string(nil), server.URLs...),
+		}
+
+		if server.Username != "" || server.Credential != "" {
+			pionServer.Username = server.Username
+			pionServer.Credential = server.Credential
+			pionServer.CredentialType = webrtc.ICECredentialTypePassword
+		}
+
+		config.ICEServers = append(config.ICEServers, pionServer)
+	}
+
+	return config
+}
+
+// withCredentials returns the config with TURN servers that have no
+// credentials given the current ones of the credential source
+func (c PeerConfig) withCredentials() (PeerConfig, error) {
+	if c.Credentials == nil {
+		return c, nil
+	}
+
+	username, credential, err := c.Credentials.TURNCredential()
+	if err != nil {
+		return PeerConfig{}, fmt.Errorf("failed to get TURN credentials: %w", err)
+	}
+
+	servers := make([]ICEServer, len(c.ICEServers))
+	for i, server := range c.ICEServers {
+		if server.isTURN() && server.Username == "" && server.Credential == "" {
+			server.Username = username
+			server.Credential = credential
+		}
+		servers[i] = server
+	}
+
+	c.ICEServers = servers
+	return c, nil
+}
+
+// gatheringTimeout returns the configured gathering deadline or the default
+func (c PeerConfig) gatheringTimeout() time.Duration {
+	if c.GatheringTimeout <= 0 {
+		return DefaultGatheringTimeout
+	}
+	return time.Duration(c.GatheringTimeout)
+}
+
+// maxBufferedAmount returns the configured send buffer limit or the default
+func (c PeerConfig) maxBufferedAmount() uint64 {
+	if c.MaxBufferedAmount == 0 {
+		return DefaultMaxBufferedAmount
+	}
+	return c.MaxBufferedAmount
+}
+
+// isTURN reports whether any of the server URLs is a TURN URL
+func (s ICEServer) isTURN() bool {
+	for _, url := range s.URLs {
+		if strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseServerList turns "stun:a,turn:b" into one ICEServer per URL
+func parseServerList(list string) []ICEServer {
+	var servers []ICEServer
+	for _, url := range strings.Split(list, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		servers = append(servers, ICEServer{URLs: []string{url}})
+	}
+	return servers
+}
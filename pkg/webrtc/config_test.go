@@ -0,0 +1,269 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultPeerConfig(t *testing.T) {
+	config := DefaultPeerConfig()
+
+	require.NoError(t, config.Validate())
+	require.Len(t, config.ICEServers, 1)
+	assert.Equal(t, []string{"stun:stun.l.google.com:19302"}, config.ICEServers[0].URLs)
+	assert.Equal(t, TransportPolicyAll, config.ICETransportPolicy)
+}
+
+func TestPeerConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  PeerConfig
+		wantErr bool
+	}{
+		{
+			name:    "no servers",
+			config:  PeerConfig{},
+			wantErr: false,
+		},
+		{
+			name: "turn with credentials",
+			config: PeerConfig{
+				ICEServers: []ICEServer{{URLs: []string{"turn:turn.example.com:3478"}, Username: "u", Credential: "p"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "turn without credentials",
+			config: PeerConfig{
+				ICEServers: []ICEServer{{URLs: []string{"turn:turn.example.com:3478"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "server without URLs",
+			config: PeerConfig{
+				ICEServers: []ICEServer{{}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown scheme",
+			config: PeerConfig{
+				ICEServers: []ICEServer{{URLs: []string{"http://example.com"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relay policy without turn",
+			config: PeerConfig{
+				ICEServers:         []ICEServer{{URLs: []string{"stun:stun.example.com:3478"}}},
+				ICETransportPolicy: TransportPolicyRelay,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid policy",
+			config: PeerConfig{
+				ICETransportPolicy: "sometimes",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPeerConfig_toPion(t *testing.T) {
+	config := PeerConfig{
+		ICEServers: []ICEServer{
+			{URLs: []string{"stun:stun.example.com:3478"}},
+			{URLs: []string{"turn:turn.example.com:3478"}, Username: "alice", Credential: "secret"},
+		},
+		ICETransportPolicy: TransportPolicyRelay,
+	}
+
+	pionConfig := config.toPion()
+
+	assert.Equal(t, webrtc.ICETransportPolicyRelay, pionConfig.ICETransportPolicy)
+	require.Len(t, pionConfig.ICEServers, 2)
+	assert.Empty(t, pionConfig.ICEServers[0].Username)
+	assert.Equal(t, "alice", pionConfig.ICEServers[1].Username)
+	assert.Equal(t, "secret", pionConfig.ICEServers[1].Credential)
+}
+
+func TestLoadPeerConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{
+		"iceServers": [
+			{"urls": ["stun:stun.example.com:3478"]},
+			{"urls": ["turn:turn.example.com:3478", "turns:turn.example.com:443?transport=tcp"],
+			 "username": "alice", "credential": "secret"}
+		],
+		"iceTransportPolicy": "relay"
+	}`), 0o600)
+	require.NoError(t, err)
+
+	config, err := LoadPeerConfigFile(path)
+	require.NoError(t, err)
+
+	require.Len(t, config.ICEServers, 2)
+	assert.Len(t, config.ICEServers[1].URLs, 2)
+	assert.Equal(t, "alice", config.ICEServers[1].Username)
+	assert.Equal(t, TransportPolicyRelay, config.ICETransportPolicy)
+}
+
+func TestLoadPeerConfigFile_Invalid(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := LoadPeerConfigFile(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+
+	badJSON := filepath.Join(dir, "bad.json")
+	require.NoError(t, os.WriteFile(badJSON, []byte(`{not json`), 0o600))
+	_, err = LoadPeerConfigFile(badJSON)
+	assert.Error(t, err)
+
+	noCreds := filepath.Join(dir, "nocreds.json")
+	require.NoError(t, os.WriteFile(noCreds, []byte(`{"iceServers":[{"urls":["turn:t.example.com"]}]}`), 0o600))
+	_, err = LoadPeerConfigFile(noCreds)
+	assert.Error(t, err)
+}
+
+func TestLoadPeerConfig_Env(t *testing.T) {
+	t.Setenv(EnvConfigFile, "")
+	t.Setenv(EnvICEServers, "stun:stun.example.com:3478, turn:turn.example.com:3478")
+	t.Setenv(EnvTURNUsername, "bob")
+	t.Setenv(EnvTURNCredential, "hunter2")
+	t.Setenv(EnvICETransportPolicy, "RELAY")
+
+	config, err := LoadPeerConfig()
+	require.NoError(t, err)
+
+	require.Len(t, config.ICEServers, 2)
+	assert.Empty(t, config.ICEServers[0].Username, "credentials only apply to TURN servers")
+	assert.Equal(t, "bob", config.ICEServers[1].Username)
+	assert.Equal(t, "hunter2", config.ICEServers[1].Credential)
+	assert.Equal(t, TransportPolicyRelay, config.ICETransportPolicy)
+}
+
+func TestLoadPeerConfig_Defaults(t *testing.T) {
+	for _, key := range []string{EnvConfigFile, EnvICEServers, EnvTURNUsername, EnvTURNCredential, EnvICETransportPolicy, EnvGatheringTimeout, EnvPrivacy} {
+		t.Setenv(key, "")
+	}
+
+	config, err := LoadPeerConfig()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPeerConfig(), config)
+}
+
+func TestNewRealPeerWithConfig_Invalid(t *testing.T) {
+	peer, err := NewRealPeerWithConfig(PeerConfig{ICETransportPolicy: TransportPolicyRelay})
+	assert.Error(t, err)
+	assert.Nil(t, peer)
+}
+
+func TestDuration_JSON(t *testing.T) {
+	var config PeerConfig
+	require.NoError(t, json.Unmarshal([]byte(`{"gatheringTimeout":"1500ms"}`), &config))
+	assert.Equal(t, 1500*time.Millisecond, time.Duration(config.GatheringTimeout))
+	assert.Equal(t, 1500*time.Millisecond, config.gatheringTimeout())
+
+	data, err := json.Marshal(config)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"gatheringTimeout":"1.5s"`)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"gatheringTimeout":5}`), &config))
+	assert.Error(t, json.Unmarshal([]byte(`{"gatheringTimeout":"soon"}`), &config))
+
+	// Zero falls back to the default
+	assert.Equal(t, DefaultGatheringTimeout, PeerConfig{}.gatheringTimeout())
+}
+
+func TestLoadPeerConfig_GatheringTimeoutEnv(t *testing.T) {
+	t.Setenv(EnvConfigFile, "")
+	t.Setenv(EnvICEServers, "")
+	t.Setenv(EnvGatheringTimeout, "2s")
+
+	config, err := LoadPeerConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, time.Duration(config.GatheringTimeout))
+
+	t.Setenv(EnvGatheringTimeout, "later")
+	_, err = LoadPeerConfig()
+	assert.Error(t, err)
+}
+
+// countingCredentials hands out a new credential on every call
+type countingCredentials struct {
+	calls int
+	err   error
+}
+
+func (c *countingCredentials) TURNCredential() (string, string, error) {
+	c.calls++
+	return fmt.Sprintf("user%d", c.calls), "pass", c.err
+}
+
+func TestPeerConfig_Credentials(t *testing.T) {
+	for _, key := range []string{EnvConfigFile, EnvTURNUsername, EnvTURNCredential, EnvICETransportPolicy, EnvGatheringTimeout, EnvPrivacy} {
+		t.Setenv(key, "")
+	}
+	t.Setenv(EnvICEServers, "stun:stun.example.com:3478,turn:turn.example.com:3478")
+
+	_, err := LoadPeerConfig()
+	require.Error(t, err, "TURN servers need credentials")
+
+	source := &countingCredentials{}
+	config, err := LoadPeerConfigWithCredentials(source)
+	require.NoError(t, err)
+	assert.Empty(t, config.ICEServers[1].Username)
+
+	first, err := config.withCredentials()
+	require.NoError(t, err)
+	assert.Empty(t, first.ICEServers[0].Username, "credentials only apply to TURN servers")
+	assert.Equal(t, "user1", first.ICEServers[1].Username)
+	assert.Equal(t, "pass", first.ICEServers[1].Credential)
+	assert.Empty(t, config.ICEServers[1].Username, "the config itself is not changed")
+
+	second, err := config.withCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, "user2", second.ICEServers[1].Username)
+
+	// Configured credentials win over the source
+	config.ICEServers[1].Username = "static"
+	config.ICEServers[1].Credential = "secret"
+	third, err := config.withCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, "static", third.ICEServers[1].Username)
+
+	source.err = errors.New("offline")
+	_, err = config.withCredentials()
+	assert.ErrorContains(t, err, "offline")
+
+	// Every new connection asks the source again
+	config.ICEServers[1].Username = ""
+	config.ICEServers[1].Credential = ""
+	source.err = nil
+	peer, err := NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer peer.Close()
+	assert.Equal(t, 5, source.calls)
+}
@@ -0,0 +1,366 @@
+package webrtc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+)
+
+// DefaultProbeTimeout bounds each diagnostic probe when DiagnoseOptions
+// does not say otherwise
+const DefaultProbeTimeout = 3 * time.Second
+
+// DiagnoseOptions tunes Diagnose and ProbeServer
+type DiagnoseOptions struct {
+	// Timeout bounds each probe. Zero means DefaultProbeTimeout
+	Timeout time.Duration
+
+	// TLSConfig verifies stuns: and turns: servers. Nil means the system
+	// roots and the URL's host name
+	TLSConfig *tls.Config
+}
+
+func (o DiagnoseOptions) timeout() time.Duration {
+	if o.Timeout <= 0 {
+		return DefaultProbeTimeout
+	}
+	return o.Timeout
+}
+
+// ServerCheck is the result of probing one STUN or TURN URL
+type ServerCheck struct {
+	URL       string
+	TURN      bool
+	Transport string // "udp", "tcp" or "tls"
+
+	OK  bool
+	Err error
+	RTT time.Duration
+
+	// MappedAddress is this host's public address as the server saw it
+	MappedAddress string
+
+	// RelayAddress is the address of the TURN allocation
+	RelayAddress string
+}
+
+// Diagnosis is what Diagnose found out
+type Diagnosis struct {
+	Servers []ServerCheck
+
+	// NAT is nil when no STUN server answered; NATErr says why
+	NAT    *NATType
+	NATErr error
+}
+
+// Diagnose probes every STUN and TURN URL of the config, classifies the
+// NAT with the UDP STUN servers and collects the lot in a report. TURN
+// credentials come from the config, or its credential source
+func Diagnose(ctx context.Context, config PeerConfig, options DiagnoseOptions) *Diagnosis {
+	diagnosis := &Diagnosis{}
+
+	config, err := config.withCredentials()
+	if err != nil {
+		diagnosis.NATErr = err
+		return diagnosis
+	}
+
+	type probe struct {
+		url    string
+		server ICEServer
+	}
+	var probes []probe
+	for _, server := range config.ICEServers {
+		for _, url := range server.URLs {
+			probes = append(probes, probe{url, server})
+		}
+	}
+
+	diagnosis.Servers = make([]ServerCheck, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			diagnosis.Servers[i] = ProbeServer(ctx, p.url, p.server.Username, p.server.Credential, options)
+		}()
+	}
+	wg.Wait()
+
+	// NAT detection wants plain UDP STUN servers, TURN ones answer too
+	var stunServers, turnServers []*net.UDPAddr
+	for _, check := range diagnosis.Servers {
+		if !check.OK || check.Transport != "udp" {
+			continue
+		}
+		uri, _ := stun.ParseURI(check.URL)
+		addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(uri.Host, strconv.Itoa(uri.Port)))
+		if err != nil {
+			continue
+		}
+		if check.TURN {
+			turnServers = append(turnServers, addr)
+		} else {
+			stunServers = append(stunServers, addr)
+		}
+	}
+
+	nat, err := DetectNAT(ctx, append(stunServers, turnServers...), options.timeout())
+	if err != nil {
+		diagnosis.NATErr = err
+	}
+	if nat.PublicAddress != "" {
+		diagnosis.NAT = &nat
+	}
+
+	return diagnosis
+}
+
+// ProbeServer checks one STUN or TURN URL: a Binding request for STUN, an
+// allocation for TURN
+func ProbeServer(ctx context.Context, rawURL, username, credential string, options DiagnoseOptions) ServerCheck {
+	check := ServerCheck{URL: rawURL}
+
+	uri, err := stun.ParseURI(rawURL)
+	if err != nil {
+		check.Err = fmt.Errorf("invalid URL: %w", err)
+		return check
+	}
+
+	check.TURN = uri.Scheme == stun.SchemeTypeTURN || uri.Scheme == stun.SchemeTypeTURNS
+	secure := uri.Scheme == stun.SchemeTypeSTUNS || uri.Scheme == stun.SchemeTypeTURNS
+	switch {
+	case secure:
+		check.Transport = "tls"
+	case uri.Proto == stun.ProtoTypeTCP:
+		check.Transport = "tcp"
+	default:
+		check.Transport = "udp"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, options.timeout())
+	defer cancel()
+
+	address := net.JoinHostPort(uri.Host, strconv.Itoa(uri.Port))
+	conn, server, err := dialServer(ctx, check.Transport, address, uri.Host, options)
+	if err != nil {
+		check.Err = ctxErr(ctx, err)
+		return check
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	start := time.Now()
+	if !check.TURN {
+		response, err := binding(conn, server, 0, options.timeout())
+		if err != nil {
+			check.Err = ctxErr(ctx, err)
+			return check
+		}
+		check.OK = true
+		check.RTT = response.rtt
+		check.MappedAddress = response.mapped.String()
+		return check
+	}
+
+	if username == "" || credential == "" {
+		check.Err = fmt.Errorf("no credentials")
+		return check
+	}
+
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: address,
+		TURNServerAddr: address,
+		Username:       username,
+		Password:       credential,
+		Conn:           conn,
+	})
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	defer client.Close()
+
+	if err := client.Listen(); err != nil {
+		check.Err = err
+		return check
+	}
+
+	if mapped, err := client.SendBindingRequest(); err == nil {
+		check.MappedAddress = mapped.String()
+	}
+
+	relay, err := client.Allocate()
+	if err != nil {
+		check.Err = ctxErr(ctx, err)
+		return check
+	}
+	defer relay.Close()
+
+	check.OK = true
+	check.RTT = time.Since(start)
+	check.RelayAddress = relay.LocalAddr().String()
+	return check
+}
+
+// dialServer opens a packet conn to a server over the transport: a UDP
+// socket, or a TCP/TLS connection framed as STUN messages
+func dialServer(ctx context.Context, transport, address, host string, options DiagnoseOptions) (net.PacketConn, net.Addr, error) {
+	if transport == "udp" {
+		server, err := net.ResolveUDPAddr("udp4", address)
+		if err != nil {
+			return nil, nil, err
+		}
+		conn, err := net.ListenUDP("udp4", nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		return conn, server, nil
+	}
+
+	var dialer interface {
+		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	} = &net.Dialer{}
+	if transport == "tls" {
+		config := &tls.Config{ServerName: host}
+		if options.TLSConfig != nil {
+			config = options.TLSConfig.Clone()
+			if config.ServerName == "" {
+				config.ServerName = host
+			}
+		}
+		dialer = &tls.Dialer{Config: config}
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+	return turn.NewSTUNConn(conn), conn.RemoteAddr(), nil
+}
+
+// problem explains a failed check in a few words
+func (c ServerCheck) problem() string {
+	var netErr net.Error
+	switch {
+	case c.Err == nil:
+		return ""
+	case errors.Is(c.Err, errNoResponse), errors.Is(c.Err, context.DeadlineExceeded),
+		errors.As(c.Err, &netErr) && netErr.Timeout():
+		return "no answer (blocked by a firewall?)"
+	case strings.Contains(c.Err.Error(), "error 401"),
+		// pion/turn answers a bad MESSAGE-INTEGRITY with 400
+		c.TURN && strings.Contains(c.Err.Error(), "Allocate error response (error 400"):
+		return "credentials rejected"
+	case strings.Contains(c.Err.Error(), "connection refused"):
+		return "connection refused"
+	default:
+		return c.Err.Error()
+	}
+}
+
+// Advice turns the findings into plain-language conclusions
+func (d *Diagnosis) Advice() []string {
+	var advice []string
+
+	reachable := map[bool]map[string]bool{false: {}, true: {}}
+	tried := map[bool]map[string]bool{false: {}, true: {}}
+	authFailed := false
+	for _, check := range d.Servers {
+		tried[check.TURN][check.Transport] = true
+		if check.OK {
+			reachable[check.TURN][check.Transport] = true
+		}
+		if check.problem() == "credentials rejected" {
+			authFailed = true
+		}
+	}
+	anyReachable := len(reachable[false]) > 0 || len(reachable[true]) > 0
+
+	switch {
+	case len(d.Servers) == 0:
+		advice = append(advice, "No STUN or TURN server is configured, so only peers on the same network can connect.")
+	case !anyReachable:
+		advice = append(advice, "No server answered. Check the internet connection and whether a firewall blocks outgoing traffic.")
+	case tried[false]["udp"] && !reachable[false]["udp"] && !reachable[true]["udp"] && (reachable[true]["tcp"] || reachable[true]["tls"]):
+		advice = append(advice, "UDP seems to be blocked: connections can only go through the TURN relay over TCP/TLS.")
+	}
+
+	if d.NAT != nil {
+		switch d.NAT.Mapping {
+		case NATNone, NATEndpointIndependent:
+			advice = append(advice, fmt.Sprintf("You are behind a %s: direct connections should work with most peers.", d.NAT.Description()))
+		case NATAddressDependent, NATAddressAndPortDependent, NATEndpointDependent:
+			advice = append(advice, "You are behind a symmetric NAT: direct connections usually fail, a TURN relay is needed.")
+		}
+	}
+
+	switch {
+	case !tried[true]["udp"] && !tried[true]["tcp"] && !tried[true]["tls"]:
+		advice = append(advice, "No TURN server is configured: peers behind strict NATs or firewalls cannot connect. Run your own with cmd/relay.")
+	case authFailed:
+		advice = append(advice, "The TURN server rejected the credentials: check the username and credential, or the shared secret.")
+	case len(reachable[true]) == 0:
+		advice = append(advice, "No TURN server could be reached: there is no fallback when a direct connection fails.")
+	}
+
+	return advice
+}
+
+// String formats the diagnosis as a human-readable report
+func (d *Diagnosis) String() string {
+	var report strings.Builder
+
+	report.WriteString("Servers:\n")
+	if len(d.Servers) == 0 {
+		report.WriteString("  none configured\n")
+	}
+	for _, check := range d.Servers {
+		if !check.OK {
+			fmt.Fprintf(&report, "  FAIL %s: %s\n", check.URL, check.problem())
+			continue
+		}
+		fmt.Fprintf(&report, "  ok   %s (%s)", check.URL, check.RTT.Round(time.Millisecond))
+		if check.MappedAddress != "" {
+			fmt.Fprintf(&report, ", public address %s", check.MappedAddress)
+		}
+		if check.RelayAddress != "" {
+			fmt.Fprintf(&report, ", relay %s", check.RelayAddress)
+		}
+		report.WriteString("\n")
+	}
+
+	report.WriteString("\nNAT:\n")
+	if d.NAT == nil {
+		fmt.Fprintf(&report, "  not detected: %v\n", d.NATErr)
+	} else {
+		fmt.Fprintf(&report, "  Type: %s\n", d.NAT.Description())
+		fmt.Fprintf(&report, "  Local address: %s\n", d.NAT.LocalAddress)
+		fmt.Fprintf(&report, "  Public address: %s\n", d.NAT.PublicAddress)
+		fmt.Fprintf(&report, "  Mapping: %s\n", d.NAT.Mapping)
+		fmt.Fprintf(&report, "  Filtering: %s\n", d.NAT.Filtering)
+		if d.NATErr != nil {
+			fmt.Fprintf(&report, "  (incomplete: %v)\n", d.NATErr)
+		}
+	}
+
+	if advice := d.Advice(); len(advice) > 0 {
+		report.WriteString("\nVerdict:\n")
+		for _, line := range advice {
+			fmt.Fprintf(&report, "  - %s\n", line)
+		}
+	}
+
+	return report.String()
+}
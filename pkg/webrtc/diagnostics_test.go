@@ -0,0 +1,362 @@
+package webrtc
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testProbeTimeout = 200 * time.Millisecond
+
+// natServer is an RFC 5780 STUN server listening on two ports of
+// 127.0.0.1 and 127.0.0.2. It answers as if the client were behind a NAT
+// with the given mapping and filtering
+type natServer struct {
+	mapping   NATBehavior
+	filtering NATBehavior
+
+	// plain leaves out OTHER-ADDRESS and ignores CHANGE-REQUEST, like most
+	// public STUN servers
+	plain bool
+
+	conns [2][2]*net.UDPConn
+
+	// The sockets each public address has sent to. Like a real NAT, the
+	// filter of a mapping lets in the senders it has contacted
+	mu        sync.Mutex
+	contacted map[string]map[[2]int]bool
+}
+
+// startNATServer binds the same two ports on both addresses
+func startNATServer(t *testing.T, mapping, filtering NATBehavior, plain bool) *natServer {
+	t.Helper()
+
+	s := &natServer{mapping: mapping, filtering: filtering, plain: plain, contacted: make(map[string]map[[2]int]bool)}
+	ips := [2]net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)}
+
+	for j := range 2 {
+		for attempt := 0; ; attempt++ {
+			first, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ips[0]})
+			require.NoError(t, err)
+			second, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ips[1], Port: first.LocalAddr().(*net.UDPAddr).Port})
+			if err != nil {
+				first.Close()
+				require.Less(t, attempt, 10, "127.0.0.2 unusable: %v", err)
+				continue
+			}
+			s.conns[0][j], s.conns[1][j] = first, second
+			break
+		}
+	}
+
+	for i := range 2 {
+		for j := range 2 {
+			conn := s.conns[i][j]
+			t.Cleanup(func() { conn.Close() })
+			go s.serve(i, j)
+		}
+	}
+	return s
+}
+
+// addr returns the address of socket i (IP), j (port)
+func (s *natServer) addr(i, j int) *net.UDPAddr {
+	return s.conns[i][j].LocalAddr().(*net.UDPAddr)
+}
+
+// mapped is the address the simulated NAT gives src towards socket i, j
+func (s *natServer) mapped(src *net.UDPAddr, i, j int) *net.UDPAddr {
+	public := net.IPv4(198, 51, 100, 1)
+	switch s.mapping {
+	case NATEndpointIndependent:
+		return &net.UDPAddr{IP: public, Port: src.Port}
+	case NATAddressDependent:
+		return &net.UDPAddr{IP: public, Port: src.Port + 100*i}
+	case NATAddressAndPortDependent:
+		return &net.UDPAddr{IP: public, Port: src.Port + 100*i + 10*j}
+	default:
+		return src
+	}
+}
+
+func (s *natServer) serve(i, j int) {
+	conn := s.conns[i][j]
+	buf := make([]byte, 1500)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		request := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if request.Decode() != nil || request.Type != stun.BindingRequest {
+			continue
+		}
+
+		// Answer from the socket the client asked for, if the simulated
+		// filtering lets it through
+		replyI, replyJ := i, j
+		if change, err := request.Get(stun.AttrChangeRequest); err == nil && len(change) == 4 && !s.plain {
+			if change[3]&changeIP != 0 {
+				replyI ^= 1
+			}
+			if change[3]&changePort != 0 {
+				replyJ ^= 1
+			}
+		}
+		mapped := s.mapped(src, i, j)
+		if !s.filter(mapped, i, j, replyI, replyJ) {
+			continue
+		}
+
+		setters := []stun.Setter{
+			stun.NewTransactionIDSetter(request.TransactionID),
+			stun.BindingSuccess,
+			&stun.XORMappedAddress{IP: mapped.IP, Port: mapped.Port},
+		}
+		if !s.plain {
+			other := s.addr(i^1, j^1)
+			setters = append(setters, &stun.OtherAddress{IP: other.IP, Port: other.Port})
+		}
+		setters = append(setters, stun.Fingerprint)
+
+		response, err := stun.Build(setters...)
+		if err != nil {
+			continue
+		}
+		s.conns[replyI][replyJ].WriteToUDP(response.Raw, src)
+	}
+}
+
+// filter records that mapped sent to socket i, j and reports whether the
+// simulated NAT lets an answer from socket replyI, replyJ through
+func (s *natServer) filter(mapped *net.UDPAddr, i, j, replyI, replyJ int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	contacted := s.contacted[mapped.String()]
+	if contacted == nil {
+		contacted = make(map[[2]int]bool)
+		s.contacted[mapped.String()] = contacted
+	}
+	contacted[[2]int{i, j}] = true
+
+	switch s.filtering {
+	case NATEndpointIndependent:
+		return true
+	case NATAddressDependent:
+		return contacted[[2]int{replyI, 0}] || contacted[[2]int{replyI, 1}]
+	default:
+		return contacted[[2]int{replyI, replyJ}]
+	}
+}
+
+func TestDetectNAT(t *testing.T) {
+	behaviors := []NATBehavior{NATEndpointIndependent, NATAddressDependent, NATAddressAndPortDependent}
+
+	for _, mapping := range behaviors {
+		for _, filtering := range behaviors {
+			t.Run(fmt.Sprintf("%s mapping, %s filtering", mapping, filtering), func(t *testing.T) {
+				server := startNATServer(t, mapping, filtering, false)
+
+				nat, err := DetectNAT(context.Background(), []*net.UDPAddr{server.addr(0, 0)}, testProbeTimeout)
+				require.NoError(t, err)
+				assert.Equal(t, mapping, nat.Mapping)
+				assert.Equal(t, filtering, nat.Filtering)
+				assert.True(t, strings.HasPrefix(nat.PublicAddress, "198.51.100.1:"), nat.PublicAddress)
+			})
+		}
+	}
+
+	t.Run("no NAT", func(t *testing.T) {
+		server := startNATServer(t, NATNone, NATEndpointIndependent, false)
+
+		nat, err := DetectNAT(context.Background(), []*net.UDPAddr{server.addr(0, 0)}, testProbeTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, NATNone, nat.Mapping)
+		assert.Equal(t, nat.LocalAddress, nat.PublicAddress)
+		assert.Equal(t, "no NAT (open internet)", nat.Description())
+	})
+}
+
+func TestDetectNAT_PlainServers(t *testing.T) {
+	tests := []struct {
+		mapping NATBehavior
+		want    NATBehavior
+	}{
+		{NATEndpointIndependent, NATEndpointIndependent},
+		{NATAddressDependent, NATEndpointDependent},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mapping), func(t *testing.T) {
+			server := startNATServer(t, tt.mapping, NATAddressAndPortDependent, true)
+			servers := []*net.UDPAddr{server.addr(0, 0), server.addr(1, 0)}
+
+			nat, err := DetectNAT(context.Background(), servers, testProbeTimeout)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, nat.Mapping)
+			assert.Equal(t, NATUnknown, nat.Filtering, "filtering needs CHANGE-REQUEST")
+		})
+	}
+
+	t.Run("single server", func(t *testing.T) {
+		server := startNATServer(t, NATEndpointIndependent, NATEndpointIndependent, true)
+
+		nat, err := DetectNAT(context.Background(), []*net.UDPAddr{server.addr(0, 0)}, testProbeTimeout)
+		require.NoError(t, err)
+		assert.Equal(t, NATUnknown, nat.Mapping)
+	})
+
+	t.Run("no answer", func(t *testing.T) {
+		silent, err := net.ResolveUDPAddr("udp4", strings.TrimPrefix(silentServerURL(t, "stun"), "stun:"))
+		require.NoError(t, err)
+
+		_, err = DetectNAT(context.Background(), []*net.UDPAddr{silent}, testProbeTimeout)
+		assert.ErrorIs(t, err, errNoResponse)
+	})
+}
+
+func TestNATType_Description(t *testing.T) {
+	assert.Equal(t, "full cone NAT", NATType{Mapping: NATEndpointIndependent, Filtering: NATEndpointIndependent}.Description())
+	assert.Equal(t, "restricted cone NAT", NATType{Mapping: NATEndpointIndependent, Filtering: NATAddressDependent}.Description())
+	assert.Equal(t, "port restricted cone NAT", NATType{Mapping: NATEndpointIndependent, Filtering: NATAddressAndPortDependent}.Description())
+	assert.Equal(t, "symmetric NAT", NATType{Mapping: NATAddressAndPortDependent, Filtering: NATUnknown}.Description())
+	assert.Equal(t, "no NAT, behind a firewall", NATType{Mapping: NATNone, Filtering: NATAddressDependent}.Description())
+}
+
+// startTURNListeners runs a pion/turn server over UDP, TCP and TLS on
+// loopback and returns a URL for each
+func startTURNListeners(t *testing.T) (udpURL, tcpURL, tlsURL string) {
+	t.Helper()
+
+	packetConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	tlsListener, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{*testCertificate(t, time.Now().Add(time.Hour))},
+	})
+	require.NoError(t, err)
+
+	generator := func() turn.RelayAddressGenerator {
+		return &turn.RelayAddressGeneratorStatic{RelayAddress: net.ParseIP("127.0.0.1"), Address: "127.0.0.1"}
+	}
+	key := turn.GenerateAuthKey(testTURNUser, testTURNRealm, testTURNPassword)
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm: testTURNRealm,
+		AuthHandler: func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+			return key, username == testTURNUser
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{{PacketConn: packetConn, RelayAddressGenerator: generator()}},
+		ListenerConfigs: []turn.ListenerConfig{
+			{Listener: tcpListener, RelayAddressGenerator: generator()},
+			{Listener: tlsListener, RelayAddressGenerator: generator()},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	return "turn:" + packetConn.LocalAddr().String() + "?transport=udp",
+		"turn:" + tcpListener.Addr().String() + "?transport=tcp",
+		"turns:" + tlsListener.Addr().String() + "?transport=tcp"
+}
+
+func TestProbeServer(t *testing.T) {
+	udpURL, tcpURL, tlsURL := startTURNListeners(t)
+	options := DiagnoseOptions{Timeout: time.Second, TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+
+	for _, url := range []string{udpURL, tcpURL, tlsURL} {
+		check := ProbeServer(context.Background(), url, testTURNUser, testTURNPassword, options)
+		require.True(t, check.OK, "%s: %v", url, check.Err)
+		assert.True(t, check.TURN)
+		assert.True(t, strings.HasPrefix(check.RelayAddress, "127.0.0.1:"), check.RelayAddress)
+		assert.True(t, strings.HasPrefix(check.MappedAddress, "127.0.0.1:"), check.MappedAddress)
+	}
+	assert.Equal(t, "tcp", ProbeServer(context.Background(), tcpURL, testTURNUser, testTURNPassword, options).Transport)
+	assert.Equal(t, "tls", ProbeServer(context.Background(), tlsURL, testTURNUser, testTURNPassword, options).Transport)
+
+	wrong := ProbeServer(context.Background(), udpURL, testTURNUser, "wrong", options)
+	assert.False(t, wrong.OK)
+	assert.Equal(t, "credentials rejected", wrong.problem())
+
+	// The TURN server also answers plain STUN
+	stunURL := "stun:" + strings.TrimSuffix(strings.TrimPrefix(udpURL, "turn:"), "?transport=udp")
+	stunCheck := ProbeServer(context.Background(), stunURL, "", "", options)
+	require.True(t, stunCheck.OK, stunCheck.Err)
+	assert.False(t, stunCheck.TURN)
+	assert.Equal(t, "udp", stunCheck.Transport)
+
+	silent := ProbeServer(context.Background(), silentServerURL(t, "stun"), "", "", DiagnoseOptions{Timeout: testProbeTimeout})
+	assert.False(t, silent.OK)
+	assert.Equal(t, "no answer (blocked by a firewall?)", silent.problem())
+
+	refused := ProbeServer(context.Background(), "turn:127.0.0.1:1?transport=tcp", testTURNUser, testTURNPassword, options)
+	assert.Equal(t, "connection refused", refused.problem())
+}
+
+func TestDiagnose(t *testing.T) {
+	udpURL, tcpURL, tlsURL := startTURNListeners(t)
+	nat := startNATServer(t, NATEndpointIndependent, NATAddressDependent, false)
+
+	config := PeerConfig{ICEServers: []ICEServer{
+		{URLs: []string{"stun:" + nat.addr(0, 0).String()}},
+		{URLs: []string{udpURL, tcpURL, tlsURL}, Username: testTURNUser, Credential: testTURNPassword},
+	}}
+	diagnosis := Diagnose(context.Background(), config, DiagnoseOptions{
+		Timeout:   testProbeTimeout * 2,
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+	})
+
+	require.Len(t, diagnosis.Servers, 4)
+	for _, check := range diagnosis.Servers {
+		assert.True(t, check.OK, "%s: %v", check.URL, check.Err)
+	}
+	require.NotNil(t, diagnosis.NAT, diagnosis.NATErr)
+	assert.Equal(t, NATEndpointIndependent, diagnosis.NAT.Mapping)
+	assert.Equal(t, NATAddressDependent, diagnosis.NAT.Filtering)
+
+	report := diagnosis.String()
+	assert.Contains(t, report, "ok   "+tlsURL)
+	assert.Contains(t, report, "Type: restricted cone NAT")
+	assert.Contains(t, report, "direct connections should work")
+	assert.NotContains(t, report, "FAIL")
+}
+
+func TestDiagnosis_Advice(t *testing.T) {
+	udpBlocked := &Diagnosis{Servers: []ServerCheck{
+		{URL: "stun:s", Transport: "udp", Err: errNoResponse},
+		{URL: "turn:t?transport=udp", TURN: true, Transport: "udp", Err: errNoResponse},
+		{URL: "turns:t", TURN: true, Transport: "tls", OK: true},
+	}}
+	assert.Contains(t, udpBlocked.Advice()[0], "UDP seems to be blocked")
+
+	symmetric := &Diagnosis{
+		Servers: []ServerCheck{{URL: "stun:s", Transport: "udp", OK: true}},
+		NAT:     &NATType{Mapping: NATAddressAndPortDependent},
+	}
+	advice := strings.Join(symmetric.Advice(), "\n")
+	assert.Contains(t, advice, "symmetric NAT")
+	assert.Contains(t, advice, "No TURN server is configured")
+
+	offline := &Diagnosis{Servers: []ServerCheck{{URL: "stun:s", Transport: "udp", Err: errNoResponse}}}
+	assert.Contains(t, offline.String(), "FAIL stun:s: no answer")
+	assert.Contains(t, offline.Advice()[0], "No server answered")
+
+	badCredentials := &Diagnosis{Servers: []ServerCheck{
+		{URL: "turn:t", TURN: true, Transport: "udp", Err: fmt.Errorf("Allocate error response (error 401: Unauthorized)")},
+	}}
+	assert.Contains(t, strings.Join(badCredentials.Advice(), "\n"), "rejected the credentials")
+
+	assert.Contains(t, (&Diagnosis{}).String(), "none configured")
+}
@@ -0,0 +1,29 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrGatheringTimeout matches (via errors.Is) any *GatheringTimeoutError
+var ErrGatheringTimeout = errors.New("ICE gathering timed out")
+
+// GatheringTimeoutError is returned when the gathering deadline passed
+// before a single ICE candidate was found
+type GatheringTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *GatheringTimeoutError) Error() string {
+	return fmt.Sprintf("ICE gathering timed out after %s without any candidates", e.Timeout)
+}
+
+// Is lets errors.Is(err, ErrGatheringTimeout) match
+func (e *GatheringTimeoutError) Is(target error) bool {
+	return target == ErrGatheringTimeout
+}
+
+// ErrWouldBlock is returned by TrySend and TrySendChannel when the channel's
+// send buffer is full. The caller can retry later or use SendContext to wait
+var ErrWouldBlock = errors.New("data channel send buffer is full")
@@ -0,0 +1,143 @@
+package webrtc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// DefaultMaxBufferedAmount is how many bytes a data channel may have queued
+// before senders have to wait, when the config does not say otherwise
+const DefaultMaxBufferedAmount = 1 << 20
+
+// sendWindow wakes senders waiting for a data channel's buffer to drain
+type sendWindow struct {
+	mu      sync.Mutex
+	drained chan struct{}
+}
+
+func newSendWindow() *sendWindow {
+	return &sendWindow{drained: make(chan struct{})}
+}
+
+// wait returns a channel that is closed the next time the buffer drains
+// (or the data channel closes)
+func (w *sendWindow) wait() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.drained
+}
+
+// wake releases every sender currently waiting
+func (w *sendWindow) wake() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.drained)
+	w.drained = make(chan struct{})
+}
+
+// Sends raw bytes over the "chat" channel, waiting while its buffer is full
+func (p *RealPeer) SendContext(ctx context.Context, data []byte) error {
+	return p.SendChannelContext(ctx, DefaultChannel, data)
+}
+
+// Sends raw bytes over the "chat" channel, or returns ErrWouldBlock if its buffer is full
+func (p *RealPeer) TrySend(data []byte) error {
+	return p.TrySendChannel(DefaultChannel, data)
+}
+
+// Sends raw bytes over the named channel. While more than MaxBufferedAmount
+// bytes are queued it waits for the buffer to drain, until ctx is done or
+// the channel closes
+func (p *RealPeer) SendChannelContext(ctx context.Context, label string, data []byte) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// The channel can change under us when an ICE restart completes,
+		// so look it up again after every wait
+		dc, window, err := p.openChannel(label)
+		if err != nil {
+			return err
+		}
+
+		// Subscribe before checking so a drain in between is not missed
+		drained := window.wait()
+		if p.hasRoom(dc, len(data)) {
+			return dc.Send(data)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-drained:
+		}
+	}
+}
+
+// Sends raw bytes over the named channel, or returns ErrWouldBlock if more
+// than MaxBufferedAmount bytes would be queued
+func (p *RealPeer) TrySendChannel(label string, data []byte) error {
+	dc, _, err := p.openChannel(label)
+	if err != nil {
+		return err
+	}
+
+	if !p.hasRoom(dc, len(data)) {
+		return ErrWouldBlock
+	}
+
+	return dc.Send(data)
+}
+
+// openChannel returns the named channel and its send window if it is open
+func (p *RealPeer) openChannel(label string) (*webrtc.DataChannel, *sendWindow, error) {
+	p.mu.RLock()
+	dc := p.channels[label]
+	window := p.windows[dc]
+	p.mu.RUnlock()
+
+	if dc == nil || window == nil || dc.ReadyState() != webrtc.DataChannelStateOpen {
+		return nil, nil, webrtc.ErrDataChannelNotOpen
+	}
+
+	return dc, window, nil
+}
+
+// hasRoom reports whether size more bytes fit in the channel's buffer.
+// Once the buffer is at or below the low threshold a message goes through
+// whatever its size: the buffer will not cross the threshold again, so a
+// sender waiting for more room would never be woken
+func (p *RealPeer) hasRoom(dc *webrtc.DataChannel, size int) bool {
+	buffered := dc.BufferedAmount()
+	return buffered <= dc.BufferedAmountLowThreshold() || buffered+uint64(size) <= p.config.maxBufferedAmount()
+}
+
+// setupFlowControl wakes waiting senders when the channel's buffer drains
+// below half of MaxBufferedAmount or the channel closes
+func (p *RealPeer) setupFlowControl(dc *webrtc.DataChannel) *sendWindow {
+	window := newSendWindow()
+
+	p.mu.Lock()
+	p.windows[dc] = window
+	p.mu.Unlock()
+
+	dc.SetBufferedAmountLowThreshold(p.config.maxBufferedAmount() / 2)
+	dc.OnBufferedAmountLow(window.wake)
+
+	return window
+}
+
+// removeFlowControl forgets a closed channel and releases its waiting senders
+func (p *RealPeer) removeFlowControl(dc *webrtc.DataChannel) {
+	p.mu.Lock()
+	window := p.windows[dc]
+	delete(p.windows, dc)
+	p.mu.Unlock()
+
+	if window != nil {
+		window.wake()
+	}
+}
@@ -0,0 +1,205 @@
+package webrtc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smallBufferConfig returns a config whose channels block after a few chunks
+func smallBufferConfig() PeerConfig {
+	config := DefaultPeerConfig()
+	config.MaxBufferedAmount = 64 * 1024
+	return config
+}
+
+func TestRealPeer_SendBeforeOpen(t *testing.T) {
+	peer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer peer.Close()
+
+	assert.Error(t, peer.TrySend([]byte("hello")))
+	assert.Error(t, peer.SendContext(context.Background(), []byte("hello")))
+	assert.Error(t, peer.TrySendChannel("missing", []byte("hello")))
+}
+
+func TestRealPeer_TrySendWouldBlock(t *testing.T) {
+	offerer, err := NewRealPeerWithConfig(smallBufferConfig())
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	connectPeers(t, offerer, answerer)
+
+	// Queue faster than SCTP can drain until the limit is hit
+	chunk := make([]byte, 16*1024)
+	var sendErr error
+	for i := 0; i < 1000 && sendErr == nil; i++ {
+		sendErr = offerer.TrySend(chunk)
+	}
+	require.ErrorIs(t, sendErr, ErrWouldBlock)
+
+	chat, ok := offerer.Stats().Channel(DefaultChannel)
+	require.True(t, ok)
+	assert.LessOrEqual(t, chat.BufferedAmount, uint64(64*1024))
+}
+
+func TestRealPeer_SendContextWaitsForDrain(t *testing.T) {
+	offerer, err := NewRealPeerWithConfig(smallBufferConfig())
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	const chunks = 64
+	var mu sync.Mutex
+	var received []byte
+	done := make(chan struct{})
+	answerer.OnMessage(func(data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, data...)
+		if len(received) == chunks*16*1024 {
+			close(done)
+		}
+	})
+
+	connectPeers(t, offerer, answerer)
+
+	// 1 MiB through a 64 KiB window: every chunk has to wait for a drain
+	var sent []byte
+	for i := 0; i < chunks; i++ {
+		chunk := bytes.Repeat([]byte{byte(i)}, 16*1024)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		require.NoError(t, offerer.SendContext(ctx, chunk))
+		cancel()
+
+		chat, _ := offerer.Stats().Channel(DefaultChannel)
+		assert.LessOrEqual(t, chat.BufferedAmount, uint64(64*1024))
+		sent = append(sent, chunk...)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("transfer did not complete")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, sent, received)
+}
+
+func TestRealPeer_SendContextCancelled(t *testing.T) {
+	offerer, err := NewRealPeerWithConfig(smallBufferConfig())
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	connectPeers(t, offerer, answerer)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, offerer.SendContext(cancelled, []byte("late")), context.Canceled)
+
+	// A sender waiting on a full buffer gives up at its deadline or gets
+	// through once the buffer drains, but never hangs
+	chunk := make([]byte, 16*1024)
+	for offerer.TrySend(chunk) == nil {
+	}
+
+	ctx, cancelWait := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelWait()
+	err = offerer.SendContext(ctx, chunk)
+	if err != nil {
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	}
+}
+
+func TestRealPeer_SendWakesOnClose(t *testing.T) {
+	offerer, err := NewRealPeerWithConfig(smallBufferConfig())
+	require.NoError(t, err)
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	connectPeers(t, offerer, answerer)
+
+	chunk := make([]byte, 16*1024)
+	for offerer.TrySend(chunk) == nil {
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		for {
+			if err := offerer.Send(chunk); err != nil {
+				result <- err
+				return
+			}
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, offerer.Close())
+
+	select {
+	case err := <-result:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked sender was not released when the channel closed")
+	}
+}
+
+func TestRealPeer_SendContextLargeChunks(t *testing.T) {
+	offerer, err := NewRealPeerWithConfig(smallBufferConfig())
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	const chunks, size = 32, 40 * 1024
+	var mu sync.Mutex
+	received := 0
+	done := make(chan struct{})
+	answerer.OnMessage(func(data []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		received += len(data)
+		if received == chunks*size {
+			close(done)
+		}
+	})
+
+	connectPeers(t, offerer, answerer)
+
+	// Chunks over half the limit cannot fit beside a partly drained buffer,
+	// which never drains past the low threshold again once it is under it
+	for i := 0; i < chunks; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		require.NoError(t, offerer.SendContext(ctx, make([]byte, size)), "chunk %d", i)
+		cancel()
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("transfer did not complete")
+	}
+}
@@ -0,0 +1,312 @@
+package webrtc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/stun"
+)
+
+// NATBehavior is how a NAT maps or filters UDP traffic, in RFC 4787 terms
+type NATBehavior string
+
+const (
+	// NATUnknown means the servers could not tell, e.g. none of them
+	// supports RFC 5780
+	NATUnknown NATBehavior = "unknown"
+
+	// NATNone means the address is not translated at all (mapping only)
+	NATNone NATBehavior = "none"
+
+	NATEndpointIndependent     NATBehavior = "endpoint-independent"
+	NATAddressDependent        NATBehavior = "address-dependent"
+	NATAddressAndPortDependent NATBehavior = "address-and-port-dependent"
+
+	// NATEndpointDependent is address or address and port dependent; two
+	// unrelated servers cannot tell which
+	NATEndpointDependent NATBehavior = "endpoint-dependent"
+)
+
+// NATType is the behaviour of the NAT (or firewall) between this host and
+// the internet, found with the RFC 5780 tests
+type NATType struct {
+	// LocalAddress is the address the probes were sent from, PublicAddress
+	// the one the first server saw
+	LocalAddress  string
+	PublicAddress string
+
+	// Mapping tells whether the public address changes with the
+	// destination, Filtering which senders may answer through it
+	Mapping   NATBehavior
+	Filtering NATBehavior
+}
+
+// Description names the NAT the way most people know it
+func (n NATType) Description() string {
+	switch {
+	case n.Mapping == NATNone && n.Filtering == NATEndpointIndependent:
+		return "no NAT (open internet)"
+	case n.Mapping == NATNone && n.Filtering == NATUnknown:
+		return "no NAT"
+	case n.Mapping == NATNone:
+		return "no NAT, behind a firewall"
+	case n.Mapping == NATAddressDependent, n.Mapping == NATAddressAndPortDependent, n.Mapping == NATEndpointDependent:
+		return "symmetric NAT"
+	case n.Mapping == NATEndpointIndependent && n.Filtering == NATEndpointIndependent:
+		return "full cone NAT"
+	case n.Mapping == NATEndpointIndependent && n.Filtering == NATAddressDependent:
+		return "restricted cone NAT"
+	case n.Mapping == NATEndpointIndependent && n.Filtering == NATAddressAndPortDependent:
+		return "port restricted cone NAT"
+	case n.Mapping == NATEndpointIndependent:
+		return "cone NAT"
+	default:
+		return "unknown NAT"
+	}
+}
+
+// CHANGE-REQUEST flags (RFC 5780 section 7.2)
+const (
+	changePort = 0x02
+	changeIP   = 0x04
+)
+
+// errNoResponse is returned by binding when the server does not answer
+var errNoResponse = errors.New("no response")
+
+// bindingResponse is what a STUN server tells about a binding request
+type bindingResponse struct {
+	mapped *net.UDPAddr
+	other  *net.UDPAddr
+	rtt    time.Duration
+}
+
+// binding sends a Binding request from conn, retransmitting it until
+// timeout, and returns the server's answer. Answers may come from any
+// address, as RFC 5780 change requests do
+func binding(conn net.PacketConn, server net.Addr, change uint32, timeout time.Duration) (bindingResponse, error) {
+	setters := []stun.Setter{stun.TransactionID, stun.BindingRequest}
+	if change != 0 {
+		value := []byte{0, 0, 0, byte(change)}
+		setters = append(setters, stun.RawAttribute{Type: stun.AttrChangeRequest, Value: value, Length: 4})
+	}
+	setters = append(setters, stun.Fingerprint)
+
+	request, err := stun.Build(setters...)
+	if err != nil {
+		return bindingResponse{}, err
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	retransmit := min(timeout, 500*time.Millisecond)
+	buf := make([]byte, 1500)
+
+	for time.Now().Before(deadline) {
+		if _, err := conn.WriteTo(request.Raw, server); err != nil {
+			return bindingResponse{}, err
+		}
+
+		conn.SetReadDeadline(minTime(deadline, time.Now().Add(retransmit)))
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return bindingResponse{}, err
+			}
+
+			response := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if response.Decode() != nil || response.TransactionID != request.TransactionID {
+				continue
+			}
+			return parseBinding(response, time.Since(start))
+		}
+	}
+	return bindingResponse{}, errNoResponse
+}
+
+// parseBinding reads the addresses of a Binding response
+func parseBinding(response *stun.Message, rtt time.Duration) (bindingResponse, error) {
+	if response.Type.Class == stun.ClassErrorResponse {
+		var code stun.ErrorCodeAttribute
+		code.GetFrom(response)
+		return bindingResponse{}, fmt.Errorf("binding refused: %s", code)
+	}
+
+	result := bindingResponse{rtt: rtt}
+
+	var xorMapped stun.XORMappedAddress
+	var mapped stun.MappedAddress
+	switch {
+	case xorMapped.GetFrom(response) == nil:
+		result.mapped = &net.UDPAddr{IP: xorMapped.IP, Port: xorMapped.Port}
+	case mapped.GetFrom(response) == nil:
+		result.mapped = &net.UDPAddr{IP: mapped.IP, Port: mapped.Port}
+	default:
+		return bindingResponse{}, fmt.Errorf("binding response has no mapped address")
+	}
+
+	var other stun.OtherAddress
+	if other.GetFrom(response) == nil {
+		result.other = &net.UDPAddr{IP: other.IP, Port: other.Port}
+	}
+	return result, nil
+}
+
+// DetectNAT classifies the NAT in front of this host. The first server
+// that supports RFC 5780 (OTHER-ADDRESS and CHANGE-REQUEST) gives the full
+// picture; otherwise the mapping is found by comparing two servers and the
+// filtering stays unknown. timeout bounds each probe, some of which are
+// expected to go unanswered
+func DetectNAT(ctx context.Context, servers []*net.UDPAddr, timeout time.Duration) (NATType, error) {
+	if len(servers) == 0 {
+		return NATType{}, fmt.Errorf("no STUN server to probe")
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return NATType{}, err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	primary := servers[0]
+	first, err := binding(conn, primary, 0, timeout)
+	if err != nil {
+		return NATType{}, fmt.Errorf("STUN server %s: %w", primary, err)
+	}
+
+	local := &net.UDPAddr{Port: conn.LocalAddr().(*net.UDPAddr).Port}
+	if local.IP, err = localIPFor(primary.IP); err != nil {
+		return NATType{}, err
+	}
+
+	nat := NATType{
+		LocalAddress:  local.String(),
+		PublicAddress: first.mapped.String(),
+		Mapping:       NATUnknown,
+		Filtering:     NATUnknown,
+	}
+
+	switch {
+	case sameAddr(first.mapped, local):
+		nat.Mapping = NATNone
+
+	case first.other != nil:
+		// Test II: the other IP, same port
+		second, err := binding(conn, &net.UDPAddr{IP: first.other.IP, Port: primary.Port}, 0, timeout)
+		if err != nil {
+			return nat, ctxErr(ctx, err)
+		}
+		if sameAddr(second.mapped, first.mapped) {
+			nat.Mapping = NATEndpointIndependent
+			break
+		}
+
+		// Test III: the other IP and port
+		third, err := binding(conn, first.other, 0, timeout)
+		if err != nil {
+			return nat, ctxErr(ctx, err)
+		}
+		if sameAddr(third.mapped, second.mapped) {
+			nat.Mapping = NATAddressDependent
+		} else {
+			nat.Mapping = NATAddressAndPortDependent
+		}
+
+	default:
+		for _, server := range servers[1:] {
+			if server.IP.Equal(primary.IP) {
+				continue
+			}
+			second, err := binding(conn, server, 0, timeout)
+			if err != nil {
+				continue
+			}
+			if sameAddr(second.mapped, first.mapped) {
+				nat.Mapping = NATEndpointIndependent
+			} else {
+				nat.Mapping = NATEndpointDependent
+			}
+			break
+		}
+	}
+
+	if first.other == nil {
+		return nat, nil
+	}
+
+	nat.Filtering, err = detectFiltering(ctx, primary, timeout)
+	return nat, err
+}
+
+// detectFiltering runs the RFC 5780 filtering tests: ask the server to answer
+// from elsewhere. They need a socket of their own, one that has only talked
+// to the primary address: the mapping tests sent to the other address, which
+// opened the NAT's filter for it
+func detectFiltering(ctx context.Context, primary *net.UDPAddr, timeout time.Duration) (NATBehavior, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return NATUnknown, err
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	_, err = binding(conn, primary, changeIP|changePort, timeout)
+	switch {
+	case err == nil:
+		return NATEndpointIndependent, nil
+	case !errors.Is(err, errNoResponse):
+		return NATUnknown, ctxErr(ctx, err)
+	}
+
+	_, err = binding(conn, primary, changePort, timeout)
+	switch {
+	case err == nil:
+		return NATAddressDependent, nil
+	case errors.Is(err, errNoResponse):
+		return NATAddressAndPortDependent, nil
+	default:
+		return NATUnknown, ctxErr(ctx, err)
+	}
+}
+
+// sameAddr compares two UDP addresses
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+// ctxErr prefers the context's error over the one its cancellation caused
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// localIPFor returns the local address used to reach remote. Nothing is sent
+func localIPFor(remote net.IP) (net.IP, error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: remote, Port: 9})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,229 @@
+package webrtc
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"time"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+// Defaults pion uses for the ICE timeouts, applied to the ones a
+// NetworkConfig leaves at zero when it sets any of them
+const (
+	DefaultDisconnectedTimeout = 5 * time.Second
+	DefaultFailedTimeout       = 25 * time.Second
+	DefaultKeepaliveInterval   = 2 * time.Second
+)
+
+// IPVersion selects the address families ICE gathers candidates for
+type IPVersion string
+
+const (
+	// IPVersionBoth gathers IPv4 and IPv6 candidates (the default)
+	IPVersionBoth IPVersion = "both"
+
+	// IPVersion4 only gathers IPv4 candidates
+	IPVersion4 IPVersion = "ipv4"
+
+	// IPVersion6 only gathers IPv6 candidates
+	IPVersion6 IPVersion = "ipv6"
+)
+
+// NetworkConfig controls which local interfaces, addresses and ports ICE
+// uses, e.g. to keep VPN, Docker and VM interfaces out of the offer. The
+// zero value uses everything, like a browser does
+type NetworkConfig struct {
+	// Interfaces lists the interfaces ICE may use, by name or path.Match
+	// pattern ("eth0", "en*"). Empty means all of them
+	Interfaces []string `json:"interfaces,omitempty"`
+
+	// ExcludeInterfaces lists interfaces ICE must not use ("docker*", "utun*")
+	ExcludeInterfaces []string `json:"excludeInterfaces,omitempty"`
+
+	// AllowedNetworks limits host candidates to addresses in these CIDRs.
+	// Empty means any address
+	AllowedNetworks []string `json:"allowedNetworks,omitempty"`
+
+	// BlockedNetworks keeps addresses in these CIDRs out of host candidates
+	BlockedNetworks []string `json:"blockedNetworks,omitempty"`
+
+	// PortMin and PortMax bound the local UDP ports, e.g. to match a
+	// firewall rule. Zero means any port
+	PortMin uint16 `json:"portMin,omitempty"`
+	PortMax uint16 `json:"portMax,omitempty"`
+
+	// IPVersion is "ipv4", "ipv6" or "both". Empty means both
+	IPVersion IPVersion `json:"ipVersion,omitempty"`
+
+	// MDNS replaces the address of host candidates with a random
+	// "<uuid>.local" name that only resolves on the local network
+	MDNS bool `json:"mdns,omitempty"`
+
+	// DisconnectedTimeout, FailedTimeout and KeepaliveInterval tune how
+	// quickly ICE notices a dead path. Zero means the pion default
+	DisconnectedTimeout Duration `json:"disconnectedTimeout,omitempty"`
+	FailedTimeout       Duration `json:"failedTimeout,omitempty"`
+	KeepaliveInterval   Duration `json:"keepaliveInterval,omitempty"`
+}
+
+// validate checks patterns, networks, ports and timeouts
+func (n NetworkConfig) validate() error {
+	for _, pattern := range append(append([]string(nil), n.Interfaces...), n.ExcludeInterfaces...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid interface pattern %q: %w", pattern, err)
+		}
+	}
+
+	if _, err := parseNetworks(n.AllowedNetworks); err != nil {
+		return err
+	}
+	if _, err := parseNetworks(n.BlockedNetworks); err != nil {
+		return err
+	}
+
+	if (n.PortMin == 0) != (n.PortMax == 0) {
+		return fmt.Errorf("port range needs both portMin and portMax")
+	}
+	if n.PortMin > n.PortMax {
+		return fmt.Errorf("invalid port range %d-%d", n.PortMin, n.PortMax)
+	}
+
+	switch n.IPVersion {
+	case "", IPVersionBoth, IPVersion4, IPVersion6:
+	default:
+		return fmt.Errorf("invalid ip version %q", n.IPVersion)
+	}
+
+	if n.DisconnectedTimeout < 0 || n.FailedTimeout < 0 || n.KeepaliveInterval < 0 {
+		return fmt.Errorf("ice timeouts cannot be negative")
+	}
+
+	return nil
+}
+
+// settingEngine translates the config into pion's SettingEngine
+func (n NetworkConfig) settingEngine() (webrtc.SettingEngine, error) {
+	var settings webrtc.SettingEngine
+
+	if len(n.Interfaces) > 0 || len(n.ExcludeInterfaces) > 0 {
+		settings.SetInterfaceFilter(n.interfaceAllowed)
+	}
+
+	filter, err := n.ipFilter()
+	if err != nil {
+		return settings, err
+	}
+	if filter != nil {
+		settings.SetIPFilter(filter)
+	}
+
+	if n.PortMin != 0 {
+		if err := settings.SetEphemeralUDPPortRange(n.PortMin, n.PortMax); err != nil {
+			return settings, fmt.Errorf("invalid port range: %w", err)
+		}
+	}
+
+	switch n.IPVersion {
+	case IPVersion4:
+		settings.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeTCP4})
+	case IPVersion6:
+		settings.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP6, webrtc.NetworkTypeTCP6})
+	}
+
+	if n.MDNS {
+		settings.SetICEMulticastDNSMode(ice.MulticastDNSModeQueryAndGather)
+	}
+
+	if n.DisconnectedTimeout != 0 || n.FailedTimeout != 0 || n.KeepaliveInterval != 0 {
+		settings.SetICETimeouts(
+			orDefault(n.DisconnectedTimeout, DefaultDisconnectedTimeout),
+			orDefault(n.FailedTimeout, DefaultFailedTimeout),
+			orDefault(n.KeepaliveInterval, DefaultKeepaliveInterval),
+		)
+	}
+
+	return settings, nil
+}
+
+// interfaceAllowed reports whether ICE may gather on the named interface
+func (n NetworkConfig) interfaceAllowed(name string) bool {
+	for _, pattern := range n.ExcludeInterfaces {
+		if matched, _ := path.Match(pattern, name); matched {
+			return false
+		}
+	}
+
+	if len(n.Interfaces) == 0 {
+		return true
+	}
+	for _, pattern := range n.Interfaces {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilter returns the filter of AllowedNetworks and BlockedNetworks, or
+// nil if neither is set
+func (n NetworkConfig) ipFilter() (func(net.IP) bool, error) {
+	if len(n.AllowedNetworks) == 0 && len(n.BlockedNetworks) == 0 {
+		return nil, nil
+	}
+
+	allowed, err := parseNetworks(n.AllowedNetworks)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := parseNetworks(n.BlockedNetworks)
+	if err != nil {
+		return nil, err
+	}
+	return func(ip net.IP) bool {
+		return ipAllowed(ip, allowed, blocked)
+	}, nil
+}
+
+// ipAllowed reports whether ip is outside blocked and, if allowed is not
+// empty, inside one of allowed
+func ipAllowed(ip net.IP, allowed, blocked []*net.IPNet) bool {
+	for _, network := range blocked {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, network := range allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNetworks parses a list of CIDRs
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// orDefault returns d, or fallback if d is zero
+func orDefault(d Duration, fallback time.Duration) time.Duration {
+	if d == 0 {
+		return fallback
+	}
+	return time.Duration(d)
+}
@@ -0,0 +1,160 @@
+package webrtc
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hostCandidates returns the address and port of every host candidate in an encoded SDP
+func hostCandidates(t *testing.T, sdp string) [][2]string {
+	t.Helper()
+
+	var candidates [][2]string
+	for _, line := range strings.Split(sdp, `\r\n`) {
+		value, ok := strings.CutPrefix(line, "a=candidate:")
+		fields := strings.Fields(value)
+		if ok && len(fields) >= 8 && fields[7] == "host" {
+			candidates = append(candidates, [2]string{fields[4], fields[5]})
+		}
+	}
+	return candidates
+}
+
+func TestNetworkConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		network NetworkConfig
+		wantErr string
+	}{
+		{name: "zero value", network: NetworkConfig{}},
+		{name: "full", network: NetworkConfig{
+			Interfaces:          []string{"eth*"},
+			ExcludeInterfaces:   []string{"docker*"},
+			AllowedNetworks:     []string{"10.0.0.0/8"},
+			BlockedNetworks:     []string{"172.17.0.0/16", "fd00::/8"},
+			PortMin:             50000,
+			PortMax:             50100,
+			IPVersion:           IPVersion4,
+			MDNS:                true,
+			DisconnectedTimeout: Duration(3 * time.Second),
+		}},
+		{name: "bad pattern", network: NetworkConfig{ExcludeInterfaces: []string{"[docker"}}, wantErr: "interface pattern"},
+		{name: "bad network", network: NetworkConfig{BlockedNetworks: []string{"172.17.0.0"}}, wantErr: "invalid network"},
+		{name: "half port range", network: NetworkConfig{PortMin: 50000}, wantErr: "port range"},
+		{name: "reversed port range", network: NetworkConfig{PortMin: 50100, PortMax: 50000}, wantErr: "port range"},
+		{name: "bad ip version", network: NetworkConfig{IPVersion: "ipv5"}, wantErr: "ip version"},
+		{name: "negative timeout", network: NetworkConfig{FailedTimeout: -1}, wantErr: "negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultPeerConfig()
+			config.Network = tt.network
+
+			err := config.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNetworkConfig_Filters(t *testing.T) {
+	network := NetworkConfig{
+		Interfaces:        []string{"eth*", "wlan0"},
+		ExcludeInterfaces: []string{"eth9"},
+	}
+	assert.True(t, network.interfaceAllowed("eth0"))
+	assert.True(t, network.interfaceAllowed("wlan0"))
+	assert.False(t, network.interfaceAllowed("eth9"))
+	assert.False(t, network.interfaceAllowed("docker0"))
+	assert.True(t, NetworkConfig{ExcludeInterfaces: []string{"utun*"}}.interfaceAllowed("en0"))
+
+	allowed, err := parseNetworks([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	blocked, err := parseNetworks([]string{"10.99.0.0/16"})
+	require.NoError(t, err)
+	assert.True(t, ipAllowed(net.ParseIP("10.1.2.3"), allowed, blocked))
+	assert.False(t, ipAllowed(net.ParseIP("10.99.2.3"), allowed, blocked))
+	assert.False(t, ipAllowed(net.ParseIP("192.168.1.2"), allowed, blocked))
+	assert.True(t, ipAllowed(net.ParseIP("192.168.1.2"), nil, blocked))
+}
+
+func TestRealPeer_PortRangeAndIPVersion(t *testing.T) {
+	config := PeerConfig{Network: NetworkConfig{PortMin: 41000, PortMax: 41100, IPVersion: IPVersion4}}
+
+	offerer, err := NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	offer, err := offerer.CreateOffer()
+	require.NoError(t, err)
+
+	candidates := hostCandidates(t, offer)
+	require.NotEmpty(t, candidates)
+	for _, candidate := range candidates {
+		ip := net.ParseIP(candidate[0])
+		require.NotNil(t, ip, candidate[0])
+		assert.NotNil(t, ip.To4(), "not an IPv4 address: %s", candidate[0])
+
+		port, err := strconv.Atoi(candidate[1])
+		require.NoError(t, err)
+		assert.True(t, port >= 41000 && port <= 41100, "port %d out of range", port)
+	}
+
+	answer, err := answerer.CreateAnswer(offer)
+	require.NoError(t, err)
+	require.NoError(t, offerer.SetRemoteAnswer(answer))
+	require.Eventually(t, func() bool {
+		return channelOpen(offerer, DefaultChannel) && channelOpen(answerer, DefaultChannel)
+	}, 10*time.Second, 20*time.Millisecond, "data channel never opened")
+
+	stats := offerer.Stats()
+	require.NotNil(t, stats.Local)
+	assert.True(t, stats.Local.Port >= 41000 && stats.Local.Port <= 41100)
+}
+
+func TestRealPeer_ExcludedInterfaces(t *testing.T) {
+	peer, err := NewRealPeerWithConfig(PeerConfig{Network: NetworkConfig{ExcludeInterfaces: []string{"*"}}})
+	require.NoError(t, err)
+	defer peer.Close()
+
+	offer, err := peer.CreateOffer()
+	require.NoError(t, err)
+	assert.Empty(t, hostCandidates(t, offer))
+
+	blocked, err := NewRealPeerWithConfig(PeerConfig{Network: NetworkConfig{BlockedNetworks: []string{"0.0.0.0/0", "::/0"}}})
+	require.NoError(t, err)
+	defer blocked.Close()
+
+	offer, err = blocked.CreateOffer()
+	require.NoError(t, err)
+	assert.Empty(t, hostCandidates(t, offer))
+}
+
+func TestRealPeer_MDNS(t *testing.T) {
+	peer, err := NewRealPeerWithConfig(PeerConfig{Network: NetworkConfig{MDNS: true}})
+	require.NoError(t, err)
+	defer peer.Close()
+
+	offer, err := peer.CreateOffer()
+	require.NoError(t, err)
+
+	candidates := hostCandidates(t, offer)
+	require.NotEmpty(t, candidates)
+	for _, candidate := range candidates {
+		assert.True(t, strings.HasSuffix(candidate[0], ".local"), "host address not hidden: %s", candidate[0])
+	}
+}
@@ -32,9 +32,13 @@ Don't worry about TURN servers yet - just STUN for direct connections
 */
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pion/webrtc/v3"
 )
@@ -43,23 +47,90 @@ type Peer interface {
 	// Creates and returns an SDP offer as a string
 	CreateOffer() (string, error)
 
+	// Like CreateOffer, but gives up when ctx is done
+	CreateOfferContext(ctx context.Context) (string, error)
+
+	// Creates an offer with new ICE credentials so a connection that went
+	// disconnected/failed (e.g. after a network change) can recover. The
+	// answer is applied with SetRemoteAnswer as usual
+	RestartICE(ctx context.Context) (string, error)
+
 	// Sets the remote SDP answer
 	SetRemoteAnswer(sdp string) error
 
 	// Creates and returns an SDP answer as a string for the given offer
 	CreateAnswer(offer string) (string , error)
 
+	// Like CreateAnswer, but gives up when ctx is done
+	CreateAnswerContext(ctx context.Context, offer string) (string, error)
+
 	// Sets the remote SDP offer
 	SetRemoteOffer(sdp string) error
 
-	// Sends raw byte over the datachannel
+	// Sends raw byte over the datachannel, waiting while its send buffer is full
 	Send(data []byte) error
 
+	// Like Send, but gives up when ctx is done
+	SendContext(ctx context.Context, data []byte) error
+
+	// Like Send, but returns ErrWouldBlock instead of waiting
+	TrySend(data []byte) error
+
 	// Registers a callback for incoming messages
 	OnMessage(callback func([]byte))
 
+	// Opens an additional named data channel, reliable or not depending on
+	// options. Channels opened before CreateOffer are part of the offer;
+	// later ones are opened in-band
+	OpenChannel(label string, options ChannelOptions) error
+
+	// Sends raw bytes over the named data channel, with the same flow
+	// control as Send
+	SendChannel(label string, data []byte) error
+
+	// Like SendChannel, but gives up when ctx is done
+	SendChannelContext(ctx context.Context, label string, data []byte) error
+
+	// Like SendChannel, but returns ErrWouldBlock instead of waiting
+	TrySendChannel(label string, data []byte) error
+
+	// Registers a callback for messages arriving on the named channel
+	OnChannelMessage(label string, callback func([]byte))
+
+	// Registers a callback fired whenever a channel (local or remote) opens
+	OnChannelOpen(callback func(label string))
+
 	// Registers a callback for connection state change
-	OnStateChange(callback func(string))
+	OnStateChange(callback func(ConnectionState))
+
+	// Registers a callback for ICE connectivity state changes
+	OnICEStateChange(callback func(ICEState))
+
+	// Registers a callback for local candidate gathering state changes
+	OnGatheringStateChange(callback func(GatheringState))
+
+	// Registers a callback for offer/answer (signaling) state changes
+	OnSignalingStateChange(callback func(SignalingState))
+
+	// Returns the selected candidate pair, RTT and data channel counters
+	Stats() Stats
+
+	// Returns the DTLS fingerprint ("sha-256 AB:CD:...") of this peer's certificate
+	LocalFingerprint() string
+
+	// Returns the DTLS fingerprint the remote peer announced in its
+	// description, or "" before it is set
+	RemoteFingerprint() string
+
+	// Registers a callback for locally gathered ICE candidates (trickle ICE).
+	// While a callback is registered CreateOffer and CreateAnswer return as soon
+	// as the local description is set instead of waiting for gathering to finish.
+	// An empty string signals the end of candidates
+	OnICECandidate(callback func(candidate string))
+
+	// Adds a remote ICE candidate received over signaling. Candidates that
+	// arrive before the remote description are queued until it is set
+	AddICECandidate(candidate string) error
 
 	// Closes the peer connection
 	Close() error
@@ -69,11 +140,40 @@ type Peer interface {
 // RealPeer implements the peer interface using pion/webrtc
 type RealPeer struct {
 	pc          *webrtc.PeerConnection
-	dataChannel *webrtc.DataChannel
+	config      PeerConfig
+
+	// Creates every connection of this peer with the config's network settings
+	api *webrtc.API
+
+	// DTLS certificate shared by the active connection and restart standbys
+	certificate webrtc.Certificate
+	fingerprint string
+
+	// Port forwarded by the config's PortMapper, nil without one
+	mapping *portMapping
+
+	// Data channels by label, "chat" is always the default one
+	channels map[string]*webrtc.DataChannel
+
+	// Flow control state of every channel, active or standby
+	windows map[*webrtc.DataChannel]*sendWindow
 
 	// Callbacks
-	onMessage func([]byte)
-	onStateChange func(string)
+	channelHandlers map[string]func([]byte)
+	onChannelOpen func(string)
+	onStateChange func(ConnectionState)
+	onICEStateChange func(ICEState)
+	onGatheringStateChange func(GatheringState)
+	onSignalingStateChange func(SignalingState)
+	onICECandidate func(string)
+
+	// Remote candidates received before the remote description was set
+	pendingCandidates []webrtc.ICECandidateInit
+
+	// Connection being negotiated by an ICE restart, promoted to pc once
+	// it is connected and all of its channels are open
+	standby         *webrtc.PeerConnection
+	standbyChannels map[string]*webrtc.DataChannel
 
 	// Mutex to protect callback assignment
 	mu sync.RWMutex
@@ -81,69 +181,219 @@ type RealPeer struct {
 
 // Creates a new RealPeer with basic STUN config
 func NewRealPeer() (*RealPeer, error){
-	// Configure ICE servers with Google STUN
-	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
-			},
-		},
+	return NewRealPeerWithConfig(DefaultPeerConfig())
+}
+
+// Creates a new RealPeer using the given ICE servers and transport policy
+func NewRealPeerWithConfig(config PeerConfig) (*RealPeer, error){
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
 
-	// Create a peer connection
-	pc, err := webrtc.NewPeerConnection(config)
+	certificate, fingerprint, err := peerCertificate(config)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := config.Network.settingEngine()
 	if err != nil {
 		return nil, err
 	}
 
+	mapping := config.mapPort(&settings)
+
 	peer := &RealPeer{
-		pc: pc,
+		config: config,
+		api: webrtc.NewAPI(webrtc.WithSettingEngine(settings)),
+		certificate: certificate,
+		fingerprint: fingerprint,
+		mapping: mapping,
+		channels: make(map[string]*webrtc.DataChannel),
+		windows: make(map[*webrtc.DataChannel]*sendWindow),
+		channelHandlers: make(map[string]func([]byte)),
+	}
+
+	// Create a peer connection
+	pc, err := peer.newPeerConnection()
+	if err != nil {
+		if mapping != nil {
+			mapping.close()
+		}
+		return nil, err
+	}
+	peer.pc = pc
+
+	return peer, nil
+}
+
+// newPeerConnection creates a pion PeerConnection wired to this peer. The
+// handlers check whether pc is the active connection or a restart standby
+func (p *RealPeer) newPeerConnection() (*webrtc.PeerConnection, error) {
+	config, err := p.config.withCredentials()
+	if err != nil {
+		return nil, err
 	}
 
+	configuration := config.toPion()
+	configuration.Certificates = []webrtc.Certificate{p.certificate}
+
+	pc, err := p.api.NewPeerConnection(configuration)
+	if err != nil {
+		return nil, err
+	}
+
+	// Channels opened by the remote side (as the answerer we receive "chat" here)
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		log.Printf("Data Channel '%s' received", dc.Label())
+		if p.isStandby(pc) {
+			p.addStandbyChannel(dc)
+			return
+		}
+		p.addChannel(dc)
+	})
+
 	// Set up connection state change handler
 	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState){
 		log.Printf("Connection state changed: %s", state.String())
-		peer.mu.RLock()
-		callback := peer.onStateChange
-		peer.mu.RUnlock()
 
-		if callback != nil {
-			callback(state.String())
+		if p.isStandby(pc) {
+			p.standbyStateChanged(state)
+			return
+		}
+
+		p.mu.RLock()
+		active := p.pc == pc
+		callback := p.onStateChange
+		p.mu.RUnlock()
+
+		// A connection replaced by an ICE restart no longer reports
+		if active && callback != nil {
+			callback(connectionState(state))
 		}
 	})
 
-	// Setup ICE connection state change handler for additional logging
+	// Forward gathered candidates for trickle ICE
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate){
+		p.mu.RLock()
+		active := p.pc == pc
+		callback := p.onICECandidate
+		p.mu.RUnlock()
+
+		// Restart offers carry their candidates in the SDP
+		if callback == nil || !active {
+			return
+		}
+
+		// A nil candidate means gathering is complete
+		if candidate == nil {
+			if mapping, local := p.portMapping(), pc.LocalDescription(); mapping != nil && local != nil {
+				callback(mapping.trickleCandidate(firstMid(local.SDP)))
+			}
+			callback("")
+			return
+		}
+
+		candidateJSON, err := json.Marshal(candidate.ToJSON())
+		if err != nil {
+			log.Printf("Failed to encode ICE candidate: %v", err)
+			return
+		}
+
+		encoded := string(candidateJSON)
+		if p.config.Privacy {
+			var ok bool
+			if encoded, ok = privateTrickleCandidate(encoded); !ok {
+				return
+			}
+		}
+		callback(encoded)
+	})
+
+	// The finer-grained states are only reported for the active connection
 	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState){
 		log.Printf("ICE connection state changed: %s", state.String())
+
+		p.mu.RLock()
+		active := p.pc == pc
+		callback := p.onICEStateChange
+		p.mu.RUnlock()
+
+		if active && callback != nil {
+			callback(iceState(state))
+		}
 	})
 
-	return peer, nil
+	pc.OnICEGatheringStateChange(func(state webrtc.ICEGathererState){
+		p.mu.RLock()
+		active := p.pc == pc
+		callback := p.onGatheringStateChange
+		p.mu.RUnlock()
+
+		if active && callback != nil {
+			callback(gatheringState(state))
+		}
+	})
+
+	pc.OnSignalingStateChange(func(state webrtc.SignalingState){
+		p.mu.RLock()
+		active := p.pc == pc
+		callback := p.onSignalingStateChange
+		p.mu.RUnlock()
+
+		if active && callback != nil {
+			callback(signalingState(state))
+		}
+	})
+
+	return pc, nil
+}
+
+// conn returns the active peer connection
+func (p *RealPeer) conn() *webrtc.PeerConnection {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.pc
 }
 
 // Creates and return an SDP offer as a string
 func (p *RealPeer) CreateOffer() (string, error){
-	// Create the data channel first (as the offerer)
-	if err := p.CreateDataChannel(); err != nil {
+	return p.CreateOfferContext(context.Background())
+}
+
+// Creates an SDP offer, waiting for ICE gathering until it completes, the
+// configured gathering timeout passes or ctx is done
+func (p *RealPeer) CreateOfferContext(ctx context.Context) (string, error){
+	if err := ctx.Err(); err != nil {
 		return "", err
 	}
 
+	// Create the data channel first (as the offerer), unless it already exists
+	if !p.hasChannel(DefaultChannel) {
+		if err := p.CreateDataChannel(); err != nil {
+			return "", err
+		}
+	}
+
+	pc := p.conn()
+
 	// Create offer 
-	offer, err := p.pc.CreateOffer(nil)
+	offer, err := pc.CreateOffer(nil)
 	if err != nil {
 		return "", err
 	}
 
 	// Set local description
-	if err := p.pc.SetLocalDescription(offer); err != nil {
+	if err := pc.SetLocalDescription(offer); err != nil {
 		return "", err
 	}
 
-	// Wait for ICE gathering to complete
-	gatherComplete := webrtc.GatheringCompletePromise(p.pc)
-	<-gatherComplete
+	// Wait for ICE gathering to complete (unless candidates are trickled)
+	if err := p.waitForCandidates(ctx, pc); err != nil {
+		return "", err
+	}
 
 	// return the complete SDP as JSON string
-	return p.sdpToString(p.pc.LocalDescription())
+	return p.sdpToString(pc.LocalDescription())
 }
 
 // Sets the remote SDP answer
@@ -153,33 +403,61 @@ func (p *RealPeer) SetRemoteAnswer(sdp string) error {
 		return err
 	}
 
-	return p.pc.SetRemoteDescription(*sessionDesc)
+	// The answer to an ICE restart belongs to the standby connection
+	if standby := p.pendingStandbyOffer(); standby != nil {
+		return standby.SetRemoteDescription(*sessionDesc)
+	}
+
+	pc := p.conn()
+	if err := pc.SetRemoteDescription(*sessionDesc); err != nil {
+		return err
+	}
+
+	return p.flushPendingCandidates(pc)
 }
 
 // Creates and returns an SDP answer as a string for the given offer
 func (p *RealPeer) CreateAnswer(offer string) (string, error) {
+	return p.CreateAnswerContext(context.Background(), offer)
+}
+
+// Creates an SDP answer, waiting for ICE gathering until it completes, the
+// configured gathering timeout passes or ctx is done
+func (p *RealPeer) CreateAnswerContext(ctx context.Context, offer string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	// An offer for a new session on an established peer is an ICE restart
+	if p.isRestartOffer(offer) {
+		return p.answerRestart(ctx, offer)
+	}
+
 	// Set the remote offer first
 	if err := p.SetRemoteOffer(offer); err != nil {
 		return "", err
 	}
 
+	pc := p.conn()
+
 	// Create answer 
-	answer, err := p.pc.CreateAnswer(nil)
+	answer, err := pc.CreateAnswer(nil)
 	if err != nil {
 		return "", err
 	}
 
 	// Set local description
-	if err := p.pc.SetLocalDescription(answer); err != nil {
+	if err := pc.SetLocalDescription(answer); err != nil {
 		return "", err
 	}
 
-	// Wait for ICE gathering to complete
-	gatherComplete := webrtc.GatheringCompletePromise(p.pc)
-	<-gatherComplete
+	// Wait for ICE gathering to complete (unless candidates are trickled)
+	if err := p.waitForCandidates(ctx, pc); err != nil {
+		return "", err
+	}
 
 	// Return the complete SDP as JSON string
-	return p.sdpToString(p.pc.LocalDescription())
+	return p.sdpToString(pc.LocalDescription())
 }
 
 // Sets the remote SDP offer
@@ -189,111 +467,180 @@ func (p *RealPeer) SetRemoteOffer(sdp string) error{
 		return err
 	}
 
+	pc := p.conn()
+
 	// Set remote description
-	if err := p.pc.SetRemoteDescription(*sessionDesc) ; err != nil {
+	if err := pc.SetRemoteDescription(*sessionDesc) ; err != nil {
 		return err
 	}
 
-	// Set up data channel handler (as the answerer we receive the channel)
-	p.pc.OnDataChannel(func(dc *webrtc.DataChannel) {
-		log.Printf("Data Channel '%s' opened", dc.Label())
-		p.dataChannel = dc
-		p.setupDataChannelHandlers()
-	})
-
-	return nil
+	return p.flushPendingCandidates(pc)
 }
 
-// Sends raw bytes over the datachannel
+// Sends raw bytes over the default "chat" datachannel
 func (p *RealPeer) Send(data []byte) error {
-	if p.dataChannel == nil {
-		return webrtc.ErrDataChannelNotOpen
-	}
+	return p.SendChannel(DefaultChannel, data)
+}
 
-	if p.dataChannel.ReadyState() != webrtc.DataChannelStateOpen {
-		return webrtc.ErrDataChannelNotOpen
-	}
+// On message registers a callback for incoming messages on the "chat" channel
+func (p *RealPeer) OnMessage(callback func([]byte)){
+	p.OnChannelMessage(DefaultChannel, callback)
+}
+
+// Registers a callback for connection state change
+func (p *RealPeer) OnStateChange(callback func(ConnectionState)){
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onStateChange = callback
+}
 
-	return p.dataChannel.Send(data)
+// Registers a callback for ICE connectivity state changes
+func (p *RealPeer) OnICEStateChange(callback func(ICEState)){
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onICEStateChange = callback
 }
 
-// On message registers a callback for incoming messages
-func (p *RealPeer) OnMessage(callback func([]byte)){
+// Registers a callback for candidate gathering state changes
+func (p *RealPeer) OnGatheringStateChange(callback func(GatheringState)){
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.onMessage = callback
+	p.onGatheringStateChange = callback
 }
 
-// Registers a callback for connection state change
-func (p *RealPeer) OnStateChange(callback func(string)){
+// Registers a callback for signaling state changes
+func (p *RealPeer) OnSignalingStateChange(callback func(SignalingState)){
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.onStateChange = callback
+	p.onSignalingStateChange = callback
+}
+
+// Registers a callback for locally gathered ICE candidates
+func (p *RealPeer) OnICECandidate(callback func(string)){
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onICECandidate = callback
+}
+
+// Adds a remote ICE candidate (JSON encoded RTCIceCandidateInit)
+func (p *RealPeer) AddICECandidate(candidate string) error {
+	var init webrtc.ICECandidateInit
+	if candidate != "" {
+		if err := json.Unmarshal([]byte(candidate), &init); err != nil {
+			return fmt.Errorf("invalid ICE candidate: %w", err)
+		}
+	}
+
+	// Queue until we have a remote description to match it against
+	p.mu.Lock()
+	pc := p.pc
+	if pc.RemoteDescription() == nil {
+		p.pendingCandidates = append(p.pendingCandidates, init)
+		p.mu.Unlock()
+		return nil
+	}
+	p.mu.Unlock()
+
+	return pc.AddICECandidate(init)
 }
 
 // Closes the peer connection
 func (p *RealPeer) Close() error {
-	if p.dataChannel != nil {
-		if err := p.dataChannel.Close() ; err != nil {
-			log.Printf("Error closing data channel: %v", err)
+	p.mu.Lock()
+	channels := make([]*webrtc.DataChannel, 0, len(p.channels))
+	for _, dc := range p.channels {
+		channels = append(channels, dc)
+	}
+	pc := p.pc
+	standby := p.standby
+	p.standby = nil
+	p.standbyChannels = nil
+	p.mu.Unlock()
+
+	for _, dc := range channels {
+		if err := dc.Close() ; err != nil {
+			log.Printf("Error closing data channel '%s': %v", dc.Label(), err)
 		}
 	}
 
-	if p.pc != nil {
-		if err := p.pc.Close(); err != nil {
+	if standby != nil {
+		if err := standby.Close(); err != nil {
+			log.Printf("Error closing standby peer connection: %v", err)
+		}
+	}
+
+	if pc != nil {
+		if err := pc.Close(); err != nil {
 			log.Printf("Error closing peer connection: %v", err)
 			return err
 		}
 	}
 
-	return nil
-}
+	p.mu.Lock()
+	mapping := p.mapping
+	p.mapping = nil
+	p.mu.Unlock()
 
-// Creates the "chat" data channel with ordered delivery
-func (p *RealPeer) CreateDataChannel()error {
-	// Configure data channel with oredered delivery 
-	dcConfig := &webrtc.DataChannelInit{
-		Ordered: &[]bool{true}[0],
+	if mapping != nil {
+		mapping.close()
 	}
-	
-	// Create data channel
-	dc , err := p.pc.CreateDataChannel("chat", dcConfig)
-	if err != nil {
-		return err
-	}
-
-	p.dataChannel = dc
-	p.setupDataChannelHandlers()
 
 	return nil
 }
 
-// Sets up event handlers for data channel
-func (p *RealPeer) setupDataChannelHandlers(){
-	p.dataChannel.OnOpen(func() {
-		log.Printf("Data channel opened")
-	})
+// Creates the "chat" data channel with ordered delivery
+func (p *RealPeer) CreateDataChannel()error {
+	return p.OpenChannel(DefaultChannel, ReliableChannel())
+}
 
-	p.dataChannel.OnMessage(func(msg webrtc.DataChannelMessage){
-		log.Printf("Received message: %s", string(msg.Data))
+// Blocks until ICE gathering on pc is complete, unless a trickle callback is
+// registered and pc is the active connection.
+// When the gathering timeout passes the candidates found so far are used;
+// only if there are none is a *GatheringTimeoutError returned
+func (p *RealPeer) waitForCandidates(ctx context.Context, pc *webrtc.PeerConnection) error {
+	p.mu.RLock()
+	trickle := p.onICECandidate != nil && pc == p.pc
+	p.mu.RUnlock()
+
+	if trickle {
+		return nil
+	}
 
-		p.mu.RLock()
-		callback := p.onMessage
-		p.mu.RUnlock()
+	timeout := p.config.gatheringTimeout()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+
+	select {
+	case <-gatherComplete:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("ICE gathering cancelled: %w", ctx.Err())
+	case <-timer.C:
+		desc := pc.LocalDescription()
+		if desc != nil && strings.Contains(desc.SDP, "a=candidate:") {
+			log.Printf("ICE gathering timed out after %s, using the candidates gathered so far", timeout)
+			return nil
+		}
+		return &GatheringTimeoutError{Timeout: timeout}
+	}
+}
 
+// Adds the remote candidates that arrived before the remote description
+func (p *RealPeer) flushPendingCandidates(pc *webrtc.PeerConnection) error {
+	p.mu.Lock()
+	pending := p.pendingCandidates
+	p.pendingCandidates = nil
+	p.mu.Unlock()
 
-		if callback != nil {
-			callback(msg.Data)
+	for _, candidate := range pending {
+		if err := pc.AddICECandidate(candidate); err != nil {
+			return fmt.Errorf("failed to add queued ICE candidate: %w", err)
 		}
-	})
-
-	p.dataChannel.OnClose(func(){
-		log.Printf("Data channel closed")
-	})
+	}
 
-	p.dataChannel.OnError(func (err error) {
-		log.Printf("Data channel error: %v", err)
-	})
+	return nil
 }
 
 // Converts a SessionDescription to a JSON string
@@ -302,9 +649,17 @@ func (p *RealPeer) sdpToString(desc *webrtc.SessionDescription) (string, error){
 		return "", webrtc.ErrSessionDescriptionNoFingerprint
 	}
 
+	sdp := desc.SDP
+	if p.config.Privacy {
+		sdp = StripLocalCandidates(sdp)
+	}
+	if mapping := p.portMapping(); mapping != nil {
+		sdp = mapping.addToSDP(sdp)
+	}
+
 	descMap := map[string] interface{}{
 		"type": desc.Type.String(),
-		"sdp": desc.SDP,
+		"sdp": sdp,
 	}
 
 	jsonBytes, err := json.Marshal(descMap)
@@ -1,8 +1,11 @@
 package webrtc
 
 import (
+	"context"
 	"encoding/json"
+	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -132,7 +135,7 @@ func TestRealPeer_OnMessageCallback(t *testing.T) {
 	
 	// Verify callback was set (we can't easily trigger it without full connection)
 	peer.mu.RLock()
-	assert.NotNil(t, peer.onMessage)
+	assert.NotNil(t, peer.channelHandlers[DefaultChannel])
 	peer.mu.RUnlock()
 }
 
@@ -142,7 +145,7 @@ func TestRealPeer_OnStateChangeCallback(t *testing.T) {
 	defer peer.Close()
 	
 	// Set state change handler
-	peer.OnStateChange(func(state string) {
+	peer.OnStateChange(func(state ConnectionState) {
 		// Callback logic would go here in real usage
 	})
 	
@@ -263,7 +266,7 @@ func TestRealPeer_CallbackThreadSafety(t *testing.T) {
 	
 	go func() {
 		for i := 0; i < 100; i++ {
-			peer.OnStateChange(func(string) {})
+			peer.OnStateChange(func(ConnectionState) {})
 		}
 		done <- true
 	}()
@@ -283,7 +286,7 @@ func TestRealPeer_CallbackThreadSafety(t *testing.T) {
 	
 	// Verify callbacks were set
 	peer.mu.RLock()
-	assert.NotNil(t, peer.onMessage)
+	assert.NotNil(t, peer.channelHandlers[DefaultChannel])
 	assert.NotNil(t, peer.onStateChange)
 	peer.mu.RUnlock()
 }
@@ -311,8 +314,268 @@ func TestRealPeer_InterfaceCompliance(t *testing.T) {
 	assert.Error(t, err) // Should error when not connected
 	
 	peer.OnMessage(func([]byte) {})     // Should not panic
-	peer.OnStateChange(func(string) {}) // Should not panic
+	peer.OnStateChange(func(ConnectionState) {}) // Should not panic
 	
 	err = peer.Close()
 	assert.NoError(t, err)
-}
\ No newline at end of file
+}
+
+func TestRealPeer_TrickleICE(t *testing.T) {
+	offerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	// Exchange candidates as they are gathered, like a signaling server would
+	offerer.OnICECandidate(func(candidate string) {
+		assert.NoError(t, answerer.AddICECandidate(candidate))
+	})
+	answerer.OnICECandidate(func(candidate string) {
+		assert.NoError(t, offerer.AddICECandidate(candidate))
+	})
+
+	received := make(chan []byte, 1)
+	answerer.OnMessage(func(data []byte) {
+		received <- data
+	})
+
+	offer, err := offerer.CreateOffer()
+	require.NoError(t, err)
+
+	answer, err := answerer.CreateAnswer(offer)
+	require.NoError(t, err)
+
+	require.NoError(t, offerer.SetRemoteAnswer(answer))
+
+	require.Eventually(t, func() bool {
+		return offerer.Send([]byte("trickled")) == nil
+	}, 10*time.Second, 50*time.Millisecond)
+
+	select {
+	case data := <-received:
+		assert.Equal(t, "trickled", string(data))
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received over trickled connection")
+	}
+}
+
+func TestRealPeer_AddICECandidateBeforeRemoteDescription(t *testing.T) {
+	peer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer peer.Close()
+
+	candidate := `{"candidate":"candidate:1 1 udp 2130706431 192.0.2.10 50000 typ host","sdpMid":"0","sdpMLineIndex":0}`
+
+	// No remote description yet, so the candidate must be queued rather than rejected
+	require.NoError(t, peer.AddICECandidate(candidate))
+
+	peer.mu.RLock()
+	assert.Len(t, peer.pendingCandidates, 1)
+	peer.mu.RUnlock()
+}
+
+func TestRealPeer_AddICECandidateInvalid(t *testing.T) {
+	peer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer peer.Close()
+
+	err = peer.AddICECandidate("{not json")
+	assert.Error(t, err)
+}
+
+// silentServerURL returns a URL for a UDP socket that never answers, so
+// gathering against it only ends with the gathering timeout
+func silentServerURL(t *testing.T, scheme string) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return scheme + ":" + conn.LocalAddr().String()
+}
+
+func TestRealPeer_GatheringTimeoutUsesPartialCandidates(t *testing.T) {
+	config := PeerConfig{
+		ICEServers:       []ICEServer{{URLs: []string{silentServerURL(t, "stun")}}},
+		GatheringTimeout: Duration(300 * time.Millisecond),
+	}
+
+	peer, err := NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer peer.Close()
+
+	start := time.Now()
+	offer, err := peer.CreateOffer()
+	require.NoError(t, err)
+
+	assert.Less(t, time.Since(start), 3*time.Second, "should not wait for the silent STUN server")
+	assert.Contains(t, offer, "typ host", "host candidates gathered before the deadline are kept")
+}
+
+func TestRealPeer_GatheringTimeoutWithoutCandidates(t *testing.T) {
+	config := PeerConfig{
+		ICEServers: []ICEServer{{
+			URLs:       []string{silentServerURL(t, "turn")},
+			Username:   "user",
+			Credential: "pass",
+		}},
+		ICETransportPolicy: TransportPolicyRelay,
+		GatheringTimeout:   Duration(300 * time.Millisecond),
+	}
+
+	peer, err := NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer peer.Close()
+
+	offer, err := peer.CreateOffer()
+	assert.Empty(t, offer)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrGatheringTimeout)
+
+	var timeoutErr *GatheringTimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	assert.Equal(t, 300*time.Millisecond, timeoutErr.Timeout)
+}
+
+func TestRealPeer_CreateOfferContextCancelled(t *testing.T) {
+	config := PeerConfig{
+		ICEServers: []ICEServer{{URLs: []string{silentServerURL(t, "stun")}}},
+	}
+
+	peer, err := NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer peer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = peer.CreateOfferContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// An already cancelled context fails straight away
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+
+	_, err = answerer.CreateAnswerContext(cancelled, `{"type":"offer","sdp":"v=0"}`)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// iceUfrag extracts the ICE username fragment from an SDP JSON string
+func iceUfrag(t *testing.T, sdp string) string {
+	t.Helper()
+
+	var desc struct {
+		SDP string `json:"sdp"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(sdp), &desc))
+
+	for _, line := range strings.Split(desc.SDP, "\r\n") {
+		if ufrag, ok := strings.CutPrefix(line, "a=ice-ufrag:"); ok {
+			return ufrag
+		}
+	}
+	t.Fatal("SDP has no ice-ufrag")
+	return ""
+}
+
+func TestRealPeer_RestartICEBeforeNegotiation(t *testing.T) {
+	peer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer peer.Close()
+
+	_, err = peer.RestartICE(context.Background())
+	assert.Error(t, err)
+}
+
+func TestRealPeer_RestartICEInBand(t *testing.T) {
+	offerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	// Restart offers and answers travel over their own channel, like the client's
+	require.NoError(t, offerer.OpenChannel("signal", ReliableChannel()))
+
+	var states []ConnectionState
+	var statesMu sync.Mutex
+	offerer.OnStateChange(func(state ConnectionState) {
+		statesMu.Lock()
+		states = append(states, state)
+		statesMu.Unlock()
+	})
+
+	received := make(chan string, 2)
+	answerer.OnMessage(func(data []byte) {
+		received <- string(data)
+	})
+
+	answerErrs := make(chan error, 1)
+	answerer.OnChannelMessage("signal", func(data []byte) {
+		go func() {
+			answer, err := answerer.CreateAnswer(string(data))
+			if err == nil {
+				err = answerer.SendChannel("signal", []byte(answer))
+			}
+			answerErrs <- err
+		}()
+	})
+
+	answers := make(chan string, 1)
+	offerer.OnChannelMessage("signal", func(data []byte) {
+		answers <- string(data)
+	})
+
+	connectPeers(t, offerer, answerer)
+	require.Eventually(t, func() bool {
+		return channelOpen(answerer, "signal")
+	}, 5*time.Second, 20*time.Millisecond)
+
+	firstOffer, err := offerer.sdpToString(offerer.conn().LocalDescription())
+	require.NoError(t, err)
+	originalConn := offerer.conn()
+
+	restartOffer, err := offerer.RestartICE(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, iceUfrag(t, firstOffer), iceUfrag(t, restartOffer), "ICE credentials must change")
+
+	require.NoError(t, offerer.SendChannel("signal", []byte(restartOffer)))
+	require.NoError(t, <-answerErrs)
+
+	select {
+	case answer := <-answers:
+		require.NoError(t, offerer.SetRemoteAnswer(answer))
+	case <-time.After(10 * time.Second):
+		t.Fatal("restart answer was not received in-band")
+	}
+
+	// Both sides switch to the new connection once its channels are open
+	require.Eventually(t, func() bool {
+		return offerer.conn() != originalConn && channelOpen(offerer, DefaultChannel) && channelOpen(answerer, DefaultChannel)
+	}, 10*time.Second, 20*time.Millisecond, "standby connection was never promoted")
+
+	require.NoError(t, offerer.Send([]byte("after restart")))
+	select {
+	case text := <-received:
+		assert.Equal(t, "after restart", text)
+	case <-time.After(10 * time.Second):
+		t.Fatal("message was not delivered after the ICE restart")
+	}
+
+	// The replaced connection is closed without reporting it to the callback
+	time.Sleep(retireDelay + 500*time.Millisecond)
+	assert.Equal(t, "closed", originalConn.ConnectionState().String())
+	statesMu.Lock()
+	assert.NotContains(t, states, ConnectionStateClosed)
+	statesMu.Unlock()
+}
@@ -0,0 +1,178 @@
+package webrtc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+// PortMapper opens a port on the user's router, e.g. with UPnP or NAT-PMP
+// (see pkg/upnp), so the remote peer can reach this one without a relay
+// even when hole punching fails
+type PortMapper interface {
+	// MapUDP forwards an external port to the local UDP port until
+	// release is called
+	MapUDP(ctx context.Context, port int) (external *net.UDPAddr, release func() error, err error)
+}
+
+// portMapTimeout bounds how long creating a peer waits for the router
+const portMapTimeout = 5 * time.Second
+
+// The forwarded address is offered as a server reflexive candidate, with
+// the type preference RFC 8445 gives those
+const (
+	mappedFoundation = "portmap"
+	mappedPriority   = 100<<24 | 65535<<8 | 255
+)
+
+// portMapping is a local UDP port shared by every connection of a peer and
+// its forwarded address
+type portMapping struct {
+	mux      ice.UDPMux
+	external *net.UDPAddr
+	release  func() error
+}
+
+// mapPort makes ICE use a single UDP port and asks the port mapper to
+// forward it. A peer works without a mapping, so failures are only logged
+// and nil is returned
+func (c PeerConfig) mapPort(settings *webrtc.SettingEngine) *portMapping {
+	if c.PortMapper == nil || c.relayOnly() || c.Network.IPVersion == IPVersion6 {
+		return nil
+	}
+
+	mux, port, err := c.Network.listenMux()
+	if err != nil {
+		log.Printf("Port mapping skipped: %v", err)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), portMapTimeout)
+	defer cancel()
+
+	external, release, err := c.PortMapper.MapUDP(ctx, port)
+	if err != nil {
+		log.Printf("Port mapping failed: %v", err)
+		mux.Close()
+		return nil
+	}
+
+	settings.SetICEUDPMux(mux)
+	return &portMapping{mux: mux, external: external, release: release}
+}
+
+// listenMux opens the UDP port every host candidate uses, in the
+// configured port range if there is one
+func (n NetworkConfig) listenMux() (ice.UDPMux, int, error) {
+	options := []ice.UDPMuxFromPortOption{
+		ice.UDPMuxFromPortWithNetworks(ice.NetworkTypeUDP4),
+	}
+	if len(n.Interfaces) > 0 || len(n.ExcludeInterfaces) > 0 {
+		options = append(options, ice.UDPMuxFromPortWithInterfaceFilter(n.interfaceAllowed))
+	}
+	filter, err := n.ipFilter()
+	if err != nil {
+		return nil, 0, err
+	}
+	if filter != nil {
+		options = append(options, ice.UDPMuxFromPortWithIPFilter(filter))
+	}
+
+	if n.PortMin == 0 {
+		port, err := freeUDPPort()
+		if err != nil {
+			return nil, 0, err
+		}
+		mux, err := ice.NewMultiUDPMuxFromPort(port, options...)
+		return mux, port, err
+	}
+
+	for port := int(n.PortMin); port <= int(n.PortMax); port++ {
+		if mux, err := ice.NewMultiUDPMuxFromPort(port, options...); err == nil {
+			return mux, port, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("no free UDP port in %d-%d", n.PortMin, n.PortMax)
+}
+
+// freeUDPPort returns a UDP port nothing listens on right now
+func freeUDPPort() (int, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find a free port: %w", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
+
+// portMapping returns the peer's forwarded port, nil without one or once
+// the peer is closed
+func (p *RealPeer) portMapping() *portMapping {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.mapping
+}
+
+// candidate returns the candidate attribute value of the forwarded address
+func (m *portMapping) candidate() string {
+	return fmt.Sprintf("%s 1 udp %d %s %d typ srflx raddr 0.0.0.0 rport 0",
+		mappedFoundation, mappedPriority, m.external.IP, m.external.Port)
+}
+
+// addToSDP inserts the forwarded address after the last candidate of the
+// SDP. An SDP without candidates (trickle ICE) is returned as is
+func (m *portMapping) addToSDP(sdp string) string {
+	last := strings.LastIndex(sdp, "a=candidate:")
+	if last < 0 {
+		return sdp
+	}
+
+	end := strings.Index(sdp[last:], "\n")
+	if end < 0 {
+		return sdp
+	}
+	insert := last + end + 1
+	return sdp[:insert] + "a=candidate:" + m.candidate() + "\r\n" + sdp[insert:]
+}
+
+// trickleCandidate encodes the forwarded address like a trickled
+// candidate of the media section mid
+func (m *portMapping) trickleCandidate(mid string) string {
+	index := uint16(0)
+	encoded, err := json.Marshal(webrtc.ICECandidateInit{
+		Candidate:     "candidate:" + m.candidate(),
+		SDPMid:        &mid,
+		SDPMLineIndex: &index,
+	})
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+// close releases the forwarded port and the UDP port
+func (m *portMapping) close() {
+	if err := m.release(); err != nil {
+		log.Printf("Failed to remove port mapping: %v", err)
+	}
+	if err := m.mux.Close(); err != nil {
+		log.Printf("Failed to close UDP port: %v", err)
+	}
+}
+
+// firstMid returns the first media section identifier of an SDP
+func firstMid(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if mid, ok := strings.CutPrefix(strings.TrimSpace(line), "a=mid:"); ok {
+			return mid
+		}
+	}
+	return "0"
+}
@@ -0,0 +1,249 @@
+package webrtc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forwarder plays the router: packets sent to its address reach the
+// mapped local port, and the answers go back out
+type forwarder struct {
+	conn     *net.UDPConn
+	internal *net.UDPAddr
+	packets  atomic.Int64
+
+	mu       sync.Mutex
+	upstream map[string]*net.UDPConn
+}
+
+func startForwarder(t *testing.T, ip net.IP) *forwarder {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: ip})
+	require.NoError(t, err)
+
+	f := &forwarder{conn: conn, upstream: make(map[string]*net.UDPConn)}
+	t.Cleanup(f.close)
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if up := f.upstreamFor(from); up != nil {
+				f.packets.Add(1)
+				up.Write(buf[:n])
+			}
+		}
+	}()
+	return f
+}
+
+// upstreamFor returns the socket forwarding the packets of one remote address
+func (f *forwarder) upstreamFor(from *net.UDPAddr) *net.UDPConn {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if up, ok := f.upstream[from.String()]; ok {
+		return up
+	}
+	if f.internal == nil {
+		return nil
+	}
+
+	up, err := net.DialUDP("udp4", nil, f.internal)
+	if err != nil {
+		return nil
+	}
+	f.upstream[from.String()] = up
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, err := up.Read(buf)
+			if err != nil {
+				return
+			}
+			f.conn.WriteToUDP(buf[:n], from)
+		}
+	}()
+	return up
+}
+
+func (f *forwarder) close() {
+	f.conn.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, up := range f.upstream {
+		up.Close()
+	}
+}
+
+// fakeMapper forwards every mapped port through a forwarder
+type fakeMapper struct {
+	forwarder *forwarder
+	err       error
+
+	mu       sync.Mutex
+	ports    []int
+	released int
+}
+
+func (m *fakeMapper) MapUDP(_ context.Context, port int) (*net.UDPAddr, func() error, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err != nil {
+		return nil, nil, m.err
+	}
+	m.ports = append(m.ports, port)
+
+	release := func() error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.released++
+		return nil
+	}
+	return m.forwarder.conn.LocalAddr().(*net.UDPAddr), release, nil
+}
+
+// keepCandidates removes the candidates of an encoded SDP that keep rejects
+func keepCandidates(sdp string, keep func(fields []string) bool) string {
+	lines := strings.Split(sdp, `\r\n`)
+	kept := lines[:0]
+	for _, line := range lines {
+		value, ok := strings.CutPrefix(line, "a=candidate:")
+		if !ok || keep(strings.Fields(value)) {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, `\r\n`)
+}
+
+// localIPv4 returns a non-loopback address of this host
+func localIPv4(t *testing.T) net.IP {
+	t.Helper()
+
+	peer, err := NewRealPeerWithConfig(PeerConfig{Network: NetworkConfig{IPVersion: IPVersion4}})
+	require.NoError(t, err)
+	defer peer.Close()
+
+	offer, err := peer.CreateOffer()
+	require.NoError(t, err)
+
+	candidates := hostCandidates(t, offer)
+	require.NotEmpty(t, candidates, "no IPv4 interface")
+	return net.ParseIP(candidates[0][0])
+}
+
+func TestRealPeer_PortMapping(t *testing.T) {
+	ip := localIPv4(t)
+	mapper := &fakeMapper{forwarder: startForwarder(t, ip)}
+	external := mapper.forwarder.conn.LocalAddr().(*net.UDPAddr)
+
+	offerer, err := NewRealPeerWithConfig(PeerConfig{PortMapper: mapper})
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	require.Len(t, mapper.ports, 1)
+	mapper.forwarder.mu.Lock()
+	mapper.forwarder.internal = &net.UDPAddr{IP: ip, Port: mapper.ports[0]}
+	mapper.forwarder.mu.Unlock()
+
+	answerer, err := NewRealPeerWithConfig(PeerConfig{})
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	offer, err := offerer.CreateOffer()
+	require.NoError(t, err)
+	assert.Contains(t, offer, "a=candidate:portmap 1 udp 1694498815 "+ip.String()+" "+strconv.Itoa(external.Port)+" typ srflx")
+
+	// Every host candidate is on the mapped port
+	for _, candidate := range hostCandidates(t, offer) {
+		assert.Equal(t, strconv.Itoa(mapper.ports[0]), candidate[1])
+	}
+
+	// Only the forwarded address is left for the answerer to reach, and the
+	// offerer learns the answerer's address from its checks
+	onlyMapped := keepCandidates(offer, func(fields []string) bool { return fields[0] == mappedFoundation })
+	answer, err := answerer.CreateAnswer(onlyMapped)
+	require.NoError(t, err)
+	require.NoError(t, offerer.SetRemoteAnswer(keepCandidates(answer, func([]string) bool { return false })))
+
+	require.Eventually(t, func() bool {
+		return channelOpen(offerer, DefaultChannel) && channelOpen(answerer, DefaultChannel)
+	}, 10*time.Second, 20*time.Millisecond, "data channel never opened")
+	assert.Positive(t, mapper.forwarder.packets.Load())
+
+	// ICE restarts offer the forwarded address too
+	restart, err := offerer.RestartICE(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, restart, "a=candidate:portmap")
+
+	require.NoError(t, offerer.Close())
+	assert.Equal(t, 1, mapper.released)
+}
+
+func TestRealPeer_PortMappingTrickle(t *testing.T) {
+	mapper := &fakeMapper{forwarder: startForwarder(t, net.IPv4(127, 0, 0, 1))}
+
+	peer, err := NewRealPeerWithConfig(PeerConfig{PortMapper: mapper})
+	require.NoError(t, err)
+	defer peer.Close()
+
+	candidates := make(chan string, 32)
+	peer.OnICECandidate(func(candidate string) { candidates <- candidate })
+
+	_, err = peer.CreateOffer()
+	require.NoError(t, err)
+
+	var trickled []string
+	for candidate := range candidates {
+		if candidate == "" {
+			break
+		}
+		trickled = append(trickled, candidate)
+	}
+	require.NotEmpty(t, trickled)
+	assert.Contains(t, trickled[len(trickled)-1], `"candidate":"candidate:portmap`)
+	assert.Contains(t, trickled[len(trickled)-1], `"sdpMid":"0"`)
+}
+
+func TestRealPeer_PortMappingSkipped(t *testing.T) {
+	t.Run("mapping fails", func(t *testing.T) {
+		mapper := &fakeMapper{err: errors.New("no gateway")}
+
+		peer, err := NewRealPeerWithConfig(PeerConfig{PortMapper: mapper})
+		require.NoError(t, err, "the peer works without a mapping")
+		defer peer.Close()
+
+		offer, err := peer.CreateOffer()
+		require.NoError(t, err)
+		assert.NotContains(t, offer, mappedFoundation)
+	})
+
+	t.Run("privacy mode", func(t *testing.T) {
+		mapper := &fakeMapper{forwarder: startForwarder(t, net.IPv4(127, 0, 0, 1))}
+		config := relayOnlyConfig(startTestTURNServer(t))
+		config.Privacy = true
+		config.PortMapper = mapper
+
+		peer, err := NewRealPeerWithConfig(config)
+		require.NoError(t, err)
+		defer peer.Close()
+		assert.Empty(t, mapper.ports)
+		assert.Nil(t, peer.mapping)
+	})
+}
@@ -0,0 +1,131 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Privacy mode never lets a room or answer code reveal the user's addresses:
+// only TURN relay candidates are gathered, and the SDP and trickled
+// candidates are filtered again before they leave the peer in case anything
+// else slipped in. The remote side only ever learns the relay's address
+
+// relayOnly reports whether ICE may only use relay candidates
+func (c PeerConfig) relayOnly() bool {
+	return c.Privacy || c.ICETransportPolicy == TransportPolicyRelay
+}
+
+// The related address of relay candidates is replaced with these
+const (
+	hiddenAddress = "0.0.0.0"
+	hiddenPort    = "0"
+)
+
+// StripLocalCandidates removes every candidate other than relay ones from an
+// SDP and hides the related address (the user's own host or server
+// reflexive address) of the relay candidates that remain
+func StripLocalCandidates(sdp string) string {
+	lines := strings.SplitAfter(sdp, "\n")
+	kept := lines[:0]
+
+	for _, line := range lines {
+		value, isCandidate := strings.CutPrefix(line, "a=candidate:")
+		if !isCandidate {
+			kept = append(kept, line)
+			continue
+		}
+
+		ending := line[len(strings.TrimRight(line, "\r\n")):]
+		if candidate, ok := privateCandidate(strings.TrimRight(value, "\r\n")); ok {
+			kept = append(kept, "a=candidate:"+candidate+ending)
+		}
+	}
+
+	return strings.Join(kept, "")
+}
+
+// privateCandidate returns a candidate attribute value ("<foundation> 1 udp
+// ... typ relay raddr ... rport ...") with its related address hidden, or
+// false if it is not a relay candidate
+func privateCandidate(candidate string) (string, bool) {
+	fields := strings.Fields(candidate)
+
+	relay := false
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "typ":
+			relay = fields[i+1] == "relay"
+		case "raddr":
+			fields[i+1] = hiddenAddress
+		case "rport":
+			fields[i+1] = hiddenPort
+		}
+	}
+
+	if !relay {
+		return "", false
+	}
+	return strings.Join(fields, " "), true
+}
+
+// privateTrickleCandidate filters a trickled candidate (JSON
+// ICECandidateInit) like StripLocalCandidates. The empty end-of-candidates
+// marker passes through
+func privateTrickleCandidate(candidateJSON string) (string, bool) {
+	if candidateJSON == "" {
+		return "", true
+	}
+
+	var init map[string]any
+	if err := json.Unmarshal([]byte(candidateJSON), &init); err != nil {
+		return "", false
+	}
+
+	value, _ := init["candidate"].(string)
+	candidate, ok := privateCandidate(strings.TrimPrefix(value, "candidate:"))
+	if !ok {
+		return "", false
+	}
+	init["candidate"] = "candidate:" + candidate
+
+	encoded, err := json.Marshal(init)
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+// RevealsAddress reports whether an encoded session description (JSON or
+// raw SDP) carries any candidate other than a relay one, or a relay
+// candidate with a related address, i.e. whether sharing it discloses a
+// local or public address of the user
+func RevealsAddress(sdp string) bool {
+	var desc struct {
+		SDP string `json:"sdp"`
+	}
+	if json.Unmarshal([]byte(sdp), &desc) == nil && desc.SDP != "" {
+		sdp = desc.SDP
+	}
+
+	for _, line := range strings.Split(sdp, "\n") {
+		value, isCandidate := strings.CutPrefix(strings.TrimSpace(line), "a=candidate:")
+		if isCandidate && revealsAddress(strings.Fields(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// revealsAddress reports whether the fields of one candidate disclose an
+// address other than a relay's
+func revealsAddress(fields []string) bool {
+	for i := 0; i+1 < len(fields); i++ {
+		switch {
+		case fields[i] == "typ" && fields[i+1] != "relay":
+			return true
+		case fields[i] == "raddr" && fields[i+1] != hiddenAddress:
+			return true
+		}
+	}
+	return false
+}
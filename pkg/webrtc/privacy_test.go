@@ -0,0 +1,137 @@
+package webrtc
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSDP = "v=0\r\n" +
+	"o=- 1 2 IN IP4 0.0.0.0\r\n" +
+	"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n" +
+	"a=candidate:1 1 udp 2130706431 192.168.1.20 50000 typ host\r\n" +
+	"a=candidate:2 1 udp 1694498815 203.0.113.7 50001 typ srflx raddr 192.168.1.20 rport 50000\r\n" +
+	"a=candidate:3 1 udp 16777215 198.51.100.1 3478 typ relay raddr 203.0.113.7 rport 50002\r\n" +
+	"a=end-of-candidates\r\n"
+
+func TestStripLocalCandidates(t *testing.T) {
+	assert.True(t, RevealsAddress(testSDP))
+
+	stripped := StripLocalCandidates(testSDP)
+	assert.False(t, RevealsAddress(stripped))
+	assert.NotContains(t, stripped, "192.168.1.20")
+	assert.NotContains(t, stripped, "203.0.113.7")
+	assert.Contains(t, stripped, "a=candidate:3 1 udp 16777215 198.51.100.1 3478 typ relay raddr 0.0.0.0 rport 0\r\n")
+	assert.Contains(t, stripped, "a=end-of-candidates\r\n")
+	assert.True(t, strings.HasPrefix(stripped, "v=0\r\no=- 1 2 IN IP4 0.0.0.0\r\n"))
+
+	assert.Equal(t, stripped, StripLocalCandidates(stripped))
+}
+
+func TestPrivateTrickleCandidate(t *testing.T) {
+	host := `{"candidate":"candidate:1 1 udp 2130706431 192.168.1.20 50000 typ host","sdpMid":"0"}`
+	_, ok := privateTrickleCandidate(host)
+	assert.False(t, ok)
+
+	relay := `{"candidate":"candidate:3 1 udp 16777215 198.51.100.1 3478 typ relay raddr 203.0.113.7 rport 50002","sdpMid":"0"}`
+	filtered, ok := privateTrickleCandidate(relay)
+	require.True(t, ok)
+	assert.Contains(t, filtered, "typ relay raddr 0.0.0.0 rport 0")
+	assert.Contains(t, filtered, `"sdpMid":"0"`)
+
+	// The end-of-candidates marker is kept
+	marker, ok := privateTrickleCandidate("")
+	assert.True(t, ok)
+	assert.Equal(t, "", marker)
+}
+
+func TestPeerConfig_ValidatePrivacy(t *testing.T) {
+	config := DefaultPeerConfig()
+	config.Privacy = true
+	assert.ErrorContains(t, config.Validate(), "TURN")
+
+	config.ICEServers = append(config.ICEServers, ICEServer{
+		URLs:       []string{"turn:turn.example.com:3478"},
+		Username:   "alice",
+		Credential: "secret",
+	})
+	require.NoError(t, config.Validate())
+
+	// Privacy wins over the transport policy
+	assert.Equal(t, "relay", config.toPion().ICETransportPolicy.String())
+}
+
+func TestLoadPeerConfig_PrivacyEnv(t *testing.T) {
+	t.Setenv(EnvConfigFile, "")
+	t.Setenv(EnvICEServers, "turn:turn.example.com:3478")
+	t.Setenv(EnvTURNUsername, "bob")
+	t.Setenv(EnvTURNCredential, "hunter2")
+	t.Setenv(EnvPrivacy, "true")
+
+	config, err := LoadPeerConfig()
+	require.NoError(t, err)
+	assert.True(t, config.Privacy)
+
+	t.Setenv(EnvPrivacy, "sometimes")
+	_, err = LoadPeerConfig()
+	assert.Error(t, err)
+}
+
+func TestRealPeer_Privacy(t *testing.T) {
+	turnURL := startTestTURNServer(t)
+
+	// A STUN server and the "all" policy would normally add srflx and host candidates
+	config := relayOnlyConfig(turnURL)
+	config.ICEServers = append(config.ICEServers, ICEServer{URLs: []string{"stun:stun.l.google.com:19302"}})
+	config.ICETransportPolicy = TransportPolicyAll
+	config.Privacy = true
+
+	offerer, err := NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeerWithConfig(config)
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	// The answerer trickles its candidates
+	var mu sync.Mutex
+	var trickled []string
+	answerer.OnICECandidate(func(candidate string) {
+		mu.Lock()
+		trickled = append(trickled, candidate)
+		mu.Unlock()
+		offerer.AddICECandidate(candidate)
+	})
+
+	offer, err := offerer.CreateOffer()
+	require.NoError(t, err)
+	assert.Contains(t, offer, "typ relay")
+	assert.False(t, RevealsAddress(offer))
+
+	answer, err := answerer.CreateAnswer(offer)
+	require.NoError(t, err)
+	assert.False(t, RevealsAddress(answer))
+	require.NoError(t, offerer.SetRemoteAnswer(answer))
+
+	require.Eventually(t, func() bool {
+		return channelOpen(offerer, DefaultChannel) && channelOpen(answerer, DefaultChannel)
+	}, 10*time.Second, 20*time.Millisecond, "data channel never opened")
+
+	stats := offerer.Stats()
+	require.NotNil(t, stats.Local)
+	assert.True(t, stats.UsingRelay())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, trickled)
+	for _, candidate := range trickled {
+		if candidate != "" {
+			assert.Contains(t, candidate, "typ relay raddr 0.0.0.0 rport 0")
+		}
+	}
+}
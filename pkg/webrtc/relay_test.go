@@ -0,0 +1,109 @@
+package webrtc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTURNRealm    = "p2p-chat.test"
+	testTURNUser     = "tester"
+	testTURNPassword = "secret"
+)
+
+// startTestTURNServer runs a pion/turn server on a random loopback UDP port
+// and returns its turn: URL
+func startTestTURNServer(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	key := turn.GenerateAuthKey(testTURNUser, testTURNRealm, testTURNPassword)
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm: testTURNRealm,
+		AuthHandler: func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+			if username == testTURNUser {
+				return key, true
+			}
+			return nil, false
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn: conn,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { server.Close() })
+
+	return "turn:" + conn.LocalAddr().String() + "?transport=udp"
+}
+
+// relayOnlyConfig returns a config that can only connect through the test TURN server
+func relayOnlyConfig(turnURL string) PeerConfig {
+	return PeerConfig{
+		ICEServers: []ICEServer{
+			{
+				URLs:       []string{turnURL},
+				Username:   testTURNUser,
+				Credential: testTURNPassword,
+			},
+		},
+		ICETransportPolicy: TransportPolicyRelay,
+	}
+}
+
+func TestRealPeer_RelayOnlyConnection(t *testing.T) {
+	turnURL := startTestTURNServer(t)
+
+	offerer, err := NewRealPeerWithConfig(relayOnlyConfig(turnURL))
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeerWithConfig(relayOnlyConfig(turnURL))
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	received := make(chan []byte, 1)
+	answerer.OnMessage(func(data []byte) {
+		received <- data
+	})
+
+	offer, err := offerer.CreateOffer()
+	require.NoError(t, err)
+
+	// A relay-only offer must not advertise host or server reflexive candidates
+	assert.Contains(t, offer, "typ relay")
+	assert.NotContains(t, offer, "typ host")
+	assert.NotContains(t, offer, "typ srflx")
+
+	answer, err := answerer.CreateAnswer(offer)
+	require.NoError(t, err)
+	require.NoError(t, offerer.SetRemoteAnswer(answer))
+
+	require.Eventually(t, func() bool {
+		return offerer.Send([]byte("hello through the relay")) == nil
+	}, 10*time.Second, 50*time.Millisecond)
+
+	select {
+	case data := <-received:
+		assert.Equal(t, "hello through the relay", string(data))
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not relayed")
+	}
+
+	stats := offerer.Stats()
+	require.NotNil(t, stats.Local)
+	assert.Equal(t, CandidateRelay, stats.Local.Type)
+	assert.True(t, stats.UsingRelay())
+}
@@ -0,0 +1,289 @@
+package webrtc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// retireDelay is how long a connection replaced by an ICE restart stays open
+// so messages already in flight on it are still delivered
+const retireDelay = 2 * time.Second
+
+// Creates an ICE restart offer.
+//
+// pion tears down the old ICE session as soon as a restart offer is created,
+// which would cut off the very data channel the offer has to travel over.
+// RestartICE therefore negotiates a standby connection with fresh ICE
+// credentials and candidates, carrying the same channels, while the current
+// one keeps running. Once the standby is connected and its channels are open
+// it silently replaces the current connection.
+func (p *RealPeer) RestartICE(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if p.conn().RemoteDescription() == nil {
+		return "", fmt.Errorf("cannot restart ICE before the connection has been negotiated")
+	}
+
+	log.Printf("Restarting ICE")
+
+	standby, err := p.newStandby()
+	if err != nil {
+		return "", fmt.Errorf("failed to create standby connection: %w", err)
+	}
+
+	// Recreate every channel so the remote side receives them on the new connection
+	for _, dc := range p.sortedChannels() {
+		newDC, err := standby.CreateDataChannel(dc.Label(), channelOptionsOf(dc).toPion())
+		if err != nil {
+			p.dropStandby(standby)
+			return "", err
+		}
+		p.addStandbyChannel(newDC)
+	}
+
+	offer, err := standby.CreateOffer(nil)
+	if err != nil {
+		p.dropStandby(standby)
+		return "", err
+	}
+
+	if err := standby.SetLocalDescription(offer); err != nil {
+		p.dropStandby(standby)
+		return "", err
+	}
+
+	if err := p.waitForCandidates(ctx, standby); err != nil {
+		p.dropStandby(standby)
+		return "", err
+	}
+
+	return p.sdpToString(standby.LocalDescription())
+}
+
+// answerRestart answers an ICE restart offer on a new standby connection
+func (p *RealPeer) answerRestart(ctx context.Context, offer string) (string, error) {
+	log.Printf("Answering ICE restart")
+
+	sessionDesc, err := p.stringToSDP(offer)
+	if err != nil {
+		return "", err
+	}
+
+	standby, err := p.newStandby()
+	if err != nil {
+		return "", fmt.Errorf("failed to create standby connection: %w", err)
+	}
+
+	if err := standby.SetRemoteDescription(*sessionDesc); err != nil {
+		p.dropStandby(standby)
+		return "", err
+	}
+
+	answer, err := standby.CreateAnswer(nil)
+	if err != nil {
+		p.dropStandby(standby)
+		return "", err
+	}
+
+	if err := standby.SetLocalDescription(answer); err != nil {
+		p.dropStandby(standby)
+		return "", err
+	}
+
+	if err := p.waitForCandidates(ctx, standby); err != nil {
+		p.dropStandby(standby)
+		return "", err
+	}
+
+	return p.sdpToString(standby.LocalDescription())
+}
+
+// isRestartOffer reports whether offer starts a new session on a peer that
+// is already negotiated. Offers for the current session are renegotiations
+// and are applied to the active connection
+func (p *RealPeer) isRestartOffer(offer string) bool {
+	remote := p.conn().RemoteDescription()
+	if remote == nil {
+		return false
+	}
+
+	sessionDesc, err := p.stringToSDP(offer)
+	if err != nil || sessionDesc.Type != webrtc.SDPTypeOffer {
+		return false
+	}
+
+	return sessionID(sessionDesc.SDP) != sessionID(remote.SDP)
+}
+
+// newStandby creates the standby connection, replacing any previous one
+func (p *RealPeer) newStandby() (*webrtc.PeerConnection, error) {
+	pc, err := p.newPeerConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	previous := p.standby
+	p.standby = pc
+	p.standbyChannels = make(map[string]*webrtc.DataChannel)
+	p.mu.Unlock()
+
+	if previous != nil {
+		log.Printf("Abandoning the previous ICE restart")
+		previous.Close()
+	}
+
+	return pc, nil
+}
+
+// dropStandby closes a standby connection that did not work out
+func (p *RealPeer) dropStandby(standby *webrtc.PeerConnection) {
+	p.mu.Lock()
+	if p.standby == standby {
+		p.standby = nil
+		p.standbyChannels = nil
+	}
+	p.mu.Unlock()
+
+	if err := standby.Close(); err != nil {
+		log.Printf("Error closing standby peer connection: %v", err)
+	}
+}
+
+// pendingStandbyOffer returns the standby connection if it is waiting for an answer
+func (p *RealPeer) pendingStandbyOffer() *webrtc.PeerConnection {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.standby != nil && p.standby.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		return p.standby
+	}
+	return nil
+}
+
+// isStandby reports whether pc is the connection being negotiated by a restart
+func (p *RealPeer) isStandby(pc *webrtc.PeerConnection) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.standby == pc
+}
+
+// isStandbyChannel reports whether dc belongs to the standby connection
+func (p *RealPeer) isStandbyChannel(dc *webrtc.DataChannel) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.standbyChannels != nil && p.standbyChannels[dc.Label()] == dc
+}
+
+// addStandbyChannel tracks a channel of the standby connection
+func (p *RealPeer) addStandbyChannel(dc *webrtc.DataChannel) {
+	p.mu.Lock()
+	if p.standbyChannels == nil {
+		p.mu.Unlock()
+		return
+	}
+	p.standbyChannels[dc.Label()] = dc
+	p.mu.Unlock()
+
+	p.setupDataChannelHandlers(dc)
+}
+
+// standbyStateChanged handles connection state changes of the standby connection
+func (p *RealPeer) standbyStateChanged(state webrtc.PeerConnectionState) {
+	switch state {
+	case webrtc.PeerConnectionStateConnected:
+		p.tryPromote()
+	case webrtc.PeerConnectionStateFailed:
+		log.Printf("ICE restart failed")
+		p.mu.RLock()
+		standby := p.standby
+		p.mu.RUnlock()
+		if standby != nil {
+			go p.dropStandby(standby)
+		}
+	}
+}
+
+// tryPromote replaces the active connection with the standby once the standby
+// is connected and every active channel is open on it
+func (p *RealPeer) tryPromote() {
+	p.mu.Lock()
+	standby := p.standby
+	if standby == nil || standby.ConnectionState() != webrtc.PeerConnectionStateConnected {
+		p.mu.Unlock()
+		return
+	}
+
+	for label := range p.channels {
+		dc := p.standbyChannels[label]
+		if dc == nil || dc.ReadyState() != webrtc.DataChannelStateOpen {
+			p.mu.Unlock()
+			return
+		}
+	}
+
+	retired := p.pc
+	p.pc = standby
+	p.channels = p.standbyChannels
+	p.standby = nil
+	p.standbyChannels = nil
+	callback := p.onStateChange
+	p.mu.Unlock()
+
+	log.Printf("ICE restart complete, switched to the new connection")
+
+	time.AfterFunc(retireDelay, func() {
+		if err := retired.Close(); err != nil {
+			log.Printf("Error closing replaced peer connection: %v", err)
+		}
+	})
+
+	if callback != nil {
+		callback(ConnectionStateConnected)
+	}
+}
+
+// sortedChannels returns the active channels ordered by label
+func (p *RealPeer) sortedChannels() []*webrtc.DataChannel {
+	p.mu.RLock()
+	channels := make([]*webrtc.DataChannel, 0, len(p.channels))
+	for _, dc := range p.channels {
+		channels = append(channels, dc)
+	}
+	p.mu.RUnlock()
+
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].Label() < channels[j].Label()
+	})
+	return channels
+}
+
+// channelOptionsOf reads the delivery settings of an existing channel
+func channelOptionsOf(dc *webrtc.DataChannel) ChannelOptions {
+	return ChannelOptions{
+		Ordered:           dc.Ordered(),
+		MaxRetransmits:    dc.MaxRetransmits(),
+		MaxPacketLifeTime: dc.MaxPacketLifeTime(),
+	}
+}
+
+// sessionID returns the session id from the SDP origin line ("o=- <id> ...")
+func sessionID(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if origin, ok := strings.CutPrefix(strings.TrimSpace(line), "o="); ok {
+			fields := strings.Fields(origin)
+			if len(fields) > 1 {
+				return fields[1]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,82 @@
+package webrtc
+
+import (
+	"github.com/pion/webrtc/v3"
+)
+
+// ConnectionState is the overall state of the peer connection, combining
+// ICE and DTLS. It is what OnStateChange reports
+type ConnectionState string
+
+const (
+	ConnectionStateNew          ConnectionState = "new"
+	ConnectionStateConnecting   ConnectionState = "connecting"
+	ConnectionStateConnected    ConnectionState = "connected"
+	ConnectionStateDisconnected ConnectionState = "disconnected"
+	ConnectionStateFailed       ConnectionState = "failed"
+	ConnectionStateClosed       ConnectionState = "closed"
+)
+
+// ICEState is the state of ICE connectivity checks, see OnICEStateChange
+type ICEState string
+
+const (
+	ICEStateNew          ICEState = "new"
+	ICEStateChecking     ICEState = "checking"
+	ICEStateConnected    ICEState = "connected"
+	ICEStateCompleted    ICEState = "completed"
+	ICEStateDisconnected ICEState = "disconnected"
+	ICEStateFailed       ICEState = "failed"
+	ICEStateClosed       ICEState = "closed"
+)
+
+// GatheringState is the state of local candidate gathering, see OnGatheringStateChange
+type GatheringState string
+
+const (
+	GatheringStateNew       GatheringState = "new"
+	GatheringStateGathering GatheringState = "gathering"
+	GatheringStateComplete  GatheringState = "complete"
+	GatheringStateClosed    GatheringState = "closed"
+)
+
+// SignalingState is the state of the offer/answer exchange, see OnSignalingStateChange
+type SignalingState string
+
+const (
+	SignalingStateStable             SignalingState = "stable"
+	SignalingStateHaveLocalOffer     SignalingState = "have-local-offer"
+	SignalingStateHaveRemoteOffer    SignalingState = "have-remote-offer"
+	SignalingStateHaveLocalPranswer  SignalingState = "have-local-pranswer"
+	SignalingStateHaveRemotePranswer SignalingState = "have-remote-pranswer"
+	SignalingStateClosed             SignalingState = "closed"
+)
+
+func (s ConnectionState) String() string { return string(s) }
+func (s ICEState) String() string        { return string(s) }
+func (s GatheringState) String() string  { return string(s) }
+func (s SignalingState) String() string  { return string(s) }
+
+// Lost reports whether the connection dropped but may still recover
+// (e.g. with an ICE restart)
+func (s ConnectionState) Lost() bool {
+	return s == ConnectionStateDisconnected || s == ConnectionStateFailed
+}
+
+// The pion state names are the ones used above, so converting is a rename
+
+func connectionState(state webrtc.PeerConnectionState) ConnectionState {
+	return ConnectionState(state.String())
+}
+
+func iceState(state webrtc.ICEConnectionState) ICEState {
+	return ICEState(state.String())
+}
+
+func gatheringState(state webrtc.ICEGathererState) GatheringState {
+	return GatheringState(state.String())
+}
+
+func signalingState(state webrtc.SignalingState) SignalingState {
+	return SignalingState(state.String())
+}
@@ -0,0 +1,70 @@
+package webrtc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stateLog records the states a peer reports, in order
+type stateLog struct {
+	mu     sync.Mutex
+	states []string
+}
+
+func (l *stateLog) add(state string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.states = append(l.states, state)
+}
+
+func (l *stateLog) contains(state string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, s := range l.states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConnectionState_Lost(t *testing.T) {
+	assert.True(t, ConnectionStateDisconnected.Lost())
+	assert.True(t, ConnectionStateFailed.Lost())
+	assert.False(t, ConnectionStateConnected.Lost())
+	assert.False(t, ConnectionStateClosed.Lost())
+}
+
+func TestRealPeer_StateEvents(t *testing.T) {
+	offerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	var connection, ice, gathering, signaling stateLog
+	offerer.OnStateChange(func(state ConnectionState) { connection.add(state.String()) })
+	offerer.OnICEStateChange(func(state ICEState) { ice.add(state.String()) })
+	offerer.OnGatheringStateChange(func(state GatheringState) { gathering.add(state.String()) })
+	offerer.OnSignalingStateChange(func(state SignalingState) { signaling.add(state.String()) })
+
+	connectPeers(t, offerer, answerer)
+
+	require.Eventually(t, func() bool {
+		return connection.contains(string(ConnectionStateConnected)) &&
+			ice.contains(string(ICEStateConnected))
+	}, 10*time.Second, 20*time.Millisecond, "states never reported connected")
+
+	assert.True(t, ice.contains(string(ICEStateChecking)))
+	assert.True(t, gathering.contains(string(GatheringStateGathering)))
+	assert.True(t, gathering.contains(string(GatheringStateComplete)))
+	assert.True(t, signaling.contains(string(SignalingStateHaveLocalOffer)))
+	assert.True(t, signaling.contains(string(SignalingStateStable)))
+}
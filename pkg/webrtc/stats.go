@@ -0,0 +1,152 @@
+package webrtc
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// Candidate types as reported in CandidateInfo.Type
+const (
+	CandidateHost  = "host"
+	CandidateSrflx = "srflx"
+	CandidatePrflx = "prflx"
+	CandidateRelay = "relay"
+)
+
+// CandidateInfo describes one side of the selected candidate pair
+type CandidateInfo struct {
+	Type     string `json:"type"`
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+}
+
+// ChannelStats holds the counters of a single data channel
+type ChannelStats struct {
+	Label            string `json:"label"`
+	State            string `json:"state"`
+	BufferedAmount   uint64 `json:"bufferedAmount"`
+	MessagesSent     uint32 `json:"messagesSent"`
+	MessagesReceived uint32 `json:"messagesReceived"`
+	BytesSent        uint64 `json:"bytesSent"`
+	BytesReceived    uint64 `json:"bytesReceived"`
+}
+
+// Stats is a snapshot of the connection.
+// Local and Remote are nil until ICE has selected a candidate pair.
+type Stats struct {
+	State  ConnectionState `json:"state"`
+	Local  *CandidateInfo  `json:"local,omitempty"`
+	Remote *CandidateInfo  `json:"remote,omitempty"`
+
+	// RTT is the latest STUN round trip time on the selected pair
+	RTT time.Duration `json:"rtt"`
+
+	// Totals across all data channels
+	BytesSent        uint64 `json:"bytesSent"`
+	BytesReceived    uint64 `json:"bytesReceived"`
+	MessagesSent     uint32 `json:"messagesSent"`
+	MessagesReceived uint32 `json:"messagesReceived"`
+
+	// Channels is sorted by label
+	Channels []ChannelStats `json:"channels"`
+}
+
+// UsingRelay reports whether either side of the selected pair is a TURN relay
+func (s Stats) UsingRelay() bool {
+	return (s.Local != nil && s.Local.Type == CandidateRelay) ||
+		(s.Remote != nil && s.Remote.Type == CandidateRelay)
+}
+
+// Channel returns the stats of the named channel
+func (s Stats) Channel(label string) (ChannelStats, bool) {
+	for _, channel := range s.Channels {
+		if channel.Label == label {
+			return channel, true
+		}
+	}
+	return ChannelStats{}, false
+}
+
+// Returns a snapshot of the selected candidate pair and data channel counters
+func (p *RealPeer) Stats() Stats {
+	pc := p.conn()
+	report := pc.GetStats()
+
+	stats := Stats{
+		State: connectionState(pc.ConnectionState()),
+	}
+
+	if pair := selectedCandidatePair(pc); pair != nil {
+		stats.Local = candidateInfo(pair.Local)
+		stats.Remote = candidateInfo(pair.Remote)
+
+		if pairStats, ok := report.GetICECandidatePairStats(pair); ok {
+			stats.RTT = time.Duration(pairStats.CurrentRoundTripTime * float64(time.Second))
+		}
+	}
+
+	p.mu.RLock()
+	channels := make([]*webrtc.DataChannel, 0, len(p.channels))
+	for _, dc := range p.channels {
+		channels = append(channels, dc)
+	}
+	p.mu.RUnlock()
+
+	for _, dc := range channels {
+		channel := ChannelStats{
+			Label:          dc.Label(),
+			State:          dc.ReadyState().String(),
+			BufferedAmount: dc.BufferedAmount(),
+		}
+
+		if dcStats, ok := report.GetDataChannelStats(dc); ok {
+			channel.MessagesSent = dcStats.MessagesSent
+			channel.MessagesReceived = dcStats.MessagesReceived
+			channel.BytesSent = dcStats.BytesSent
+			channel.BytesReceived = dcStats.BytesReceived
+		}
+
+		stats.MessagesSent += channel.MessagesSent
+		stats.MessagesReceived += channel.MessagesReceived
+		stats.BytesSent += channel.BytesSent
+		stats.BytesReceived += channel.BytesReceived
+		stats.Channels = append(stats.Channels, channel)
+	}
+
+	sort.Slice(stats.Channels, func(i, j int) bool {
+		return stats.Channels[i].Label < stats.Channels[j].Label
+	})
+
+	return stats
+}
+
+// selectedCandidatePair returns the pair ICE is using, or nil if there is none yet
+func selectedCandidatePair(pc *webrtc.PeerConnection) *webrtc.ICECandidatePair {
+	sctp := pc.SCTP()
+	if sctp == nil || sctp.Transport() == nil || sctp.Transport().ICETransport() == nil {
+		return nil
+	}
+
+	pair, err := sctp.Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil {
+		return nil
+	}
+	return pair
+}
+
+// candidateInfo converts a pion candidate into a CandidateInfo
+func candidateInfo(candidate *webrtc.ICECandidate) *CandidateInfo {
+	if candidate == nil {
+		return nil
+	}
+
+	return &CandidateInfo{
+		Type:     candidate.Typ.String(),
+		Address:  candidate.Address,
+		Port:     int(candidate.Port),
+		Protocol: candidate.Protocol.String(),
+	}
+}
@@ -0,0 +1,72 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRealPeer_StatsBeforeConnection(t *testing.T) {
+	peer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer peer.Close()
+
+	stats := peer.Stats()
+	assert.Equal(t, ConnectionStateNew, stats.State)
+	assert.Nil(t, stats.Local)
+	assert.Nil(t, stats.Remote)
+	assert.False(t, stats.UsingRelay())
+	assert.Empty(t, stats.Channels)
+}
+
+func TestRealPeer_Stats(t *testing.T) {
+	offerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer offerer.Close()
+
+	answerer, err := NewRealPeer()
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	received := make(chan []byte, 3)
+	answerer.OnMessage(func(data []byte) {
+		received <- data
+	})
+
+	connectPeers(t, offerer, answerer)
+
+	for _, text := range []string{"one", "two", "three"} {
+		require.NoError(t, offerer.Send([]byte(text)))
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("message was not delivered")
+		}
+	}
+
+	stats := offerer.Stats()
+	assert.Equal(t, ConnectionStateConnected, stats.State)
+
+	require.NotNil(t, stats.Local)
+	require.NotNil(t, stats.Remote)
+	assert.NotEmpty(t, stats.Local.Address)
+	assert.NotZero(t, stats.Local.Port)
+	assert.Equal(t, "udp", stats.Local.Protocol)
+	assert.False(t, stats.UsingRelay(), "loopback peers connect without a relay")
+
+	chat, ok := stats.Channel(DefaultChannel)
+	require.True(t, ok)
+	assert.Equal(t, "open", chat.State)
+	assert.Equal(t, uint32(3), chat.MessagesSent)
+	assert.Equal(t, uint64(len("one")+len("two")+len("three")), chat.BytesSent)
+	assert.Equal(t, uint32(3), stats.MessagesSent)
+
+	require.Eventually(t, func() bool {
+		chat, _ := answerer.Stats().Channel(DefaultChannel)
+		return chat.MessagesReceived == 3
+	}, 5*time.Second, 20*time.Millisecond)
+}